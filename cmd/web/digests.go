@@ -0,0 +1,20 @@
+package main
+
+import (
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/handlers"
+)
+
+// digestInterval is how often the users who asked for a digest are mailed their unread notifications
+const digestInterval = time.Hour
+
+// digestJob mails the digests of the notifications, every digestInterval
+func digestJob() job {
+	return job{
+		name:     "digests",
+		interval: digestInterval,
+		run:      handlers.Repo.SendNotificationDigests,
+		report:   "Queued %d notification digests",
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/handlers"
+)
+
+// guestMailInterval is how often the scheduled guest mails are checked for those that are due
+const guestMailInterval = 15 * time.Minute
+
+// guestMailJob queues the guest mails that are due, once at start and then every guestMailInterval. The mails
+// are recorded, so a restart or a second instance sends none twice
+func guestMailJob() job {
+	return job{
+		name:     "guest mails",
+		interval: guestMailInterval,
+		atStart:  true,
+		run:      handlers.Repo.SendGuestMails,
+		report:   "Queued %d guest mails",
+	}
+}
@@ -1,25 +1,37 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/fangjjcs/bookings-app/pkg/config"
 	"github.com/fangjjcs/bookings-app/pkg/driver"
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/events"
 	"github.com/fangjjcs/bookings-app/pkg/handlers"
 	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/mailer"
 	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
 	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/fangjjcs/bookings-app/pkg/repository/dbrepo"
 )
 
 const portNumber = ":8088"
 
+// pathToEmailTemplates is where the templates of the mails are
+var pathToEmailTemplates = "./templates/email"
+
 var app config.AppConfig
 var session *scs.SessionManager
 
@@ -34,11 +46,13 @@ func main() {
 	if err != nil{
 		log.Fatal(err)
 	}
-	defer db.SQL.Close() 
+	if db != nil{
+		defer db.SQL.Close()
+	}
 
-	defer close(app.MailChan)
-	listenForMail()
-	fmt.Println("Starting mail listener...")
+	mailer := startMailer()
+	fmt.Println("Starting mail outbox...")
+	jobs := startScheduler(purgeJob(), digestJob(), guestMailJob())
 
 
 	fmt.Printf(fmt.Sprintf("Staring application on port %s\n", portNumber))
@@ -48,10 +62,29 @@ func main() {
 		Handler: routes(&app),
 	}
 
+	// on shutdown the requests and the mails under way are finished, queued mails wait for the next start
+	stopped := make(chan struct{})
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		<-quit
+		infoLog.Println("Shutting down...")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			errorLog.Println(err)
+		}
+		jobs.Stop()
+		mailer.Stop()
+		close(stopped)
+	}()
+
 	err = srv.ListenAndServe()
-	if err != nil {
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-stopped
 }
 
 func run() (*driver.DB, error) {
@@ -62,6 +95,7 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Restrictions{})
 	gob.Register(models.Room{})
 	gob.Register(map[string]int{})
+	gob.Register(time.Time{})
 
 	// read flags
 	inProduction := flag.Bool("production", true, "Application is in production")
@@ -72,16 +106,37 @@ func run() (*driver.DB, error) {
 	dbPass := flag.String("dbpass", "", "Database password")
 	dbPort := flag.String("dbport", "", "Database port")
 	dbSSL := flag.String("dbssl", "disable", "Database ssl settings (disable, prefer, require)")
+	dbDriver := flag.String("dbdriver", "postgres", "Database driver (postgres, memory)")
+	paymentGateway := flag.String("payments", "fake", "Payment gateway (fake)")
+	mailTransport := flag.String("mail", mailer.TransportSMTP, "Mail transport (smtp, file, log)")
+	mailFrom := flag.String("mailfrom", "server@booking.com", "Sender address of the mails")
+	smtpHost := flag.String("smtphost", "localhost", "SMTP server host")
+	smtpPort := flag.Int("smtpport", 1025, "SMTP server port")
+	smtpUser := flag.String("smtpuser", "", "SMTP username")
+	smtpPass := flag.String("smtppass", "", "SMTP password")
+	smtpEncryption := flag.String("smtpencryption", mailer.EncryptionNone, "SMTP encryption (none, ssl, starttls)")
+	mailDir := flag.String("maildir", "mail", "Directory the file mail transport writes .eml files to")
+	baseURL := flag.String("url", "http://localhost"+portNumber, "Address the site is reached at, for the links in mails")
+	realIPHeader := flag.String("realipheader", "", "Header the proxy in front of the site sets to the client address, like X-Forwarded-For, empty when the site is reached directly")
 
 	flag.Parse()
 
-	if *dbName == "" || *dbUser ==""{
-		fmt.Println("Missing required flags")
+	if *dbDriver != "postgres" && *dbDriver != "memory"{
+		fmt.Println("Unknown database driver", *dbDriver)
+		os.Exit(1)
+	}
+
+	// only the fake gateway exists so far, a real one implements payments.PaymentGateway and is chosen here
+	if *paymentGateway != "fake"{
+		fmt.Println("Unknown payment gateway", *paymentGateway)
 		os.Exit(1)
 	}
+	app.Payments = payments.NewFakeGateway()
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
+	if *dbDriver == "postgres" && (*dbName == "" || *dbUser ==""){
+		fmt.Println("Missing required flags")
+		os.Exit(1)
+	}
 
 	
 	// log
@@ -90,6 +145,26 @@ func run() (*driver.DB, error) {
 	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 	app.ErrorLog = errorLog
 
+	// mails go out through the smtp server, or into files or the log where there is none
+	sender, err := mailer.New(mailer.Config{
+		Transport:  *mailTransport,
+		Host:       *smtpHost,
+		Port:       *smtpPort,
+		Username:   *smtpUser,
+		Password:   *smtpPass,
+		Encryption: *smtpEncryption,
+		From:       *mailFrom,
+		Dir:        *mailDir,
+	}, infoLog)
+	if err != nil{
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	mailSender = sender
+	app.MailFrom = *mailFrom
+	app.BaseURL = strings.TrimSuffix(*baseURL, "/")
+	app.RealIPHeader = http.CanonicalHeaderKey(strings.TrimSpace(*realIPHeader))
+
 	// set up the session
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -99,15 +174,18 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 
-	// connect to database
-	log.Println("Connecting to database...")
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
-	//"host=localhost port=5432 dbname=Bookings user=fang password="
-	db, err := driver.ConnectSQL(connectionString)
-	if err != nil{
-		log.Fatal("Cannot connect to database")
+	// connect to database, unless everything is kept in memory
+	var db *driver.DB
+	if *dbDriver == "postgres"{
+		log.Println("Connecting to database...")
+		connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
+		//"host=localhost port=5432 dbname=Bookings user=fang password="
+		conn, err := driver.ConnectSQL(connectionString)
+		if err != nil{
+			log.Fatal("Cannot connect to database")
+		}
+		db = conn
 	}
-	
 
 
 	tc, err := render.CreateTemplateCache()
@@ -117,11 +195,28 @@ func run() (*driver.DB, error) {
 	}
 
 	app.TemplateCache = tc
+
+	app.EmailTemplates, err = emails.Load(pathToEmailTemplates)
+	if err != nil {
+		log.Fatal("cannot load the email templates: ", err)
+		return nil, err
+	}
 	app.InProduction =  *inProduction //true // change this to true when in production
 	app.UseCache = *useCache // define whenever you allow to use cache or not
 
-	repo := handlers.NewRepo(&app, db)
+	var repo *handlers.Repository
+	if db != nil{
+		repo = handlers.NewRepo(&app, db)
+	}else{
+		log.Printf("Using in-memory database, log in with %s / %s\n", dbrepo.DemoEmail, dbrepo.DemoPassword)
+		repo = handlers.NewMemoryRepo(&app)
+	}
 	handlers.NewHandlers(repo)
+
+	// what happens in the app is passed on to whoever subscribed, like the notifications of the staff
+	app.Events = events.New()
+	repo.ListenForNotifications()
+
 	render.NewTemplates(&app)
 	helpers.NewHelpers(&app)
 
@@ -1,10 +1,16 @@
 package main
 
-import "testing"
+import (
+	"os"
+	"testing"
+)
 
 func TestRun(t *testing.T){
+	// run against the in-memory database, no postgres needed
+	os.Args = append(os.Args, "-dbdriver=memory")
+
 	_, err := run()
 	if err != nil{
 		t.Error("Failed run()")
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/handlers"
+)
+
+// purgeInterval is how often the trash is checked for reservations past their retention
+const purgeInterval = time.Hour
+
+// purgeJob purges the trash, once at start and then every purgeInterval
+func purgeJob() job {
+	return job{
+		name:     "purge",
+		interval: purgeInterval,
+		atStart:  true,
+		run:      handlers.Repo.PurgeTrash,
+		report:   "Purged %d reservations from the trash",
+	}
+}
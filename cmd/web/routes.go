@@ -5,6 +5,7 @@ import (
 
 	"github.com/fangjjcs/bookings-app/pkg/config"
 	"github.com/fangjjcs/bookings-app/pkg/handlers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
@@ -13,13 +14,49 @@ func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(middleware.Recoverer)
+
+	// the JSON API is for machine clients, so it sits outside the session and csrf middleware
+	mux.Mount("/api/v1", apiRoutes())
+	mux.Mount("/", webRoutes())
+
+	return mux
+}
+
+// apiRoutes are the routes of the versioned JSON API
+func apiRoutes() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.NotFound(handlers.Repo.APINotFound)
+	mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+
+	// every call needs an api key, each route checks its scope
+	mux.Use(handlers.Repo.APIAuth)
+	read := mux.With(handlers.Repo.RequireScope(models.ScopeReadAvailability))
+	write := mux.With(handlers.Repo.RequireScope(models.ScopeWriteReservations))
+
+	read.Get("/rooms", handlers.Repo.APIRooms)
+	read.Get("/availability", handlers.Repo.APIAvailability)
+	write.Post("/reservations", handlers.Repo.APICreateReservation)
+	write.Get("/reservations/{token}", handlers.Repo.APIGetReservation)
+	write.Delete("/reservations/{token}", handlers.Repo.APICancelReservation)
+
+	return mux
+}
+
+// webRoutes are the routes of the public site and the admin tool
+func webRoutes() http.Handler {
+	mux := chi.NewRouter()
+
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals", handlers.Repo.Generals)
-	mux.Get("/majors", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	// the old room pages, kept so existing links still work
+	mux.Get("/generals", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently).ServeHTTP)
+	mux.Get("/majors", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently).ServeHTTP)
 	
 	mux.Get("/search-availability", handlers.Repo.SearchAvailability)
 	mux.Post("/search-availability", handlers.Repo.PostSearchAvailability)
@@ -30,26 +67,122 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/make-reservation", handlers.Repo.MakeReservation)
 	mux.Post("/make-reservation", handlers.Repo.PostMakeReservation)
+	mux.Get("/make-payment", handlers.Repo.MakePayment)
+	mux.Post("/make-payment", handlers.Repo.PostMakePayment)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
+	mux.Get("/my-booking/{token}", handlers.Repo.ManageBooking)
+	mux.Post("/my-booking/{token}", handlers.Repo.PostManageBooking)
+	mux.Post("/my-booking/{token}/dates", handlers.Repo.PostChangeBookingDates)
+	mux.Post("/my-booking/{token}/cancel", handlers.Repo.PostCancelBooking)
+
 	mux.Get("/contact", handlers.Repo.Contact)
 
 	mux.Get("/user/login", handlers.Repo.Login)
 	mux.Post("/user/login", handlers.Repo.PostLogin)
+	mux.Get("/user/two-factor", handlers.Repo.TwoFactor)
+	mux.Post("/user/two-factor", handlers.Repo.PostTwoFactor)
 	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/forgot-password", handlers.Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/set-password/{token}", handlers.Repo.SetPassword)
+	mux.Post("/user/set-password/{token}", handlers.Repo.PostSetPassword)
 	mux.Route("/admin", func(mux chi.Router){
 		mux.Use(Auth)
-		mux.Get("/dashboard", handlers.Repo.AdminDashboard) 
-		mux.Get("/reservations-new", handlers.Repo.AdminNewReservation)
-		mux.Get("/reservations-all", handlers.Repo.AdminAllReservation)
-		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationCalendar)
-		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationCalendar)
 
-		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		// every admin route checks the permission matrix of models
+		can := func(permission string) chi.Router {
+			return mux.With(handlers.Repo.RequirePermission(permission))
+		}
+
+		can(models.PermViewDashboard).Get("/dashboard", handlers.Repo.AdminDashboard)
+
+		// every user manages their own second factor, these skip the permission check
+		// so users who are required to set it up can reach them
+		mux.With(handlers.Repo.RequireUser).Get("/two-factor", handlers.Repo.AdminTwoFactor)
+		mux.With(handlers.Repo.RequireUser).Post("/two-factor", handlers.Repo.AdminPostTwoFactor)
+		mux.With(handlers.Repo.RequireUser).Post("/two-factor/disable", handlers.Repo.AdminPostDisableTwoFactor)
+		mux.With(handlers.Repo.RequireUser).Post("/two-factor/recovery-codes", handlers.Repo.AdminPostRecoveryCodes)
+
+		// every user who can see the reservations is notified of new ones
+		can(models.PermViewReservations).Get("/notifications", handlers.Repo.AdminNotifications)
+		can(models.PermViewReservations).Post("/notifications", handlers.Repo.AdminPostNotifications)
+		can(models.PermViewReservations).Get("/notifications/unread", handlers.Repo.AdminUnreadNotifications)
+		can(models.PermViewReservations).Get("/notifications/{id}", handlers.Repo.AdminOpenNotification)
+		can(models.PermViewReservations).Get("/read-notifications/do", handlers.Repo.AdminReadNotifications)
+
+		can(models.PermViewReservations).Get("/reservations-pending", handlers.Repo.AdminPendingReservations)
+		// the pending reservations were called new before
+		mux.Handle("/reservations-new", http.RedirectHandler("/admin/reservations-pending", http.StatusMovedPermanently))
+		can(models.PermViewReservations).Get("/reservations-all", handlers.Repo.AdminAllReservation)
+		can(models.PermViewReservations).Get("/reservations-calendar", handlers.Repo.AdminReservationCalendar)
+		can(models.PermEditCalendar).Post("/reservations-calendar", handlers.Repo.AdminPostReservationCalendar)
+
+		can(models.PermEditReservations).Get("/change-reservation-status/{src}/{id}/{status}/do", handlers.Repo.AdminChangeReservationStatus)
+		can(models.PermEditReservations).Get("/cancel-reservation/{src}/{id}/do", handlers.Repo.AdminCancelReservation)
+		can(models.PermDeleteReservations).Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		can(models.PermDeleteReservations).Get("/trash", handlers.Repo.AdminTrash)
+		can(models.PermDeleteReservations).Get("/restore-reservation/{id}/do", handlers.Repo.AdminRestoreReservation)
+		can(models.PermViewAudit).Get("/audit", handlers.Repo.AdminAudit)
+		can(models.PermManageMail).Get("/outbox", handlers.Repo.AdminOutbox)
+		can(models.PermManageMail).Get("/outbox/{id}", handlers.Repo.AdminShowOutboxMessage)
+		can(models.PermManageMail).Get("/resend-mail/{id}/do", handlers.Repo.AdminResendMail)
+		can(models.PermManageMail).Get("/email-templates", handlers.Repo.AdminEmailTemplates)
+		can(models.PermManageMail).Get("/email-templates/{name}", handlers.Repo.AdminShowEmailTemplate)
+		can(models.PermManageMail).Get("/email-templates/{name}/html", handlers.Repo.AdminEmailTemplateHTML)
+		can(models.PermManageMail).Get("/guest-emails", handlers.Repo.AdminGuestEmails)
+		can(models.PermManageMail).Post("/guest-emails", handlers.Repo.AdminPostGuestEmails)
+
+		can(models.PermViewReservations).Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
+		can(models.PermViewReservations).Get("/reservations/{src}/{id}/invoice", handlers.Repo.AdminReservationInvoice)
+		can(models.PermEditReservations).Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		can(models.PermManagePayments).Get("/capture-payment/{src}/{id}/do", handlers.Repo.AdminCapturePayment)
+		can(models.PermManagePayments).Get("/void-payment/{src}/{id}/do", handlers.Repo.AdminVoidPayment)
+		can(models.PermManagePayments).Post("/refund-payment/{src}/{id}", handlers.Repo.AdminPostRefundPayment)
+
+		can(models.PermViewRooms).Get("/rooms", handlers.Repo.AdminRooms)
+		can(models.PermManageRooms).Get("/rooms/new", handlers.Repo.AdminNewRoom)
+		can(models.PermManageRooms).Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+		can(models.PermViewRooms).Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+		can(models.PermManageRooms).Post("/rooms/{id}", handlers.Repo.AdminPostShowRoom)
+		can(models.PermManageRooms).Get("/activate-room/{id}/do", handlers.Repo.AdminActivateRoom)
+		can(models.PermManageRooms).Get("/deactivate-room/{id}/do", handlers.Repo.AdminDeactivateRoom)
+		can(models.PermManageRooms).Post("/rooms/{id}/seasonal-rates", handlers.Repo.AdminPostSeasonalRate)
+		can(models.PermManageRooms).Get("/delete-seasonal-rate/{room_id}/{id}/do", handlers.Repo.AdminDeleteSeasonalRate)
+		can(models.PermManageRooms).Post("/rooms/{id}/stay-discounts", handlers.Repo.AdminPostStayDiscount)
+		can(models.PermManageRooms).Get("/delete-stay-discount/{room_id}/{id}/do", handlers.Repo.AdminDeleteStayDiscount)
+		can(models.PermManageRooms).Post("/rooms/{id}/cancellation-rules", handlers.Repo.AdminPostCancellationRule)
+		can(models.PermManageRooms).Get("/delete-cancellation-rule/{room_id}/{id}/do", handlers.Repo.AdminDeleteCancellationRule)
+
+		can(models.PermManagePromotions).Get("/promotions", handlers.Repo.AdminPromotions)
+		can(models.PermManagePromotions).Post("/promotions", handlers.Repo.AdminPostPromotion)
+		can(models.PermManagePromotions).Get("/promotions/{id}", handlers.Repo.AdminShowPromotion)
+		can(models.PermManagePromotions).Get("/activate-promotion/{id}/do", handlers.Repo.AdminActivatePromotion)
+		can(models.PermManagePromotions).Get("/deactivate-promotion/{id}/do", handlers.Repo.AdminDeactivatePromotion)
+
+		can(models.PermManageTaxes).Get("/taxes", handlers.Repo.AdminTaxRules)
+		can(models.PermManageTaxes).Post("/taxes", handlers.Repo.AdminPostTaxRule)
+		can(models.PermManageTaxes).Get("/activate-tax-rule/{id}/do", handlers.Repo.AdminActivateTaxRule)
+		can(models.PermManageTaxes).Get("/deactivate-tax-rule/{id}/do", handlers.Repo.AdminDeactivateTaxRule)
+		can(models.PermManageTaxes).Get("/delete-tax-rule/{id}/do", handlers.Repo.AdminDeleteTaxRule)
+
+		can(models.PermManageAPIKeys).Get("/api-keys", handlers.Repo.AdminAPIKeys)
+		can(models.PermManageAPIKeys).Post("/api-keys", handlers.Repo.AdminPostAPIKey)
+		can(models.PermManageAPIKeys).Get("/revoke-api-key/{id}/do", handlers.Repo.AdminRevokeAPIKey)
+
+		can(models.PermManageUsers).Get("/users", handlers.Repo.AdminUsers)
+		can(models.PermManageUsers).Post("/users", handlers.Repo.AdminPostInviteUser)
+		can(models.PermManageUsers).Get("/users/{id}", handlers.Repo.AdminShowUser)
+		can(models.PermManageUsers).Post("/users/{id}", handlers.Repo.AdminPostShowUser)
+		can(models.PermManageUsers).Get("/disable-user/{id}/do", handlers.Repo.AdminDisableUser)
+		can(models.PermManageUsers).Get("/enable-user/{id}/do", handlers.Repo.AdminEnableUser)
+		can(models.PermManageUsers).Get("/reset-user-password/{id}/do", handlers.Repo.AdminResetUserPassword)
+		can(models.PermManageUsers).Get("/reset-user-two-factor/{id}/do", handlers.Repo.AdminResetUserTwoFactor)
+		can(models.PermManageUsers).Get("/login-activity", handlers.Repo.AdminLoginActivity)
+		can(models.PermManageUsers).Get("/unlock-user/{id}/do", handlers.Repo.AdminUnlockUser)
 
-		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		can(models.PermManageSettings).Get("/settings", handlers.Repo.AdminSettings)
+		can(models.PermManageSettings).Post("/settings", handlers.Repo.AdminPostSettings)
 
 	})
 
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// job is work the scheduler runs in the background every interval, run returns how many things it did and
+// report is logged with that count when it did any
+type job struct {
+	name     string
+	interval time.Duration
+	// atStart runs the job once right away, otherwise it first runs after an interval
+	atStart bool
+	run     func() (int, error)
+	report  string
+}
+
+// scheduler runs jobs in the background, each in its own goroutine so a slow job holds up no other
+type scheduler struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startScheduler starts running the jobs
+func startScheduler(jobs ...job) *scheduler {
+	s := &scheduler{stop: make(chan struct{})}
+	for _, j := range jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+	return s
+}
+
+func (s *scheduler) loop(j job) {
+	defer s.wg.Done()
+
+	if j.atStart {
+		s.runJob(j)
+	}
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.runJob(j)
+		}
+	}
+}
+
+func (s *scheduler) runJob(j job) {
+	n, err := j.run()
+	if err != nil {
+		errorLog.Printf("%s: %v\n", j.name, err)
+	} else if n > 0 {
+		infoLog.Printf(j.report+"\n", n)
+	}
+}
+
+// Stop ends the jobs, it returns once those running are done
+func (s *scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	infoLog = log.New(ioutil.Discard, "", 0)
+	errorLog = log.New(ioutil.Discard, "", 0)
+
+	var atStart, later, failing int32
+	s := startScheduler(
+		job{name: "at start", interval: time.Hour, atStart: true, run: func() (int, error) {
+			atomic.AddInt32(&atStart, 1)
+			return 1, nil
+		}},
+		job{name: "later", interval: time.Hour, run: func() (int, error) {
+			atomic.AddInt32(&later, 1)
+			return 0, nil
+		}},
+		job{name: "failing", interval: 10 * time.Millisecond, run: func() (int, error) {
+			atomic.AddInt32(&failing, 1)
+			return 0, errors.New("failed")
+		}},
+	)
+	time.Sleep(100 * time.Millisecond)
+	s.Stop()
+
+	if n := atomic.LoadInt32(&atStart); n != 1 {
+		t.Errorf("expected the job to run once at start, but %d times", n)
+	}
+	if n := atomic.LoadInt32(&later); n != 0 {
+		t.Errorf("expected the job to wait for its interval, but it ran %d times", n)
+	}
+	// a job that fails keeps running
+	if n := atomic.LoadInt32(&failing); n < 2 {
+		t.Errorf("expected the failing job to run again, but %d times", n)
+	}
+
+	ran := atomic.LoadInt32(&failing)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&failing); n != ran {
+		t.Errorf("expected no runs after Stop, but %d more", n-ran)
+	}
+}
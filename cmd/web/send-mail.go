@@ -1,51 +1,17 @@
 package main
 
 import (
-	"log"
-	"time"
-
-	"github.com/fangjjcs/bookings-app/pkg/models"
-
-	mail "github.com/xhit/go-simple-mail/v2"
+	"github.com/fangjjcs/bookings-app/pkg/handlers"
+	"github.com/fangjjcs/bookings-app/pkg/outbox"
 )
 
-func listenForMail(){
-	// execute in the background
-	go func(){
-		for{
-			msg := <- app.MailChan
-			sendMsg(msg)
-		}
-	}() 
-}
-
-func sendMsg(m models.MailData){
-
-	// setting up a local mail server
-	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
-	server.KeepAlive =  false
-	server.ConnectTimeout = 10 * time.Second
-	server.SendTimeout = 10 * time.Second
-	
-	// client
-	client, err := server.Connect()
-	if err != nil {
-		errorLog.Println(err)
-	}
+// mailSender delivers the mails, it is chosen by the mail flags
+var mailSender outbox.Sender
 
-	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-	email.SetBody(mail.TextHTML,m.Content)
-	
-	err = email.Send(client)
-	if err != nil{
-		log.Println(err)
-	}else{
-		log.Println("Email Send!")
-	}
-
-
-
-}
\ No newline at end of file
+// startMailer delivers the mails of the outbox in the background, stop the returned worker to wait for the
+// deliveries under way
+func startMailer() *outbox.Worker {
+	worker := outbox.New(handlers.Repo.DB, mailSender, infoLog, errorLog)
+	worker.Start()
+	return worker
+}
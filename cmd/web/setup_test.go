@@ -7,6 +7,7 @@ import (
 )
 
 func TestMain(m *testing.M){
+	pathToEmailTemplates = "./../../templates/email"
 	os.Exit(m.Run())
 }
 
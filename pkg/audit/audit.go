@@ -0,0 +1,167 @@
+// Package audit works out what a change did to a record, field by field, for the audit log
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// zeroTime is how encoding/json writes a time that was never set
+const zeroTime = "0001-01-01T00:00:00Z"
+
+// ignored are fields left out of every diff, the timestamps change with every write, the room of a reservation is
+// a record of its own and the secrets must never end up in the log
+var ignored = map[string]bool{
+	"Room":         true,
+	"CreatedAt":    true,
+	"UpdatedAt":    true,
+	"Password":     true,
+	"TOTPSecret":   true,
+	"TOTPLastStep": true,
+	"TokenHash":    true,
+	"KeyHash":      true,
+	"AccessToken":  true,
+}
+
+// Change is a field of a record before and after a change, nested fields are named with dots like Quote.Total
+type Change struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// Diff returns the fields that differ between two versions of a record, by name. before is nil for a record that
+// was created and after is nil for one that was deleted, then only the fields that were set are returned
+func Diff(before, after interface{}) ([]Change, error) {
+	from, err := flatten(before)
+	if err != nil {
+		return nil, err
+	}
+	to, err := flatten(after)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool)
+	for field := range from {
+		fields[field] = true
+	}
+	for field := range to {
+		fields[field] = true
+	}
+
+	var changes []Change
+	for field := range fields {
+		f, inFrom := from[field]
+		t, inTo := to[field]
+		if reflect.DeepEqual(f, t) {
+			continue
+		}
+		if (!inFrom && isZero(t)) || (!inTo && isZero(f)) {
+			continue
+		}
+		changes = append(changes, Change{Field: field, From: f, To: t})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes, nil
+}
+
+// Encode returns the changes as the JSON kept in the audit log
+func Encode(changes []Change) string {
+	if len(changes) == 0 {
+		return "[]"
+	}
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return "[]"
+	}
+	return string(b)
+}
+
+// Decode reads the changes back from the audit log, a broken entry has none
+func Decode(s string) []Change {
+	var changes []Change
+	if err := json.Unmarshal([]byte(s), &changes); err != nil {
+		return nil
+	}
+	return changes
+}
+
+// Format returns a value of a change as it is shown to the staff, empty for a value that was not set
+func Format(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		if v == zeroTime {
+			return ""
+		}
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// flatten returns the fields of a record as JSON values by name, nested objects are flattened with dotted names
+func flatten(record interface{}) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if record == nil {
+		return fields, nil
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = json.Unmarshal(b, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		// a plain value, such as a single setting
+		fields["Value"] = value
+		return fields, nil
+	}
+	flattenInto(fields, "", object)
+	return fields, nil
+}
+
+func flattenInto(fields map[string]interface{}, prefix string, object map[string]interface{}) {
+	for name, value := range object {
+		if ignored[name] {
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenInto(fields, prefix+name+".", nested)
+			continue
+		}
+		fields[prefix+name] = value
+	}
+}
+
+// isZero reports whether a JSON value is the zero value of its Go type
+func isZero(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == "" || v == zeroTime
+	case float64:
+		return v == 0
+	case bool:
+		return !v
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
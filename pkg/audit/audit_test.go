@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+type record struct {
+	ID        int
+	Name      string
+	Password  string
+	Nights    int
+	Start     time.Time
+	Place     room
+	UpdatedAt time.Time
+}
+
+type room struct {
+	RoomName string
+}
+
+func TestDiff(t *testing.T) {
+	start := time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)
+	before := record{ID: 1, Name: "John", Password: "old", Nights: 2, Place: room{RoomName: "Suite"}}
+	after := before
+	after.Name = "Jane"
+	after.Password = "new"
+	after.Start = start
+	after.Place.RoomName = "Quarters"
+	after.UpdatedAt = time.Now()
+
+	changes, err := Diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Field: "Name", From: "John", To: "Jane"},
+		{Field: "Place.RoomName", From: "Suite", To: "Quarters"},
+		{Field: "Start", From: zeroTime, To: "2026-08-01T00:00:00Z"},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, but %+v", len(expected), changes)
+	}
+	for i, c := range changes {
+		if c != expected[i] {
+			t.Errorf("expected %+v, but %+v", expected[i], c)
+		}
+	}
+
+	// a created record lists the fields that were set
+	changes, _ = Diff(nil, record{ID: 2, Name: "John"})
+	if len(changes) != 2 || changes[0].Field != "ID" || changes[0].From != nil || changes[1].To != "John" {
+		t.Errorf("expected the ID and the name of the new record, but %+v", changes)
+	}
+
+	// a deleted one the fields it had
+	changes, _ = Diff(record{ID: 2, Nights: 3}, nil)
+	if len(changes) != 2 || changes[1].Field != "Nights" || changes[1].To != nil {
+		t.Errorf("expected the ID and the nights of the deleted record, but %+v", changes)
+	}
+
+	// maps are diffed by key
+	changes, _ = Diff(map[string]string{"a": "1", "b": "2"}, map[string]string{"a": "1", "b": "3"})
+	if len(changes) != 1 || changes[0].Field != "b" {
+		t.Errorf("expected the changed key, but %+v", changes)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	changes, _ := Diff(record{Name: "John", Nights: 2}, record{Name: "Jane", Nights: 3})
+	decoded := Decode(Encode(changes))
+	if len(decoded) != 2 || decoded[0].Field != "Name" || Format(decoded[1].To) != "3" {
+		t.Errorf("expected the changes back, but %+v", decoded)
+	}
+	if Encode(nil) != "[]" || Decode("broken") != nil {
+		t.Error("expected no changes for nothing and a broken entry")
+	}
+	if Format(zeroTime) != "" || Format(nil) != "" || Format(true) != "true" {
+		t.Error("expected unset values to be empty")
+	}
+}
@@ -5,16 +5,31 @@ import (
 	"log"
 
 	"github.com/alexedwards/scs/v2"
-	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/events"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
 	UseCache      bool
 	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	// EmailTemplates render the mails the app sends
+	EmailTemplates *emails.Templates
+	InfoLog        *log.Logger
+	ErrorLog       *log.Logger
+	InProduction   bool
+	Session        *scs.SessionManager
+	Payments       payments.PaymentGateway
+	// MailFrom is the sender address of the mails the app sends
+	MailFrom string
+	// BaseURL is the address the site is reached at, every link in a mail is built on it and never on the Host
+	// of a request, which the client controls
+	BaseURL string
+	// RealIPHeader is the header a proxy in front of the site puts the address of the client in, like
+	// X-Forwarded-For. Without one the site must be reached directly, every request counts for the address it
+	// came from
+	RealIPHeader string
+	// Events passes on what happens in the app to whoever subscribed
+	Events *events.Bus
 }
@@ -0,0 +1,177 @@
+// Package emails renders the mails the app sends from the templates in templates/email. Every mail has an html
+// and a plain text version, each in the shared layout of its kind, and its subject is the "subject" block of
+// the text version
+package emails
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+)
+
+// names of the mails
+const (
+	Confirmation  = "confirmation"
+	Modification  = "modification"
+	Cancellation  = "cancellation"
+	Reminder      = "reminder"
+	PasswordReset = "password-reset"
+	// CheckIn and ThankYou go to the guests on a schedule like the Reminder
+	CheckIn  = "check-in"
+	ThankYou = "thank-you"
+	// NewReservation and NotificationDigest are the notifications of the staff
+	NewReservation     = "new-reservation"
+	NotificationDigest = "notification-digest"
+)
+
+// Names are the mails there are templates for, in the order they are listed in the admin tool
+var Names = []string{Confirmation, Modification, Cancellation, Reminder, CheckIn, ThankYou, PasswordReset,
+	NewReservation, NotificationDigest}
+
+// Brand is who the mails are from, shown in the header and footer of the layout
+type Brand struct {
+	Name    string
+	Address []string
+}
+
+// defaultBrandName is the name in the mails while the business has none in the settings
+const defaultBrandName = "Bookings"
+
+// BrandOf returns the brand of the business name and address of the settings, the first line is the name
+func BrandOf(issuer string) Brand {
+	var lines []string
+	for _, l := range strings.Split(issuer, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) == 0 {
+		return Brand{Name: defaultBrandName}
+	}
+	return Brand{Name: lines[0], Address: lines[1:]}
+}
+
+// Data is what the templates show, each mail uses the fields it needs
+type Data struct {
+	Brand Brand
+	// Name is the first name of whom the mail is for
+	Name        string
+	Reservation models.Reservations
+	// Before is the reservation before it was modified
+	Before models.Reservations
+	// Link is where the guest manages their booking, the set password link of a user or the page of the admin
+	// tool a notification is about
+	Link string
+	// Cancellation is what cancelling cost and Refund what is paid back
+	Cancellation pricing.Cancellation
+	Refund       int
+	// Invite is set on the password mail of a new user
+	Invite bool
+	// Notifications are those of a digest, with absolute links
+	Notifications []models.Notification
+	// Instructions are what the guests need to know to check in, ReviewLink is where they review their stay
+	Instructions string
+	ReviewLink   string
+}
+
+// DatesChanged reports whether a modification moved the stay to other dates
+func (d Data) DatesChanged() bool {
+	return !d.Before.StartDate.Equal(d.Reservation.StartDate) || !d.Before.EndDate.Equal(d.Reservation.EndDate)
+}
+
+// Message is a rendered mail
+type Message struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// functions of the templates, for both kinds
+var functions = map[string]interface{}{
+	"money":  pricing.FormatMoney,
+	"date":   FormatDate,
+	"nights": Nights,
+	"lines":  Lines,
+}
+
+// FormatDate is how dates are written in the mails
+func FormatDate(t time.Time) string {
+	return t.Format("Monday, January 2, 2006")
+}
+
+// Nights is how many nights a stay from start to end has
+func Nights(start, end time.Time) int {
+	return int(end.Sub(start).Hours()+12) / 24
+}
+
+// Lines splits a text into its lines, the html templates put breaks between them
+func Lines(s string) []string {
+	return strings.Split(strings.Replace(strings.TrimSpace(s), "\r\n", "\n", -1), "\n")
+}
+
+// Templates are the parsed templates of all mails
+type Templates struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// Load parses the templates of all mails in dir, each mail is <name>.html.tmpl and <name>.txt.tmpl with the
+// layouts layout.html.tmpl and layout.txt.tmpl
+func Load(dir string) (*Templates, error) {
+	t := &Templates{
+		html: make(map[string]*htmltemplate.Template),
+		text: make(map[string]*texttemplate.Template),
+	}
+
+	for _, name := range Names {
+		h, err := htmltemplate.New("layout.html.tmpl").Funcs(functions).
+			ParseFiles(filepath.Join(dir, "layout.html.tmpl"), filepath.Join(dir, name+".html.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		txt, err := texttemplate.New("layout.txt.tmpl").Funcs(functions).
+			ParseFiles(filepath.Join(dir, "layout.txt.tmpl"), filepath.Join(dir, name+".txt.tmpl"))
+		if err != nil {
+			return nil, err
+		}
+		if txt.Lookup("subject") == nil {
+			return nil, fmt.Errorf("emails: %s.txt.tmpl has no subject", name)
+		}
+		t.html[name] = h
+		t.text[name] = txt
+	}
+	return t, nil
+}
+
+// Render returns the mail of a name with the data
+func (t *Templates) Render(name string, data Data) (Message, error) {
+	h, ok := t.html[name]
+	if !ok {
+		return Message{}, fmt.Errorf("emails: no template %q", name)
+	}
+	txt := t.text[name]
+
+	var subject, html, text bytes.Buffer
+	if err := txt.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := h.ExecuteTemplate(&html, "layout", data); err != nil {
+		return Message{}, err
+	}
+	if err := txt.ExecuteTemplate(&text, "layout", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
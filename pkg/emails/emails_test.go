@@ -0,0 +1,91 @@
+package emails
+
+import (
+	"strings"
+	"testing"
+)
+
+const pathToTemplates = "./../../templates/email"
+
+func TestRender(t *testing.T) {
+	templates, err := Load(pathToTemplates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	brand := BrandOf("Fort Smythe B&B\n1 Main Street\n")
+
+	var theTests = []struct {
+		name     string
+		subject  string
+		expected []string
+	}{
+		{Confirmation, "Reservation Confirmation", []string{"General's Quarters", "$310.00", "Promo code SUMMER", "/my-booking/sample-token"}},
+		{Modification, "Your reservation is changed", []string{"General's Quarters", "It was from", "/my-booking/sample-token"}},
+		{Cancellation, "Reservation Cancelled", []string{"costs 20% of the price, $62.00", "$248.00 is refunded"}},
+		{Reminder, "Your stay starts soon", []string{"General's Quarters", "/my-booking/sample-token"}},
+		{CheckIn, "How to check in", []string{"General's Quarters", "from 3 pm", "Parking is free", "/my-booking/sample-token"}},
+		{ThankYou, "Thank you for staying with us", []string{"Dear John", "https://example.com/review"}},
+		{PasswordReset, "Reset your password", []string{"Dear Jane", "/user/set-password/sample-token", "expires in 2 hours"}},
+		{NewReservation, "New reservation: John Smith", []string{"Hello Jane", "General's Quarters", "555-0100", "/admin/reservations/all/1/show"}},
+		{NotificationDigest, "2 new notifications", []string{"New reservation by John Smith", "Major's Suite", "/admin/notifications/2", "/admin/notifications"}},
+	}
+
+	for _, e := range theTests {
+		msg, err := templates.Render(e.name, Sample(e.name, brand))
+		if err != nil {
+			t.Fatalf("for %s: %v", e.name, err)
+		}
+		if msg.Subject != e.subject {
+			t.Errorf("for %s, expected subject %q, but %q", e.name, e.subject, msg.Subject)
+		}
+		for _, part := range []string{msg.Text, strings.Replace(msg.HTML, "&#39;", "'", -1)} {
+			for _, s := range append(e.expected, "Fort Smythe B&", "1 Main Street") {
+				if !strings.Contains(part, s) {
+					t.Errorf("for %s, expected %q in\n%s", e.name, s, part)
+				}
+			}
+		}
+		if strings.Contains(msg.Text, "<") {
+			t.Errorf("for %s, expected no html in the text part\n%s", e.name, msg.Text)
+		}
+	}
+}
+
+func TestRenderEscapes(t *testing.T) {
+	templates, err := Load(pathToTemplates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := Sample(Confirmation, BrandOf(""))
+	data.Name = "<script>alert(1)</script>"
+	msg, err := templates.Render(Confirmation, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(msg.HTML, "<script>") {
+		t.Error("expected the guest's name to be escaped in the html part")
+	}
+	if !strings.Contains(msg.Text, "Dear <script>") {
+		t.Error("expected the guest's name as it is in the text part")
+	}
+	if !strings.Contains(msg.HTML, defaultBrandName) {
+		t.Error("expected the default brand without a business name")
+	}
+
+	invite := Sample(PasswordReset, BrandOf(""))
+	invite.Invite = true
+	if msg, _ := templates.Render(PasswordReset, invite); msg.Subject != "You are invited to the bookings admin tool" {
+		t.Errorf("unexpected subject of the invite %q", msg.Subject)
+	}
+
+	if _, err := templates.Render("newsletter", data); err == nil {
+		t.Error("expected an error for an unknown mail")
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	if _, err := Load("./no-such-dir"); err == nil {
+		t.Error("expected an error without templates")
+	}
+}
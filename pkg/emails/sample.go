@@ -0,0 +1,79 @@
+package emails
+
+import (
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+)
+
+// Sample returns made up data to preview the mail of a name with
+func Sample(name string, brand Brand) Data {
+	start := time.Now().AddDate(0, 1, 0).Truncate(24 * time.Hour)
+	reservation := models.Reservations{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@example.com",
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 3),
+		Guests:    2,
+		Room:      models.Room{RoomName: "General's Quarters"},
+		Quote: models.Quote{
+			Nights:          make([]models.NightPrice, 3),
+			Subtotal:        36000,
+			DiscountPercent: 10,
+			Discount:        3600,
+			PromoCode:       "SUMMER",
+			PromoDiscount:   2000,
+			Charges:         []models.Charge{{Name: "City tax", Amount: 600}},
+			Total:           31000,
+		},
+	}
+
+	data := Data{
+		Brand:       brand,
+		Name:        reservation.FirstName,
+		Reservation: reservation,
+		Before:      reservation,
+		Link:        "https://example.com/my-booking/sample-token",
+	}
+
+	switch name {
+	case Modification:
+		data.Before.StartDate = start.AddDate(0, 0, -7)
+		data.Before.EndDate = data.Before.StartDate.AddDate(0, 0, 3)
+	case Cancellation:
+		data.Cancellation = pricing.Cancellation{DaysBefore: 10, Percent: 20, Fee: 6200}
+		data.Refund = 24800
+	case CheckIn:
+		data.Instructions = "Check-in is from 3 pm at the front desk.\nParking is free in the lot behind the house."
+	case ThankYou:
+		data.ReviewLink = "https://example.com/review"
+	case PasswordReset:
+		data.Name = "Jane"
+		data.Link = "https://example.com/user/set-password/sample-token"
+	case NewReservation:
+		data.Name = "Jane"
+		data.Reservation.Phone = "555-0100"
+		data.Link = "https://example.com/admin/reservations/all/1/show"
+	case NotificationDigest:
+		data.Name = "Jane"
+		data.Link = "https://example.com/admin/notifications"
+		data.Notifications = []models.Notification{
+			{
+				Title:     "New reservation by John Smith",
+				Body:      "General's Quarters, " + start.Format("Jan 2") + " to " + start.AddDate(0, 0, 3).Format("Jan 2, 2006"),
+				Link:      "https://example.com/admin/notifications/1",
+				CreatedAt: time.Now().Add(-40 * time.Minute),
+			},
+			{
+				Title:     "New reservation by Mary Jones",
+				Body:      "Major's Suite, " + start.Format("Jan 2") + " to " + start.AddDate(0, 0, 1).Format("Jan 2, 2006"),
+				Link:      "https://example.com/admin/notifications/2",
+				CreatedAt: time.Now().Add(-10 * time.Minute),
+			},
+		}
+	}
+	return data
+}
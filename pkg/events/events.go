@@ -0,0 +1,65 @@
+// Package events passes on what happens in the app to whoever subscribed to it, so the code where something
+// happens does not need to know who is interested in it
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// names of the events
+const (
+	// ReservationCreated is published once a reservation is booked, on the site or through the API
+	ReservationCreated = "reservation.created"
+)
+
+// Event is something that happened, each event sets the fields it is about
+type Event struct {
+	Name        string
+	Reservation models.Reservations
+	At          time.Time
+}
+
+// Handler is called with the events it subscribed to
+type Handler func(Event)
+
+// Bus passes events on to the handlers subscribed to their name
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+// New returns a bus nobody subscribed to yet
+func New() *Bus {
+	return &Bus{handlers: make(map[string][]Handler)}
+}
+
+// Subscribe calls h with every event of a name that is published from now on
+func (b *Bus) Subscribe(name string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[name] = append(b.handlers[name], h)
+}
+
+// Publish calls the handlers subscribed to the name of the event one after the other, in the order they
+// subscribed, and returns when they are done. The time of the event is now unless it is set. A nil bus drops the
+// event
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+
+	b.mu.RLock()
+	handlers := b.handlers[e.Name]
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(e)
+	}
+}
@@ -0,0 +1,40 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestPublish(t *testing.T) {
+	bus := New()
+
+	var got []string
+	bus.Subscribe(ReservationCreated, func(e Event) {
+		got = append(got, "first "+e.Reservation.FirstName)
+		if e.At.IsZero() {
+			t.Error("expected the event to have a time")
+		}
+	})
+	bus.Subscribe(ReservationCreated, func(e Event) {
+		got = append(got, "second "+e.Reservation.FirstName)
+	})
+	bus.Subscribe("reservation.cancelled", func(e Event) {
+		got = append(got, "cancelled")
+	})
+
+	bus.Publish(Event{Name: ReservationCreated, Reservation: models.Reservations{FirstName: "John"}})
+	if len(got) != 2 || got[0] != "first John" || got[1] != "second John" {
+		t.Errorf("expected both handlers of the event in order, but %v", got)
+	}
+
+	bus.Publish(Event{Name: "room.created"})
+	if len(got) != 2 {
+		t.Errorf("expected an event nobody subscribed to to be dropped, but %v", got)
+	}
+}
+
+func TestPublishNilBus(t *testing.T) {
+	var bus *Bus
+	bus.Publish(Event{Name: ReservationCreated})
+}
@@ -4,11 +4,15 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
 )
 
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
 type Form struct{
 	url.Values
 	Error errors
@@ -64,4 +68,18 @@ func (f *Form) IsEmail(field string, r *http.Request){
 	if !govalidator.IsEmail(r.Form.Get(field)){
 		f.Error.Add(field, "Invalid e-mail address")
 	}
-}
\ No newline at end of file
+}
+// IsSlug checks that a field only holds lower case letters, digits and dashes, so it can be used in a url
+func (f *Form) IsSlug(field string, r *http.Request){
+	if !slugPattern.MatchString(r.Form.Get(field)){
+		f.Error.Add(field, "Only lower case letters, numbers and dashes are allowed")
+	}
+}
+
+// IsNumber checks that a field is a whole number of at least min
+func (f *Form) IsNumber(field string, min int, r *http.Request){
+	n, err := strconv.Atoi(r.Form.Get(field))
+	if err != nil || n < min {
+		f.Error.Add(field, fmt.Sprintf("This field needs to be a number of at least %d", min))
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// RequirePermission lets a logged in user through only when their access level has permission,
+// it runs after Auth and reads the access level from the database so changes apply right away.
+// Users who have to use two-factor authentication are sent to set it up first.
+func (m *Repository) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, ok := m.sessionUser(w, r)
+			if !ok {
+				return
+			}
+			// keep the session in step, the admin layout hides actions by it
+			m.App.Session.Put(r.Context(), "access_level", u.AccessLevel)
+
+			if !u.TwoFactorEnabled() {
+				required, err := m.requiresTwoFactor(u)
+				if err != nil {
+					helpers.ServerError(w, err)
+					return
+				}
+				if required {
+					m.App.Session.Put(r.Context(), "warning", "Please set up two-factor authentication first")
+					http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+					return
+				}
+			}
+
+			if !models.Can(u.AccessLevel, permission) {
+				m.App.Session.Put(r.Context(), "error", "You are not allowed to do that")
+				http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireUser lets a logged in user through whatever their access level, for the pages every user manages
+// themselves. It runs after Auth
+func (m *Repository) RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := m.sessionUser(w, r); !ok {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sessionUser returns the logged in user. A deleted or disabled user and a session that started before the
+// password was last changed are logged out and sent to the login page
+func (m *Repository) sessionUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	u, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil || u.Disabled() || m.App.Session.GetTime(r.Context(), "logged_in_at").Before(u.PasswordChangedAt) {
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+		_ = m.App.Session.Destroy(r.Context())
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return u, false
+	}
+	return u, true
+}
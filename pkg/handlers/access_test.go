@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestPermissionMatrix(t *testing.T) {
+	var theTests = []struct {
+		accessLevel int
+		permission  string
+		expected    bool
+	}{
+		{models.AccessViewer, models.PermViewReservations, true},
+		{models.AccessViewer, models.PermEditReservations, false},
+		{models.AccessFrontDesk, models.PermEditCalendar, true},
+		{models.AccessFrontDesk, models.PermDeleteReservations, false},
+		{models.AccessManager, models.PermManageRooms, true},
+		{models.AccessFrontDesk, models.PermManagePromotions, false},
+		{models.AccessManager, models.PermManagePromotions, true},
+		{models.AccessFrontDesk, models.PermManagePayments, false},
+		{models.AccessManager, models.PermManagePayments, true},
+		{models.AccessFrontDesk, models.PermManageTaxes, false},
+		{models.AccessManager, models.PermManageTaxes, true},
+		{models.AccessFrontDesk, models.PermViewAudit, false},
+		{models.AccessManager, models.PermViewAudit, true},
+		{models.AccessManager, models.PermManageMail, false},
+		{models.AccessOwner, models.PermManageMail, true},
+		{models.AccessManager, models.PermManageAPIKeys, false},
+		{models.AccessOwner, models.PermManageAPIKeys, true},
+		{models.AccessOwner, "no-such-permission", false},
+		{0, models.PermViewReservations, false},
+	}
+
+	for _, e := range theTests {
+		if models.Can(e.accessLevel, e.permission) != e.expected {
+			t.Errorf("for level %d and %s, expected %t", e.accessLevel, e.permission, e.expected)
+		}
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	getRoutes() // sets up the session and the repository
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	var theTests = []struct {
+		name               string
+		userID             int
+		permission         string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		// user 1 is the demo owner of the memory repository
+		{"owner", 1, models.PermManageAPIKeys, http.StatusOK, ""},
+		{"unknown-permission", 1, "no-such-permission", http.StatusSeeOther, "/admin/dashboard"},
+		{"unknown-user", 99, models.PermViewReservations, http.StatusSeeOther, "/user/login"},
+	}
+
+	for _, e := range theTests {
+		req := httptest.NewRequest("GET", "/admin/something", nil)
+		ctx, _ := session.Load(req.Context(), "")
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", e.userID)
+
+		rr := httptest.NewRecorder()
+		Repo.RequirePermission(e.permission)(ok).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d, but %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected redirect to %s, but %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
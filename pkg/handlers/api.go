@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+	"github.com/go-chi/chi"
+)
+
+// maxAPIBodySize limits the size of a JSON request body
+const maxAPIBodySize = 1 << 20
+
+// apiEnvelope wraps every API response, exactly one of Data and Error is set
+type apiEnvelope struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error *apiError   `json:"error,omitempty"`
+}
+
+// apiError describes what went wrong, Fields holds validation messages per field
+type apiError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// apiRoom is a room as the API shows it
+type apiRoom struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	Capacity    int    `json:"capacity"`
+}
+
+// apiAvailability is the answer to an availability query
+type apiAvailability struct {
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	Available bool      `json:"available"`
+	Rooms     []apiRoom `json:"rooms"`
+}
+
+// apiReservation is a reservation as the API shows it
+type apiReservation struct {
+	ID        int    `json:"id"`
+	Token     string `json:"token"`
+	RoomID    int    `json:"room_id"`
+	RoomName  string `json:"room_name"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	Guests    int    `json:"guests"`
+	Status    string `json:"status"`
+	// Total is the price of the stay in cents, with its taxes and fees
+	Total int `json:"total"`
+	// CancelledAt is left out until the reservation is cancelled, CancellationFee is in cents
+	CancelledAt     string `json:"cancelled_at,omitempty"`
+	CancellationFee int    `json:"cancellation_fee"`
+}
+
+// apiReservationRequest is the body of a create reservation request
+type apiReservationRequest struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	// Guests is 1 when it is left out
+	Guests int `json:"guests"`
+}
+
+func toAPIRoom(room models.Room) apiRoom {
+	return apiRoom{
+		ID:          room.ID,
+		Name:        room.RoomName,
+		Slug:        room.Slug,
+		Description: room.Description,
+		Capacity:    room.Capacity,
+	}
+}
+
+func toAPIReservation(res models.Reservations) apiReservation {
+	out := apiReservation{
+		ID:        res.ID,
+		Token:     res.AccessToken,
+		RoomID:    res.RoomID,
+		RoomName:  res.Room.RoomName,
+		StartDate: res.StartDate.Format("2006-01-02"),
+		EndDate:   res.EndDate.Format("2006-01-02"),
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+		Guests:    res.Guests,
+		Total:     res.Quote.Total,
+
+		Status:          res.Status,
+		CancellationFee: res.CancellationFee,
+	}
+	if res.Cancelled() {
+		out.CancelledAt = res.CancelledAt.Format(time.RFC3339)
+	}
+	return out
+}
+
+// writeJSON sends data wrapped in the API envelope
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	out, err := json.Marshal(apiEnvelope{Data: data})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// writeJSONError sends an error wrapped in the API envelope
+func writeJSONError(w http.ResponseWriter, status int, code, message string, fields map[string]string) {
+	out, _ := json.Marshal(apiEnvelope{Error: &apiError{Code: code, Message: message, Fields: fields}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// writeJSONServerError logs err and sends a 500 without leaking details
+func (m *Repository) writeJSONServerError(w http.ResponseWriter, err error) {
+	m.App.ErrorLog.Println(err)
+	writeJSONError(w, http.StatusInternalServerError, "server_error", "Something went wrong, please try again later", nil)
+}
+
+// parseStay parses arrival and departure dates, departure must be after arrival
+func parseStay(start, end string) (time.Time, time.Time, error) {
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, start)
+	if err != nil {
+		return startDate, startDate, errors.New("start date must look like 2006-01-02")
+	}
+	endDate, err := time.Parse(layout, end)
+	if err != nil {
+		return startDate, endDate, errors.New("end date must look like 2006-01-02")
+	}
+	if !endDate.After(startDate) {
+		return startDate, endDate, errors.New("end date must be after start date")
+	}
+	return startDate, endDate, nil
+}
+
+// APINotFound answers unknown API routes
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusNotFound, "not_found", "No such endpoint", nil)
+}
+
+// APIMethodNotAllowed answers known API routes called with the wrong method
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed", nil)
+}
+
+// APIRooms lists the bookable rooms
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllActiveRooms()
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	out := []apiRoom{}
+	for _, room := range rooms {
+		out = append(out, toAPIRoom(room))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// APIAvailability lists the rooms free for ?start=&end=, or checks a single room with &room_id=
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	startDate, endDate, err := parseStay(r.URL.Query().Get("start"), r.URL.Query().Get("end"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_dates", err.Error(), nil)
+		return
+	}
+
+	result := apiAvailability{
+		StartDate: startDate.Format("2006-01-02"),
+		EndDate:   endDate.Format("2006-01-02"),
+		Rooms:     []apiRoom{},
+	}
+
+	if r.URL.Query().Get("room_id") != "" {
+		roomID, err := strconv.Atoi(r.URL.Query().Get("room_id"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_room", "room_id must be a number", nil)
+			return
+		}
+		room, err := m.DB.GetRoomByID(roomID)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+			writeJSONError(w, http.StatusNotFound, "room_not_found", "No such room", nil)
+			return
+		}
+		if err != nil {
+			m.writeJSONServerError(w, err)
+			return
+		}
+
+		available, err := m.DB.SearchAvailabilityByDatesAndRoomID(startDate, endDate, roomID)
+		if err != nil {
+			m.writeJSONServerError(w, err)
+			return
+		}
+		if available {
+			result.Rooms = append(result.Rooms, toAPIRoom(room))
+		}
+	} else {
+		rooms, err := m.DB.SearchAvailibilityForAllRooms(startDate, endDate)
+		if err != nil {
+			m.writeJSONServerError(w, err)
+			return
+		}
+		for _, room := range rooms {
+			result.Rooms = append(result.Rooms, toAPIRoom(room))
+		}
+	}
+
+	result.Available = len(result.Rooms) > 0
+	writeJSON(w, http.StatusOK, result)
+}
+
+// APICreateReservation books a room, the response holds the guest token of the reservation
+func (m *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	var req apiReservationRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_json", "The request body is not valid JSON", nil)
+		return
+	}
+
+	fields := make(map[string]string)
+	if strings.TrimSpace(req.FirstName) == "" {
+		fields["first_name"] = "This field can not be empty."
+	}
+	if strings.TrimSpace(req.LastName) == "" {
+		fields["last_name"] = "This field can not be empty."
+	}
+	if !govalidator.IsEmail(req.Email) {
+		fields["email"] = "Invalid e-mail address"
+	}
+	if req.Guests == 0 {
+		req.Guests = 1
+	}
+	if req.Guests < 1 || req.Guests > maxGuests {
+		fields["guests"] = "guests has to be from 1 to " + strconv.Itoa(maxGuests)
+	}
+	startDate, endDate, err := parseStay(req.StartDate, req.EndDate)
+	if err != nil {
+		fields["dates"] = err.Error()
+	} else if startDate.Before(time.Now().Truncate(24 * time.Hour)) {
+		fields["dates"] = "start date can not be in the past"
+	}
+
+	room, err := m.DB.GetRoomByID(req.RoomID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		fields["room_id"] = "No such room"
+	} else if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	if len(fields) > 0 {
+		writeJSONError(w, http.StatusUnprocessableEntity, "validation_failed", "The reservation is not valid", fields)
+		return
+	}
+
+	reservation := models.Reservations{
+		FirstName: strings.TrimSpace(req.FirstName),
+		LastName:  strings.TrimSpace(req.LastName),
+		Email:     req.Email,
+		Phone:     req.Phone,
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    room.ID,
+		Room:      room,
+		Guests:    req.Guests,
+	}
+	reservation.Quote, err = m.quoteStay(room, startDate, endDate)
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+	reservation.Quote, err = m.addCharges(reservation.Quote, reservation.Guests)
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+	reservation.AccessToken, err = helpers.GenerateToken()
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	confirmation := m.heldConfirmation(reservation)
+	reservation.ID, err = m.DB.BookReservation(reservation, confirmation)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		writeJSONError(w, http.StatusConflict, "room_not_available", "The room is not available for these dates", nil)
+		return
+	}
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	m.reservationCreated(r, reservation)
+	if confirmation != nil {
+		m.sendConfirmation(reservation, confirmation.ID)
+	}
+
+	w.Header().Set("Location", "/api/v1/reservations/"+reservation.AccessToken)
+	writeJSON(w, http.StatusCreated, toAPIReservation(reservation))
+}
+
+// reservationFromToken looks up the reservation of the token in the url, it answers 404 itself
+func (m *Repository) reservationFromToken(w http.ResponseWriter, r *http.Request) (models.Reservations, bool) {
+	res, err := m.DB.GetReservationByAccessToken(chi.URLParam(r, "token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "reservation_not_found", "No such reservation", nil)
+		return res, false
+	}
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return res, false
+	}
+	return res, true
+}
+
+// APIGetReservation shows the reservation of a guest token
+func (m *Repository) APIGetReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromToken(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIReservation(res))
+}
+
+// APICancelReservation cancels the reservation of a guest token with the fee of the room's cancellation policy
+// and frees the room
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromToken(w, r)
+	if !ok {
+		return
+	}
+	if res.Cancelled() {
+		writeJSONError(w, http.StatusConflict, "reservation_cancelled", "The reservation is cancelled already", nil)
+		return
+	}
+	if !res.CanBecome(models.ReservationCancelled) {
+		writeJSONError(w, http.StatusConflict, "invalid_status", "The reservation can not be cancelled once the stay has started", nil)
+		return
+	}
+
+	_, err := m.cancelReservation(r, res)
+	if err != nil {
+		m.writeJSONServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// testAPIKey stores a new api key with scopes and returns it
+func testAPIKey(t *testing.T, scopes ...string) string {
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Repo.DB.InsertAPIKey(models.APIKey{
+		Name:    "test",
+		Prefix:  prefix,
+		KeyHash: helpers.HashToken(key),
+		Scopes:  scopes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestAPI(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := testServer.Client()
+
+	key := testAPIKey(t, models.ScopeReadAvailability, models.ScopeWriteReservations)
+	readOnly := testAPIKey(t, models.ScopeReadAvailability)
+
+	start := time.Now().AddDate(0, 1, 0).Format("2006-01-02")
+	end := time.Now().AddDate(0, 1, 3).Format("2006-01-02")
+	body := `{"room_id":2,"start_date":"` + start + `","end_date":"` + end + `","first_name":"John","last_name":"Mayor","email":"john@mail.com"}`
+
+	// the guest token of the reservation created below
+	var created struct {
+		Data struct {
+			Token string `json:"token"`
+			Total int    `json:"total"`
+		} `json:"data"`
+	}
+
+	var theTests = []struct {
+		name               string
+		method             string
+		url                string
+		body               string
+		key                string
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{"rooms", "GET", "/api/v1/rooms", "", key, http.StatusOK, ""},
+		{"availability", "GET", "/api/v1/availability?start=" + start + "&end=" + end, "", key, http.StatusOK, ""},
+		{"availability-room", "GET", "/api/v1/availability?start=" + start + "&end=" + end + "&room_id=2", "", key, http.StatusOK, ""},
+		{"availability-bad-dates", "GET", "/api/v1/availability?start=" + end + "&end=" + start, "", key, http.StatusBadRequest, "invalid_dates"},
+		{"availability-no-room", "GET", "/api/v1/availability?start=" + start + "&end=" + end + "&room_id=99", "", key, http.StatusNotFound, "room_not_found"},
+		{"create", "POST", "/api/v1/reservations", body, key, http.StatusCreated, ""},
+		{"create-again", "POST", "/api/v1/reservations", body, key, http.StatusConflict, "room_not_available"},
+		{"create-invalid", "POST", "/api/v1/reservations", `{"room_id":2}`, key, http.StatusUnprocessableEntity, "validation_failed"},
+		{"create-bad-json", "POST", "/api/v1/reservations", `{"room_id":`, key, http.StatusBadRequest, "invalid_json"},
+		{"get", "GET", "/api/v1/reservations/{token}", "", key, http.StatusOK, ""},
+		{"cancel", "DELETE", "/api/v1/reservations/{token}", "", key, http.StatusNoContent, ""},
+		{"get-cancelled", "GET", "/api/v1/reservations/{token}", "", key, http.StatusOK, ""},
+		{"cancel-again", "DELETE", "/api/v1/reservations/{token}", "", key, http.StatusConflict, "reservation_cancelled"},
+		{"unknown", "GET", "/api/v1/nothing", "", key, http.StatusNotFound, "not_found"},
+		{"no-key", "GET", "/api/v1/rooms", "", "", http.StatusUnauthorized, "unauthorized"},
+		{"bad-key", "GET", "/api/v1/rooms", "", "bk_00000000_nothing", http.StatusUnauthorized, "unauthorized"},
+		{"missing-scope", "POST", "/api/v1/reservations", body, readOnly, http.StatusForbidden, "forbidden"},
+	}
+
+	for _, e := range theTests {
+		url := strings.Replace(e.url, "{token}", created.Data.Token, 1)
+		req, _ := http.NewRequest(e.method, testServer.URL+url, strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/json")
+		if e.key != "" {
+			req.Header.Set("Authorization", "Bearer "+e.key)
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d, but %d", e.name, e.expectedStatusCode, res.StatusCode)
+		}
+
+		if e.name == "create" {
+			json.NewDecoder(res.Body).Decode(&created)
+			if created.Data.Token == "" {
+				t.Error("no guest token in the created reservation")
+			}
+			// three nights of the majors suite at its weekday or weekend rate
+			if created.Data.Total < 3*12900 || created.Data.Total > 3*14900 {
+				t.Errorf("unexpected total %d of the created reservation", created.Data.Total)
+			}
+		} else if e.expectedCode != "" {
+			var envelope apiEnvelope
+			json.NewDecoder(res.Body).Decode(&envelope)
+			if envelope.Error == nil || envelope.Error.Code != e.expectedCode {
+				t.Errorf("for %s, expected error code %s, but %+v", e.name, e.expectedCode, envelope.Error)
+			}
+		}
+		res.Body.Close()
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/go-chi/chi"
+)
+
+// apiKeyPrefix starts every api key, so keys are easy to spot in logs and code
+const apiKeyPrefix = "bk"
+
+// lastUsedResolution is how stale last_used_at may get before it is written again
+const lastUsedResolution = time.Minute
+
+// newAPIKey generates a key, formatted bk_<prefix>_<secret>; the prefix is stored in clear to find the key
+func newAPIKey() (key, prefix string, err error) {
+	b := make([]byte, 4)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(b)
+
+	secret, err := helpers.GenerateToken()
+	if err != nil {
+		return "", "", err
+	}
+	return apiKeyPrefix + "_" + prefix + "_" + secret, prefix, nil
+}
+
+// APIAuth authenticates machine clients by the "Authorization: Bearer <api key>" header
+// and puts their api key into the request context
+func (m *Repository) APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized", "An api key is required", nil)
+			return
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+
+		parts := strings.SplitN(token, "_", 3)
+		if len(parts) != 3 || parts[0] != apiKeyPrefix {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized", "The api key is not valid", nil)
+			return
+		}
+
+		k, err := m.DB.GetAPIKeyByPrefix(parts[1])
+		if err != nil || subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(helpers.HashToken(token))) != 1 || !k.Usable() {
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized", "The api key is not valid", nil)
+			return
+		}
+
+		if time.Since(k.LastUsedAt) > lastUsedResolution {
+			if err := m.DB.UpdateLastUsedForAPIKey(k.ID, time.Now()); err != nil {
+				m.App.ErrorLog.Println(err)
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithAPIKey(r.Context(), k)))
+	})
+}
+
+// RequireScope lets a request through only when its api key has scope, it runs after APIAuth
+func (m *Repository) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k, ok := helpers.APIKeyFromContext(r.Context())
+			if !ok {
+				writeJSONError(w, http.StatusUnauthorized, "unauthorized", "An api key is required", nil)
+				return
+			}
+			if !k.HasScope(scope) {
+				writeJSONError(w, http.StatusForbidden, "forbidden", "The api key lacks the "+scope+" scope", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AdminAPIKeys lists the api keys, right after creating one it also shows the new key once
+func (m *Repository) AdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := m.DB.AllAPIKeys()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["keys"] = keys
+	data["scopes"] = models.APIScopes
+
+	stringMap := make(map[string]string)
+	stringMap["new_key"] = m.App.Session.PopString(r.Context(), "new_api_key")
+
+	render.RenderTemplate(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostAPIKey creates an api key
+func (m *Repository) AdminPostAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	var scopes []string
+	for _, scope := range models.APIScopes {
+		if r.Form.Get("scope_"+scope) != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		form.Error.Add("scopes", "Choose at least one scope")
+	}
+
+	var expiresAt time.Time
+	if r.Form.Get("expires_in_days") != "" {
+		form.IsNumber("expires_in_days", 1, r)
+		days, _ := strconv.Atoi(r.Form.Get("expires_in_days"))
+		expiresAt = time.Now().AddDate(0, 0, days)
+	}
+
+	if !form.Valid() {
+		keys, err := m.DB.AllAPIKeys()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data := make(map[string]interface{})
+		data["keys"] = keys
+		data["scopes"] = models.APIScopes
+		render.RenderTemplate(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	apiKey := models.APIKey{
+		Name:      strings.TrimSpace(r.Form.Get("name")),
+		Prefix:    prefix,
+		KeyHash:   helpers.HashToken(key),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		UserID:    m.App.Session.GetInt(r.Context(), "user_id"),
+	}
+	apiKey.ID, err = m.DB.InsertAPIKey(apiKey)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditCreate, models.AuditAPIKey, apiKey.ID, nil, apiKey)
+
+	// only the hash is stored, so this is the one chance to copy the key
+	m.App.Session.Put(r.Context(), "new_api_key", key)
+	m.App.Session.Put(r.Context(), "flash", "API key created!")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// AdminRevokeAPIKey revokes an api key
+func (m *Repository) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.RevokeAPIKey(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditRevoke, models.AuditAPIKey, id, nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "API key is revoked.")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/audit"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+)
+
+// actions of the audit log
+const (
+	auditCreate     = "create"
+	auditUpdate     = "update"
+	auditDelete     = "delete"
+	auditRestore    = "restore"
+	auditStatus     = "change-status"
+	auditCancel     = "cancel"
+	auditCapture    = "capture"
+	auditVoid       = "void"
+	auditRefund     = "refund"
+	auditActivate   = "activate"
+	auditDeactivate = "deactivate"
+	auditRevoke     = "revoke"
+	auditPassword   = "reset-password"
+	auditResend     = "resend"
+	// a password chosen through an invite or password reset link
+	auditSetPassword  = "set-password"
+	auditTwoFactorOn  = "enable-two-factor"
+	auditTwoFactorOff = "disable-two-factor"
+	auditUnlock       = "unlock"
+	// new recovery codes replace the old ones of a user
+	auditRegenerateCodes = "regenerate-codes"
+	// a reservation deleted for good when its time in the trash is up
+	auditPurge = "purge"
+)
+
+// auditActions are the actions offered in the search of the audit log
+var auditActions = []string{
+	auditCreate, auditUpdate, auditDelete, auditRestore, auditStatus, auditCancel, auditCapture, auditVoid,
+	auditRefund, auditActivate, auditDeactivate, auditRevoke, auditPassword, auditResend, auditSetPassword,
+	auditTwoFactorOn, auditTwoFactorOff, auditUnlock, auditRegenerateCodes, auditPurge,
+}
+
+// auditPageSize is how many entries of the audit log are shown at most
+const auditPageSize = 200
+
+// actorOf returns who makes the changes of a request, the logged in staff member or else a name for the guest
+// or the API client. API calls have no session
+func (m *Repository) actorOf(r *http.Request) (int, string) {
+	if k, ok := helpers.APIKeyFromContext(r.Context()); ok {
+		return 0, "api key " + k.Name
+	}
+	if userID := m.App.Session.GetInt(r.Context(), "user_id"); userID > 0 {
+		return userID, ""
+	}
+	return 0, "guest"
+}
+
+// audit adds a change made by a request to the audit log with what it did to the record. before is nil when the
+// record was created and after is nil when it was deleted. The change is already made, so a failure to log it is
+// only reported in the error log
+func (m *Repository) audit(r *http.Request, action, entity string, id int, before, after interface{}) {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		m.App.ErrorLog.Println("audit:", err)
+		return
+	}
+
+	userID, actor := m.actorOf(r)
+	m.insertAudit(models.AuditEntry{
+		UserID:    userID,
+		Actor:     actor,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  id,
+		Changes:   audit.Encode(changes),
+		IPAddress: m.clientIP(r),
+	})
+}
+
+// auditJob adds a change made by a background job to the audit log, the job is named as the actor
+func (m *Repository) auditJob(job, action, entity string, id int, before, after interface{}) {
+	changes, err := audit.Diff(before, after)
+	if err != nil {
+		m.App.ErrorLog.Println("audit:", err)
+		return
+	}
+
+	m.insertAudit(models.AuditEntry{
+		Actor:    job,
+		Action:   action,
+		Entity:   entity,
+		EntityID: id,
+		Changes:  audit.Encode(changes),
+	})
+}
+
+// insertAudit stores an entry of the audit log, a failure is only reported in the error log
+func (m *Repository) insertAudit(e models.AuditEntry) {
+	if err := m.DB.InsertAuditEntry(e); err != nil {
+		m.App.ErrorLog.Println("audit:", err)
+	}
+}
+
+// auditActive adds switching a record on or off to the audit log
+func (m *Repository) auditActive(r *http.Request, entity string, id int, active bool) {
+	action := auditDeactivate
+	if active {
+		action = auditActivate
+	}
+	m.audit(r, action, entity, id, map[string]bool{"Active": !active}, map[string]bool{"Active": active})
+}
+
+// auditFilterOf reads a search of the audit log from the query string
+func auditFilterOf(r *http.Request) models.AuditFilter {
+	q := r.URL.Query()
+	f := models.AuditFilter{
+		Entity: q.Get("entity"),
+		Action: q.Get("action"),
+		Query:  q.Get("q"),
+		Limit:  auditPageSize,
+	}
+	f.EntityID, _ = strconv.Atoi(q.Get("entity_id"))
+	f.UserID, _ = strconv.Atoi(q.Get("user_id"))
+	if from, err := time.Parse("2006-01-02", q.Get("from")); err == nil {
+		f.From = from
+	}
+	if until, err := time.Parse("2006-01-02", q.Get("until")); err == nil {
+		// the last day is part of the search
+		f.Until = until.AddDate(0, 0, 1)
+	}
+	return f
+}
+
+// AdminAudit shows the audit log, searched by the query string
+func (m *Repository) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	entries, err := m.DB.SearchAuditEntries(auditFilterOf(r))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	stringMap := make(map[string]string)
+	for _, key := range []string{"entity", "entity_id", "action", "user_id", "q", "from", "until"} {
+		stringMap[key] = q.Get(key)
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["entities"] = models.AuditEntities
+	data["actions"] = auditActions
+	data["users"] = users
+
+	render.RenderTemplate(w, r, "admin-audit.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
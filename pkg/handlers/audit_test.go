@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/audit"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestAuditLog(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	start := time.Now().AddDate(0, 5, 0).Truncate(24 * time.Hour)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 2))
+	postReservation(t, client, testServer, "john@mail.com", "")
+	if res := postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+	id := lastReservationID(t)
+
+	values := url.Values{}
+	values.Add("first_name", "Jane")
+	values.Add("last_name", "Smith")
+	values.Add("email", "john@mail.com")
+	values.Add("phone", "555-555-5555")
+	res, err := client.PostForm(testServer.URL+"/admin/reservations/all/"+strconv.Itoa(id), values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	entries, _ := Repo.DB.SearchAuditEntries(models.AuditFilter{Entity: models.AuditReservation, EntityID: id})
+	if len(entries) != 2 || entries[1].Action != auditCreate || entries[0].Action != auditUpdate {
+		t.Fatalf("expected the booking and the change, but %+v", entries)
+	}
+	if entries[1].Actor != "guest" || entries[0].IPAddress == "" {
+		t.Errorf("expected who made the changes and from where, but %+v", entries)
+	}
+
+	changes := audit.Decode(entries[0].Changes)
+	fields := make(map[string]audit.Change)
+	for _, c := range changes {
+		fields[c.Field] = c
+	}
+	if c, ok := fields["FirstName"]; !ok || c.From != "John" || c.To != "Jane" {
+		t.Errorf("expected the first name from John to Jane, but %+v", changes)
+	}
+	if _, ok := fields["Email"]; ok {
+		t.Errorf("expected the unchanged email left out, but %+v", changes)
+	}
+	if _, ok := fields["AccessToken"]; ok {
+		t.Error("expected the access token never to be logged")
+	}
+
+	for _, path := range []string{"/admin/audit?entity=reservation&q=Jane", "/admin/reservations/all/" + strconv.Itoa(id) + "/show"} {
+		res, err := client.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("for %s, expected 200, but %d", path, res.StatusCode)
+		}
+	}
+
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+	"github.com/go-chi/chi"
+)
+
+// cancellationTerms is what cancelling a reservation now costs and how much of the payment goes back
+type cancellationTerms struct {
+	pricing.Cancellation
+	Refund int
+}
+
+// cancellationTermsOf works out the fee of cancelling a reservation now with its room's policy, and the refund of
+// what was paid
+func (m *Repository) cancellationTermsOf(reservation models.Reservations) (cancellationTerms, error) {
+	rules, err := m.DB.CancellationRulesForRoom(reservation.RoomID)
+	if err != nil {
+		return cancellationTerms{}, err
+	}
+	ledger, err := m.DB.PaymentsForReservation(reservation.ID)
+	if err != nil {
+		return cancellationTerms{}, err
+	}
+
+	terms := cancellationTerms{
+		Cancellation: pricing.Cancel(reservation.Quote.Total, reservation.StartDate, time.Now(), rules),
+	}
+	if paid := payments.Summarize(ledger).Refundable(); paid > terms.Fee {
+		terms.Refund = paid - terms.Fee
+	}
+	return terms, nil
+}
+
+// cancelReservation cancels a reservation with the fee of its room's policy, frees the room, settles the payment
+// and mails the guest. The reservation is cancelled even when the payment can not be settled, that is logged
+// on its ledger for the staff. It is cancelled by whoever makes the request, the staff, the guest or an API client
+func (m *Repository) cancelReservation(r *http.Request, reservation models.Reservations) (cancellationTerms, error) {
+	terms, err := m.cancellationTermsOf(reservation)
+	if err != nil {
+		return terms, err
+	}
+
+	userID, _ := m.actorOf(r)
+	err = m.DB.CancelReservation(reservation.ID, terms.Fee, userID)
+	if err != nil {
+		return terms, err
+	}
+	cancelled := reservation
+	cancelled.Status = models.ReservationCancelled
+	cancelled.CancellationFee = terms.Fee
+	m.audit(r, auditCancel, models.AuditReservation, reservation.ID, reservation, cancelled)
+
+	ledger, err := m.DB.PaymentsForReservation(reservation.ID)
+	if err != nil {
+		return terms, err
+	}
+	summary := payments.Summarize(ledger)
+
+	switch {
+	case summary.CanCapture() && terms.Fee > 0:
+		// only the fee is taken of a payment that was never captured
+		reference, err := m.App.Payments.Capture(summary.Authorization, terms.Fee)
+		m.recordPayment(models.Payment{
+			ReservationID: reservation.ID,
+			Kind:          models.PaymentCapture,
+			Amount:        terms.Fee,
+			Reference:     reference,
+			Succeeded:     err == nil,
+			Message:       paymentMessage(err),
+		})
+	case summary.CanCapture():
+		err := m.App.Payments.Void(summary.Authorization)
+		m.recordPayment(models.Payment{
+			ReservationID: reservation.ID,
+			Kind:          models.PaymentVoid,
+			Amount:        summary.Authorized,
+			Reference:     summary.Authorization,
+			Succeeded:     err == nil,
+			Message:       paymentMessage(err),
+		})
+	case terms.Refund > 0:
+		reference, err := m.App.Payments.Refund(summary.Capture, terms.Refund)
+		m.recordPayment(models.Payment{
+			ReservationID: reservation.ID,
+			Kind:          models.PaymentRefund,
+			Amount:        terms.Refund,
+			Reference:     reference,
+			Succeeded:     err == nil,
+			Message:       paymentMessage(err),
+		})
+		if err != nil {
+			terms.Refund = 0
+		}
+	}
+
+	m.sendMail(emails.Cancellation, reservation.Email, emails.Data{
+		Name:         reservation.FirstName,
+		Reservation:  reservation,
+		Cancellation: terms.Cancellation,
+		Refund:       terms.Refund,
+	})
+	return terms, nil
+}
+
+// AdminCancelReservation cancels a reservation with the fee of its room's cancellation policy
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	reservation, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !reservation.CanBecome(models.ReservationCancelled) {
+		m.App.Session.Put(r.Context(), "error", "The reservation can not be cancelled from its status")
+		backToReservation(w, r, id)
+		return
+	}
+
+	terms, err := m.cancelReservation(r, reservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	msg := "Reservation cancelled"
+	if terms.Fee > 0 {
+		msg += ", " + pricing.FormatMoney(terms.Fee) + " cancellation fee"
+	}
+	if terms.Refund > 0 {
+		msg += ", " + pricing.FormatMoney(terms.Refund) + " refunded"
+	}
+	m.App.Session.Put(r.Context(), "flash", msg)
+	backToReservation(w, r, id)
+}
+
+// AdminPostCancellationRule adds a rule to the cancellation policy of a room
+func (m *Repository) AdminPostCancellationRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("days_before", "penalty")
+	form.IsNumber("days_before", 1, r)
+	form.IsNumber("penalty", 1, r)
+
+	rule := models.CancellationRule{RoomID: room.ID}
+	rule.DaysBefore, _ = strconv.Atoi(r.Form.Get("days_before"))
+	rule.Percent, _ = strconv.Atoi(r.Form.Get("penalty"))
+	if rule.Percent > 100 {
+		form.Error.Add("penalty", "A fee can not be more than 100%")
+	}
+
+	if form.Valid() {
+		rules, err := m.DB.CancellationRulesForRoom(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		for _, other := range rules {
+			if other.DaysBefore == rule.DaysBefore {
+				form.Error.Add("days_before", fmt.Sprintf("There is a rule for %d days already", rule.DaysBefore))
+			}
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+
+	rule.ID, err = m.DB.InsertCancellationRule(rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditCreate, models.AuditCancellationRule, rule.ID, nil, rule)
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation rule added!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteCancellationRule deletes a rule of the cancellation policy of a room
+func (m *Repository) AdminDeleteCancellationRule(w http.ResponseWriter, r *http.Request) {
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "room_id"))
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	var deleted interface{}
+	rules, err := m.DB.CancellationRulesForRoom(roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	for _, rule := range rules {
+		if rule.ID == id {
+			deleted = rule
+		}
+	}
+
+	err = m.DB.DeleteCancellationRule(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditDelete, models.AuditCancellationRule, id, deleted, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation rule deleted.")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", roomID), http.StatusSeeOther)
+}
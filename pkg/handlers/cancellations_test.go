@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+)
+
+func TestCancellationPolicy(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	addRule := func(daysBefore, penalty string) {
+		values := url.Values{}
+		values.Add("days_before", daysBefore)
+		values.Add("penalty", penalty)
+		res, err := client.PostForm(testServer.URL+"/admin/rooms/1/cancellation-rules", values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	addRule("30", "100")
+	addRule("120", "20")
+	addRule("120", "50")
+	addRule("10", "101")
+
+	rules, _ := Repo.DB.CancellationRulesForRoom(1)
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, a rule for the same days and a fee over 100%% refused, but %+v", rules)
+	}
+
+	start := time.Now().AddDate(0, 2, 0).Truncate(24 * time.Hour)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 3))
+	postReservation(t, client, testServer, "john@mail.com", "")
+	if res := postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+
+	id := lastReservationID(t)
+	reservation, _ := Repo.DB.GetReservationByID(id)
+	fee := (reservation.Quote.Total*20 + 50) / 100
+
+	res, err := client.Get(testServer.URL + "/admin/cancel-reservation/all/" + strconv.Itoa(id) + "/do")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	reservation, err = Repo.DB.GetReservationByID(id)
+	if err != nil {
+		t.Fatal("expected the cancelled reservation to be kept")
+	}
+	if !reservation.Cancelled() || reservation.CancellationFee != fee {
+		t.Errorf("expected the reservation cancelled with a %d fee, but %v and %d", fee, reservation.CancelledAt, reservation.CancellationFee)
+	}
+
+	ledger, _ := Repo.DB.PaymentsForReservation(id)
+	last := ledger[len(ledger)-1]
+	if last.Kind != models.PaymentRefund || last.Amount != reservation.Quote.Total-fee {
+		t.Errorf("expected %d refunded, but %+v", reservation.Quote.Total-fee, last)
+	}
+	if summary := payments.Summarize(ledger); summary.State != payments.StatePartiallyRefunded {
+		t.Errorf("expected the fee to be kept, but %+v", summary)
+	}
+
+	if available, _ := Repo.DB.SearchAvailabilityByDatesAndRoomID(start, start.AddDate(0, 0, 3), 1); !available {
+		t.Error("expected the room to be free again")
+	}
+
+	msg := lastMail(t)
+	if msg.Subject != "Reservation Cancelled" || !strings.Contains(msg.Content, pricing.FormatMoney(fee)) {
+		t.Errorf("expected the cancellation mail with the fee, but %s", msg.Content)
+	}
+
+	// a cancelled reservation is cancelled once
+	res, err = client.Get(testServer.URL + "/admin/cancel-reservation/all/" + strconv.Itoa(id) + "/do")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if after, _ := Repo.DB.PaymentsForReservation(id); len(after) != len(ledger) {
+		t.Error("expected nothing to be refunded again")
+	}
+
+	for _, rule := range rules {
+		Repo.DB.DeleteCancellationRule(rule.ID)
+	}
+}
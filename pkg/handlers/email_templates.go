@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/go-chi/chi"
+)
+
+// previewMail renders the mail of the template in the url with sample data, it answers 404 itself for an
+// unknown template
+func (m *Repository) previewMail(w http.ResponseWriter, r *http.Request) (models.MailData, bool) {
+	name := chi.URLParam(r, "name")
+	known := false
+	for _, n := range emails.Names {
+		known = known || n == name
+	}
+	if !known {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.MailData{}, false
+	}
+
+	data := emails.Sample(name, emails.Brand{})
+	msg, err := m.mailFor(name, data.Reservation.Email, data)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return msg, false
+	}
+	return msg, true
+}
+
+// AdminEmailTemplates lists the mails the app sends with their subjects
+func (m *Repository) AdminEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	subjects := make(map[string]string)
+	for _, name := range emails.Names {
+		msg, err := m.App.EmailTemplates.Render(name, emails.Sample(name, emails.Brand{}))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		subjects[name] = msg.Subject
+	}
+
+	data := make(map[string]interface{})
+	data["names"] = emails.Names
+
+	render.RenderTemplate(w, r, "admin-email-templates.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: subjects,
+	})
+}
+
+// AdminShowEmailTemplate previews a mail with sample data, its html version is shown in a frame
+func (m *Repository) AdminShowEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	msg, ok := m.previewMail(w, r)
+	if !ok {
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["message"] = msg
+
+	render.RenderTemplate(w, r, "admin-email-template-show.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: map[string]string{"name": chi.URLParam(r, "name")},
+	})
+}
+
+// AdminEmailTemplateHTML writes the html version of a mail with sample data for the frame of its preview, it
+// is sandboxed so a template can not run scripts in the admin tool
+func (m *Repository) AdminEmailTemplateHTML(w http.ResponseWriter, r *http.Request) {
+	msg, ok := m.previewMail(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Write([]byte(msg.Content))
+}
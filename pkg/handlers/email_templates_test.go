@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestEmailTemplates(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	Repo.DB.UpdateSetting(models.SettingInvoiceIssuer, "Fort Smythe B&B\n1 Main Street")
+	defer Repo.DB.UpdateSetting(models.SettingInvoiceIssuer, "")
+
+	get := func(path string) (*http.Response, string) {
+		res, err := client.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	res, body := get("/admin/email-templates")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the list of templates, but %d", res.StatusCode)
+	}
+	for _, name := range emails.Names {
+		if !strings.Contains(body, "/admin/email-templates/"+name) {
+			t.Errorf("expected %s in the list", name)
+		}
+	}
+
+	res, body = get("/admin/email-templates/cancellation")
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, "Reservation Cancelled") || !strings.Contains(body, "is refunded to your card") {
+		t.Errorf("expected the preview of the cancellation, but %d", res.StatusCode)
+	}
+
+	res, body = get("/admin/email-templates/confirmation/html")
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, "Fort Smythe B&amp;B") {
+		t.Errorf("expected the html of the confirmation with the business name, but %d", res.StatusCode)
+	}
+	if res.Header.Get("Content-Security-Policy") != "sandbox" {
+		t.Error("expected the preview to be sandboxed")
+	}
+
+	if res, _ = get("/admin/email-templates/newsletter"); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected an unknown template to be not found, but %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+)
+
+// defaultGuestMailHour is the hour from which the guest mails of a day are sent while none is set
+const defaultGuestMailHour = 9
+
+// guestMailGraceDays is how many days after its day a mail after departure is still sent, for a server that was
+// down, without mailing every past guest when the mail is turned on
+const guestMailGraceDays = 7
+
+// guestMail is a mail the guests get on a schedule around their stay
+type guestMail struct {
+	// Name is the mail of the emails package, it is also the kind recorded for every reservation it went to
+	Name        string
+	Title       string
+	Description string
+	EnabledKey  string
+	DaysKey     string
+	DefaultDays int
+	MaxDays     int
+	// AfterDeparture counts the days after the departure, otherwise they are days before the arrival
+	AfterDeparture bool
+}
+
+// guestMails are the scheduled guest mails, in the order the admin tool lists them
+var guestMails = []guestMail{
+	{
+		Name:        emails.Reminder,
+		Title:       "Reminder",
+		Description: "Reminds the guests of their stay and how to change it.",
+		EnabledKey:  models.SettingReminderEnabled,
+		DaysKey:     models.SettingReminderDays,
+		DefaultDays: 3,
+		MaxDays:     60,
+	},
+	{
+		Name:        emails.CheckIn,
+		Title:       "Check-in instructions",
+		Description: "Tells the guests how to check in, 0 days sends it on the day they arrive.",
+		EnabledKey:  models.SettingCheckInEnabled,
+		DaysKey:     models.SettingCheckInDays,
+		DefaultDays: 0,
+		MaxDays:     7,
+	},
+	{
+		Name:           emails.ThankYou,
+		Title:          "Thank you",
+		Description:    "Thanks the guests for their stay and asks for a review when there is a review link.",
+		EnabledKey:     models.SettingThankYouEnabled,
+		DaysKey:        models.SettingThankYouDays,
+		DefaultDays:    1,
+		MaxDays:        30,
+		AfterDeparture: true,
+	},
+}
+
+// intSetting returns a setting that is a number between min and max, or def while it is not
+func (m *Repository) intSetting(key string, def, min, max int) int {
+	value, err := m.DB.GetSetting(key)
+	if err != nil {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return def
+	}
+	return n
+}
+
+// SendGuestMails queues the enabled guest mails that are due and returns how many were queued. Every mail is
+// recorded with its reservation, so running it again, after a restart or on another instance, sends nothing
+// twice. Nothing is sent before the hour of the settings
+func (m *Repository) SendGuestMails() (int, error) {
+	// the dates of the reservations are days in UTC, so the hour is one of UTC as well and a day of mails starts
+	// and ends at the same time on every server
+	now := time.Now().UTC()
+	if now.Hour() < m.intSetting(models.SettingGuestMailHour, defaultGuestMailHour, 0, 23) {
+		return 0, nil
+	}
+	today := now.Truncate(24 * time.Hour)
+
+	instructions, err := m.DB.GetSetting(models.SettingCheckInInstructions)
+	if err != nil {
+		return 0, err
+	}
+	reviewURL, err := m.DB.GetSetting(models.SettingReviewURL)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, gm := range guestMails {
+		if enabled, err := m.DB.GetSetting(gm.EnabledKey); err != nil || enabled != "true" {
+			continue
+		}
+		days := m.intSetting(gm.DaysKey, gm.DefaultDays, 0, gm.MaxDays)
+
+		// a mail before the arrival still goes to those who booked after its day
+		var reservations []models.Reservations
+		if gm.AfterDeparture {
+			due := today.AddDate(0, 0, -days)
+			reservations, err = m.DB.DeparturesWithoutGuestMail(gm.Name, due.AddDate(0, 0, -guestMailGraceDays), due)
+		} else {
+			reservations, err = m.DB.ArrivalsWithoutGuestMail(gm.Name, today, today.AddDate(0, 0, days))
+		}
+		if err != nil {
+			return queued, err
+		}
+
+		for _, res := range reservations {
+			msg, err := m.mailFor(gm.Name, res.Email, emails.Data{
+				Name:         res.FirstName,
+				Reservation:  res,
+				Link:         m.App.BaseURL + bookingPath(res),
+				Instructions: instructions,
+				ReviewLink:   reviewURL,
+			})
+			if err != nil {
+				return queued, err
+			}
+			sent, err := m.DB.QueueGuestMail(res.ID, gm.Name, models.OutboxMessage{MailData: msg})
+			if err != nil {
+				return queued, err
+			}
+			if sent {
+				queued++
+			}
+		}
+	}
+	return queued, nil
+}
+
+// guestMailKeys are the settings of the guest mails
+func guestMailKeys() []string {
+	keys := []string{models.SettingGuestMailHour, models.SettingCheckInInstructions, models.SettingReviewURL}
+	for _, gm := range guestMails {
+		keys = append(keys, gm.EnabledKey, gm.DaysKey)
+	}
+	return keys
+}
+
+// AdminGuestEmails shows the settings of the scheduled guest mails
+func (m *Repository) AdminGuestEmails(w http.ResponseWriter, r *http.Request) {
+	values := make(map[string]string)
+	for _, key := range guestMailKeys() {
+		value, err := m.DB.GetSetting(key)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		values[key] = value
+	}
+	for _, gm := range guestMails {
+		values[gm.DaysKey] = strconv.Itoa(m.intSetting(gm.DaysKey, gm.DefaultDays, 0, gm.MaxDays))
+	}
+	values[models.SettingGuestMailHour] = strconv.Itoa(m.intSetting(models.SettingGuestMailHour, defaultGuestMailHour, 0, 23))
+
+	m.renderAdminGuestEmails(w, r, values, forms.New(nil))
+}
+
+func (m *Repository) renderAdminGuestEmails(w http.ResponseWriter, r *http.Request, values map[string]string, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["guest_mails"] = guestMails
+
+	render.RenderTemplate(w, r, "admin-guest-emails.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: values,
+		Form:      form,
+	})
+}
+
+// AdminPostGuestEmails saves the settings of the scheduled guest mails
+func (m *Repository) AdminPostGuestEmails(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	values := map[string]string{
+		models.SettingGuestMailHour:       strings.TrimSpace(r.Form.Get(models.SettingGuestMailHour)),
+		models.SettingCheckInInstructions: strings.TrimSpace(r.Form.Get(models.SettingCheckInInstructions)),
+		models.SettingReviewURL:           strings.TrimSpace(r.Form.Get(models.SettingReviewURL)),
+	}
+
+	form := forms.New(r.PostForm)
+	for _, gm := range guestMails {
+		values[gm.EnabledKey] = strconv.FormatBool(r.Form.Get(gm.EnabledKey) != "")
+		values[gm.DaysKey] = strings.TrimSpace(r.Form.Get(gm.DaysKey))
+		if days, err := strconv.Atoi(values[gm.DaysKey]); err != nil || days < 0 || days > gm.MaxDays {
+			form.Error.Add(gm.DaysKey, fmt.Sprintf("Enter a number of days between 0 and %d", gm.MaxDays))
+		}
+	}
+	if hour, err := strconv.Atoi(values[models.SettingGuestMailHour]); err != nil || hour < 0 || hour > 23 {
+		form.Error.Add(models.SettingGuestMailHour, "Enter an hour between 0 and 23")
+	}
+	if review := values[models.SettingReviewURL]; review != "" {
+		if u, err := url.Parse(review); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			form.Error.Add(models.SettingReviewURL, "Enter a web address starting with https://")
+		}
+	}
+	if !form.Valid() {
+		m.renderAdminGuestEmails(w, r, values, form)
+		return
+	}
+
+	before := make(map[string]string)
+	for key := range values {
+		before[key], err = m.DB.GetSetting(key)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	for key, value := range values {
+		err = m.DB.UpdateSetting(key, value)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	m.audit(r, auditUpdate, models.AuditSettings, 0, before, values)
+
+	m.App.Session.Put(r.Context(), "flash", "Guest email settings saved!")
+	http.Redirect(w, r, "/admin/guest-emails", http.StatusSeeOther)
+}
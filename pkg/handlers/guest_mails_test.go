@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository/dbrepo"
+)
+
+func TestGuestMails(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+	if location := postLogin(t, client, testServer, dbrepo.DemoEmail, dbrepo.DemoPassword); location != "/" {
+		t.Fatalf("expected to be logged in, but sent to %s", location)
+	}
+
+	// nothing goes out while the mails are off
+	if queued, err := Repo.SendGuestMails(); err != nil || queued != 0 {
+		t.Fatalf("expected no guest mails at first, but %d, %v", queued, err)
+	}
+
+	settings := url.Values{
+		models.SettingReminderEnabled:     {"true"},
+		models.SettingReminderDays:        {"3"},
+		models.SettingCheckInEnabled:      {"true"},
+		models.SettingCheckInDays:         {"0"},
+		models.SettingThankYouEnabled:     {"true"},
+		models.SettingThankYouDays:        {"99"},
+		models.SettingGuestMailHour:       {"0"},
+		models.SettingCheckInInstructions: {"The key is under the mat."},
+		models.SettingReviewURL:           {"https://example.com/review"},
+	}
+	res, err := client.PostForm(testServer.URL+"/admin/guest-emails", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "between 0 and 30") {
+		t.Fatalf("expected too many days to be refused, but %d", res.StatusCode)
+	}
+	settings.Set(models.SettingThankYouDays, "1")
+	res, err = client.PostForm(testServer.URL+"/admin/guest-emails", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the settings to be saved, but %d", res.StatusCode)
+	}
+	if enabled, _ := Repo.DB.GetSetting(models.SettingReminderEnabled); enabled != "true" {
+		t.Errorf("expected the reminder to be enabled, but %q", enabled)
+	}
+
+	// a stay that starts in two days is due for its reminder, not yet for its check-in mail
+	start := time.Now().AddDate(0, 0, 2)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 2))
+	postReservation(t, client, testServer, "soon@mail.com", "")
+	if res := postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+	arriving := lastReservationID(t)
+
+	// a stay that ended yesterday is due for its thank-you
+	yesterday := time.Now().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	departed, _ := Repo.DB.InsertReservations(models.Reservations{FirstName: "Dora", Email: "dora@mail.com",
+		StartDate: yesterday.AddDate(0, 0, -3), EndDate: yesterday, RoomID: 2, AccessToken: "departed-token"})
+
+	if _, err := Repo.SendGuestMails(); err != nil {
+		t.Fatal(err)
+	}
+
+	mails, _ := Repo.DB.GuestMailsForReservation(arriving)
+	if len(mails) != 1 || mails[0].Kind != emails.Reminder {
+		t.Fatalf("expected only the reminder, but %+v", mails)
+	}
+	msg, _ := Repo.DB.GetOutboxMessage(mails[0].OutboxMessageID)
+	if msg.To != "soon@mail.com" || msg.Subject != "Your stay starts soon" || !strings.Contains(msg.PlainContent, "http://localhost:8088/my-booking/") {
+		t.Errorf("expected the reminder with the manage link, but %+v", msg)
+	}
+
+	mails, _ = Repo.DB.GuestMailsForReservation(departed)
+	if len(mails) != 1 || mails[0].Kind != emails.ThankYou {
+		t.Fatalf("expected the thank-you, but %+v", mails)
+	}
+	msg, _ = Repo.DB.GetOutboxMessage(mails[0].OutboxMessageID)
+	if msg.To != "dora@mail.com" || !strings.Contains(msg.PlainContent, "https://example.com/review") {
+		t.Errorf("expected the thank-you with the review link, but %+v", msg)
+	}
+
+	// running again, like after a restart, sends nothing twice
+	if queued, err := Repo.SendGuestMails(); err != nil || queued != 0 {
+		t.Errorf("expected nothing left to send, but %d, %v", queued, err)
+	}
+
+	res, err = client.Get(testServer.URL + fmt.Sprintf("/admin/reservations/all/%d/show", arriving))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "Guest Emails") {
+		t.Error("expected the guest mails on the reservation")
+	}
+}
+
+func TestGuestMailHourInUTC(t *testing.T) {
+	getRoutes() // sets up the session and the repository
+
+	hour := time.Now().UTC().Hour()
+	if hour == 23 {
+		t.Skip("no later hour left in the day")
+	}
+	// a server far from UTC, the hour of the settings is still one of UTC
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("UTC+14", 14*60*60)
+
+	for key, value := range map[string]string{
+		models.SettingReminderEnabled: "true",
+		models.SettingReminderDays:    "3",
+		models.SettingGuestMailHour:   fmt.Sprint(hour + 1),
+	} {
+		if err := Repo.DB.UpdateSetting(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	id, _ := Repo.DB.InsertReservations(models.Reservations{FirstName: "Utc", Email: "utc@mail.com",
+		StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 2, AccessToken: "utc-token"})
+
+	if _, err := Repo.SendGuestMails(); err != nil {
+		t.Fatal(err)
+	}
+	if mails, _ := Repo.DB.GuestMailsForReservation(id); len(mails) != 0 {
+		t.Errorf("expected nothing before the hour in UTC, but %+v", mails)
+	}
+
+	if err := Repo.DB.UpdateSetting(models.SettingGuestMailHour, fmt.Sprint(hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Repo.SendGuestMails(); err != nil {
+		t.Fatal(err)
+	}
+	if mails, _ := Repo.DB.GuestMailsForReservation(id); len(mails) != 1 || mails[0].Kind != emails.Reminder {
+		t.Errorf("expected the reminder from the hour in UTC on, but %+v", mails)
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,6 +16,7 @@ import (
 	"github.com/fangjjcs/bookings-app/pkg/forms"
 	"github.com/fangjjcs/bookings-app/pkg/helpers"
 	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
 	"github.com/fangjjcs/bookings-app/pkg/render"
 	"github.com/fangjjcs/bookings-app/pkg/repository"
 	"github.com/fangjjcs/bookings-app/pkg/repository/dbrepo"
@@ -38,6 +40,14 @@ func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 	}
 }
 
+// NewMemoryRepo creates a new repository backed by the in-memory database
+func NewMemoryRepo(a *config.AppConfig) *Repository {
+	return &Repository{
+		App: a,
+		DB: dbrepo.NewMemoryRepo(a),
+	}
+}
+
 // NewHandlers sets the repository for the handlers
 func NewHandlers(r *Repository) {
 	Repo = r
@@ -64,21 +74,6 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// Generals is the handler for the about page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-
-	// send data to the template
-	render.RenderTemplate(w, r, "generals.page.tmpl", &models.TemplateData{
-	})
-}
-// Majors is the handler for the about page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-
-	// send data to the template
-	render.RenderTemplate(w, r, "majors.page.tmpl", &models.TemplateData{
-	})
-}
-
 // SearchAvailability is the handler for the about page
 func (m *Repository) SearchAvailability(w http.ResponseWriter, r *http.Request) {
 
@@ -89,7 +84,12 @@ func (m *Repository) SearchAvailability(w http.ResponseWriter, r *http.Request)
 
 // PostSearchAvailability is for "book now" search
 func (m *Repository) PostSearchAvailability(w http.ResponseWriter, r *http.Request) {
-	
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w,err)
+		return
+	}
+
 	start := r.Form.Get("start")
 	end := r.Form.Get("end")
 
@@ -97,10 +97,17 @@ func (m *Repository) PostSearchAvailability(w http.ResponseWriter, r *http.Reque
 	startDate, err := time.Parse(layout, start)
 	if err != nil {
 		helpers.ServerError(w,err)
+		return
 	}
 	endDate, err := time.Parse(layout, end)
 	if err != nil {
 		helpers.ServerError(w,err)
+		return
+	}
+	if !endDate.After(startDate){
+		m.App.Session.Put(r.Context(),"error","The departure date has to be after the arrival date")
+		http.Redirect(w,r,"/search-availability",http.StatusSeeOther)
+		return
 	}
 
 	rooms, err := m.DB.SearchAvailibilityForAllRooms(startDate, endDate)
@@ -119,8 +126,19 @@ func (m *Repository) PostSearchAvailability(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// what the stay costs in each room, by room id
+	quotes := make(map[int]models.Quote)
+	for _, room := range rooms {
+		quotes[room.ID], err = m.quoteStay(room, startDate, endDate)
+		if err != nil{
+			helpers.ServerError(w,err)
+			return
+		}
+	}
+
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["quotes"] = quotes
 
 	res := models.Reservations{
 		StartDate: startDate,
@@ -135,16 +153,20 @@ func (m *Repository) PostSearchAvailability(w http.ResponseWriter, r *http.Reque
 	})	
 }
 
-// SearchAvailability is the handler for the search-availability page
+// SearchAvailabilityByRoomID is the handler for the search-availability page of one room
 func (m *Repository) SearchAvailabilityByRoomID(w http.ResponseWriter, r *http.Request) {
-	
-	roomID, _ := strconv.Atoi(r.Form.Get("room_id")) 
-	roomType := r.Form.Get("room_type")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w,err)
+		return
+	}
 
-	room := models.Room{
-		ID: roomID,
-		RoomName: roomType,
+	roomID, _ := strconv.Atoi(r.Form.Get("room_id")) 
+	room, ok := m.bookableRoom(w, roomID)
+	if !ok{
+		return
 	}
+
 	data := make(map[string]interface{})
 	data["room"] = room
 	m.App.Session.Put(r.Context(),"Room",room)
@@ -155,41 +177,67 @@ func (m *Repository) SearchAvailabilityByRoomID(w http.ResponseWriter, r *http.R
 }
 
 func (m *Repository) PostSearchAvailabilityByRoomID(w http.ResponseWriter, r *http.Request) {
-	
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w,err)
+		return
+	}
+
 	start := r.Form.Get("start")
 	end := r.Form.Get("end")
-	room, _ := m.App.Session.Get(r.Context(),"Room").(models.Room)
+	room, ok := m.App.Session.Get(r.Context(),"Room").(models.Room)
+	if !ok{
+		helpers.ServerError(w,errors.New("cannot get room from session"))
+		return
+	}
+	// the room in the session may have been deactivated since
+	room, ok = m.bookableRoom(w, room.ID)
+	if !ok{
+		return
+	}
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, start)
 	if err != nil {
 		helpers.ServerError(w,err)
+		return
 	}
 	endDate, err := time.Parse(layout, end)
 	if err != nil {
 		helpers.ServerError(w,err)
+		return
+	}
+	if !endDate.After(startDate){
+		m.App.Session.Put(r.Context(),"error","The departure date has to be after the arrival date")
+		http.Redirect(w,r,fmt.Sprintf("/rooms/%s", room.Slug),http.StatusSeeOther)
+		return
 	}
 
-	available, _ := m.DB.SearchAvailabilityByDatesAndRoomID(startDate, endDate,room.ID)
-	log.Println(available)
+	available, err := m.DB.SearchAvailabilityByDatesAndRoomID(startDate, endDate,room.ID)
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
 	if !available{
-		// log.Println("here")
 		m.App.Session.Put(r.Context(),"error","Sorry, We don't have available room now.")
-		log.Println(m.App.Session)
-		if room.ID == 1{
-			http.Redirect(w,r,"/generals",http.StatusSeeOther)
-		}else{
-			http.Redirect(w,r,"/majors",http.StatusSeeOther)
-		}
-		
+		http.Redirect(w,r,fmt.Sprintf("/rooms/%s", room.Slug),http.StatusSeeOther)
 		return
 	}
-	// log.Println("and here")
 	res := models.Reservations{
 		StartDate: startDate,
 		EndDate: endDate,
 		RoomID: room.ID,
 		Room: room,
 	}
+	res.Quote, err = m.quoteStay(room, startDate, endDate)
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+	res.Quote, err = m.addCharges(res.Quote, res.Guests)
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
 	
 	// put these information into session in order to make a reservation in other page.
 	m.App.Session.Put(r.Context(),"reservation",res)
@@ -204,24 +252,31 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 		helpers.ServerError(w,err)
 		return
 	}
-	roomType := chi.URLParam(r,"room_name") //from url format "/choose-room/{id}"
-	if err != nil {
-		helpers.ServerError(w,err)
-		return
-	}
 	// Get information from session
 	res, ok := m.App.Session.Get(r.Context(),"reservation").(models.Reservations)
 	if !ok{
-		helpers.ServerError(w,err)
+		helpers.ServerError(w,errors.New("cannot get reservation from session"))
 		return
 	}
-	room := models.Room{
-		RoomName: roomType,
+	// the room name in the url is only for display, the database has the current one
+	room, ok := m.bookableRoom(w, roomID)
+	if !ok {
+		return
 	}
 	
 	// Add and Put information back into the session
 	res.RoomID = roomID
 	res.Room = room
+	res.Quote, err = m.quoteStay(room, res.StartDate, res.EndDate)
+	if err != nil {
+		helpers.ServerError(w,err)
+		return
+	}
+	res.Quote, err = m.addCharges(res.Quote, res.Guests)
+	if err != nil {
+		helpers.ServerError(w,err)
+		return
+	}
 	m.App.Session.Put(r.Context(),"reservation", res)
 	http.Redirect(w,r,"/make-reservation",http.StatusSeeOther)
 
@@ -236,22 +291,7 @@ func (m *Repository) MakeReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Reformat time from GO time format to "YYYY-MM-DD" and display on template instead of displaying time data in reservation
-	sd := res.StartDate.Format("2006-01-02")
-	ed := res.EndDate.Format("2006-01-02")
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
-
-	data := make(map[string]interface{})
-	data["reservation"] = res
-
-	// send data to the template
-	render.RenderTemplate(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
-		Data: data,
-		StringMap: stringMap,
-	})
+	m.renderMakeReservation(w, r, res, forms.New(nil))
 }
 
 // PostMakeReservation is the handler for Post the reservation form
@@ -278,57 +318,71 @@ func (m *Repository) PostMakeReservation(w http.ResponseWriter, r *http.Request)
 	form.Required("first_name","last_name","email")
 	form.MinLength("first_name", 3, r)
 	form.IsEmail("email",r)
+	reservation.Guests = guestsFromForm(form)
 
 	if !form.Valid(){
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
-		render.RenderTemplate(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
-		})
+		m.renderMakeReservation(w, r, reservation, form)
 		return
 	}
 
-	// Booking by insert Reservation !
-	newReservationID, err := m.DB.InsertReservations(reservation)
+	// the price is worked out again with the rates at booking time, that quote is kept with the reservation
+	room, ok := m.bookableRoom(w, reservation.RoomID)
+	if !ok{
+		return
+	}
+	reservation.Quote, err = m.quoteStay(room, reservation.StartDate, reservation.EndDate)
 	if err != nil{
 		helpers.ServerError(w,err)
+		return
 	}
-	// Adding a restriction after a successful booking 
-	restriction := models.RoomRestrictions{
-		StartDate: reservation.StartDate,
-		EndDate: reservation.EndDate,
-		RoomID: reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1, // 1 for reservation
+	reservation.Quote, err = m.applyPromoCode(form, reservation)
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
 	}
-	err = m.DB.InsertRoomRestriction(restriction)
+	reservation.Quote, err = m.addCharges(reservation.Quote, reservation.Guests)
 	if err != nil{
 		helpers.ServerError(w,err)
+		return
+	}
+	if !form.Valid(){
+		m.renderMakeReservation(w, r, reservation, form)
+		return
 	}
 
+	// a stay that costs something is paid for first, the payment step books it
+	if reservation.Quote.Total > 0{
+		m.App.Session.Put(r.Context(),"reservation", reservation)
+		http.Redirect(w,r,"/make-payment", http.StatusSeeOther)
+		return
+	}
 
-	// Build-up Confirm e-mail
-	mailMsg := fmt.Sprintf(`
-	 	<strong>Reservation Confirmation</strong><br>
-		 <br>
-		 Dear %s, <br>
-		 This is a confirmation for your reservation from %s to %s.
-	`,reservation.FirstName,reservation.StartDate.Format("2006-01-02"),reservation.EndDate.Format("2006-01-02"))
-
-	msg := models.MailData{
-		To: reservation.Email,
-		From: "server@booking.com",
-		Subject: "Reservation Confirmation",
-		Content: mailMsg,
+	reservation, confirmationID, ok := m.book(w, r, reservation)
+	if !ok{
+		return
 	}
-	// Put msg in the channel
-	m.App.MailChan <- msg
+	m.confirmReservation(w, r, reservation, confirmationID)
+}
 
+// renderMakeReservation shows the reservation form again with its errors
+func (m *Repository) renderMakeReservation(w http.ResponseWriter, r *http.Request, reservation models.Reservations, form *forms.Form) {
+	// Reformat time from GO time format to "YYYY-MM-DD" and display on template instead of displaying time data in reservation
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = reservation.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = reservation.EndDate.Format("2006-01-02")
+
+	if reservation.Guests == 0 {
+		reservation.Guests = 1
+	}
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
 
-	// transmit reservation data by session
-	m.App.Session.Put(r.Context(),"reservation", reservation)
-	http.Redirect(w,r,"reservation-summary", http.StatusSeeOther)
+	// send data to the template
+	render.RenderTemplate(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+		StringMap: stringMap,
+	})
 }
 
 // ReservationSummary Get data from session and load into reservation-summary page
@@ -346,8 +400,15 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request){
 
 	m.App.Session.Remove(r.Context(),"reservation")
 
+	ledger, err := m.DB.PaymentsForReservation(reservation.ID)
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
+	data["payment"] = payments.Summarize(ledger)
 
 	sd := reservation.StartDate.Format("2006-01-02")
 	ed := reservation.EndDate.Format("2006-01-02")
@@ -404,18 +465,86 @@ func (m *Repository) PostLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email,password)
-	// Login Failed
+	// too many failures from one address block it for a while, whichever accounts it tries
+	failures, err := m.DB.CountFailedLoginsForIP(m.clientIP(r), time.Now().Add(-ipFailureWindow))
 	if err != nil{
-		log.Println(err)
-		m.App.Session.Put(r.Context(),"error","Invalid login credentials")
+		helpers.ServerError(w,err)
+		return
+	}
+	if failures >= maxIPFailures{
+		err = m.recordAuthEvent(r, 0, email, models.AuthIPBlocked, false)
+		if err != nil{
+			helpers.ServerError(w,err)
+			return
+		}
+		m.rejectLogin(w, r)
+		return
+	}
+
+	u, err := m.DB.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows){
+		helpers.ServerError(w,err)
+		return
+	}
+	// the pause counts the failures of the email, which an email without an account has as well, so neither the
+	// pause nor the message tells which emails have an account
+	emailFailures, err := m.DB.CountFailedLoginsForEmail(email, time.Now().Add(-lockoutDuration))
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+	if emailFailures > failures{
+		failures = emailFailures
+	}
+	loginDelay(failedLoginDelay(failures))
+
+	if u.Locked(){
+		// checked all the same, so a locked account takes as long as any other failure
+		m.DB.Authenticate(email, password)
+		err = m.recordAuthEvent(r, u.ID, u.Email, models.AuthAccountLocked, false)
+		if err != nil{
+			helpers.ServerError(w,err)
+			return
+		}
+		m.rejectLogin(w, r)
+		return
+	}
+
+	_, _, err = m.DB.Authenticate(email,password)
+	if errors.Is(err, repository.ErrUserDisabled){
+		err = m.recordAuthEvent(r, u.ID, u.Email, models.AuthAccountDisabled, false)
+		if err != nil{
+			helpers.ServerError(w,err)
+			return
+		}
+		m.App.Session.Put(r.Context(),"error","This account is disabled")
 		http.Redirect(w, r, "/user/login",http.StatusSeeOther)
 		return
 	}
-	// Login successfully
-	m.App.Session.Put(r.Context(),"user_id", id)
-	m.App.Session.Put(r.Context(),"flash","Login Successfully")
-	http.Redirect(w,r,"/",http.StatusSeeOther)
+	// Login Failed, the reason goes to the auth audit log and never to the page
+	if errors.Is(err, repository.ErrInvalidCredentials){
+		if u.ID == 0{
+			err = m.recordAuthEvent(r, 0, email, models.AuthUnknownEmail, false)
+		} else {
+			_, err = m.recordFailedLogin(r, u, models.AuthBadPassword)
+		}
+		if err != nil{
+			helpers.ServerError(w,err)
+			return
+		}
+		m.rejectLogin(w, r)
+		return
+	}
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+	// the second factor is checked before user_id goes into the session
+	if u.TwoFactorEnabled(){
+		m.startTwoFactor(w, r, u)
+		return
+	}
+	m.logIn(w, r, u, models.AuthLogin)
 }
 
 // Log out
@@ -435,9 +564,9 @@ func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request){
 	render.RenderTemplate(w,r,"admin-dashboard.page.tmpl", &models.TemplateData{})
 }
 
-// Get All New Reservations in admin tool
-func (m *Repository) AdminNewReservation(w http.ResponseWriter, r *http.Request){
-	reservations, err := m.DB.AllNewReservations()
+// AdminPendingReservations shows the reservations waiting to be confirmed in admin tool
+func (m *Repository) AdminPendingReservations(w http.ResponseWriter, r *http.Request){
+	reservations, err := m.DB.ReservationsWithStatus(models.ReservationPending)
 	if err != nil{
 		helpers.ServerError(w,err)
 		return
@@ -445,21 +574,38 @@ func (m *Repository) AdminNewReservation(w http.ResponseWriter, r *http.Request)
 
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
-	render.RenderTemplate(w,r,"admin-new-reservations.page.tmpl", &models.TemplateData{
+	render.RenderTemplate(w,r,"admin-pending-reservations.page.tmpl", &models.TemplateData{
 		Data: data,
 	})
 }
 
+// AdminAllReservation shows all reservations in admin tool, or those in the status of the query
 func (m *Repository) AdminAllReservation(w http.ResponseWriter, r *http.Request){
-	reservations, err := m.DB.AllReservations()
+	status := r.URL.Query().Get("status")
+
+	var reservations []models.Reservations
+	var err error
+	if isReservationStatus(status){
+		reservations, err = m.DB.ReservationsWithStatus(status)
+	}else{
+		status = ""
+		reservations, err = m.DB.AllReservations()
+	}
 	if err != nil{
 		helpers.ServerError(w,err)
 		return
 	}
+
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
+	data["statuses"] = models.ReservationStatuses
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
 	render.RenderTemplate(w,r,"admin-all-reservations.page.tmpl", &models.TemplateData{
 		Data: data,
+		StringMap: stringMap,
 	})
 }
 
@@ -492,8 +638,45 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	ledger, err := m.DB.PaymentsForReservation(id)
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+
+	terms, err := m.cancellationTermsOf(reservation)
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+
+	history, err := m.DB.StatusChangesForReservation(id)
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+
+	trail, err := m.DB.SearchAuditEntries(models.AuditFilter{Entity: models.AuditReservation, EntityID: id})
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+
+	guestMails, err := m.DB.GuestMailsForReservation(id)
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
+	data["ledger"] = ledger
+	data["payment"] = payments.Summarize(ledger)
+	data["cancellation"] = terms
+	data["history"] = history
+	data["audit"] = trail
+	data["guest_mails"] = guestMails
+	data["actions"] = statusActionsFor(reservation)
 
 	render.RenderTemplate(w,r,"admin-reservation-show.page.tmpl", &models.TemplateData{
 		Data: data,
@@ -530,6 +713,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	before := reservation
 	reservation.FirstName = r.Form.Get("first_name")
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Email = r.Form.Get("email")
@@ -540,6 +724,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 		helpers.ServerError(w,err)
 		return
 	}
+	m.audit(r, auditUpdate, models.AuditReservation, id, before, reservation)
 
 	m.App.Session.Put(r.Context(),"flash","Changes saved!")
 
@@ -557,48 +742,37 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 
 
 
-// AdminProcessReservation Marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request){
-	
-	id, _ := strconv.Atoi(chi.URLParam(r,"id"))
-	src := chi.URLParam(r,"src")
-	// These code also work 
-	// exploded := strings.Split(r.RequestURI,"/")
-	// id, err := strconv.Atoi(exploded[4])
-	// if err != nil{
-	// 	helpers.ServerError(w,err)
-	// 	return
-	// }
-	// src := exploded[3]
-	
-	_ = m.DB.UpdateProcessedForReservation(id,1)
-	m.App.Session.Put(r.Context(),"flash","Reservation is marked as Processed!")
-
-	month := r.URL.Query().Get("m")
-	year := r.URL.Query().Get("y")
-
-	if year == ""{
-		http.Redirect(w,r, fmt.Sprintf("/admin/reservations-%s",src),http.StatusSeeOther)
-	}else{
-		http.Redirect(w,r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s",year, month),http.StatusSeeOther)
-	}
-	
-
-}
-
 //AdminDeleteReservation Delete a reservation
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request){
 	
-	id, _ := strconv.Atoi(chi.URLParam(r,"id"))
+	id, err := strconv.Atoi(chi.URLParam(r,"id"))
+	if err != nil{
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
 	src := chi.URLParam(r,"src")
 	
-	_ = m.DB.DeleteReservation(id)
+	reservation, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows){
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+	err = m.DB.DeleteReservation(id, m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil{
+		helpers.ServerError(w,err)
+		return
+	}
+	m.audit(r, auditDelete, models.AuditReservation, id, reservation, nil)
 
 	month := r.URL.Query().Get("m")
 	year := r.URL.Query().Get("y")
 
 
-	m.App.Session.Put(r.Context(),"flash","Reservation is deleted.")
+	m.App.Session.Put(r.Context(),"flash","Reservation is moved to the trash.")
 
 	if year == ""{
 		http.Redirect(w,r, fmt.Sprintf("/admin/reservations-%s",src),http.StatusSeeOther)
@@ -748,6 +922,8 @@ func (m *Repository) AdminPostReservationCalendar(w http.ResponseWriter, r *http
 						err := m.DB.DeleteBlockByID(value)
 						if err != nil {
 							log.Println(err)
+						} else {
+							m.audit(r, auditDelete, models.AuditBlock, value, map[string]interface{}{"RoomID": x.ID, "Date": name}, nil)
 						}
 					}
 				}
@@ -769,6 +945,8 @@ func (m *Repository) AdminPostReservationCalendar(w http.ResponseWriter, r *http
 			err := m.DB.InsertBlockForRoom(roomID,t)
 			if err != nil {
 				log.Println(err)
+			} else {
+				m.audit(r, auditCreate, models.AuditBlock, 0, nil, map[string]interface{}{"RoomID": roomID, "Date": exploded[3]})
 			}
 
 		}
@@ -788,20 +966,39 @@ type JsonResponse struct{
 }
 
 
+// JsonSearchAvailability answers whether a room is free, for ?start=2006-01-02&end=2006-01-02&room_id=1
 func (m *Repository) JsonSearchAvailability(w http.ResponseWriter, r *http.Request) {
 	
+	startDate, endDate, err := parseStay(r.URL.Query().Get("start"), r.URL.Query().Get("end"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	roomID, _ := strconv.Atoi(r.URL.Query().Get("room_id"))
+	if _, ok := m.bookableRoom(w, roomID); !ok {
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesAndRoomID(startDate, endDate, roomID)
+	if err != nil {
+		helpers.ServerError(w,err)
+		return
+	}
+
 	resp := JsonResponse{
-		STATUS : true,
+		STATUS : available,
 		MESSAGE : "Available!",
 	}
+	if !available {
+		resp.MESSAGE = "Not available"
+	}
 	// JsonResponse -> []byte
 	jsonResult, err := json.MarshalIndent(resp,"","    ")
 	if err != nil {
-		// log.Println(err)
 		helpers.ServerError(w,err)
+		return
 	}
-	log.Println(string(jsonResult))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonResult)
 
-}
\ No newline at end of file
+}
@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
 )
 
 type postData struct{
@@ -25,17 +32,134 @@ var theTest = []struct{
 	{"ms", "/majors", "GET", []postData{}, http.StatusOK},
 	{"search-availability-get", "/search-availability", "GET", []postData{}, http.StatusOK},
 	{"contact", "/contact", "GET", []postData{}, http.StatusOK},
-	{"make-reservation", "/make-reservation", "GET", []postData{}, http.StatusOK},
-	{"eservation-summary", "/reservation-summary", "GET", []postData{}, http.StatusOK},
 	{"search-availability-post", "/search-availability", "POST", []postData{
 		{key: "start", value: "2021-01-01"},{key: "end", value: "2021-01-02"},
 	}, http.StatusOK},
+	{"choose-room", "/choose-room/1-generals quater", "GET", []postData{}, http.StatusOK},
+	{"make-reservation", "/make-reservation", "GET", []postData{}, http.StatusOK},
 	{"make-reservation-post", "/make-reservation", "POST", []postData{
 		{key: "first_name", value: "John"},
 		{key: "last_name", value: "Mayor"},
 		{key: "email", value: "john@mail.com"},
 		{key: "phone", value: "0987-09889"},
 	}, http.StatusOK},
+	{"eservation-summary", "/reservation-summary", "GET", []postData{}, http.StatusOK},
+	{"make-payment", "/make-payment", "GET", []postData{}, http.StatusOK},
+	{"make-payment-post", "/make-payment", "POST", []postData{
+		{key: "card_name", value: "John Mayor"},
+		{key: "card_number", value: "4242424242424242"},
+	}, http.StatusOK},
+	{"rooms", "/rooms", "GET", []postData{}, http.StatusOK},
+	{"room", "/rooms/generals-quarters", "GET", []postData{}, http.StatusOK},
+	{"room-unknown", "/rooms/no-such-room", "GET", []postData{}, http.StatusNotFound},
+	{"admin-rooms", "/admin/rooms", "GET", []postData{}, http.StatusOK},
+	{"admin-new-room", "/admin/rooms/new", "GET", []postData{}, http.StatusOK},
+	{"admin-new-room-post", "/admin/rooms/new", "POST", []postData{
+		{key: "room_name", value: "Colonel's Cabin"},
+		{key: "slug", value: "colonels-cabin"},
+		{key: "capacity", value: "4"},
+		{key: "base_rate", value: "99.50"},
+		{key: "sort_order", value: "3"},
+		{key: "active", value: "1"},
+	}, http.StatusOK},
+	{"new-room", "/rooms/colonels-cabin", "GET", []postData{}, http.StatusOK},
+	{"admin-show-room", "/admin/rooms/1", "GET", []postData{}, http.StatusOK},
+	{"admin-show-room-unknown", "/admin/rooms/99", "GET", []postData{}, http.StatusNotFound},
+	{"admin-post-seasonal-rate", "/admin/rooms/1/seasonal-rates", "POST", []postData{
+		{key: "season_name", value: "Summer"},
+		{key: "season_start", value: "2026-07-01"},
+		{key: "season_end", value: "2026-08-31"},
+		{key: "season_rate", value: "119"},
+	}, http.StatusOK},
+	{"admin-post-seasonal-rate-invalid", "/admin/rooms/1/seasonal-rates", "POST", []postData{
+		{key: "season_name", value: "Backwards"},
+		{key: "season_start", value: "2026-08-31"},
+		{key: "season_end", value: "2026-07-01"},
+		{key: "season_rate", value: "ten"},
+	}, http.StatusOK},
+	{"admin-delete-seasonal-rate", "/admin/delete-seasonal-rate/1/1/do", "GET", []postData{}, http.StatusOK},
+	{"admin-post-stay-discount", "/admin/rooms/1/stay-discounts", "POST", []postData{
+		{key: "min_nights", value: "7"},
+		{key: "percent", value: "10"},
+	}, http.StatusOK},
+	{"admin-delete-stay-discount", "/admin/delete-stay-discount/1/1/do", "GET", []postData{}, http.StatusOK},
+	{"admin-post-cancellation-rule", "/admin/rooms/2/cancellation-rules", "POST", []postData{
+		{key: "days_before", value: "14"},
+		{key: "penalty", value: "50"},
+	}, http.StatusOK},
+	{"admin-post-invalid-cancellation-rule", "/admin/rooms/2/cancellation-rules", "POST", []postData{
+		{key: "days_before", value: "14"},
+		{key: "penalty", value: "150"},
+	}, http.StatusOK},
+	{"admin-delete-cancellation-rule", "/admin/delete-cancellation-rule/2/3/do", "GET", []postData{}, http.StatusOK},
+	{"admin-deactivate-room", "/admin/deactivate-room/3/do", "GET", []postData{}, http.StatusOK},
+	{"deactivated-room", "/rooms/colonels-cabin", "GET", []postData{}, http.StatusNotFound},
+	{"admin-promotions", "/admin/promotions", "GET", []postData{}, http.StatusOK},
+	{"admin-post-promotion", "/admin/promotions", "POST", []postData{
+		{key: "code", value: "summer26"},
+		{key: "kind", value: "fixed"},
+		{key: "amount", value: "20"},
+		{key: "stay_from", value: "2026-07-01"},
+		{key: "room_1", value: "1"},
+	}, http.StatusOK},
+	{"admin-post-promotion-invalid", "/admin/promotions", "POST", []postData{
+		{key: "code", value: "no spaces"},
+		{key: "kind", value: "percent"},
+		{key: "amount", value: "150"},
+	}, http.StatusOK},
+	{"admin-show-promotion", "/admin/promotions/1", "GET", []postData{}, http.StatusOK},
+	{"admin-show-promotion-unknown", "/admin/promotions/99", "GET", []postData{}, http.StatusNotFound},
+	{"admin-deactivate-promotion", "/admin/deactivate-promotion/1/do", "GET", []postData{}, http.StatusOK},
+	{"admin-activate-promotion", "/admin/activate-promotion/1/do", "GET", []postData{}, http.StatusOK},
+	{"admin-taxes", "/admin/taxes", "GET", []postData{}, http.StatusOK},
+	{"admin-post-tax-rule", "/admin/taxes", "POST", []postData{
+		{key: "name", value: "City tax"},
+		{key: "kind", value: "tax"},
+		{key: "basis", value: "guest-night"},
+		{key: "amount", value: "2.50"},
+		{key: "effective_from", value: "2026-01-01"},
+	}, http.StatusOK},
+	{"admin-post-tax-rule-invalid", "/admin/taxes", "POST", []postData{
+		{key: "name", value: "Backwards"},
+		{key: "kind", value: "duty"},
+		{key: "basis", value: "percent"},
+		{key: "amount", value: "150"},
+		{key: "effective_from", value: "2026-08-31"},
+		{key: "effective_until", value: "2026-07-01"},
+	}, http.StatusOK},
+	{"admin-deactivate-tax-rule", "/admin/deactivate-tax-rule/1/do", "GET", []postData{}, http.StatusOK},
+	{"admin-activate-tax-rule", "/admin/activate-tax-rule/1/do", "GET", []postData{}, http.StatusOK},
+	{"admin-delete-tax-rule", "/admin/delete-tax-rule/1/do", "GET", []postData{}, http.StatusOK},
+	{"admin-delete-tax-rule-unknown", "/admin/delete-tax-rule/99/do", "GET", []postData{}, http.StatusNotFound},
+	{"admin-users", "/admin/users", "GET", []postData{}, http.StatusOK},
+	{"admin-invite-user", "/admin/users", "POST", []postData{
+		{key: "first_name", value: "Jane"},
+		{key: "last_name", value: "Doe"},
+		{key: "email", value: "jane@mail.com"},
+		{key: "access_level", value: "2"},
+	}, http.StatusOK},
+	{"admin-show-user", "/admin/users/2", "GET", []postData{}, http.StatusOK},
+	{"admin-show-user-unknown", "/admin/users/99", "GET", []postData{}, http.StatusNotFound},
+	{"set-password-bad-token", "/user/set-password/nothing", "GET", []postData{}, http.StatusOK},
+	{"admin-settings", "/admin/settings", "GET", []postData{}, http.StatusOK},
+	{"admin-post-settings", "/admin/settings", "POST", []postData{
+		{key: "require_2fa_level", value: "0"},
+		{key: "invoice_issuer", value: "Fort Smythe B&B\n1 Main Street"},
+		{key: "tax_name", value: "VAT"},
+		{key: "tax_rate", value: "7.5"},
+	}, http.StatusOK},
+	{"admin-post-settings-invalid", "/admin/settings", "POST", []postData{
+		{key: "require_2fa_level", value: "0"},
+		{key: "tax_rate", value: "101"},
+	}, http.StatusOK},
+	{"admin-reservation-invoice-unknown", "/admin/reservations/all/99/invoice", "GET", []postData{}, http.StatusNotFound},
+	{"admin-pending-reservations", "/admin/reservations-pending", "GET", []postData{}, http.StatusOK},
+	{"admin-confirmed-reservations", "/admin/reservations-all?status=confirmed", "GET", []postData{}, http.StatusOK},
+	{"admin-change-status-unknown", "/admin/change-reservation-status/all/99/confirmed/do", "GET", []postData{}, http.StatusNotFound},
+	{"admin-change-status-invalid", "/admin/change-reservation-status/all/1/processed/do", "GET", []postData{}, http.StatusBadRequest},
+	{"two-factor-without-login", "/user/two-factor", "GET", []postData{}, http.StatusOK},
+	{"admin-login-activity", "/admin/login-activity", "GET", []postData{}, http.StatusOK},
+	{"admin-unlock-user", "/admin/unlock-user/1/do", "GET", []postData{}, http.StatusOK},
 }
 
 
@@ -43,6 +167,14 @@ func TestHandlers(t *testing.T){
 	routes := getRoutes()
 	testServer := httptest.NewTLSServer(routes)
 	defer testServer.Close()
+
+	// keep the session cookie between requests, so the booking flow can be followed
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testServer.Client().Jar = jar
+
 	for _, e := range theTest{
 		if e.method == "GET" {
 			res, err := testServer.Client().Get(testServer.URL + e.url)
@@ -69,4 +201,60 @@ func TestHandlers(t *testing.T){
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+func TestInactiveRoom(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	id, err := Repo.DB.InsertRoom(models.Room{RoomName: "Old Wing", Slug: "old-wing", Capacity: 2, Active: true, BaseRate: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().AddDate(0, 5, 0).Truncate(24 * time.Hour)
+	end := start.AddDate(0, 0, 2)
+
+	get := func(path string) int {
+		res, err := client.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// the guest chooses the room while it is active, then it is deactivated
+	values := url.Values{}
+	values.Add("start", start.Format("2006-01-02"))
+	values.Add("end", end.Format("2006-01-02"))
+	res, err := client.PostForm(testServer.URL+"/search-availability", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if status := get(fmt.Sprintf("/choose-room/%d-old-wing", id)); status != http.StatusSeeOther {
+		t.Fatalf("expected the active room to be chosen, but %d", status)
+	}
+	if err := Repo.DB.UpdateActiveForRoom(id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := get(fmt.Sprintf("/choose-room/%d-old-wing", id)); status != http.StatusNotFound {
+		t.Errorf("expected choosing a deactivated room to be not found, but %d", status)
+	}
+	query := fmt.Sprintf("/search-availability-json?start=%s&end=%s&room_id=%d", values.Get("start"), values.Get("end"), id)
+	if status := get(query); status != http.StatusNotFound {
+		t.Errorf("expected searching a deactivated room to be not found, but %d", status)
+	}
+	if res, _ := postReservation(t, client, testServer, "late@mail.com", ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected booking a deactivated room from the session to be not found, but %d", res.StatusCode)
+	}
+
+	// no other path books it either
+	_, err = Repo.DB.BookReservation(models.Reservations{FirstName: "Late", Email: "late@mail.com", StartDate: start,
+		EndDate: end, RoomID: id}, nil)
+	if !errors.Is(err, repository.ErrRoomNotAvailable) {
+		t.Errorf("expected a deactivated room not to be available, but %v", err)
+	}
+}
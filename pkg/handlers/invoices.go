@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/invoices"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/go-chi/chi"
+)
+
+// invoiceSettings reads the settings printed on invoices
+func (m *Repository) invoiceSettings() (invoices.Settings, error) {
+	var s invoices.Settings
+	var err error
+
+	if s.Issuer, err = m.DB.GetSetting(models.SettingInvoiceIssuer); err != nil {
+		return s, err
+	}
+	if s.TaxName, err = m.DB.GetSetting(models.SettingTaxName); err != nil {
+		return s, err
+	}
+	rate, err := m.DB.GetSetting(models.SettingTaxRate)
+	if err != nil {
+		return s, err
+	}
+	// the rate is stored the way it was validated, a bad value is left out rather than failing the invoice
+	s.TaxRate, _ = strconv.Atoi(rate)
+	return s, nil
+}
+
+// invoiceOf returns the invoice of a booked reservation with its payments, the invoice is issued the first
+// time it is asked for and stays as it was then
+func (m *Repository) invoiceOf(reservation models.Reservations) (invoices.Document, error) {
+	settings, err := m.invoiceSettings()
+	if err != nil {
+		return invoices.Document{}, err
+	}
+
+	inv, err := m.DB.InvoiceForReservation(reservation.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		inv, err = m.DB.IssueInvoice(invoices.Build(reservation, settings, time.Now()))
+	}
+	if err != nil {
+		return invoices.Document{}, err
+	}
+
+	ledger, err := m.DB.PaymentsForReservation(reservation.ID)
+	if err != nil {
+		return invoices.Document{}, err
+	}
+
+	return invoices.Document{
+		Invoice: inv,
+		Issuer:  settings.Issuer,
+		Stay: fmt.Sprintf("%s from %s to %s", reservation.Room.RoomName,
+			render.HumanDate(reservation.StartDate), render.HumanDate(reservation.EndDate)),
+		Payments: ledger,
+	}, nil
+}
+
+// confirmationHold is how long a confirmation queued with its booking waits for sendConfirmation to attach the
+// invoice, after that it goes out without it
+const confirmationHold = time.Minute
+
+// heldConfirmation is the confirmation mail of a reservation about to be booked, it is queued along with the
+// booking and held back until sendConfirmation releases it. It is nil when the mail could not be rendered, the
+// booking goes ahead without it
+func (m *Repository) heldConfirmation(reservation models.Reservations) *models.OutboxMessage {
+	msg, err := m.mailFor(emails.Confirmation, reservation.Email, emails.Data{
+		Name:        reservation.FirstName,
+		Reservation: reservation,
+		Link:        m.App.BaseURL + bookingPath(reservation),
+	})
+	if err != nil {
+		m.App.ErrorLog.Printf("could not render the confirmation to %s: %v", reservation.Email, err)
+		return nil
+	}
+	return &models.OutboxMessage{
+		MailData:      msg,
+		NextAttemptAt: time.Now().Add(confirmationHold),
+	}
+}
+
+// sendConfirmation releases the confirmation mail queued with a new reservation, a stay with a price gets its
+// invoice attached
+func (m *Repository) sendConfirmation(reservation models.Reservations, confirmationID int) {
+	if confirmationID == 0 {
+		return
+	}
+
+	var attachments []models.MailAttachment
+	if reservation.Quote.Total > 0 {
+		doc, err := m.invoiceOf(reservation)
+		if err != nil {
+			// the guest still gets the confirmation, the invoice can be sent from the admin tool
+			m.App.ErrorLog.Printf("could not invoice reservation %d: %v", reservation.ID, err)
+		} else {
+			attachments = append(attachments, models.MailAttachment{
+				Name:        doc.FileName(),
+				ContentType: "application/pdf",
+				Data:        invoices.PDF(doc),
+			})
+		}
+	}
+
+	if err := m.DB.ReleaseMail(confirmationID, attachments); err != nil {
+		m.App.ErrorLog.Printf("could not release the confirmation of reservation %d: %v", reservation.ID, err)
+	}
+}
+
+// AdminReservationInvoice shows the invoice of a reservation, with ?format=pdf it downloads as pdf
+func (m *Repository) AdminReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	reservation, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if reservation.Quote.Total <= 0 {
+		m.App.Session.Put(r.Context(), "error", "This reservation has no price to invoice")
+		backToReservation(w, r, id)
+		return
+	}
+
+	doc, err := m.invoiceOf(reservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "pdf" {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+doc.FileName()+`"`)
+		w.Write(invoices.PDF(doc))
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["document"] = doc
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = chi.URLParam(r, "src")
+
+	render.RenderTemplate(w, r, "invoice.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
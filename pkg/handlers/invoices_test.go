@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestReservationInvoice(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	Repo.DB.UpdateSetting(models.SettingTaxName, "VAT")
+	Repo.DB.UpdateSetting(models.SettingTaxRate, "1000")
+
+	start := time.Now().AddDate(0, 2, 0)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 2))
+	postReservation(t, client, testServer, "john@mail.com", "")
+	if res := postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+
+	msg := lastMail(t)
+	if len(msg.Attachments) != 1 || msg.Attachments[0].ContentType != "application/pdf" {
+		t.Fatalf("expected the invoice attached as pdf, but %d attachments", len(msg.Attachments))
+	}
+
+	id := lastReservationID(t)
+	inv, err := Repo.DB.InvoiceForReservation(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Attachments[0].Name != "invoice-"+inv.Number+".pdf" {
+		t.Errorf("unexpected attachment name %s", msg.Attachments[0].Name)
+	}
+	reservation, _ := Repo.DB.GetReservationByID(id)
+	if !strings.Contains(msg.Content, "/my-booking/"+reservation.AccessToken) {
+		t.Error("expected the link to manage the booking in the mail")
+	}
+
+	url := testServer.URL + "/admin/reservations/all/" + strconv.Itoa(id) + "/invoice"
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), inv.Number) || !strings.Contains(string(body), "VAT 10%") {
+		t.Errorf("expected the invoice page of %s, but %d", inv.Number, res.StatusCode)
+	}
+
+	res, err = client.Get(url + "?format=pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.Header.Get("Content-Type") != "application/pdf" {
+		t.Errorf("expected a pdf, but %s", res.Header.Get("Content-Type"))
+	}
+
+	// showing it again does not issue another invoice
+	if again, _ := Repo.DB.InvoiceForReservation(id); again.Number != inv.Number {
+		t.Errorf("expected invoice %s, but %s", inv.Number, again.Number)
+	}
+}
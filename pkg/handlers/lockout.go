@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+)
+
+// limits on guessing passwords and codes
+const (
+	// maxFailedLogins is how many failed logins in a row lock an account
+	maxFailedLogins = 5
+	// lockoutDuration is how long a locked account stays locked, every further failure locks it again
+	lockoutDuration = 15 * time.Minute
+	// maxIPFailures is how many failed logins an ip address may make within ipFailureWindow
+	maxIPFailures   = 20
+	ipFailureWindow = 15 * time.Minute
+	// maxLoginDelay caps the pause added to logins after repeated failures
+	maxLoginDelay = 5 * time.Second
+)
+
+// invalidLoginMessage is shown for every failed login, so the form does not tell which emails have an account
+const invalidLoginMessage = "Invalid login credentials"
+
+// recentAuthEventCount is how many login attempts the login activity page shows
+const recentAuthEventCount = 100
+
+// loginDelay pauses a login, tests replace it to run without waiting
+var loginDelay = time.Sleep
+
+// failedLoginDelay is the pause before checking a login after that many failures, it doubles from the third
+// failure on
+func failedLoginDelay(failures int) time.Duration {
+	if failures < 3 {
+		return 0
+	}
+	delay := 500 * time.Millisecond
+	for i := 3; i < failures && delay < maxLoginDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+	return delay
+}
+
+// clientIP is the ip address a request came from. It is the address of the connection, unless the site runs
+// behind a proxy named by RealIPHeader, then it is the last address in that header, the one the proxy added.
+// Whatever else a client puts in the header is ignored
+func (m *Repository) clientIP(r *http.Request) string {
+	if m.App.RealIPHeader != "" {
+		values := strings.Split(strings.Join(r.Header.Values(m.App.RealIPHeader), ","), ",")
+		if ip := net.ParseIP(strings.TrimSpace(values[len(values)-1])); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recordAuthEvent adds a login attempt to the auth audit log
+func (m *Repository) recordAuthEvent(r *http.Request, userID int, email, event string, success bool) error {
+	return m.DB.InsertAuthEvent(models.AuthEvent{
+		UserID:    userID,
+		Email:     email,
+		IPAddress: m.clientIP(r),
+		Event:     event,
+		Success:   success,
+	})
+}
+
+// recordFailedLogin records a wrong password or code of a known user and locks the account after too many,
+// it reports whether the account is locked now
+func (m *Repository) recordFailedLogin(r *http.Request, u models.User, event string) (bool, error) {
+	err := m.recordAuthEvent(r, u.ID, u.Email, event, false)
+	if err != nil {
+		return false, err
+	}
+
+	failures, err := m.DB.IncrementFailedLoginsForUser(u.ID)
+	if err != nil {
+		return false, err
+	}
+	if failures < maxFailedLogins {
+		return false, nil
+	}
+
+	err = m.DB.UpdateLockedUntilForUser(u.ID, time.Now().Add(lockoutDuration))
+	if err != nil {
+		return false, err
+	}
+	m.App.InfoLog.Printf("user %d is locked out after %d failed logins", u.ID, failures)
+	return true, nil
+}
+
+// rejectLogin sends the user back to the login form with the same message for every failure
+func (m *Repository) rejectLogin(w http.ResponseWriter, r *http.Request) {
+	m.App.Session.Put(r.Context(), "error", invalidLoginMessage)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// AdminLoginActivity shows the locked accounts and the latest login attempts
+func (m *Repository) AdminLoginActivity(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var locked []models.User
+	for _, u := range users {
+		if u.Locked() {
+			locked = append(locked, u)
+		}
+	}
+
+	events, err := m.DB.RecentAuthEvents(recentAuthEventCount)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["locked"] = locked
+	data["events"] = events
+
+	render.RenderTemplate(w, r, "admin-login-activity.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminUnlockUser lifts the lock of a user and forgets their failed logins
+func (m *Repository) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.ResetFailedLoginsForUser(u.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	unlocked := u
+	unlocked.FailedLogins = 0
+	unlocked.LockedUntil = time.Time{}
+	m.audit(r, auditUnlock, models.AuditUser, u.ID, u, unlocked)
+
+	m.App.Session.Put(r.Context(), "flash", u.Email+" is unlocked")
+	http.Redirect(w, r, "/admin/login-activity", http.StatusSeeOther)
+}
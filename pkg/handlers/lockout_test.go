@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository/dbrepo"
+)
+
+func TestFailedLoginDelay(t *testing.T) {
+	var theTests = []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, 500 * time.Millisecond},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{50, maxLoginDelay},
+	}
+
+	for _, e := range theTests {
+		if got := failedLoginDelay(e.failures); got != e.expected {
+			t.Errorf("for %d failures, expected %s, but %s", e.failures, e.expected, got)
+		}
+	}
+}
+
+// loginClient returns a client of a test server that keeps its cookies and does not follow redirects
+func loginClient(t *testing.T, testServer *httptest.Server) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := testServer.Client()
+	client.Jar = jar
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return client
+}
+
+// postLogin logs in and returns where the login redirected to
+func postLogin(t *testing.T, client *http.Client, testServer *httptest.Server, email, password string) string {
+	values := url.Values{}
+	values.Add("email", email)
+	values.Add("password", password)
+	res, err := client.PostForm(testServer.URL+"/user/login", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.Header.Get("Location")
+}
+
+func TestLoginLockout(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	for i := 0; i < maxFailedLogins; i++ {
+		if location := postLogin(t, client, testServer, dbrepo.DemoEmail, "wrong"); location != "/user/login" {
+			t.Fatalf("expected a wrong password to go back to the login, but %s", location)
+		}
+	}
+
+	u, _ := Repo.DB.GetUserByEmail(dbrepo.DemoEmail)
+	if !u.Locked() {
+		t.Fatalf("expected the account to be locked after %d failures", maxFailedLogins)
+	}
+
+	// the right password does not help while the account is locked
+	if location := postLogin(t, client, testServer, dbrepo.DemoEmail, dbrepo.DemoPassword); location != "/user/login" {
+		t.Errorf("expected a locked account to go back to the login, but %s", location)
+	}
+
+	events, _ := Repo.DB.RecentAuthEvents(10)
+	if len(events) != maxFailedLogins+1 || events[0].Event != models.AuthAccountLocked || events[1].Event != models.AuthBadPassword {
+		t.Errorf("unexpected auth events %v", events)
+	}
+
+	res, err := client.Get(testServer.URL + "/admin/unlock-user/1/do")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if location := postLogin(t, client, testServer, dbrepo.DemoEmail, dbrepo.DemoPassword); location != "/" {
+		t.Errorf("expected an unlocked account to log in, but %s", location)
+	}
+	u, _ = Repo.DB.GetUserByEmail(dbrepo.DemoEmail)
+	if u.FailedLogins != 0 {
+		t.Errorf("expected the failed logins to be reset, but %d", u.FailedLogins)
+	}
+	entries, _ := Repo.DB.SearchAuditEntries(models.AuditFilter{Entity: models.AuditUser, EntityID: u.ID, Action: auditUnlock})
+	if len(entries) != 1 || !strings.Contains(entries[0].Changes, "FailedLogins") {
+		t.Errorf("expected the unlock in the audit log, but %+v", entries)
+	}
+}
+
+func TestLoginIPBlock(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	// unknown emails do not lock any account, but they count against the address
+	for i := 0; i < maxIPFailures; i++ {
+		postLogin(t, client, testServer, "nobody@mail.com", "wrong")
+	}
+
+	if location := postLogin(t, client, testServer, dbrepo.DemoEmail, dbrepo.DemoPassword); location != "/user/login" {
+		t.Errorf("expected a blocked address to go back to the login, but %s", location)
+	}
+
+	events, _ := Repo.DB.RecentAuthEvents(1)
+	if len(events) != 1 || events[0].Event != models.AuthIPBlocked {
+		t.Errorf("expected an %s event, but %v", models.AuthIPBlocked, events)
+	}
+	u, _ := Repo.DB.GetUserByEmail(dbrepo.DemoEmail)
+	if u.Locked() || u.FailedLogins != 0 {
+		t.Error("a blocked address changed the account")
+	}
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	if err := Repo.DB.UpdateTOTPForUser(1, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if location := postLogin(t, client, testServer, dbrepo.DemoEmail, dbrepo.DemoPassword); location != "/user/two-factor" {
+		t.Fatalf("expected a redirect to the code, but %s", location)
+	}
+
+	// wrong codes re-render the form until the account locks
+	var res *http.Response
+	for i := 0; i < maxFailedLogins; i++ {
+		values := url.Values{}
+		values.Add("code", "000000")
+		var err error
+		res, err = client.PostForm(testServer.URL+"/user/two-factor", values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	if res.StatusCode != http.StatusSeeOther || res.Header.Get("Location") != "/user/login" {
+		t.Errorf("expected the last wrong code to go back to the login, but %d to %s", res.StatusCode, res.Header.Get("Location"))
+	}
+	u, _ := Repo.DB.GetUserByEmail(dbrepo.DemoEmail)
+	if !u.Locked() {
+		t.Error("wrong codes did not lock the account")
+	}
+}
+
+func TestLoginDelayUnknownEmail(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	var delays []time.Duration
+	loginDelay = func(d time.Duration) { delays = append(delays, d) }
+	defer func() { loginDelay = func(time.Duration) {} }()
+
+	// an account and an email without one that both failed 3 times elsewhere
+	known, _ := Repo.DB.InsertUser(models.User{FirstName: "Kim", Email: "kim@mail.com", AccessLevel: models.AccessFrontDesk})
+	for i := 0; i < 3; i++ {
+		Repo.DB.InsertAuthEvent(models.AuthEvent{UserID: known, Email: "kim@mail.com", IPAddress: "192.0.2.1", Event: models.AuthBadPassword})
+		Repo.DB.InsertAuthEvent(models.AuthEvent{Email: "nobody-here@mail.com", IPAddress: "192.0.2.1", Event: models.AuthUnknownEmail})
+	}
+
+	postLogin(t, client, testServer, "kim@mail.com", "wrong")
+	postLogin(t, client, testServer, "nobody-here@mail.com", "wrong")
+	if len(delays) != 2 || delays[0] == 0 || delays[0] != delays[1] {
+		t.Errorf("expected the same pause for both emails, but %v", delays)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	defer func(header string) { Repo.App.RealIPHeader = header }(Repo.App.RealIPHeader)
+
+	tests := []struct {
+		name      string
+		header    string
+		forwarded []string
+		expected  string
+	}{
+		{"direct", "", nil, "192.0.2.10"},
+		{"header without a proxy", "", []string{"198.51.100.7"}, "192.0.2.10"},
+		{"proxy", "X-Forwarded-For", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"forged by the client", "X-Forwarded-For", []string{"203.0.113.1, 198.51.100.7"}, "198.51.100.7"},
+		{"several headers", "X-Forwarded-For", []string{"203.0.113.1", "198.51.100.7"}, "198.51.100.7"},
+		{"not an address", "X-Forwarded-For", []string{"unknown"}, "192.0.2.10"},
+		{"missing", "X-Forwarded-For", nil, "192.0.2.10"},
+	}
+
+	for _, e := range tests {
+		Repo.App.RealIPHeader = e.header
+		req := httptest.NewRequest("POST", "/user/login", nil)
+		req.RemoteAddr = "192.0.2.10:52100"
+		for _, value := range e.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		if ip := Repo.clientIP(req); ip != e.expected {
+			t.Errorf("for %s expected %s, but %s", e.name, e.expected, ip)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/events"
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/go-chi/chi"
+)
+
+// notificationsPageSize is how many notifications are shown at most
+const notificationsPageSize = 100
+
+// notificationMenuSize is how many notifications the bell of the admin layout lists
+const notificationMenuSize = 5
+
+// ListenForNotifications subscribes the notifications of the staff to the events of the app
+func (m *Repository) ListenForNotifications() {
+	m.App.Events.Subscribe(events.ReservationCreated, m.notifyNewReservation)
+}
+
+// notificationPath is where a notification is opened, which marks it read
+func notificationPath(id int) string {
+	return fmt.Sprintf("/admin/notifications/%d", id)
+}
+
+// notifyNewReservation tells every active user who can see reservations about a new one, in the admin tool and
+// by mail to those who want their mails right away
+func (m *Repository) notifyNewReservation(e events.Event) {
+	res := e.Reservation
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		m.App.ErrorLog.Printf("could not notify the staff of reservation %d: %v", res.ID, err)
+		return
+	}
+
+	n := models.Notification{
+		Kind:  models.NotificationNewReservation,
+		Title: fmt.Sprintf("New reservation by %s %s", res.FirstName, res.LastName),
+		Body:  fmt.Sprintf("%s, %s to %s", res.Room.RoomName, res.StartDate.Format("Jan 2"), res.EndDate.Format("Jan 2, 2006")),
+		Link:  fmt.Sprintf("/admin/reservations/all/%d/show", res.ID),
+	}
+	for _, u := range users {
+		if u.Disabled() || !models.Can(u.AccessLevel, models.PermViewReservations) {
+			continue
+		}
+		n.UserID = u.ID
+		n.MailedAt = time.Time{}
+		if u.NotifyEmail == models.NotifyEmailImmediate {
+			m.sendMail(emails.NewReservation, u.Email, emails.Data{
+				Name:        u.FirstName,
+				Reservation: res,
+				Link:        m.App.BaseURL + n.Link,
+			})
+			n.MailedAt = time.Now()
+		}
+		if _, err := m.DB.InsertNotification(n); err != nil {
+			m.App.ErrorLog.Printf("could not notify user %d of reservation %d: %v", u.ID, res.ID, err)
+		}
+	}
+}
+
+// SendNotificationDigests mails every active user who asked for a digest the notifications they have neither
+// read nor been mailed yet, in one mail each, and returns how many digests were queued
+func (m *Repository) SendNotificationDigests() (int, error) {
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, u := range users {
+		if u.Disabled() || u.NotifyEmail != models.NotifyEmailDigest {
+			continue
+		}
+		notifications, err := m.DB.UnmailedNotifications(u.ID)
+		if err != nil {
+			return queued, err
+		}
+		if len(notifications) == 0 {
+			continue
+		}
+
+		ids := make([]int, len(notifications))
+		for i := range notifications {
+			ids[i] = notifications[i].ID
+			notifications[i].Link = m.App.BaseURL + notificationPath(notifications[i].ID)
+		}
+		msg, err := m.mailFor(emails.NotificationDigest, u.Email, emails.Data{
+			Name:          u.FirstName,
+			Notifications: notifications,
+			Link:          m.App.BaseURL + "/admin/notifications",
+		})
+		if err != nil {
+			return queued, err
+		}
+		m.queueMail(msg)
+		err = m.DB.MarkNotificationsMailed(ids)
+		if err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// AdminNotifications shows the latest notifications of the logged in user and how they get them by mail
+func (m *Repository) AdminNotifications(w http.ResponseWriter, r *http.Request) {
+	u, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	notifications, err := m.DB.NotificationsForUser(u.ID, notificationsPageSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	unread, err := m.DB.CountUnreadNotifications(u.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["notifications"] = notifications
+
+	stringMap := make(map[string]string)
+	stringMap["notify_email"] = u.NotifyEmail
+
+	intMap := make(map[string]int)
+	intMap["unread"] = unread
+
+	render.RenderTemplate(w, r, "admin-notifications.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostNotifications sets how the logged in user gets their notifications by mail
+func (m *Repository) AdminPostNotifications(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	mode := r.Form.Get("notify_email")
+	valid := false
+	for _, s := range models.NotifyEmailModes {
+		valid = valid || s == mode
+	}
+	if !valid {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	u, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.UpdateNotifyEmailForUser(u.ID, mode)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	after := u
+	after.NotifyEmail = mode
+	m.audit(r, auditUpdate, models.AuditUser, u.ID, u, after)
+
+	m.App.Session.Put(r.Context(), "flash", "Notification settings saved")
+	http.Redirect(w, r, "/admin/notifications", http.StatusSeeOther)
+}
+
+// AdminOpenNotification marks a notification of the logged in user read and goes to what it is about
+func (m *Repository) AdminOpenNotification(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	n, err := m.DB.GetNotificationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	// the notifications of other users are not found either
+	if n.UserID != m.App.Session.GetInt(r.Context(), "user_id") {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.MarkNotificationRead(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// only ever go to a page of the site
+	link := n.Link
+	if !strings.HasPrefix(link, "/") || strings.HasPrefix(link, "//") {
+		link = "/admin/notifications"
+	}
+	http.Redirect(w, r, link, http.StatusSeeOther)
+}
+
+// AdminReadNotifications marks every notification of the logged in user read
+func (m *Repository) AdminReadNotifications(w http.ResponseWriter, r *http.Request) {
+	err := m.DB.MarkAllNotificationsRead(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "All notifications marked as read")
+	http.Redirect(w, r, "/admin/notifications", http.StatusSeeOther)
+}
+
+// notificationJSON is a notification the bell of the admin layout lists
+type notificationJSON struct {
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	Link      string    `json:"link"`
+	Unread    bool      `json:"unread"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// unreadNotificationsJSON is what the bell of the admin layout shows
+type unreadNotificationsJSON struct {
+	Unread        int                `json:"unread"`
+	Notifications []notificationJSON `json:"notifications"`
+}
+
+// AdminUnreadNotifications sends the count of unread notifications of the logged in user and their latest
+// notifications as json, the bell of the admin layout polls it
+func (m *Repository) AdminUnreadNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	unread, err := m.DB.CountUnreadNotifications(userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	latest, err := m.DB.NotificationsForUser(userID, notificationMenuSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	resp := unreadNotificationsJSON{Unread: unread, Notifications: []notificationJSON{}}
+	for _, n := range latest {
+		resp.Notifications = append(resp.Notifications, notificationJSON{
+			Title:     n.Title,
+			Body:      n.Body,
+			Link:      notificationPath(n.ID),
+			Unread:    n.Unread(),
+			CreatedAt: n.CreatedAt,
+		})
+	}
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository/dbrepo"
+)
+
+func TestNotifications(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+	if location := postLogin(t, client, testServer, dbrepo.DemoEmail, dbrepo.DemoPassword); location != "/" {
+		t.Fatalf("expected to be logged in, but sent to %s", location)
+	}
+	admin, _ := Repo.DB.GetUserByEmail(dbrepo.DemoEmail)
+
+	// a front desk clerk wants a mail right away, someone who is disabled gets nothing
+	clerk, _ := Repo.DB.InsertUser(models.User{FirstName: "Clara", Email: "clara@mail.com", AccessLevel: models.AccessFrontDesk})
+	Repo.DB.UpdateNotifyEmailForUser(clerk, models.NotifyEmailImmediate)
+	gone, _ := Repo.DB.InsertUser(models.User{FirstName: "Gone", Email: "gone@mail.com", AccessLevel: models.AccessOwner})
+	Repo.DB.UpdateDisabledForUser(gone, true)
+
+	// the admin asks for a digest
+	res, err := client.PostForm(testServer.URL+"/admin/notifications", url.Values{"notify_email": {"weekly"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an unknown mode to be refused, but %d", res.StatusCode)
+	}
+	res, err = client.PostForm(testServer.URL+"/admin/notifications", url.Values{"notify_email": {models.NotifyEmailDigest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if u, _ := Repo.DB.GetUserByID(admin.ID); u.NotifyEmail != models.NotifyEmailDigest {
+		t.Errorf("expected the admin to get a digest, but %q", u.NotifyEmail)
+	}
+	entries, _ := Repo.DB.SearchAuditEntries(models.AuditFilter{Entity: models.AuditUser, EntityID: admin.ID, Action: auditUpdate})
+	if len(entries) == 0 || !strings.Contains(entries[0].Changes, "NotifyEmail") {
+		t.Errorf("expected the notification setting in the audit log, but %+v", entries)
+	}
+
+	start := time.Now().AddDate(0, 5, 0)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 2))
+	postReservation(t, client, testServer, "notify@mail.com", "")
+	if res := postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+	reservationID := lastReservationID(t)
+
+	// the clerk is mailed right away
+	msg := lastMail(t)
+	if msg.To != "clara@mail.com" || msg.Subject != "New reservation: John Mayor" ||
+		!strings.Contains(msg.PlainContent, fmt.Sprintf("http://localhost:8088/admin/reservations/all/%d/show", reservationID)) {
+		t.Errorf("expected the clerk to be mailed about the reservation, but %+v", msg)
+	}
+	clerkNotifications, _ := Repo.DB.NotificationsForUser(clerk, 10)
+	if len(clerkNotifications) != 1 || clerkNotifications[0].MailedAt.IsZero() {
+		t.Errorf("expected the clerk's notification to be mailed, but %+v", clerkNotifications)
+	}
+	if n, _ := Repo.DB.CountUnreadNotifications(gone); n != 0 {
+		t.Errorf("expected a disabled user not to be notified, but %d", n)
+	}
+
+	// the bell of the admin
+	res, err = client.Get(testServer.URL + "/admin/notifications/unread")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bell unreadNotificationsJSON
+	err = json.NewDecoder(res.Body).Decode(&bell)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bell.Unread != 1 || len(bell.Notifications) != 1 || !bell.Notifications[0].Unread ||
+		bell.Notifications[0].Title != "New reservation by John Mayor" || !strings.Contains(bell.Notifications[0].Body, start.Format("Jan 2")) {
+		t.Fatalf("expected one unread notification, but %+v", bell)
+	}
+
+	res, err = client.Get(testServer.URL + "/admin/notifications")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), bell.Notifications[0].Link) {
+		t.Errorf("expected the notification on the page, but %d", res.StatusCode)
+	}
+
+	// the digest goes out once
+	if queued, err := Repo.SendNotificationDigests(); err != nil || queued != 1 {
+		t.Fatalf("expected one digest, but %d, %v", queued, err)
+	}
+	msg = lastMail(t)
+	if msg.To != dbrepo.DemoEmail || msg.Subject != "1 new notification" ||
+		!strings.Contains(msg.PlainContent, "http://localhost:8088"+bell.Notifications[0].Link) {
+		t.Errorf("expected the admin's digest, but %+v", msg)
+	}
+	if queued, _ := Repo.SendNotificationDigests(); queued != 0 {
+		t.Errorf("expected nothing left for a digest, but %d", queued)
+	}
+
+	// the notification of someone else can not be opened
+	res, err = client.Get(testServer.URL + notificationPath(clerkNotifications[0].ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the clerk's notification to be not found, but %d", res.StatusCode)
+	}
+
+	// opening it goes to the reservation
+	res, err = client.Get(testServer.URL + bell.Notifications[0].Link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if location := res.Header.Get("Location"); location != fmt.Sprintf("/admin/reservations/all/%d/show", reservationID) {
+		t.Errorf("expected to go to the reservation, but %s", location)
+	}
+	if n, _ := Repo.DB.CountUnreadNotifications(admin.ID); n != 0 {
+		t.Errorf("expected the notification to be read, but %d unread", n)
+	}
+
+	res, err = client.Get(testServer.URL + "/admin/read-notifications/do")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		t.Errorf("expected to be sent back to the notifications, but %d", res.StatusCode)
+	}
+}
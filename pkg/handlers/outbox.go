@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/go-chi/chi"
+)
+
+// outboxPageSize is how many mails of the outbox are shown at most
+const outboxPageSize = 200
+
+// queueMail adds a mail to the outbox, it is sent in the background from the configured sender address. The
+// change that made the mail is already saved, so a failure to queue it is only reported in the error log
+func (m *Repository) queueMail(msg models.MailData) {
+	if msg.From == "" {
+		msg.From = m.App.MailFrom
+	}
+	if _, err := m.DB.QueueMail(models.OutboxMessage{MailData: msg}); err != nil {
+		m.App.ErrorLog.Printf("could not queue the mail %q to %s: %v", msg.Subject, msg.To, err)
+	}
+}
+
+// mailFor renders the mail of a name to an address, from the business name and address of the settings
+func (m *Repository) mailFor(name, to string, data emails.Data) (models.MailData, error) {
+	issuer, err := m.DB.GetSetting(models.SettingInvoiceIssuer)
+	if err != nil {
+		return models.MailData{}, err
+	}
+	data.Brand = emails.BrandOf(issuer)
+
+	msg, err := m.App.EmailTemplates.Render(name, data)
+	if err != nil {
+		return models.MailData{}, err
+	}
+	return models.MailData{
+		To:           to,
+		From:         m.App.MailFrom,
+		Subject:      msg.Subject,
+		Content:      msg.HTML,
+		PlainContent: msg.Text,
+	}, nil
+}
+
+// sendMail renders the mail of a name and queues it. The change the mail is about is already saved, so a
+// failure is only reported in the error log
+func (m *Repository) sendMail(name, to string, data emails.Data) {
+	msg, err := m.mailFor(name, to, data)
+	if err != nil {
+		m.App.ErrorLog.Printf("could not render the %s mail to %s: %v", name, to, err)
+		return
+	}
+	m.queueMail(msg)
+}
+
+// AdminOutbox shows the mails of the outbox, the newest first, optionally only those with a status
+func (m *Repository) AdminOutbox(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	valid := status == ""
+	for _, s := range models.OutboxStatuses {
+		valid = valid || s == status
+	}
+	if !valid {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	messages, err := m.DB.OutboxMessages(status, outboxPageSize)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["messages"] = messages
+	data["statuses"] = models.OutboxStatuses
+
+	render.RenderTemplate(w, r, "admin-outbox.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: map[string]string{"status": status},
+	})
+}
+
+// AdminShowOutboxMessage shows a mail of the outbox with its content and its last error
+func (m *Repository) AdminShowOutboxMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	msg, err := m.DB.GetOutboxMessage(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["message"] = msg
+
+	render.RenderTemplate(w, r, "admin-outbox-message.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminResendMail queues a sent or dead mail of the outbox again
+func (m *Repository) AdminResendMail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	before, err := m.DB.GetOutboxMessage(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if before.Status == models.OutboxPending {
+		m.App.Session.Put(r.Context(), "error", "This mail is still waiting to be sent")
+		http.Redirect(w, r, "/admin/outbox/"+strconv.Itoa(id), http.StatusSeeOther)
+		return
+	}
+
+	if err := m.DB.ResendMail(id); err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	after, err := m.DB.GetOutboxMessage(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditResend, models.AuditMail, id, outboxAuditOf(before), outboxAuditOf(after))
+
+	m.App.Session.Put(r.Context(), "flash", "The mail is queued again.")
+	http.Redirect(w, r, "/admin/outbox/"+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+// outboxAudit is what the audit log keeps of a mail of the outbox, without its content and attachments
+type outboxAudit struct {
+	To       string
+	Subject  string
+	Status   string
+	Attempts int
+}
+
+func outboxAuditOf(msg models.OutboxMessage) outboxAudit {
+	return outboxAudit{To: msg.To, Subject: msg.Subject, Status: msg.Status, Attempts: msg.Attempts}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// lastMail returns the mail queued last, or a zero mail when the outbox is empty
+func lastMail(t *testing.T) models.OutboxMessage {
+	messages, err := Repo.DB.OutboxMessages("", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) == 0 {
+		return models.OutboxMessage{}
+	}
+	return messages[0]
+}
+
+func TestOutbox(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	start := time.Now().AddDate(0, 4, 0)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 2))
+	postReservation(t, client, testServer, "outbox@mail.com", "")
+	if res := postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+
+	// the confirmation was queued with the booking and released with its invoice
+	msg := lastMail(t)
+	if msg.To != "outbox@mail.com" || msg.Status != models.OutboxPending || len(msg.Attachments) != 1 {
+		t.Fatalf("expected the confirmation with its invoice to be pending, but %+v", msg)
+	}
+	if msg.NextAttemptAt.After(time.Now()) {
+		t.Errorf("expected the released confirmation to be due, but at %s", msg.NextAttemptAt)
+	}
+
+	res, err := client.Get(testServer.URL + "/admin/outbox?status=pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "outbox@mail.com") {
+		t.Errorf("expected the mail in the outbox, but %d", res.StatusCode)
+	}
+
+	res, err = client.Get(testServer.URL + "/admin/outbox?status=lost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an unknown status to be refused, but %d", res.StatusCode)
+	}
+
+	resend := testServer.URL + "/admin/resend-mail/" + strconv.Itoa(msg.ID) + "/do"
+
+	// a pending mail is not resent
+	if res, err = client.Get(resend); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if again, _ := Repo.DB.GetOutboxMessage(msg.ID); again.Status != models.OutboxPending {
+		t.Errorf("expected the mail to stay pending, but %s", again.Status)
+	}
+
+	// a dead mail is queued again with fresh attempts
+	Repo.DB.ClaimDueMail(100, time.Minute)
+	if err := Repo.DB.MarkMailFailed(msg.ID, "connection refused", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	res, err = client.Get(testServer.URL + "/admin/outbox/" + strconv.Itoa(msg.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "connection refused") {
+		t.Error("expected the last error on the mail's page")
+	}
+
+	if res, err = client.Get(resend); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	again, _ := Repo.DB.GetOutboxMessage(msg.ID)
+	if again.Status != models.OutboxPending || again.Attempts != 0 || again.LastError != "" {
+		t.Errorf("expected the mail to be pending again, but %s after %d attempts", again.Status, again.Attempts)
+	}
+
+	entries, _ := Repo.DB.SearchAuditEntries(models.AuditFilter{Entity: models.AuditMail, EntityID: msg.ID})
+	if len(entries) != 1 || entries[0].Action != auditResend {
+		t.Errorf("expected the resend in the audit log, but %+v", entries)
+	}
+
+	res, err = client.Get(testServer.URL + "/admin/outbox/999999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown mail, but %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fangjjcs/bookings-app/pkg/events"
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+	"github.com/go-chi/chi"
+)
+
+// book stores the reservation in the session's stay along with its confirmation mail, which is held back until
+// sendConfirmation releases it. When the room or the promo code was taken meanwhile the guest is sent back with
+// an error and ok is false
+func (m *Repository) book(w http.ResponseWriter, r *http.Request, reservation models.Reservations) (models.Reservations, int, bool) {
+	// the guest uses this token to look up the booking later
+	token, err := helpers.GenerateToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return reservation, 0, false
+	}
+	reservation.AccessToken = token
+
+	// Booking: the availability check, the reservation, its room restriction and its confirmation are written together
+	confirmation := m.heldConfirmation(reservation)
+	reservation.ID, err = m.DB.BookReservation(reservation, confirmation)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room has just been booked for these dates. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return reservation, 0, false
+	}
+	if errors.Is(err, repository.ErrPromoCodeUsedUp) {
+		room, err := m.DB.GetRoomByID(reservation.RoomID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return reservation, 0, false
+		}
+		reservation.Quote, err = m.quoteStay(room, reservation.StartDate, reservation.EndDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return reservation, 0, false
+		}
+		reservation.Quote, err = m.addCharges(reservation.Quote, reservation.Guests)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return reservation, 0, false
+		}
+		m.App.Session.Put(r.Context(), "reservation", reservation)
+		m.App.Session.Put(r.Context(), "error", "Sorry, "+pricing.ErrPromoUsedUp.Error())
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return reservation, 0, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return reservation, 0, false
+	}
+	m.reservationCreated(r, reservation)
+	if confirmation == nil {
+		return reservation, 0, true
+	}
+	return reservation, confirmation.ID, true
+}
+
+// reservationCreated records a booked reservation in the audit log and publishes it to the subscribers of
+// events.ReservationCreated, every way of booking ends here
+func (m *Repository) reservationCreated(r *http.Request, reservation models.Reservations) {
+	m.audit(r, auditCreate, models.AuditReservation, reservation.ID, nil, reservation)
+	m.App.Events.Publish(events.Event{Name: events.ReservationCreated, Reservation: reservation})
+}
+
+// confirmReservation mails the guest about a booked reservation and shows them its summary
+func (m *Repository) confirmReservation(w http.ResponseWriter, r *http.Request, reservation models.Reservations, confirmationID int) {
+	m.sendConfirmation(reservation, confirmationID)
+
+	// transmit reservation data by session
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// recordPayment adds a call to the payment gateway to the reservation's ledger, the money has moved already so
+// a failure is only logged
+func (m *Repository) recordPayment(p models.Payment) {
+	_, err := m.DB.InsertPayment(p)
+	if err != nil {
+		m.App.ErrorLog.Printf("could not record %s of %d for reservation %d (%s): %v",
+			p.Kind, p.Amount, p.ReservationID, p.Reference, err)
+	}
+}
+
+// paymentMessage is what the ledger keeps of a refused transaction
+func paymentMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// cardFromForm reads the card of the payment form, the expiry is written as MM/YY
+func cardFromForm(form *forms.Form) payments.Card {
+	card := payments.Card{
+		Name:   strings.TrimSpace(form.Get("card_name")),
+		Number: strings.TrimSpace(form.Get("card_number")),
+		CVC:    strings.TrimSpace(form.Get("card_cvc")),
+	}
+
+	parts := strings.Split(strings.TrimSpace(form.Get("card_expiry")), "/")
+	if len(parts) == 2 {
+		card.ExpMonth, _ = strconv.Atoi(strings.TrimSpace(parts[0]))
+		card.ExpYear, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if card.ExpYear < 100 {
+			card.ExpYear += 2000
+		}
+	}
+	return card
+}
+
+// renderMakePayment shows the payment form of the reservation in the session
+func (m *Repository) renderMakePayment(w http.ResponseWriter, r *http.Request, reservation models.Reservations, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+
+	stringMap := make(map[string]string)
+	if _, ok := m.App.Payments.(*payments.FakeGateway); ok {
+		stringMap["declined_card"] = payments.FakeDeclinedCard
+	}
+
+	render.RenderTemplate(w, r, "make-payment.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// MakePayment shows the payment form, the last step before a stay is booked
+func (m *Repository) MakePayment(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservations)
+	if !ok || reservation.ID != 0 {
+		m.App.Session.Put(r.Context(), "error", "Can not get data from the session!")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	m.renderMakePayment(w, r, reservation, forms.New(nil))
+}
+
+// PostMakePayment authorizes the card, books the stay and then captures the payment, when the booking fails
+// the authorization is released again
+func (m *Repository) PostMakePayment(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservations)
+	if !ok || reservation.ID != 0 {
+		m.App.Session.Put(r.Context(), "error", "Can not get data from the session!")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("card_name", "card_number", "card_expiry", "card_cvc")
+	if !form.Valid() {
+		m.renderMakePayment(w, r, reservation, form)
+		return
+	}
+
+	card := cardFromForm(form)
+	amount := reservation.Quote.Total
+	authorization, err := m.App.Payments.Authorize(amount, card)
+	if errors.Is(err, payments.ErrInvalidCard) || errors.Is(err, payments.ErrDeclined) {
+		form.Error.Add("card_number", "Sorry, "+err.Error())
+		m.renderMakePayment(w, r, reservation, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation, confirmationID, ok := m.book(w, r, reservation)
+	if !ok {
+		if err := m.App.Payments.Void(authorization); err != nil {
+			m.App.ErrorLog.Printf("could not void %s: %v", authorization, err)
+		}
+		return
+	}
+
+	m.recordPayment(models.Payment{
+		ReservationID: reservation.ID,
+		Kind:          models.PaymentAuthorize,
+		Amount:        amount,
+		Reference:     authorization,
+		Succeeded:     true,
+		CardLast4:     card.Last4(),
+	})
+
+	// the stay is booked either way, an authorization that could not be captured is left for the staff
+	capture, err := m.App.Payments.Capture(authorization, amount)
+	if err != nil {
+		m.App.ErrorLog.Printf("could not capture %s of reservation %d: %v", authorization, reservation.ID, err)
+	}
+	m.recordPayment(models.Payment{
+		ReservationID: reservation.ID,
+		Kind:          models.PaymentCapture,
+		Amount:        amount,
+		Reference:     capture,
+		Succeeded:     err == nil,
+		Message:       paymentMessage(err),
+		CardLast4:     card.Last4(),
+	})
+
+	m.confirmReservation(w, r, reservation, confirmationID)
+}
+
+// paymentsOfReservation returns the reservation in the url with where its payment stands
+func (m *Repository) paymentsOfReservation(w http.ResponseWriter, r *http.Request) (int, payments.Summary, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return 0, payments.Summary{}, false
+	}
+
+	ledger, err := m.DB.PaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return 0, payments.Summary{}, false
+	}
+	return id, payments.Summarize(ledger), true
+}
+
+// backToReservation redirects to the admin page of the reservation in the url
+func backToReservation(w http.ResponseWriter, r *http.Request, id int) {
+	url := fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), id)
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// AdminCapturePayment captures the authorized payment of a reservation that could not be captured at booking
+func (m *Repository) AdminCapturePayment(w http.ResponseWriter, r *http.Request) {
+	id, summary, ok := m.paymentsOfReservation(w, r)
+	if !ok {
+		return
+	}
+	if !summary.CanCapture() {
+		m.App.Session.Put(r.Context(), "error", "There is no payment to capture")
+		backToReservation(w, r, id)
+		return
+	}
+
+	reference, err := m.App.Payments.Capture(summary.Authorization, summary.Authorized)
+	payment := models.Payment{
+		ReservationID: id,
+		Kind:          models.PaymentCapture,
+		Amount:        summary.Authorized,
+		Reference:     reference,
+		Succeeded:     err == nil,
+		Message:       paymentMessage(err),
+	}
+	m.recordPayment(payment)
+	m.audit(r, auditCapture, models.AuditReservation, id, nil, payment)
+
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "The payment could not be captured: "+err.Error())
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Payment captured")
+	}
+	backToReservation(w, r, id)
+}
+
+// AdminVoidPayment releases the authorized payment of a reservation
+func (m *Repository) AdminVoidPayment(w http.ResponseWriter, r *http.Request) {
+	id, summary, ok := m.paymentsOfReservation(w, r)
+	if !ok {
+		return
+	}
+	if !summary.CanCapture() {
+		m.App.Session.Put(r.Context(), "error", "There is no payment to void")
+		backToReservation(w, r, id)
+		return
+	}
+
+	err := m.App.Payments.Void(summary.Authorization)
+	payment := models.Payment{
+		ReservationID: id,
+		Kind:          models.PaymentVoid,
+		Amount:        summary.Authorized,
+		Reference:     summary.Authorization,
+		Succeeded:     err == nil,
+		Message:       paymentMessage(err),
+	}
+	m.recordPayment(payment)
+	m.audit(r, auditVoid, models.AuditReservation, id, nil, payment)
+
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "The payment could not be voided: "+err.Error())
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Payment voided")
+	}
+	backToReservation(w, r, id)
+}
+
+// AdminPostRefundPayment refunds part or all of the captured payment of a reservation
+func (m *Repository) AdminPostRefundPayment(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, summary, ok := m.paymentsOfReservation(w, r)
+	if !ok {
+		return
+	}
+
+	amount, err := pricing.ParseMoney(r.Form.Get("amount"))
+	if err != nil || amount <= 0 || amount > summary.Refundable() {
+		m.App.Session.Put(r.Context(), "error",
+			"Enter an amount to refund of at most "+pricing.FormatMoney(summary.Refundable()))
+		backToReservation(w, r, id)
+		return
+	}
+
+	reference, err := m.App.Payments.Refund(summary.Capture, amount)
+	payment := models.Payment{
+		ReservationID: id,
+		Kind:          models.PaymentRefund,
+		Amount:        amount,
+		Reference:     reference,
+		Succeeded:     err == nil,
+		Message:       paymentMessage(err),
+	}
+	m.recordPayment(payment)
+	m.audit(r, auditRefund, models.AuditReservation, id, nil, payment)
+
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "The refund failed: "+err.Error())
+	} else {
+		m.App.Session.Put(r.Context(), "flash", pricing.FormatMoney(amount)+" refunded")
+	}
+	backToReservation(w, r, id)
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+)
+
+// postPayment pays for the stay in the session with a card number and returns the response
+func postPayment(t *testing.T, client *http.Client, testServer *httptest.Server, cardNumber string) *http.Response {
+	values := url.Values{}
+	values.Add("card_name", "John Mayor")
+	values.Add("card_number", cardNumber)
+	values.Add("card_expiry", time.Now().AddDate(2, 0, 0).Format("01/06"))
+	values.Add("card_cvc", "123")
+	res, err := client.PostForm(testServer.URL+"/make-payment", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+// lastReservationID is the id of the newest reservation
+func lastReservationID(t *testing.T) int {
+	all, err := Repo.DB.AllReservations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := 0
+	for _, res := range all {
+		if res.ID > id {
+			id = res.ID
+		}
+	}
+	return id
+}
+
+func TestPaymentCheckout(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	start := time.Now().AddDate(0, 2, 0)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 2))
+
+	res, _ := postReservation(t, client, testServer, "john@mail.com", "")
+	if location := res.Header.Get("Location"); location != "/make-payment" {
+		t.Fatalf("expected the reservation form to go on to the payment, but %s", location)
+	}
+
+	var theTests = []struct {
+		name       string
+		cardNumber string
+		status     int
+	}{
+		{"invalid", "4242424242424241", http.StatusOK},
+		{"declined", payments.FakeDeclinedCard, http.StatusOK},
+		{"approved", "4242 4242 4242 4242", http.StatusSeeOther},
+	}
+	for _, e := range theTests {
+		if res := postPayment(t, client, testServer, e.cardNumber); res.StatusCode != e.status {
+			t.Fatalf("for %s, expected %d, but %d", e.name, e.status, res.StatusCode)
+		}
+	}
+
+	id := lastReservationID(t)
+	reservation, _ := Repo.DB.GetReservationByID(id)
+	ledger, _ := Repo.DB.PaymentsForReservation(id)
+	if len(ledger) != 2 || ledger[0].Kind != models.PaymentAuthorize || ledger[1].Kind != models.PaymentCapture {
+		t.Fatalf("expected an authorization and a capture, but %+v", ledger)
+	}
+	if ledger[1].CardLast4 != "4242" {
+		t.Errorf("expected the card ending 4242, but %s", ledger[1].CardLast4)
+	}
+	summary := payments.Summarize(ledger)
+	if summary.State != payments.StatePaid || summary.Captured != reservation.Quote.Total {
+		t.Errorf("expected %d paid, but %+v", reservation.Quote.Total, summary)
+	}
+
+	// the payment form can not be sent again for the booked stay
+	if res := postPayment(t, client, testServer, "4242424242424242"); res.Header.Get("Location") != "/search-availability" {
+		t.Errorf("expected a second payment to be refused, but %s", res.Header.Get("Location"))
+	}
+
+	refund := func(amount string) {
+		values := url.Values{}
+		values.Add("amount", amount)
+		res, err := client.PostForm(testServer.URL+"/admin/refund-payment/all/"+strconv.Itoa(id), values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	refund("10")
+	refund("100000")
+	ledger, _ = Repo.DB.PaymentsForReservation(id)
+	summary = payments.Summarize(ledger)
+	if len(ledger) != 3 || summary.State != payments.StatePartiallyRefunded || summary.Refunded != 1000 {
+		t.Errorf("expected 10.00 refunded, but %+v", summary)
+	}
+
+	refund(pricing.FormatAmount(summary.Refundable()))
+	ledger, _ = Repo.DB.PaymentsForReservation(id)
+	if summary = payments.Summarize(ledger); summary.State != payments.StateRefunded {
+		t.Errorf("expected the payment to be refunded, but %+v", summary)
+	}
+}
+
+func TestPaymentVoidedWhenRoomIsTaken(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	start := time.Now().AddDate(0, 3, 0)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 2))
+	postReservation(t, client, testServer, "john@mail.com", "")
+
+	// someone else books the room while the guest types their card
+	_, err := Repo.DB.BookReservation(models.Reservations{FirstName: "Jane", StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := postPayment(t, client, testServer, "4242424242424242")
+	if location := res.Header.Get("Location"); location != "/search-availability" {
+		t.Errorf("expected the guest to search again, but %s", location)
+	}
+	if ledger, _ := Repo.DB.PaymentsForReservation(lastReservationID(t)); len(ledger) != 0 {
+		t.Errorf("expected no payments of the other booking, but %d", len(ledger))
+	}
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+	"github.com/go-chi/chi"
+)
+
+// bookingPath is the link a guest manages a reservation with, the token is all it takes
+func bookingPath(reservation models.Reservations) string {
+	return "/my-booking/" + reservation.AccessToken
+}
+
+// canChangeBooking reports whether the guest can still change or cancel a reservation, up to the day it starts
+// while it is pending or confirmed
+func canChangeBooking(reservation models.Reservations) bool {
+	today := time.Now().Truncate(24 * time.Hour)
+	return reservation.CanBecome(models.ReservationCancelled) && !reservation.StartDate.Before(today)
+}
+
+// bookingFromURL looks up the reservation of the token in the url, writing the error response when there is none
+func (m *Repository) bookingFromURL(w http.ResponseWriter, r *http.Request) (models.Reservations, bool) {
+	reservation, err := m.DB.GetReservationByAccessToken(chi.URLParam(r, "token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return reservation, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return reservation, false
+	}
+	return reservation, true
+}
+
+// renderManageBooking shows the guest's reservation with the contact form and the dates form, and what
+// cancelling it would cost
+func (m *Repository) renderManageBooking(w http.ResponseWriter, r *http.Request, reservation models.Reservations, form *forms.Form) {
+	ledger, err := m.DB.PaymentsForReservation(reservation.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	terms, err := m.cancellationTermsOf(reservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+	data["payment"] = payments.Summarize(ledger)
+	data["can_change"] = canChangeBooking(reservation)
+	data["cancellation"] = terms
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = reservation.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = reservation.EndDate.Format("2006-01-02")
+	stringMap["path"] = bookingPath(reservation)
+
+	render.RenderTemplate(w, r, "manage-booking.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// ManageBooking shows a guest their reservation from the link in the confirmation e-mail
+func (m *Repository) ManageBooking(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.bookingFromURL(w, r)
+	if !ok {
+		return
+	}
+	m.renderManageBooking(w, r, reservation, forms.New(nil))
+}
+
+// PostManageBooking updates the guest's contact details
+func (m *Repository) PostManageBooking(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation, ok := m.bookingFromURL(w, r)
+	if !ok {
+		return
+	}
+	if reservation.Cancelled() {
+		m.App.Session.Put(r.Context(), "error", "This reservation is cancelled")
+		http.Redirect(w, r, bookingPath(reservation), http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3, r)
+	form.IsEmail("email", r)
+
+	before := reservation
+	reservation.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	reservation.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	reservation.Email = r.Form.Get("email")
+	reservation.Phone = r.Form.Get("phone")
+
+	if !form.Valid() {
+		m.renderManageBooking(w, r, reservation, form)
+		return
+	}
+
+	err = m.DB.UpdateReservation(reservation, reservation.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditUpdate, models.AuditReservation, reservation.ID, before, reservation)
+	m.sendModification(before, reservation, 0)
+
+	m.App.Session.Put(r.Context(), "flash", "Your details are saved")
+	http.Redirect(w, r, bookingPath(reservation), http.StatusSeeOther)
+}
+
+// PostChangeBookingDates moves the guest's reservation to other dates when the room is free then, the stay is
+// priced again for the new dates with the promo code it was booked with, when the code still holds for them.
+// A paid stay can not cost more than was paid, what a cheaper one costs less is refunded
+func (m *Repository) PostChangeBookingDates(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation, ok := m.bookingFromURL(w, r)
+	if !ok {
+		return
+	}
+	if reservation.Cancelled() {
+		m.App.Session.Put(r.Context(), "error", "This reservation is cancelled")
+		http.Redirect(w, r, bookingPath(reservation), http.StatusSeeOther)
+		return
+	}
+	if !canChangeBooking(reservation) {
+		m.App.Session.Put(r.Context(), "error", "This stay has started and can not be changed anymore")
+		http.Redirect(w, r, bookingPath(reservation), http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start", "end")
+	start, end, err := parseStay(r.Form.Get("start"), r.Form.Get("end"))
+	switch {
+	case !form.Valid():
+	case err != nil:
+		form.Error.Add("end", "Choose an arrival and a departure after it")
+	case start.Before(time.Now().Truncate(24 * time.Hour)):
+		form.Error.Add("start", "Choose an arrival from today on")
+	}
+	if !form.Valid() {
+		m.renderManageBooking(w, r, reservation, form)
+		return
+	}
+
+	// the same check as the search, except the nights the reservation holds itself do not count
+	available, err := m.DB.SearchAvailabilityByDatesAndRoomID(start, end, reservation.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !available && !overlaps(reservation, start, end) {
+		form.Error.Add("start", "Sorry, the room is not available for these dates")
+		m.renderManageBooking(w, r, reservation, form)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(reservation.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	q, err := m.quoteStay(room, start, end)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	// the promo code has to hold for the new dates as well, the reservation is already one of its uses
+	if reservation.Quote.PromoCodeID > 0 {
+		p, err := m.DB.GetPromoCodeByID(reservation.Quote.PromoCodeID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+		reason := pricing.ErrPromoNotValid
+		if err == nil {
+			reason = pricing.CheckPromo(p, pricing.PromoUse{
+				RoomID:   reservation.RoomID,
+				Start:    start,
+				End:      end,
+				BookedAt: reservation.CreatedAt,
+			})
+		}
+		if reason != nil {
+			form.Error.Add("start", "Sorry, your promo code "+reservation.Quote.PromoCode+" can not be kept: "+reason.Error())
+			m.renderManageBooking(w, r, reservation, form)
+			return
+		}
+		q = pricing.ApplyPromo(q, p)
+	}
+	q, err = m.addCharges(q, reservation.Guests)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the guest can not pay more here, new dates that cost more than what was paid are left for the staff.
+	// Nothing is checked while no money has moved, like for a reservation booked through the API
+	ledger, err := m.DB.PaymentsForReservation(reservation.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	summary := payments.Summarize(ledger)
+	refund := 0
+	switch {
+	case summary.CanCapture() && q.Total != reservation.Quote.Total:
+		form.Error.Add("start", "Sorry, your payment is not settled yet. Please contact us to change the price of your stay")
+	case summary.Captured > 0 && q.Total > summary.Refundable():
+		form.Error.Add("start", "Sorry, these dates cost "+pricing.FormatMoney(q.Total-summary.Refundable())+
+			" more than you paid. Please contact us to change to these dates")
+	case summary.Captured > 0:
+		refund = summary.Refundable() - q.Total
+	}
+	if !form.Valid() {
+		m.renderManageBooking(w, r, reservation, form)
+		return
+	}
+
+	changed := reservation
+	changed.StartDate = start
+	changed.EndDate = end
+	changed.Quote = q
+	err = m.DB.ChangeReservationDates(changed)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		form.Error.Add("start", "Sorry, the room is not available for these dates")
+		m.renderManageBooking(w, r, reservation, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditUpdate, models.AuditReservation, reservation.ID, reservation, changed)
+
+	// what the shorter or cheaper stay does not cost any more is paid back, a refund that fails stays on the
+	// ledger for the staff
+	if refund > 0 {
+		reference, err := m.App.Payments.Refund(summary.Capture, refund)
+		payment := models.Payment{
+			ReservationID: reservation.ID,
+			Kind:          models.PaymentRefund,
+			Amount:        refund,
+			Reference:     reference,
+			Succeeded:     err == nil,
+			Message:       paymentMessage(err),
+		}
+		m.recordPayment(payment)
+		m.audit(r, auditRefund, models.AuditReservation, reservation.ID, nil, payment)
+		if err != nil {
+			refund = 0
+		}
+	}
+	m.sendModification(reservation, changed, refund)
+
+	flash := "Your stay is moved to " + render.HumanDate(start) + " - " + render.HumanDate(end)
+	if refund > 0 {
+		flash += ", " + pricing.FormatMoney(refund) + " is refunded to your card"
+	}
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, bookingPath(reservation), http.StatusSeeOther)
+}
+
+// sendModification mails the guest the details of their changed reservation, a changed address is told at the
+// old one as well
+func (m *Repository) sendModification(before, after models.Reservations, refund int) {
+	data := emails.Data{
+		Name:        after.FirstName,
+		Reservation: after,
+		Before:      before,
+		Link:        m.App.BaseURL + bookingPath(after),
+		Refund:      refund,
+	}
+	m.sendMail(emails.Modification, after.Email, data)
+	if !strings.EqualFold(before.Email, after.Email) {
+		m.sendMail(emails.Modification, before.Email, data)
+	}
+}
+
+// overlaps reports whether new dates share a night with the reservation, those nights are booked by the guest
+func overlaps(reservation models.Reservations, start, end time.Time) bool {
+	return start.Before(reservation.EndDate) && end.After(reservation.StartDate)
+}
+
+// PostCancelBooking cancels the guest's reservation with the fee of the room's cancellation policy and frees
+// the room, the rest of the payment is refunded
+func (m *Repository) PostCancelBooking(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.bookingFromURL(w, r)
+	if !ok {
+		return
+	}
+	if reservation.Cancelled() {
+		m.App.Session.Put(r.Context(), "error", "This reservation is cancelled")
+		http.Redirect(w, r, bookingPath(reservation), http.StatusSeeOther)
+		return
+	}
+	if !canChangeBooking(reservation) {
+		m.App.Session.Put(r.Context(), "error", "This stay has started and can not be cancelled anymore")
+		http.Redirect(w, r, bookingPath(reservation), http.StatusSeeOther)
+		return
+	}
+
+	terms, err := m.cancelReservation(r, reservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	msg := "Your reservation is cancelled"
+	if terms.Refund > 0 {
+		msg += ", " + pricing.FormatMoney(terms.Refund) + " is refunded to your card"
+	}
+	m.App.Session.Put(r.Context(), "flash", msg)
+	http.Redirect(w, r, bookingPath(reservation), http.StatusSeeOther)
+}
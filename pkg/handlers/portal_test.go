@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
+)
+
+func TestManageBooking(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	start := time.Now().AddDate(0, 2, 0).Truncate(24 * time.Hour)
+	book := func(from, to time.Time) models.Reservations {
+		chooseStay(t, client, testServer, from, to)
+		postReservation(t, client, testServer, "john@mail.com", "")
+		if res := postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+			t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+		}
+		reservation, err := Repo.DB.GetReservationByID(lastReservationID(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reservation
+	}
+	reservation := book(start, start.AddDate(0, 0, 2))
+	book(start.AddDate(0, 0, 5), start.AddDate(0, 0, 7))
+	path := testServer.URL + "/my-booking/" + reservation.AccessToken
+
+	post := func(url string, values url.Values) (*http.Response, string) {
+		res, err := client.PostForm(url, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	res, err := client.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected the booking page, but %d", res.StatusCode)
+	}
+	res, err = client.Get(testServer.URL + "/my-booking/nothing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected an unknown token to be not found, but %d", res.StatusCode)
+	}
+
+	// contact details
+	contact := url.Values{"first_name": {"Johnny"}, "last_name": {"Mayor"}, "email": {"not an email"}}
+	if res, body := post(path, contact); res.StatusCode != http.StatusOK || !strings.Contains(body, "Invalid") {
+		t.Errorf("expected a bad email to show the form again, but %d", res.StatusCode)
+	}
+	contact.Set("email", "johnny@mail.com")
+	before := lastMail(t).ID
+	if res, _ := post(path, contact); res.StatusCode != http.StatusSeeOther {
+		t.Errorf("expected the details to be saved, but %d", res.StatusCode)
+	}
+	// the old address is told about the change as well
+	mails, _ := Repo.DB.OutboxMessages("", 2)
+	if len(mails) != 2 || mails[1].ID <= before || mails[0].To != "john@mail.com" || mails[1].To != "johnny@mail.com" ||
+		mails[1].Subject != "Your reservation is changed" {
+		t.Errorf("expected the modification mailed to the new and the old address, but %+v", mails)
+	}
+	if changed, _ := Repo.DB.GetReservationByID(reservation.ID); changed.FirstName != "Johnny" || changed.Email != "johnny@mail.com" {
+		t.Errorf("unexpected contact details %s %s", changed.FirstName, changed.Email)
+	}
+
+	// dates, the second reservation holds nights 5 and 6
+	dates := func(from, to time.Time) url.Values {
+		return url.Values{"start": {from.Format("2006-01-02")}, "end": {to.Format("2006-01-02")}}
+	}
+	var theTests = []struct {
+		name     string
+		from, to time.Time
+		expected int
+	}{
+		{"backwards", start.AddDate(0, 0, 2), start, http.StatusOK},
+		{"past", start.AddDate(0, -3, 0), start.AddDate(0, -3, 2), http.StatusOK},
+		{"taken", start.AddDate(0, 0, 1), start.AddDate(0, 0, 6), http.StatusOK},
+		// the guest paid for 2 nights
+		{"longer than paid", start.AddDate(0, 0, 1), start.AddDate(0, 0, 4), http.StatusOK},
+		{"one night later", start.AddDate(0, 0, 1), start.AddDate(0, 0, 3), http.StatusSeeOther},
+		{"one night shorter", start.AddDate(0, 0, 1), start.AddDate(0, 0, 2), http.StatusSeeOther},
+	}
+	for _, e := range theTests {
+		if res, _ := post(path+"/dates", dates(e.from, e.to)); res.StatusCode != e.expected {
+			t.Errorf("for %s, expected %d, but %d", e.name, e.expected, res.StatusCode)
+		}
+	}
+
+	changed, _ := Repo.DB.GetReservationByID(reservation.ID)
+	if !changed.StartDate.Equal(start.AddDate(0, 0, 1)) || len(changed.Quote.Nights) != 1 {
+		t.Errorf("expected the stay to move to 1 night a day later, but %+v", changed)
+	}
+	// the night less is paid back
+	ledger, _ := Repo.DB.PaymentsForReservation(reservation.ID)
+	if summary := payments.Summarize(ledger); summary.Refunded != reservation.Quote.Total-changed.Quote.Total ||
+		summary.Refundable() != changed.Quote.Total || summary.Refunded == 0 {
+		t.Errorf("expected the difference to be refunded, but %+v", summary)
+	}
+	if msg := lastMail(t); msg.To != "johnny@mail.com" || !strings.Contains(msg.PlainContent, "It was from") ||
+		!strings.Contains(msg.PlainContent, "is refunded to your card") {
+		t.Errorf("expected the new dates to be mailed with the old ones and the refund, but %s", msg.PlainContent)
+	}
+	restrictions, _ := Repo.DB.GetRestrictionsForRoomByDate(1, start, start.AddDate(0, 0, 4))
+	if len(restrictions) != 1 || !restrictions[0].EndDate.Equal(start.AddDate(0, 0, 2)) {
+		t.Errorf("expected the room restriction to move along, but %+v", restrictions)
+	}
+
+	// cancel
+	if res, _ := post(path+"/cancel", url.Values{}); res.StatusCode != http.StatusSeeOther {
+		t.Errorf("expected the reservation to be cancelled, but %d", res.StatusCode)
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesAndRoomID(start, start.AddDate(0, 0, 4), 1); !available {
+		t.Error("expected the room to be free again")
+	}
+	if cancelled, _ := Repo.DB.GetReservationByID(reservation.ID); !cancelled.Cancelled() {
+		t.Error("expected the reservation to be kept as cancelled")
+	}
+
+	// the dates of a cancelled reservation can not be changed
+	post(path+"/dates", dates(start, start.AddDate(0, 0, 1)))
+	if available, _ := Repo.DB.SearchAvailabilityByDatesAndRoomID(start, start.AddDate(0, 0, 1), 1); !available {
+		t.Error("expected the dates of a cancelled reservation to stay free")
+	}
+}
+
+func TestChangeBookingDatesWithPromo(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	start := time.Now().AddDate(0, 7, 0).Truncate(24 * time.Hour)
+	_, err := Repo.DB.InsertPromoCode(models.PromoCode{
+		Code:      "TWONIGHTS",
+		Kind:      models.PromoPercent,
+		Amount:    20,
+		MinNights: 2,
+		Active:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 3))
+	postReservation(t, client, testServer, "promo-dates@mail.com", "TWONIGHTS")
+	if res := postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+	reservation, _ := Repo.DB.GetReservationByID(lastReservationID(t))
+	if reservation.Quote.PromoCode != "TWONIGHTS" {
+		t.Fatalf("expected the promo code on the booking, but %+v", reservation.Quote)
+	}
+	path := testServer.URL + "/my-booking/" + reservation.AccessToken + "/dates"
+
+	// one night is too short for the code
+	res, err := client.PostForm(path, url.Values{"start": {start.Format("2006-01-02")}, "end": {start.AddDate(0, 0, 1).Format("2006-01-02")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "can not be kept") {
+		t.Errorf("expected the promo code to stop the change, but %d", res.StatusCode)
+	}
+
+	// two nights keep it, the percentage is taken of the new price
+	res, err = client.PostForm(path, url.Values{"start": {start.Format("2006-01-02")}, "end": {start.AddDate(0, 0, 2).Format("2006-01-02")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the stay to be shortened, but %d", res.StatusCode)
+	}
+	changed, _ := Repo.DB.GetReservationByID(reservation.ID)
+	price := changed.Quote.Subtotal - changed.Quote.Discount
+	if changed.Quote.PromoCode != "TWONIGHTS" || changed.Quote.PromoDiscount != (price*20+50)/100 {
+		t.Errorf("expected 20%% off the new price, but %+v", changed.Quote)
+	}
+}
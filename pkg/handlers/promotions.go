@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/go-chi/chi"
+)
+
+// promoCodePattern is what a promo code may look like once it is upper cased
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9-]{3,32}$`)
+
+// applyPromoCode prices the reservation's quote with the promo code the guest entered, a code that can not be
+// used is put on the form and the quote is returned without it
+func (m *Repository) applyPromoCode(form *forms.Form, res models.Reservations) (models.Quote, error) {
+	code := pricing.NormalizeCode(form.Get("promo_code"))
+	if code == "" {
+		return res.Quote, nil
+	}
+
+	p, err := m.DB.GetPromoCodeByCode(code)
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Error.Add("promo_code", "Sorry, "+pricing.ErrPromoNotValid.Error())
+		return res.Quote, nil
+	}
+	if err != nil {
+		return res.Quote, err
+	}
+
+	total, forEmail, err := m.DB.CountPromoRedemptions(p.ID, res.Email)
+	if err != nil {
+		return res.Quote, err
+	}
+
+	err = pricing.CheckPromo(p, pricing.PromoUse{
+		RoomID:           res.RoomID,
+		Start:            res.StartDate,
+		End:              res.EndDate,
+		BookedAt:         time.Now(),
+		Redemptions:      total,
+		EmailRedemptions: forEmail,
+	})
+	if err != nil {
+		form.Error.Add("promo_code", "Sorry, "+err.Error())
+		return res.Quote, nil
+	}
+	return pricing.ApplyPromo(res.Quote, p), nil
+}
+
+// optionalDate reads a date field that may be left empty, a bad date is put on the form
+func optionalDate(form *forms.Form, field string) time.Time {
+	if form.Get(field) == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02", form.Get(field))
+	if err != nil {
+		form.Error.Add(field, "Enter a date such as 2026-07-01")
+	}
+	return t
+}
+
+// optionalNumber reads a whole number field that may be left empty for 0, a bad number is put on the form
+func optionalNumber(form *forms.Form, field string) int {
+	if form.Get(field) == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(form.Get(field))
+	if err != nil || n < 0 {
+		form.Error.Add(field, "This field needs to be a number of at least 0")
+	}
+	return n
+}
+
+// promoCodeFromForm reads a new promo code from the posted form
+func promoCodeFromForm(r *http.Request, rooms []models.Room) (models.PromoCode, *forms.Form) {
+	form := forms.New(r.PostForm)
+	form.Required("code", "kind", "amount")
+
+	p := models.PromoCode{
+		Code:           pricing.NormalizeCode(r.Form.Get("code")),
+		Description:    strings.TrimSpace(r.Form.Get("description")),
+		Kind:           r.Form.Get("kind"),
+		BookFrom:       optionalDate(form, "book_from"),
+		BookUntil:      optionalDate(form, "book_until"),
+		StayFrom:       optionalDate(form, "stay_from"),
+		StayUntil:      optionalDate(form, "stay_until"),
+		MinNights:      optionalNumber(form, "min_nights"),
+		MaxRedemptions: optionalNumber(form, "max_redemptions"),
+		MaxPerEmail:    optionalNumber(form, "max_per_email"),
+		Active:         true,
+	}
+
+	if p.Code != "" && !promoCodePattern.MatchString(p.Code) {
+		form.Error.Add("code", "Use 3 to 32 letters, numbers and dashes")
+	}
+
+	switch p.Kind {
+	case models.PromoPercent:
+		form.IsNumber("amount", 1, r)
+		p.Amount, _ = strconv.Atoi(r.Form.Get("amount"))
+		if p.Amount > 100 {
+			form.Error.Add("amount", "A discount can not be more than 100%")
+		}
+	case models.PromoFixed:
+		p.Amount = moneyFromForm(form, "amount")
+		if form.Get("amount") != "" && p.Amount <= 0 {
+			form.Error.Add("amount", "Enter an amount such as 20.00")
+		}
+	default:
+		form.Error.Add("kind", "Choose a kind of discount")
+	}
+
+	if !p.BookFrom.IsZero() && !p.BookUntil.IsZero() && p.BookUntil.Before(p.BookFrom) {
+		form.Error.Add("book_until", "The booking window can not end before it starts")
+	}
+	if !p.StayFrom.IsZero() && !p.StayUntil.IsZero() && p.StayUntil.Before(p.StayFrom) {
+		form.Error.Add("stay_until", "The stay window can not end before it starts")
+	}
+
+	for _, room := range rooms {
+		if r.Form.Get(fmt.Sprintf("room_%d", room.ID)) != "" {
+			p.RoomIDs = append(p.RoomIDs, room.ID)
+		}
+	}
+	return p, form
+}
+
+// roomNames maps the ids of rooms to their names, for the rooms promo codes are restricted to
+func roomNames(rooms []models.Room) map[int]string {
+	names := make(map[int]string)
+	for _, room := range rooms {
+		names[room.ID] = room.RoomName
+	}
+	return names
+}
+
+// renderPromotions shows the promotions page with the promo code form
+func (m *Repository) renderPromotions(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	codes, err := m.DB.AllPromoCodes()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["codes"] = codes
+	data["rooms"] = rooms
+	data["room_names"] = roomNames(rooms)
+
+	render.RenderTemplate(w, r, "admin-promotions.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPromotions shows the promo codes with how often they were used
+func (m *Repository) AdminPromotions(w http.ResponseWriter, r *http.Request) {
+	m.renderPromotions(w, r, forms.New(nil))
+}
+
+// AdminPostPromotion creates a promo code
+func (m *Repository) AdminPostPromotion(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	p, form := promoCodeFromForm(r, rooms)
+	if form.Valid() {
+		_, err = m.DB.GetPromoCodeByCode(p.Code)
+		if err == nil {
+			form.Error.Add("code", "This code already exists")
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderPromotions(w, r, form)
+		return
+	}
+
+	p.ID, err = m.DB.InsertPromoCode(p)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditCreate, models.AuditPromoCode, p.ID, nil, p)
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code "+p.Code+" created!")
+	http.Redirect(w, r, "/admin/promotions", http.StatusSeeOther)
+}
+
+// AdminShowPromotion shows a promo code and the reservations that used it
+func (m *Repository) AdminShowPromotion(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.promoCodeFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	redemptions, err := m.DB.PromoRedemptionsForCode(p.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	discounts := 0
+	for _, redemption := range redemptions {
+		discounts += redemption.Discount
+	}
+
+	data := make(map[string]interface{})
+	data["code"] = p
+	data["redemptions"] = redemptions
+	data["room_names"] = roomNames(rooms)
+
+	intMap := make(map[string]int)
+	intMap["discounts"] = discounts
+
+	render.RenderTemplate(w, r, "admin-promotion-show.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// AdminActivatePromotion lets guests use a promo code again
+func (m *Repository) AdminActivatePromotion(w http.ResponseWriter, r *http.Request) {
+	m.setPromotionActive(w, r, true)
+}
+
+// AdminDeactivatePromotion stops guests from using a promo code, its past uses are kept
+func (m *Repository) AdminDeactivatePromotion(w http.ResponseWriter, r *http.Request) {
+	m.setPromotionActive(w, r, false)
+}
+
+func (m *Repository) setPromotionActive(w http.ResponseWriter, r *http.Request, active bool) {
+	p, ok := m.promoCodeFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.UpdateActiveForPromoCode(p.ID, active)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.auditActive(r, models.AuditPromoCode, p.ID, active)
+
+	if active {
+		m.App.Session.Put(r.Context(), "flash", p.Code+" can be used again")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", p.Code+" can not be used anymore")
+	}
+	http.Redirect(w, r, "/admin/promotions", http.StatusSeeOther)
+}
+
+// promoCodeFromURL looks up the promo code in the url, writing the error response when there is none
+func (m *Repository) promoCodeFromURL(w http.ResponseWriter, r *http.Request) (models.PromoCode, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.PromoCode{}, false
+	}
+
+	p, err := m.DB.GetPromoCodeByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return p, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return p, false
+	}
+	return p, true
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// chooseStay searches for a stay and picks room 1, which puts the reservation in the session
+func chooseStay(t *testing.T, client *http.Client, testServer *httptest.Server, start, end time.Time) {
+	values := url.Values{}
+	values.Add("start", start.Format("2006-01-02"))
+	values.Add("end", end.Format("2006-01-02"))
+	res, err := client.PostForm(testServer.URL+"/search-availability", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	res, err = client.Get(testServer.URL + "/choose-room/1-generals quater")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected choosing the room to redirect, but %d", res.StatusCode)
+	}
+}
+
+// postReservation books the stay in the session with a promo code and returns the response with its body
+func postReservation(t *testing.T, client *http.Client, testServer *httptest.Server, email, promoCode string) (*http.Response, string) {
+	values := url.Values{}
+	values.Add("first_name", "John")
+	values.Add("last_name", "Mayor")
+	values.Add("email", email)
+	values.Add("promo_code", promoCode)
+	res, err := client.PostForm(testServer.URL+"/make-reservation", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	return res, string(body)
+}
+
+func TestPromoCodeCheckout(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	promoID, err := Repo.DB.InsertPromoCode(models.PromoCode{
+		Code:        "WELCOME10",
+		Kind:        models.PromoPercent,
+		Amount:      10,
+		RoomIDs:     []int{1},
+		MaxPerEmail: 1,
+		Active:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().AddDate(0, 2, 0)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 2))
+
+	res, body := postReservation(t, client, testServer, "john@mail.com", "nothing")
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, "not valid") {
+		t.Errorf("expected an unknown code to show the form again, but %d", res.StatusCode)
+	}
+
+	// codes are not case sensitive
+	res, _ = postReservation(t, client, testServer, "john@mail.com", "welcome10")
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to go on to the payment, but %d", res.StatusCode)
+	}
+	if res = postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+
+	redemptions, _ := Repo.DB.PromoRedemptionsForCode(promoID)
+	if len(redemptions) != 1 {
+		t.Fatalf("expected 1 redemption, but %d", len(redemptions))
+	}
+	reservation, err := Repo.DB.GetReservationByID(redemptions[0].ReservationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := reservation.Quote
+	if q.PromoCode != "WELCOME10" || q.PromoDiscount == 0 || q.Total != q.Subtotal-q.Discount-q.PromoDiscount {
+		t.Errorf("unexpected quote of the reservation %+v", q)
+	}
+	if redemptions[0].Discount != q.PromoDiscount {
+		t.Errorf("expected the redemption to record %d, but %d", q.PromoDiscount, redemptions[0].Discount)
+	}
+
+	// the code can be used once per email
+	chooseStay(t, client, testServer, start.AddDate(0, 0, 7), start.AddDate(0, 0, 9))
+	res, body = postReservation(t, client, testServer, "JOHN@mail.com", "WELCOME10")
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, "already been used") {
+		t.Errorf("expected a second use with the same email to show the form again, but %d", res.StatusCode)
+	}
+}
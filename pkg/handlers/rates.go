@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+	"github.com/go-chi/chi"
+)
+
+// quoteStay prices a stay in room with the room's current rates
+func (m *Repository) quoteStay(room models.Room, start, end time.Time) (models.Quote, error) {
+	seasons, err := m.DB.SeasonalRatesForRoom(room.ID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+	discounts, err := m.DB.StayDiscountsForRoom(room.ID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	plan := pricing.Plan{
+		Room:      room,
+		Seasons:   seasons,
+		Discounts: discounts,
+	}
+	return plan.Quote(start, end)
+}
+
+// moneyFromForm reads an amount field of a form as cents, a bad amount is put on the form
+func moneyFromForm(form *forms.Form, field string) int {
+	cents, err := pricing.ParseMoney(form.Get(field))
+	if err != nil {
+		form.Error.Add(field, "Enter an amount such as 129.50")
+	}
+	return cents
+}
+
+// AdminPostSeasonalRate adds a seasonal rate to a room
+func (m *Repository) AdminPostSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("season_name", "season_start", "season_end", "season_rate")
+
+	rate := models.SeasonalRate{
+		RoomID:      room.ID,
+		Name:        strings.TrimSpace(r.Form.Get("season_name")),
+		NightlyRate: moneyFromForm(form, "season_rate"),
+		WeekendRate: moneyFromForm(form, "season_weekend_rate"),
+	}
+
+	layout := "2006-01-02"
+	rate.StartDate, err = time.Parse(layout, r.Form.Get("season_start"))
+	if err != nil {
+		form.Error.Add("season_start", "Enter a date such as 2026-07-01")
+	}
+	rate.EndDate, err = time.Parse(layout, r.Form.Get("season_end"))
+	if err != nil {
+		form.Error.Add("season_end", "Enter a date such as 2026-08-31")
+	} else if rate.EndDate.Before(rate.StartDate) {
+		form.Error.Add("season_end", "The season can not end before it starts")
+	}
+
+	if !form.Valid() {
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+
+	rate.ID, err = m.DB.InsertSeasonalRate(rate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditCreate, models.AuditSeasonalRate, rate.ID, nil, rate)
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate added!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteSeasonalRate deletes a seasonal rate of a room
+func (m *Repository) AdminDeleteSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "room_id"))
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	var deleted interface{}
+	rates, err := m.DB.SeasonalRatesForRoom(roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	for _, rate := range rates {
+		if rate.ID == id {
+			deleted = rate
+		}
+	}
+
+	err = m.DB.DeleteSeasonalRate(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditDelete, models.AuditSeasonalRate, id, deleted, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate deleted.")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", roomID), http.StatusSeeOther)
+}
+
+// AdminPostStayDiscount adds a length of stay discount to a room
+func (m *Repository) AdminPostStayDiscount(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("min_nights", "percent")
+	form.IsNumber("min_nights", 2, r)
+	form.IsNumber("percent", 1, r)
+
+	d := models.StayDiscount{RoomID: room.ID}
+	d.MinNights, _ = strconv.Atoi(r.Form.Get("min_nights"))
+	d.Percent, _ = strconv.Atoi(r.Form.Get("percent"))
+	if d.Percent > 100 {
+		form.Error.Add("percent", "A discount can not be more than 100%")
+	}
+
+	if form.Valid() {
+		discounts, err := m.DB.StayDiscountsForRoom(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		for _, other := range discounts {
+			if other.MinNights == d.MinNights {
+				form.Error.Add("min_nights", fmt.Sprintf("Stays of %d nights already have a discount", d.MinNights))
+			}
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+
+	d.ID, err = m.DB.InsertStayDiscount(d)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditCreate, models.AuditStayDiscount, d.ID, nil, d)
+
+	m.App.Session.Put(r.Context(), "flash", "Stay discount added!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteStayDiscount deletes a length of stay discount of a room
+func (m *Repository) AdminDeleteStayDiscount(w http.ResponseWriter, r *http.Request) {
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "room_id"))
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	var deleted interface{}
+	discounts, err := m.DB.StayDiscountsForRoom(roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	for _, d := range discounts {
+		if d.ID == id {
+			deleted = d
+		}
+	}
+
+	err = m.DB.DeleteStayDiscount(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditDelete, models.AuditStayDiscount, id, deleted, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Stay discount deleted.")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", roomID), http.StatusSeeOther)
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/go-chi/chi"
+)
+
+// Rooms lists the active rooms on the public site
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllActiveRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.RenderTemplate(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// bookableRoom looks up a room guests can book, an unknown or deactivated room answers 404 like its page does
+func (m *Repository) bookableRoom(w http.ResponseWriter, id int) (models.Room, bool) {
+	room, err := m.DB.GetRoomByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return room, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return room, false
+	}
+	return room, true
+}
+
+// Room shows the page of one room, found by the slug in the url
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !room.Active) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.RenderTemplate(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRooms lists every room in the admin tool
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.RenderTemplate(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewRoom shows an empty room form
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminRoom(w, r, models.Room{Capacity: 2, Active: true}, forms.New(nil))
+}
+
+// AdminPostNewRoom creates a room
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, form := m.roomFromForm(r, models.Room{})
+	if !form.Valid() {
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+
+	room.ID, err = m.DB.InsertRoom(room)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditCreate, models.AuditRoom, room.ID, nil, room)
+
+	m.App.Session.Put(r.Context(), "flash", "Room created!")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminShowRoom shows the form of an existing room with its seasonal rates and stay discounts
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderAdminRoom(w, r, room, forms.New(nil))
+}
+
+func (m *Repository) renderAdminRoom(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	// a new room gets its rates once it is saved
+	if room.ID > 0 {
+		seasons, err := m.DB.SeasonalRatesForRoom(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		discounts, err := m.DB.StayDiscountsForRoom(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		rules, err := m.DB.CancellationRulesForRoom(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["seasons"] = seasons
+		data["discounts"] = discounts
+		data["cancellation_rules"] = rules
+	}
+
+	render.RenderTemplate(w, r, "admin-room-show.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostShowRoom saves changes to an existing room
+func (m *Repository) AdminPostShowRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	existing, ok := m.roomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	room, form := m.roomFromForm(r, existing)
+	if !form.Valid() {
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+
+	err = m.DB.UpdateRoom(room)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditUpdate, models.AuditRoom, room.ID, existing, room)
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved!")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminActivateRoom puts a room back on the public site
+func (m *Repository) AdminActivateRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomActive(w, r, true)
+}
+
+// AdminDeactivateRoom takes a room off the public site, its reservations are kept
+func (m *Repository) AdminDeactivateRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomActive(w, r, false)
+}
+
+func (m *Repository) setRoomActive(w http.ResponseWriter, r *http.Request, active bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.UpdateActiveForRoom(id, active)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.auditActive(r, models.AuditRoom, id, active)
+
+	if active {
+		m.App.Session.Put(r.Context(), "flash", "Room is activated.")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Room is deactivated.")
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// roomFromForm copies the posted room form onto room and validates it
+func (m *Repository) roomFromForm(r *http.Request, room models.Room) (models.Room, *forms.Form) {
+	room.RoomName = strings.TrimSpace(r.Form.Get("room_name"))
+	room.Slug = strings.TrimSpace(r.Form.Get("slug"))
+	room.Description = r.Form.Get("description")
+	room.Image = strings.TrimSpace(r.Form.Get("image"))
+	room.Capacity, _ = strconv.Atoi(r.Form.Get("capacity"))
+	room.SortOrder, _ = strconv.Atoi(r.Form.Get("sort_order"))
+	room.Active = r.Form.Get("active") != ""
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "slug", "capacity", "base_rate")
+	form.IsSlug("slug", r)
+	form.IsNumber("capacity", 1, r)
+	form.IsNumber("sort_order", 0, r)
+	room.BaseRate = moneyFromForm(form, "base_rate")
+	room.WeekendRate = moneyFromForm(form, "weekend_rate")
+
+	if form.Error.Get("slug") == "" {
+		other, err := m.DB.GetRoomBySlug(room.Slug)
+		if err == nil && other.ID != room.ID {
+			form.Error.Add("slug", fmt.Sprintf("The slug is already used by %s", other.RoomName))
+		}
+	}
+
+	return room, form
+}
+
+// roomFromURL looks up the room of the id in the url, it answers 404 itself
+func (m *Repository) roomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return room, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return room, false
+	}
+	return room, true
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/invoices"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+)
+
+// AdminSettings shows the site settings
+func (m *Repository) AdminSettings(w http.ResponseWriter, r *http.Request) {
+	values := make(map[string]string)
+	for _, key := range []string{models.SettingRequire2FALevel, models.SettingInvoiceIssuer, models.SettingTaxName, models.SettingTaxRate, models.SettingTrashRetentionDays} {
+		value, err := m.DB.GetSetting(key)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		values[key] = value
+	}
+
+	// the rate is kept in hundredths of a percent and edited as a percentage
+	if rate, _ := strconv.Atoi(values[models.SettingTaxRate]); rate > 0 {
+		values[models.SettingTaxRate] = strings.TrimSuffix(invoices.FormatRate(rate), "%")
+	} else {
+		values[models.SettingTaxRate] = ""
+	}
+	values[models.SettingTrashRetentionDays] = strconv.Itoa(m.trashRetentionDays())
+
+	m.renderAdminSettings(w, r, values, forms.New(nil))
+}
+
+func (m *Repository) renderAdminSettings(w http.ResponseWriter, r *http.Request, values map[string]string, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["levels"] = accessLevelNames()
+
+	render.RenderTemplate(w, r, "admin-settings.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: values,
+		Form:      form,
+	})
+}
+
+// AdminPostSettings saves the site settings
+func (m *Repository) AdminPostSettings(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	values := map[string]string{
+		models.SettingRequire2FALevel:    r.Form.Get(models.SettingRequire2FALevel),
+		models.SettingInvoiceIssuer:      strings.TrimSpace(r.Form.Get(models.SettingInvoiceIssuer)),
+		models.SettingTaxName:            strings.TrimSpace(r.Form.Get(models.SettingTaxName)),
+		models.SettingTaxRate:            strings.TrimSpace(r.Form.Get(models.SettingTaxRate)),
+		models.SettingTrashRetentionDays: strings.TrimSpace(r.Form.Get(models.SettingTrashRetentionDays)),
+	}
+
+	form := forms.New(r.PostForm)
+	if level, err := strconv.Atoi(values[models.SettingRequire2FALevel]); err != nil || (level != 0 && !models.ValidAccessLevel(level)) {
+		form.Error.Add(models.SettingRequire2FALevel, "Choose who has to use two-factor authentication")
+	}
+	// a percentage with up to 2 decimals reads the same way as an amount of money
+	rate, err := pricing.ParseMoney(values[models.SettingTaxRate])
+	if err != nil || rate < 0 || rate > 10000 {
+		form.Error.Add(models.SettingTaxRate, "Enter a percentage between 0 and 100")
+	}
+	if days, err := strconv.Atoi(values[models.SettingTrashRetentionDays]); err != nil || days < 1 || days > 365 {
+		form.Error.Add(models.SettingTrashRetentionDays, "Enter a number of days between 1 and 365")
+	}
+	if !form.Valid() {
+		m.renderAdminSettings(w, r, values, form)
+		return
+	}
+	values[models.SettingTaxRate] = strconv.Itoa(rate)
+
+	before := make(map[string]string)
+	for key := range values {
+		before[key], err = m.DB.GetSetting(key)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	for key, value := range values {
+		err = m.DB.UpdateSetting(key, value)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	m.audit(r, auditUpdate, models.AuditSettings, 0, before, values)
+
+	m.App.Session.Put(r.Context(), "flash", "Settings saved!")
+	http.Redirect(w, r, "/admin/settings", http.StatusSeeOther)
+}
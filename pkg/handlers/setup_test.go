@@ -11,8 +11,15 @@ import (
 	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/fangjjcs/bookings-app/pkg/audit"
 	"github.com/fangjjcs/bookings-app/pkg/config"
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/events"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/invoices"
 	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
 	"github.com/fangjjcs/bookings-app/pkg/render"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -23,14 +30,25 @@ import (
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate": render.HumanDate,
+	"formatDate": render.FormatDate,
+	"iterate": render.Iterate,
+	"money": pricing.FormatMoney,
+	"amount": pricing.FormatAmount,
+	"rate": invoices.FormatRate,
+	"statusClass": render.StatusClass,
+	"changes": audit.Decode,
+	"auditValue": audit.Format,
+}
 
 var infoLog *log.Logger
 var errorLog *log.Logger
  
 func getRoutes() http.Handler{
 	// what am I going to put in the session
-	gob.Register(models.Reservation{})
+	gob.Register(models.Reservations{})
+	gob.Register(time.Time{})
 
 	// change this to true when in production
 	app.InProduction = false
@@ -50,17 +68,33 @@ func getRoutes() http.Handler{
 
 	app.Session = session
 
+	// failed logins are not slowed down in tests
+	loginDelay = func(time.Duration) {}
+
+	// mails stay in the outbox, there is no mail server in tests
+	app.MailFrom = "server@booking.com"
+	app.Payments = payments.NewFakeGateway()
+
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
 		log.Fatal("cannot create template cache")
 		
 	}
 	app.TemplateCache = tc
+	app.EmailTemplates, err = emails.Load(pathToTemplates + "/email")
+	if err != nil {
+		log.Fatal("cannot load the email templates")
+	}
 	app.UseCache = true // define whenever you allow to use cache or not
 
-	repo := NewRepo(&app)
+	app.BaseURL = "http://localhost:8088"
+	app.Events = events.New()
+
+	repo := NewMemoryRepo(&app)
 	NewHandlers(repo)
+	repo.ListenForNotifications()
 	render.NewTemplates(&app)
+	helpers.NewHelpers(&app)
 
 	//routes
 	mux := chi.NewRouter()
@@ -71,18 +105,117 @@ func getRoutes() http.Handler{
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals", Repo.Generals)
-	mux.Get("/majors", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Get("/generals", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently).ServeHTTP)
+	mux.Get("/majors", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently).ServeHTTP)
 	mux.Get("/search-availability", Repo.SearchAvailability)
 	mux.Post("/search-availability", Repo.PostSearchAvailability)
 	mux.Get("/search-availability-json", Repo.JsonSearchAvailability)
+	mux.Get("/choose-room/{id}-{room_name}", Repo.ChooseRoom)
 
 	mux.Get("/make-reservation", Repo.MakeReservation)
 	mux.Post("/make-reservation", Repo.PostMakeReservation)
+	mux.Get("/make-payment", Repo.MakePayment)
+	mux.Post("/make-payment", Repo.PostMakePayment)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/my-booking/{token}", Repo.ManageBooking)
+	mux.Post("/my-booking/{token}", Repo.PostManageBooking)
+	mux.Post("/my-booking/{token}/dates", Repo.PostChangeBookingDates)
+	mux.Post("/my-booking/{token}/cancel", Repo.PostCancelBooking)
 
 	mux.Get("/contact", Repo.Contact)
 
+	mux.Get("/admin/notifications", Repo.AdminNotifications)
+	mux.Post("/admin/notifications", Repo.AdminPostNotifications)
+	mux.Get("/admin/notifications/unread", Repo.AdminUnreadNotifications)
+	mux.Get("/admin/notifications/{id}", Repo.AdminOpenNotification)
+	mux.Get("/admin/read-notifications/do", Repo.AdminReadNotifications)
+	mux.Get("/admin/reservations-pending", Repo.AdminPendingReservations)
+	mux.Get("/admin/reservations-all", Repo.AdminAllReservation)
+	mux.Get("/admin/change-reservation-status/{src}/{id}/{status}/do", Repo.AdminChangeReservationStatus)
+	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+	mux.Get("/admin/reservations/{src}/{id}/invoice", Repo.AdminReservationInvoice)
+	mux.Get("/admin/capture-payment/{src}/{id}/do", Repo.AdminCapturePayment)
+	mux.Get("/admin/void-payment/{src}/{id}/do", Repo.AdminVoidPayment)
+	mux.Post("/admin/refund-payment/{src}/{id}", Repo.AdminPostRefundPayment)
+	mux.Get("/admin/cancel-reservation/{src}/{id}/do", Repo.AdminCancelReservation)
+	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
+	mux.Get("/admin/trash", Repo.AdminTrash)
+	mux.Get("/admin/restore-reservation/{id}/do", Repo.AdminRestoreReservation)
+	mux.Get("/admin/audit", Repo.AdminAudit)
+	mux.Get("/admin/outbox", Repo.AdminOutbox)
+	mux.Get("/admin/outbox/{id}", Repo.AdminShowOutboxMessage)
+	mux.Get("/admin/resend-mail/{id}/do", Repo.AdminResendMail)
+	mux.Get("/admin/email-templates", Repo.AdminEmailTemplates)
+	mux.Get("/admin/email-templates/{name}", Repo.AdminShowEmailTemplate)
+	mux.Get("/admin/email-templates/{name}/html", Repo.AdminEmailTemplateHTML)
+	mux.Get("/admin/guest-emails", Repo.AdminGuestEmails)
+	mux.Post("/admin/guest-emails", Repo.AdminPostGuestEmails)
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/new", Repo.AdminNewRoom)
+	mux.Post("/admin/rooms/new", Repo.AdminPostNewRoom)
+	mux.Get("/admin/rooms/{id}", Repo.AdminShowRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostShowRoom)
+	mux.Get("/admin/deactivate-room/{id}/do", Repo.AdminDeactivateRoom)
+	mux.Post("/admin/rooms/{id}/seasonal-rates", Repo.AdminPostSeasonalRate)
+	mux.Get("/admin/delete-seasonal-rate/{room_id}/{id}/do", Repo.AdminDeleteSeasonalRate)
+	mux.Post("/admin/rooms/{id}/stay-discounts", Repo.AdminPostStayDiscount)
+	mux.Get("/admin/delete-stay-discount/{room_id}/{id}/do", Repo.AdminDeleteStayDiscount)
+	mux.Post("/admin/rooms/{id}/cancellation-rules", Repo.AdminPostCancellationRule)
+	mux.Get("/admin/delete-cancellation-rule/{room_id}/{id}/do", Repo.AdminDeleteCancellationRule)
+	mux.Get("/admin/promotions", Repo.AdminPromotions)
+	mux.Post("/admin/promotions", Repo.AdminPostPromotion)
+	mux.Get("/admin/promotions/{id}", Repo.AdminShowPromotion)
+	mux.Get("/admin/activate-promotion/{id}/do", Repo.AdminActivatePromotion)
+	mux.Get("/admin/deactivate-promotion/{id}/do", Repo.AdminDeactivatePromotion)
+	mux.Get("/admin/taxes", Repo.AdminTaxRules)
+	mux.Post("/admin/taxes", Repo.AdminPostTaxRule)
+	mux.Get("/admin/activate-tax-rule/{id}/do", Repo.AdminActivateTaxRule)
+	mux.Get("/admin/deactivate-tax-rule/{id}/do", Repo.AdminDeactivateTaxRule)
+	mux.Get("/admin/delete-tax-rule/{id}/do", Repo.AdminDeleteTaxRule)
+	mux.Get("/admin/api-keys", Repo.AdminAPIKeys)
+	mux.Post("/admin/api-keys", Repo.AdminPostAPIKey)
+	mux.Get("/admin/revoke-api-key/{id}/do", Repo.AdminRevokeAPIKey)
+	mux.Get("/admin/users", Repo.AdminUsers)
+	mux.Post("/admin/users", Repo.AdminPostInviteUser)
+	mux.Get("/admin/users/{id}", Repo.AdminShowUser)
+	mux.Post("/admin/users/{id}", Repo.AdminPostShowUser)
+	mux.Get("/admin/disable-user/{id}/do", Repo.AdminDisableUser)
+	mux.Get("/admin/enable-user/{id}/do", Repo.AdminEnableUser)
+	mux.Get("/admin/reset-user-password/{id}/do", Repo.AdminResetUserPassword)
+
+	mux.Get("/user/login", Repo.Login)
+	mux.Post("/user/login", Repo.PostLogin)
+	mux.Get("/user/two-factor", Repo.TwoFactor)
+	mux.Post("/user/two-factor", Repo.PostTwoFactor)
+	mux.Get("/admin/two-factor", Repo.AdminTwoFactor)
+	mux.Post("/admin/two-factor", Repo.AdminPostTwoFactor)
+	mux.Post("/admin/two-factor/disable", Repo.AdminPostDisableTwoFactor)
+	mux.Post("/admin/two-factor/recovery-codes", Repo.AdminPostRecoveryCodes)
+	mux.Get("/admin/reset-user-two-factor/{id}/do", Repo.AdminResetUserTwoFactor)
+	mux.Get("/admin/login-activity", Repo.AdminLoginActivity)
+	mux.Get("/admin/unlock-user/{id}/do", Repo.AdminUnlockUser)
+	mux.Get("/admin/settings", Repo.AdminSettings)
+	mux.Post("/admin/settings", Repo.AdminPostSettings)
+	mux.Get("/user/forgot-password", Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/set-password/{token}", Repo.SetPassword)
+	mux.Post("/user/set-password/{token}", Repo.PostSetPassword)
+
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.NotFound(Repo.APINotFound)
+		mux.Use(Repo.APIAuth)
+		read := mux.With(Repo.RequireScope(models.ScopeReadAvailability))
+		write := mux.With(Repo.RequireScope(models.ScopeWriteReservations))
+		read.Get("/rooms", Repo.APIRooms)
+		read.Get("/availability", Repo.APIAvailability)
+		write.Post("/reservations", Repo.APICreateReservation)
+		write.Get("/reservations/{token}", Repo.APIGetReservation)
+		write.Delete("/reservations/{token}", Repo.APICancelReservation)
+	})
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+	"github.com/go-chi/chi"
+)
+
+// statusAction is a button of the admin reservation page that moves a reservation on to a status
+type statusAction struct {
+	Status string
+	Label  string
+}
+
+// statusLabels are the buttons of the statuses a reservation can be moved on to by hand, cancelling has its own
+// button as it charges the cancellation policy
+var statusLabels = map[string]string{
+	models.ReservationConfirmed:  "Confirm",
+	models.ReservationCheckedIn:  "Check In",
+	models.ReservationCheckedOut: "Check Out",
+	models.ReservationNoShow:     "No-show",
+}
+
+// statusActionsFor returns the buttons for the statuses a reservation can move on to
+func statusActionsFor(reservation models.Reservations) []statusAction {
+	var actions []statusAction
+	for _, status := range models.Transitions(reservation.Status) {
+		if label, ok := statusLabels[status]; ok {
+			actions = append(actions, statusAction{Status: status, Label: label})
+		}
+	}
+	return actions
+}
+
+// isReservationStatus reports whether s is one of the statuses of a reservation
+func isReservationStatus(s string) bool {
+	for _, status := range models.ReservationStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// statusOf is the status of a reservation as a record of the audit log
+func statusOf(status string) map[string]string {
+	return map[string]string{"Status": status}
+}
+
+// AdminChangeReservationStatus moves a reservation on to the status in the url, as the logged in user
+func (m *Repository) AdminChangeReservationStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	status := chi.URLParam(r, "status")
+	if _, ok := statusLabels[status]; !ok {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	before, _ := m.DB.GetReservationByID(id)
+	err = m.DB.ChangeReservationStatus(id, status, m.App.Session.GetInt(r.Context(), "user_id"))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	case errors.Is(err, repository.ErrInvalidTransition):
+		m.App.Session.Put(r.Context(), "error", "The reservation can not be "+status+" from its status")
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		m.audit(r, auditStatus, models.AuditReservation, id, statusOf(before.Status), statusOf(status))
+		m.App.Session.Put(r.Context(), "flash", "Reservation is "+status)
+	}
+	backToReservation(w, r, id)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestReservationLifecycle(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	start := time.Now().AddDate(0, 3, 0).Truncate(24 * time.Hour)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 2))
+	postReservation(t, client, testServer, "john@mail.com", "")
+	if res := postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+
+	id := lastReservationID(t)
+	reservation, _ := Repo.DB.GetReservationByID(id)
+	if reservation.Status != models.ReservationPending {
+		t.Fatalf("expected a new reservation to be pending, but %s", reservation.Status)
+	}
+
+	var theTests = []struct {
+		status   string
+		expected string
+	}{
+		{models.ReservationCheckedOut, models.ReservationPending},
+		{models.ReservationConfirmed, models.ReservationConfirmed},
+		{models.ReservationCheckedIn, models.ReservationCheckedIn},
+	}
+	for _, e := range theTests {
+		res, err := client.Get(testServer.URL + "/admin/change-reservation-status/all/" + strconv.Itoa(id) + "/" + e.status + "/do")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusSeeOther {
+			t.Errorf("for %s, expected to go back to the reservation, but %d", e.status, res.StatusCode)
+		}
+		if reservation, _ = Repo.DB.GetReservationByID(id); reservation.Status != e.expected {
+			t.Errorf("for %s, expected the reservation to be %s, but %s", e.status, e.expected, reservation.Status)
+		}
+	}
+
+	history, _ := Repo.DB.StatusChangesForReservation(id)
+	if len(history) != 2 || history[1].ToStatus != models.ReservationCheckedIn {
+		t.Errorf("expected confirming and checking in in the history, but %+v", history)
+	}
+
+	// the guest can not cancel a stay that started
+	res, err := client.PostForm(testServer.URL+"/my-booking/"+reservation.AccessToken+"/cancel", url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if reservation, _ = Repo.DB.GetReservationByID(id); reservation.Status != models.ReservationCheckedIn {
+		t.Errorf("expected a checked-in reservation not to be cancelled, but %s", reservation.Status)
+	}
+}
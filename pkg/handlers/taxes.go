@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/go-chi/chi"
+)
+
+// maxGuests is the most guests a reservation can be made for
+const maxGuests = 10
+
+// taxRuleBases are the ways a rule can be charged, as shown in the admin tool
+var taxRuleBases = map[string]string{
+	models.ChargePercent:       "percent of the room price",
+	models.ChargePerStay:       "per stay",
+	models.ChargePerNight:      "per night",
+	models.ChargePerGuest:      "per guest",
+	models.ChargePerGuestNight: "per guest per night",
+}
+
+// addCharges adds the taxes and fees in effect to a quote for a number of guests, it comes after the discounts
+// and the promo code
+func (m *Repository) addCharges(q models.Quote, guests int) (models.Quote, error) {
+	rules, err := m.DB.AllTaxRules()
+	if err != nil {
+		return q, err
+	}
+	return pricing.ApplyCharges(q, rules, guests), nil
+}
+
+// guestsFromForm reads the number of guests of the reservation form, it is 1 when left out and a bad number
+// is put on the form
+func guestsFromForm(form *forms.Form) int {
+	if form.Get("guests") == "" {
+		return 1
+	}
+	guests, err := strconv.Atoi(form.Get("guests"))
+	if err != nil || guests < 1 || guests > maxGuests {
+		form.Error.Add("guests", "Enter the number of guests, from 1 to "+strconv.Itoa(maxGuests))
+		return 1
+	}
+	return guests
+}
+
+// taxRuleFromForm reads a new tax or fee rule from the posted form
+func taxRuleFromForm(r *http.Request) (models.TaxRule, *forms.Form) {
+	form := forms.New(r.PostForm)
+	form.Required("name", "kind", "basis", "amount")
+
+	rule := models.TaxRule{
+		Name:           strings.TrimSpace(r.Form.Get("name")),
+		Kind:           r.Form.Get("kind"),
+		Basis:          r.Form.Get("basis"),
+		EffectiveFrom:  optionalDate(form, "effective_from"),
+		EffectiveUntil: optionalDate(form, "effective_until"),
+		Active:         true,
+	}
+
+	if rule.Kind != models.TaxKindTax && rule.Kind != models.TaxKindFee {
+		form.Error.Add("kind", "Choose a tax or a fee")
+	}
+	if _, ok := taxRuleBases[rule.Basis]; !ok {
+		form.Error.Add("basis", "Choose how it is charged")
+	}
+
+	// a percentage with up to 2 decimals reads the same way as an amount of money
+	amount, err := pricing.ParseMoney(form.Get("amount"))
+	switch {
+	case form.Get("amount") == "":
+	case err != nil || amount <= 0:
+		form.Error.Add("amount", "Enter a percentage or an amount such as 2.50")
+	case rule.Basis == models.ChargePercent && amount > 10000:
+		form.Error.Add("amount", "A tax can not be more than 100%")
+	}
+	rule.Amount = amount
+
+	if !rule.EffectiveFrom.IsZero() && !rule.EffectiveUntil.IsZero() && rule.EffectiveUntil.Before(rule.EffectiveFrom) {
+		form.Error.Add("effective_until", "A rule can not end before it starts")
+	}
+	return rule, form
+}
+
+// renderTaxRules shows the taxes and fees page with the rule form
+func (m *Repository) renderTaxRules(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rules, err := m.DB.AllTaxRules()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+	data["bases"] = taxRuleBases
+
+	render.RenderTemplate(w, r, "admin-taxes.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminTaxRules shows the taxes and fees charged on stays
+func (m *Repository) AdminTaxRules(w http.ResponseWriter, r *http.Request) {
+	m.renderTaxRules(w, r, forms.New(nil))
+}
+
+// AdminPostTaxRule adds a tax or fee rule, it is charged on stays booked from now on
+func (m *Repository) AdminPostTaxRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rule, form := taxRuleFromForm(r)
+	if !form.Valid() {
+		m.renderTaxRules(w, r, form)
+		return
+	}
+
+	rule.ID, err = m.DB.InsertTaxRule(rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditCreate, models.AuditTaxRule, rule.ID, nil, rule)
+
+	m.App.Session.Put(r.Context(), "flash", rule.Name+" added!")
+	http.Redirect(w, r, "/admin/taxes", http.StatusSeeOther)
+}
+
+// AdminActivateTaxRule charges a tax or fee rule again
+func (m *Repository) AdminActivateTaxRule(w http.ResponseWriter, r *http.Request) {
+	m.setTaxRuleActive(w, r, true)
+}
+
+// AdminDeactivateTaxRule stops charging a tax or fee rule, stays booked with it keep it
+func (m *Repository) AdminDeactivateTaxRule(w http.ResponseWriter, r *http.Request) {
+	m.setTaxRuleActive(w, r, false)
+}
+
+func (m *Repository) setTaxRuleActive(w http.ResponseWriter, r *http.Request, active bool) {
+	rule, ok := m.taxRuleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.UpdateActiveForTaxRule(rule.ID, active)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.auditActive(r, models.AuditTaxRule, rule.ID, active)
+
+	if active {
+		m.App.Session.Put(r.Context(), "flash", rule.Name+" is charged again")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", rule.Name+" is not charged anymore")
+	}
+	http.Redirect(w, r, "/admin/taxes", http.StatusSeeOther)
+}
+
+// AdminDeleteTaxRule deletes a tax or fee rule
+func (m *Repository) AdminDeleteTaxRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := m.taxRuleFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteTaxRule(rule.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditDelete, models.AuditTaxRule, rule.ID, rule, nil)
+
+	m.App.Session.Put(r.Context(), "flash", rule.Name+" deleted")
+	http.Redirect(w, r, "/admin/taxes", http.StatusSeeOther)
+}
+
+// taxRuleFromURL looks up the tax rule in the url, writing the error response when there is none
+func (m *Repository) taxRuleFromURL(w http.ResponseWriter, r *http.Request) (models.TaxRule, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.TaxRule{}, false
+	}
+
+	rule, err := m.DB.GetTaxRuleByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return rule, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return rule, false
+	}
+	return rule, true
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestTaxesAtCheckout(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	rules := []models.TaxRule{
+		{Name: "Cleaning fee", Kind: models.TaxKindFee, Basis: models.ChargePerStay, Amount: 2500, Active: true},
+		{Name: "City tax", Kind: models.TaxKindTax, Basis: models.ChargePerGuestNight, Amount: 150, Active: true},
+		{Name: "Old tax", Kind: models.TaxKindTax, Basis: models.ChargePercent, Amount: 1000, Active: false},
+	}
+	for _, rule := range rules {
+		if _, err := Repo.DB.InsertTaxRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now().AddDate(0, 2, 0)
+	chooseStay(t, client, testServer, start, start.AddDate(0, 0, 2))
+
+	values := url.Values{}
+	values.Add("first_name", "John")
+	values.Add("last_name", "Mayor")
+	values.Add("email", "john@mail.com")
+	values.Add("guests", "30")
+	res, err := client.PostForm(testServer.URL+"/make-reservation", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected too many guests to show the form again, but %d", res.StatusCode)
+	}
+
+	values.Set("guests", "3")
+	res, err = client.PostForm(testServer.URL+"/make-reservation", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to go on to the payment, but %d", res.StatusCode)
+	}
+	if res = postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+
+	reservation, err := Repo.DB.GetReservationByID(lastReservationID(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := reservation.Quote
+	if reservation.Guests != 3 || len(q.Charges) != 2 {
+		t.Fatalf("expected 3 guests and the 2 active rules, but %d and %+v", reservation.Guests, q.Charges)
+	}
+	// 3 guests for 2 nights at 1.50
+	if q.Charges[1].Amount != 900 || q.Total != q.Subtotal-q.Discount+2500+900 {
+		t.Errorf("unexpected quote of the reservation %+v", q)
+	}
+
+	doc, err := Repo.invoiceOf(reservation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Invoice.Total != q.Total {
+		t.Errorf("expected the invoice to total %d, but %d", q.Total, doc.Invoice.Total)
+	}
+}
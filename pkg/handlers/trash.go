@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+	"github.com/go-chi/chi"
+)
+
+// defaultTrashRetentionDays is how long deleted reservations are kept when the setting was never saved
+const defaultTrashRetentionDays = 30
+
+// trashedReservation is a reservation of the trash with who deleted it and when it is purged
+type trashedReservation struct {
+	models.Reservations
+	DeletedByName string
+	PurgeAt       time.Time
+	// Expired is past its retention, it can not be restored anymore and is only kept for its payments or invoice
+	Expired bool
+}
+
+// trashRetentionDays returns how many days deleted reservations are kept in the trash
+func (m *Repository) trashRetentionDays() int {
+	value, err := m.DB.GetSetting(models.SettingTrashRetentionDays)
+	if err != nil {
+		return defaultTrashRetentionDays
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		return defaultTrashRetentionDays
+	}
+	return days
+}
+
+// pastRetention reports whether a reservation has been in the trash longer than the retention of days
+func pastRetention(res models.Reservations, days int) bool {
+	return res.DeletedAt.AddDate(0, 0, days).Before(time.Now())
+}
+
+// PurgeTrash deletes the reservations kept in the trash longer than the retention for good, those with payments
+// or an invoice stay. Every purged reservation goes to the audit log, it returns how many were purged
+func (m *Repository) PurgeTrash() (int, error) {
+	trash, err := m.DB.DeletedReservations()
+	if err != nil {
+		return 0, err
+	}
+	trashed := make(map[int]models.Reservations)
+	for _, res := range trash {
+		trashed[res.ID] = res
+	}
+
+	purged, err := m.DB.PurgeDeletedReservations(time.Now().AddDate(0, 0, -m.trashRetentionDays()))
+	for _, id := range purged {
+		m.auditJob("trash purge", auditPurge, models.AuditReservation, id, trashed[id], nil)
+	}
+	return len(purged), err
+}
+
+// AdminTrash shows the deleted reservations that can still be restored
+func (m *Repository) AdminTrash(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.DeletedReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	names := make(map[int]string)
+	for _, u := range users {
+		names[u.ID] = u.FirstName + " " + u.LastName
+	}
+
+	days := m.trashRetentionDays()
+	var trash []trashedReservation
+	for _, res := range reservations {
+		trash = append(trash, trashedReservation{
+			Reservations:  res,
+			DeletedByName: names[res.DeletedBy],
+			PurgeAt:       res.DeletedAt.AddDate(0, 0, days),
+			Expired:       pastRetention(res, days),
+		})
+	}
+
+	data := make(map[string]interface{})
+	data["trash"] = trash
+
+	render.RenderTemplate(w, r, "admin-trash.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: map[string]int{"retention_days": days},
+	})
+}
+
+// AdminRestoreReservation takes a reservation out of the trash when it is within the retention and its room is
+// still free
+func (m *Repository) AdminRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	trash, err := m.DB.DeletedReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	var before models.Reservations
+	for _, res := range trash {
+		if res.ID == id {
+			before = res
+		}
+	}
+	if before.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	// a reservation kept past the retention for its payments or invoice stays in the trash
+	if days := m.trashRetentionDays(); pastRetention(before, days) {
+		m.App.Session.Put(r.Context(), "error", "The reservation was deleted more than "+strconv.Itoa(days)+" days ago, it can not be restored")
+		http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.RestoreReservation(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	case errors.Is(err, repository.ErrRoomNotAvailable):
+		m.App.Session.Put(r.Context(), "error", "The room is booked on the dates of the reservation, it can not be restored")
+	case err != nil:
+		helpers.ServerError(w, err)
+		return
+	default:
+		after, err := m.DB.GetReservationByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.audit(r, auditRestore, models.AuditReservation, id, before, after)
+		m.App.Session.Put(r.Context(), "flash", "Reservation is restored.")
+	}
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
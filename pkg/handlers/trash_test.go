@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestTrash(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+	client := loginClient(t, testServer)
+
+	start := time.Now().AddDate(0, 4, 0).Truncate(24 * time.Hour)
+	end := start.AddDate(0, 0, 2)
+	chooseStay(t, client, testServer, start, end)
+	postReservation(t, client, testServer, "john@mail.com", "")
+	if res := postPayment(t, client, testServer, "4242424242424242"); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be booked, but %d", res.StatusCode)
+	}
+	id := lastReservationID(t)
+
+	get := func(path string) int {
+		res, err := client.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	get("/admin/delete-reservation/all/" + strconv.Itoa(id) + "/do")
+	if _, err := Repo.DB.GetReservationByID(id); err == nil {
+		t.Fatal("expected the deleted reservation to be gone from the reservations")
+	}
+	if status := get("/admin/trash"); status != http.StatusOK {
+		t.Errorf("expected the trash to show, but %d", status)
+	}
+
+	// a reservation goes to the trash once, and a bad id is refused
+	if status := get("/admin/delete-reservation/all/" + strconv.Itoa(id) + "/do"); status != http.StatusNotFound {
+		t.Errorf("expected deleting again to be not found, but %d", status)
+	}
+	if status := get("/admin/delete-reservation/all/x/do"); status != http.StatusBadRequest {
+		t.Errorf("expected a bad id to be refused, but %d", status)
+	}
+
+	if status := get("/admin/restore-reservation/" + strconv.Itoa(id) + "/do"); status != http.StatusSeeOther {
+		t.Errorf("expected to go back to the trash, but %d", status)
+	}
+	if _, err := Repo.DB.GetReservationByID(id); err != nil {
+		t.Errorf("expected the reservation to be restored, but %v", err)
+	}
+	entries, _ := Repo.DB.SearchAuditEntries(models.AuditFilter{Entity: models.AuditReservation, EntityID: id, Action: auditRestore})
+	if len(entries) != 1 || !strings.Contains(entries[0].Changes, "DeletedAt") {
+		t.Errorf("expected the restore with its changes in the audit log, but %+v", entries)
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesAndRoomID(start, end, 1); available {
+		t.Error("expected the restored reservation to hold its room")
+	}
+
+	// a reservation is restored once
+	if status := get("/admin/restore-reservation/" + strconv.Itoa(id) + "/do"); status != http.StatusNotFound {
+		t.Errorf("expected not found, but %d", status)
+	}
+
+	// nothing is purged within the retention
+	if purged, _ := Repo.PurgeTrash(); purged != 0 {
+		t.Errorf("expected nothing purged, but %d", purged)
+	}
+}
+
+func TestPastRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		deletedAt time.Time
+		expected  bool
+	}{
+		{"just deleted", time.Now(), false},
+		{"within the retention", time.Now().AddDate(0, 0, -29), false},
+		{"past the retention", time.Now().AddDate(0, 0, -31), true},
+	}
+
+	for _, e := range tests {
+		if past := pastRetention(models.Reservations{DeletedAt: e.deletedAt}, 30); past != e.expected {
+			t.Errorf("for %s expected %v, but %v", e.name, e.expected, past)
+		}
+	}
+}
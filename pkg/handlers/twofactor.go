@@ -0,0 +1,426 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/fangjjcs/bookings-app/pkg/totp"
+)
+
+// totpIssuer names the site in authenticator apps
+const totpIssuer = "Bookings"
+
+// twoFactorTTL is how long a user has to enter their code after the password
+const twoFactorTTL = 5 * time.Minute
+
+// recoveryCodeCount is how many recovery codes a user gets at a time
+const recoveryCodeCount = 10
+
+// logIn puts a user who passed every check into the session, event is what the auth audit log records
+func (m *Repository) logIn(w http.ResponseWriter, r *http.Request, u models.User, event string) {
+	err := m.DB.ResetFailedLoginsForUser(u.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.recordAuthEvent(r, u.ID, u.Email, event, true)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "user_id", u.ID)
+	m.App.Session.Put(r.Context(), "access_level", u.AccessLevel)
+	// a later password change logs out the sessions started before it
+	m.App.Session.Put(r.Context(), "logged_in_at", time.Now())
+	m.App.Session.Put(r.Context(), "flash", "Login Successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// startTwoFactor remembers a user whose password was right and asks for their code
+func (m *Repository) startTwoFactor(w http.ResponseWriter, r *http.Request, u models.User) {
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "pending_user_id", u.ID)
+	m.App.Session.Put(r.Context(), "pending_at", time.Now())
+	http.Redirect(w, r, "/user/two-factor", http.StatusSeeOther)
+}
+
+// pendingUser is the user waiting to enter their code, it sends everyone else back to the login page
+func (m *Repository) pendingUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id := m.App.Session.GetInt(r.Context(), "pending_user_id")
+	startedAt := m.App.Session.GetTime(r.Context(), "pending_at")
+
+	if id == 0 || time.Since(startedAt) > twoFactorTTL {
+		m.App.Session.Remove(r.Context(), "pending_user_id")
+		m.App.Session.Put(r.Context(), "error", "Please log in first")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return models.User{}, false
+	}
+
+	u, err := m.DB.GetUserByID(id)
+	if err != nil || u.Disabled() || u.Locked() || !u.TwoFactorEnabled() {
+		m.App.Session.Remove(r.Context(), "pending_user_id")
+		m.App.Session.Put(r.Context(), "error", "Please log in first")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return models.User{}, false
+	}
+	return u, true
+}
+
+// checkTOTP validates a code of the user's authenticator app and uses it up
+func (m *Repository) checkTOTP(u models.User, code string) (bool, error) {
+	step, ok := totp.Validate(u.TOTPSecret, code, time.Now(), u.TOTPLastStep)
+	if !ok {
+		return false, nil
+	}
+	return m.DB.UseTOTPStepForUser(u.ID, step)
+}
+
+// normalizeRecoveryCode lets users type recovery codes with or without dashes, spaces and capitals
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// newRecoveryCodes stores fresh recovery codes for a user and returns them, only their hashes are kept
+func (m *Repository) newRecoveryCodes(userID int) ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	var codes, hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, helpers.HashToken(code))
+	}
+
+	err := m.DB.ReplaceRecoveryCodes(userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// requiresTwoFactor reports whether the settings make u use two-factor authentication
+func (m *Repository) requiresTwoFactor(u models.User) (bool, error) {
+	value, err := m.DB.GetSetting(models.SettingRequire2FALevel)
+	if err != nil {
+		return false, err
+	}
+	level, _ := strconv.Atoi(value)
+	return level > 0 && u.AccessLevel >= level, nil
+}
+
+// TwoFactor asks a user whose password was right for their code
+func (m *Repository) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.pendingUser(w, r); !ok {
+		return
+	}
+
+	render.RenderTemplate(w, r, "two-factor.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostTwoFactor logs in a user with a code of their authenticator app or a recovery code
+func (m *Repository) PostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	u, ok := m.pendingUser(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+
+	code := strings.TrimSpace(r.Form.Get("code"))
+	usedRecoveryCode := false
+	if form.Valid() {
+		loginDelay(failedLoginDelay(u.FailedLogins))
+		if len(normalizeRecoveryCode(code)) == totp.Digits {
+			ok, err = m.checkTOTP(u, code)
+		} else {
+			usedRecoveryCode = true
+			ok, err = m.DB.UseRecoveryCode(u.ID, helpers.HashToken(normalizeRecoveryCode(code)))
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !ok {
+			// wrong codes count towards the lockout like wrong passwords
+			locked, err := m.recordFailedLogin(r, u, models.AuthBadCode)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			if locked {
+				m.App.Session.Remove(r.Context(), "pending_user_id")
+				m.rejectLogin(w, r)
+				return
+			}
+			form.Error.Add("code", "The code is not valid")
+		}
+	}
+
+	if !form.Valid() {
+		render.RenderTemplate(w, r, "two-factor.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "pending_user_id")
+	m.App.Session.Remove(r.Context(), "pending_at")
+
+	if usedRecoveryCode {
+		left, err := m.DB.CountRecoveryCodes(u.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("You used a recovery code, %d are left", left))
+		m.logIn(w, r, u, models.AuthLoginRecoveryCode)
+		return
+	}
+	m.logIn(w, r, u, models.AuthLogin)
+}
+
+// AdminTwoFactor shows the two-factor authentication of the logged in user, with the
+// secret to scan when it is not set up yet
+func (m *Repository) AdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminTwoFactor(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderAdminTwoFactor(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	u, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	required, err := m.requiresTwoFactor(u)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = u
+	data["required"] = required
+
+	stringMap := make(map[string]string)
+	intMap := make(map[string]int)
+
+	if u.TwoFactorEnabled() {
+		intMap["recovery_codes_left"], err = m.DB.CountRecoveryCodes(u.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if codes := m.App.Session.PopString(r.Context(), "recovery_codes"); codes != "" {
+			data["recovery_codes"] = strings.Split(codes, ",")
+		}
+	} else {
+		// the secret stays in the session until a code proves the app has it
+		secret := m.App.Session.GetString(r.Context(), "totp_secret")
+		if secret == "" {
+			secret, err = totp.GenerateSecret()
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			m.App.Session.Put(r.Context(), "totp_secret", secret)
+		}
+		stringMap["secret"] = secret
+		stringMap["uri"] = totp.URI(totpIssuer, u.Email, secret)
+	}
+
+	render.RenderTemplate(w, r, "admin-two-factor.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Form:      form,
+	})
+}
+
+// AdminPostTwoFactor turns on two-factor authentication once the user enters a code of the new secret
+func (m *Repository) AdminPostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	secret := m.App.Session.GetString(r.Context(), "totp_secret")
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	step, ok := totp.Validate(secret, r.Form.Get("code"), time.Now(), 0)
+	if secret == "" || !ok {
+		form.Error.Add("code", "The code is not valid, check the time on your phone")
+	}
+	if !form.Valid() {
+		m.renderAdminTwoFactor(w, r, form)
+		return
+	}
+
+	err = m.DB.UpdateTOTPForUser(userID, secret)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	_, err = m.DB.UseTOTPStepForUser(userID, step)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, auditTwoFactorOn, models.AuditUser, userID, nil, nil)
+
+	codes, err := m.newRecoveryCodes(userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "totp_secret")
+	m.App.Session.Put(r.Context(), "recovery_codes", strings.Join(codes, ","))
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication is on")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminPostDisableTwoFactor turns off two-factor authentication, it takes a current code
+func (m *Repository) AdminPostDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, form, ok := m.confirmOwnCode(w, r)
+	if !ok {
+		return
+	}
+
+	required, err := m.requiresTwoFactor(u)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if required {
+		form.Error.Add("code", "Two-factor authentication is required for your access level")
+	}
+	if !form.Valid() {
+		m.renderAdminTwoFactor(w, r, form)
+		return
+	}
+
+	err = m.DB.UpdateTOTPForUser(u.ID, "")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.DB.ReplaceRecoveryCodes(u.ID, nil)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditTwoFactorOff, models.AuditUser, u.ID, nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication is off")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// AdminPostRecoveryCodes replaces the recovery codes of the logged in user, it takes a current code
+func (m *Repository) AdminPostRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	u, form, ok := m.confirmOwnCode(w, r)
+	if !ok {
+		return
+	}
+	if !form.Valid() {
+		m.renderAdminTwoFactor(w, r, form)
+		return
+	}
+
+	codes, err := m.newRecoveryCodes(u.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditRegenerateCodes, models.AuditUser, u.ID, nil, nil)
+
+	m.App.Session.Put(r.Context(), "recovery_codes", strings.Join(codes, ","))
+	m.App.Session.Put(r.Context(), "flash", "New recovery codes are ready, the old ones stopped working")
+	http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+}
+
+// confirmOwnCode checks the posted code against the logged in user's authenticator app,
+// a wrong code is put on the returned form
+func (m *Repository) confirmOwnCode(w http.ResponseWriter, r *http.Request) (models.User, *forms.Form, bool) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return models.User{}, nil, false
+	}
+
+	u, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return u, nil, false
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !u.TwoFactorEnabled() {
+		form.Error.Add("code", "Two-factor authentication is not on")
+		return u, form, true
+	}
+
+	ok, err := m.checkTOTP(u, r.Form.Get("code"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return u, nil, false
+	}
+	if !ok {
+		form.Error.Add("code", "The code is not valid")
+	}
+	return u, form, true
+}
+
+// AdminResetUserTwoFactor turns off two-factor authentication for a user who lost their phone
+func (m *Repository) AdminResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.UpdateTOTPForUser(u.ID, "")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.DB.ReplaceRecoveryCodes(u.ID, nil)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditTwoFactorOff, models.AuditUser, u.ID, nil, nil)
+
+	m.App.Session.Put(r.Context(), "flash", "Two-factor authentication of "+u.Email+" is off")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", u.ID), http.StatusSeeOther)
+}
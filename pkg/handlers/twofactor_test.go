@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository/dbrepo"
+	"github.com/fangjjcs/bookings-app/pkg/totp"
+	"github.com/go-chi/chi"
+)
+
+func TestTwoFactorLogin(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := testServer.Client()
+	client.Jar = jar
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	// user 1 is the demo owner of the memory repository
+	secret, _ := totp.GenerateSecret()
+	if err := Repo.DB.UpdateTOTPForUser(1, secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := Repo.DB.ReplaceRecoveryCodes(1, []string{helpers.HashToken("abcdefghij")}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := url.Values{}
+	login.Add("email", dbrepo.DemoEmail)
+	login.Add("password", dbrepo.DemoPassword)
+	res, err := client.PostForm(testServer.URL+"/user/login", login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSeeOther || !strings.HasSuffix(res.Header.Get("Location"), "/user/two-factor") {
+		t.Fatalf("expected a redirect to the code, but %d to %s", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	code, _ := totp.Code(secret, time.Now())
+
+	var theTests = []struct {
+		name               string
+		code               string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"wrong-code", "000000", http.StatusOK, ""},
+		{"code", code, http.StatusSeeOther, "/"},
+		{"no-pending-login", code, http.StatusSeeOther, "/user/login"},
+	}
+
+	for _, e := range theTests {
+		values := url.Values{}
+		values.Add("code", e.code)
+		res, err := client.PostForm(testServer.URL+"/user/two-factor", values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d, but %d", e.name, e.expectedStatusCode, res.StatusCode)
+		}
+		if e.expectedLocation != "" && res.Header.Get("Location") != e.expectedLocation {
+			t.Errorf("for %s, expected redirect to %s, but %s", e.name, e.expectedLocation, res.Header.Get("Location"))
+		}
+	}
+
+	// the same code does not work for a second login
+	res, err = client.PostForm(testServer.URL+"/user/login", login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	values := url.Values{}
+	values.Add("code", code)
+	res, err = client.PostForm(testServer.URL+"/user/two-factor", values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected the used code to be refused, but %d", res.StatusCode)
+	}
+
+	// a recovery code works instead of the app, and only once
+	for i, expected := range []string{"/", "/user/two-factor"} {
+		res, err := client.PostForm(testServer.URL+"/user/login", login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		values := url.Values{}
+		values.Add("code", "ABCDE-FGHIJ")
+		res, err = client.PostForm(testServer.URL+"/user/two-factor", values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if i == 0 && res.Header.Get("Location") != expected {
+			t.Errorf("expected a redirect to %s, but %d to %s", expected, res.StatusCode, res.Header.Get("Location"))
+		}
+		if i == 1 && res.StatusCode != http.StatusOK {
+			t.Errorf("expected the used recovery code to be refused, but %d", res.StatusCode)
+		}
+	}
+}
+
+func TestRequireTwoFactor(t *testing.T) {
+	getRoutes() // sets up the session and the repository
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if err := Repo.DB.UpdateSetting(models.SettingRequire2FALevel, "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/admin/something", nil)
+		ctx, _ := session.Load(req.Context(), "")
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+
+		rr := httptest.NewRecorder()
+		Repo.RequirePermission(models.PermViewDashboard)(ok).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve()
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/two-factor" {
+		t.Errorf("expected a redirect to /admin/two-factor, but %d to %s", rr.Code, rr.Header().Get("Location"))
+	}
+
+	if err := Repo.DB.UpdateTOTPForUser(1, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if rr := serve(); rr.Code != http.StatusOK {
+		t.Errorf("expected %d once two-factor authentication is on, but %d", http.StatusOK, rr.Code)
+	}
+}
+
+func TestTwoFactorAudit(t *testing.T) {
+	getRoutes() // sets up the session and the repository
+
+	id, err := Repo.DB.InsertUser(models.User{FirstName: "Audited", Email: "audited@mail.com", AccessLevel: models.AccessViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := totp.GenerateSecret()
+
+	post := func(handler http.HandlerFunc, code string) *httptest.ResponseRecorder {
+		values := url.Values{}
+		values.Add("code", code)
+		req := httptest.NewRequest("POST", "/admin/two-factor", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx, _ := session.Load(req.Context(), "")
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", id)
+		session.Put(ctx, "totp_secret", secret)
+
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+	actions := func() []string {
+		entries, _ := Repo.DB.SearchAuditEntries(models.AuditFilter{Entity: models.AuditUser, EntityID: id})
+		var actions []string
+		for _, e := range entries {
+			actions = append(actions, e.Action)
+		}
+		return actions
+	}
+
+	code, _ := totp.Code(secret, time.Now())
+	if rr := post(Repo.AdminPostTwoFactor, code); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected two-factor authentication to be turned on, but %d", rr.Code)
+	}
+	if got := actions(); len(got) != 1 || got[0] != auditTwoFactorOn {
+		t.Errorf("expected %s in the audit log, but %v", auditTwoFactorOn, got)
+	}
+
+	// the code of the next step, the one above is used up
+	code, _ = totp.Code(secret, time.Now().Add(30*time.Second))
+	if rr := post(Repo.AdminPostDisableTwoFactor, code); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected two-factor authentication to be turned off, but %d", rr.Code)
+	}
+	if got := actions(); len(got) != 2 || got[0] != auditTwoFactorOff {
+		t.Errorf("expected %s in the audit log, but %v", auditTwoFactorOff, got)
+	}
+
+	// an owner turning it off for a user who lost their phone
+	if err := Repo.DB.UpdateTOTPForUser(id, secret); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", fmt.Sprintf("/admin/reset-user-two-factor/%d/do", id), nil)
+	ctx, _ := session.Load(req.Context(), "")
+	session.Put(ctx, "user_id", 1)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", strconv.Itoa(id))
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	Repo.AdminResetUserTwoFactor(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected the reset to redirect, but %d", rr.Code)
+	}
+	if got := actions(); len(got) != 3 || got[0] != auditTwoFactorOff {
+		t.Errorf("expected the reset in the audit log, but %v", got)
+	}
+}
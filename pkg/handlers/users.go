@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/emails"
+	"github.com/fangjjcs/bookings-app/pkg/forms"
+	"github.com/fangjjcs/bookings-app/pkg/helpers"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/render"
+	"github.com/go-chi/chi"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// how long the links mailed to users stay valid
+const (
+	inviteTTL        = 7 * 24 * time.Hour
+	passwordResetTTL = 2 * time.Hour
+)
+
+// minPasswordLength is the shortest password a user may set
+const minPasswordLength = 8
+
+// accessLevelNames maps each access level to its name, templates range over it for the access level select
+func accessLevelNames() map[int]string {
+	names := make(map[int]string)
+	for _, level := range models.AccessLevels {
+		names[level] = models.AccessLevelName(level)
+	}
+	return names
+}
+
+// issueUserToken replaces any earlier token of the user for purpose and returns the new one
+func (m *Repository) issueUserToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	err := m.DB.UseUserTokens(userID, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := helpers.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = m.DB.InsertUserToken(models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: helpers.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendUserToken issues a user token and mails its set password link
+func (m *Repository) sendUserToken(u models.User, purpose string, ttl time.Duration) error {
+	token, err := m.issueUserToken(u.ID, purpose, ttl)
+	if err != nil {
+		return err
+	}
+	m.sendMail(emails.PasswordReset, u.Email, emails.Data{
+		Name:   u.FirstName,
+		Link:   m.App.BaseURL + "/user/set-password/" + token,
+		Invite: purpose == models.TokenInvite,
+	})
+	return nil
+}
+
+// AdminUsers lists the users and shows the invite form
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminUsers(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderAdminUsers(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+	data["levels"] = accessLevelNames()
+
+	intMap := make(map[string]int)
+	intMap["user_id"] = m.App.Session.GetInt(r.Context(), "user_id")
+
+	render.RenderTemplate(w, r, "admin-users.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+		Form:   form,
+	})
+}
+
+// AdminPostInviteUser creates a user without a password and mails them a link to choose one
+func (m *Repository) AdminPostInviteUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	u, form := m.userFromForm(r, models.User{})
+	if !form.Valid() {
+		m.renderAdminUsers(w, r, form)
+		return
+	}
+
+	u.ID, err = m.DB.InsertUser(u)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditCreate, models.AuditUser, u.ID, nil, u)
+
+	err = m.sendUserToken(u, models.TokenInvite, inviteTTL)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Invitation sent to "+u.Email)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminShowUser shows the form of an existing user
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	m.renderAdminUser(w, r, u, forms.New(nil))
+}
+
+func (m *Repository) renderAdminUser(w http.ResponseWriter, r *http.Request, u models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = u
+	data["levels"] = accessLevelNames()
+
+	intMap := make(map[string]int)
+	intMap["user_id"] = m.App.Session.GetInt(r.Context(), "user_id")
+
+	render.RenderTemplate(w, r, "admin-user-show.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+		Form:   form,
+	})
+}
+
+// AdminPostShowUser saves changes to an existing user
+func (m *Repository) AdminPostShowUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	existing, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	u, form := m.userFromForm(r, existing)
+	if u.ID == m.App.Session.GetInt(r.Context(), "user_id") && u.AccessLevel != existing.AccessLevel {
+		form.Error.Add("access_level", "You can not change your own access level")
+	}
+	if !form.Valid() {
+		m.renderAdminUser(w, r, u, form)
+		return
+	}
+
+	err = m.DB.UpdateUser(u)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditUpdate, models.AuditUser, u.ID, existing, u)
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved!")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminDisableUser keeps a user from logging in
+func (m *Repository) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	m.setUserDisabled(w, r, true)
+}
+
+// AdminEnableUser lets a disabled user log in again
+func (m *Repository) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	m.setUserDisabled(w, r, false)
+}
+
+func (m *Repository) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if u.ID == m.App.Session.GetInt(r.Context(), "user_id") {
+		m.App.Session.Put(r.Context(), "error", "You can not disable yourself")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.UpdateDisabledForUser(u.ID, disabled)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.auditActive(r, models.AuditUser, u.ID, !disabled)
+
+	if disabled {
+		m.App.Session.Put(r.Context(), "flash", "User is disabled.")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "User is enabled.")
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminResetUserPassword clears the password of a user and mails them a link to choose a new one
+func (m *Repository) AdminResetUserPassword(w http.ResponseWriter, r *http.Request) {
+	u, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if u.ID == m.App.Session.GetInt(r.Context(), "user_id") {
+		m.App.Session.Put(r.Context(), "error", "You can not reset your own password here")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	// an empty hash never matches, so the old password stops working right away
+	err := m.DB.UpdatePasswordForUser(u.ID, "")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditPassword, models.AuditUser, u.ID, nil, nil)
+
+	err = m.sendUserToken(u, models.TokenPasswordReset, passwordResetTTL)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Password reset link sent to "+u.Email)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// ForgotPassword shows the form to request a password reset link
+func (m *Repository) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.RenderTemplate(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostForgotPassword mails a password reset link, the answer is the same whether the email is known or not
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email", r)
+	if !form.Valid() {
+		render.RenderTemplate(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	u, err := m.DB.GetUserByEmail(strings.TrimSpace(r.Form.Get("email")))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+	if err == nil && !u.Disabled() {
+		err = m.sendUserToken(u, models.TokenPasswordReset, passwordResetTTL)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If an account exists for that email, a link to reset the password is on its way")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// SetPassword shows the form to choose a password, reached from an invite or password reset mail
+func (m *Repository) SetPassword(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.userTokenFromURL(w, r); !ok {
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = chi.URLParam(r, "token")
+
+	render.RenderTemplate(w, r, "set-password.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// PostSetPassword uses up the token and sets the password of its user, which logs out the sessions they had
+func (m *Repository) PostSetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if _, ok := m.userTokenFromURL(w, r); !ok {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "confirm_password")
+	form.MinLength("password", minPasswordLength, r)
+	if r.Form.Get("password") != r.Form.Get("confirm_password") {
+		form.Error.Add("confirm_password", "The passwords do not match")
+	}
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["token"] = chi.URLParam(r, "token")
+		render.RenderTemplate(w, r, "set-password.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+			Form:      form,
+		})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(r.Form.Get("password")), bcrypt.DefaultCost)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the token is used up in the same step, so a second request with it sets nothing
+	userID, err := m.DB.SetPasswordWithToken(helpers.HashToken(chi.URLParam(r, "token")), string(hashedPassword))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This link is not valid anymore")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.audit(r, auditSetPassword, models.AuditUser, userID, nil, nil)
+
+	// the mailed link proves the account is theirs, so an earlier lockout no longer applies
+	err = m.DB.ResetFailedLoginsForUser(userID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your password is set, please log in")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// userFromURL looks up the user of the id in the url, it answers 404 itself
+func (m *Repository) userFromURL(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return models.User{}, false
+	}
+
+	u, err := m.DB.GetUserByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return u, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return u, false
+	}
+	return u, true
+}
+
+// userTokenFromURL looks up the usable user token in the url, unknown, used and expired tokens go back to the login page
+func (m *Repository) userTokenFromURL(w http.ResponseWriter, r *http.Request) (models.UserToken, bool) {
+	t, err := m.DB.GetUserTokenByHash(helpers.HashToken(chi.URLParam(r, "token")))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return t, false
+	}
+	if err != nil || !t.Usable() {
+		m.App.Session.Put(r.Context(), "error", "This link is not valid anymore")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return t, false
+	}
+	return t, true
+}
+
+// userFromForm copies the posted user form onto u and validates it
+func (m *Repository) userFromForm(r *http.Request, u models.User) (models.User, *forms.Form) {
+	u.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	u.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	u.Email = strings.TrimSpace(r.Form.Get("email"))
+	u.AccessLevel, _ = strconv.Atoi(r.Form.Get("access_level"))
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "access_level")
+	form.IsEmail("email", r)
+	if !models.ValidAccessLevel(u.AccessLevel) {
+		form.Error.Add("access_level", "Choose an access level")
+	}
+
+	if form.Error.Get("email") == "" {
+		other, err := m.DB.GetUserByEmail(u.Email)
+		if err == nil && other.ID != u.ID {
+			form.Error.Add("email", "The email is already used by another user")
+		}
+	}
+
+	return u, form
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestSetPassword(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := testServer.Client()
+	client.Jar = jar
+	// look at the redirects themselves instead of following them
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	id, err := Repo.DB.InsertUser(models.User{FirstName: "Invited", Email: "invited@mail.com", AccessLevel: models.AccessViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := Repo.issueUserToken(id, models.TokenInvite, inviteTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := "/user/set-password/" + token
+
+	var theTests = []struct {
+		name               string
+		method             string
+		password           string
+		confirm            string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"form", "GET", "", "", http.StatusOK, ""},
+		{"too-short", "POST", "short", "short", http.StatusOK, ""},
+		{"mismatch", "POST", "secret123", "secret124", http.StatusOK, ""},
+		{"set", "POST", "secret123", "secret123", http.StatusSeeOther, "/user/login"},
+		{"used-token", "GET", "", "", http.StatusSeeOther, "/user/login"},
+		{"used-token-post", "POST", "another123", "another123", http.StatusSeeOther, "/user/login"},
+	}
+
+	for _, e := range theTests {
+		var res *http.Response
+		if e.method == "GET" {
+			res, err = client.Get(testServer.URL + link)
+		} else {
+			values := url.Values{}
+			values.Add("password", e.password)
+			values.Add("confirm_password", e.confirm)
+			res, err = client.PostForm(testServer.URL+link, values)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d, but %d", e.name, e.expectedStatusCode, res.StatusCode)
+		}
+		if e.expectedLocation != "" && !strings.HasSuffix(res.Header.Get("Location"), e.expectedLocation) {
+			t.Errorf("for %s, expected redirect to %s, but %s", e.name, e.expectedLocation, res.Header.Get("Location"))
+		}
+	}
+
+	// only the password set through the token works
+	if _, _, err := Repo.DB.Authenticate("invited@mail.com", "secret123"); err != nil {
+		t.Error("invited user could not log in:", err)
+	}
+
+	entries, _ := Repo.DB.SearchAuditEntries(models.AuditFilter{Entity: models.AuditUser, EntityID: id})
+	if len(entries) != 1 || entries[0].Action != auditSetPassword {
+		t.Errorf("expected the password set in the audit log, but %+v", entries)
+	}
+
+	// a session started before the new password is logged out, one started after it is not
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, e := range []struct {
+		name         string
+		loggedInAt   time.Time
+		expectedCode int
+	}{
+		{"session before the password", time.Now().Add(-time.Hour), http.StatusSeeOther},
+		{"session after the password", time.Now(), http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+		ctx, _ := session.Load(req.Context(), "")
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", id)
+		session.Put(ctx, "logged_in_at", e.loggedInAt)
+
+		rr := httptest.NewRecorder()
+		Repo.RequireUser(ok).ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("for %s, expected %d, but %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	routes := getRoutes()
+	testServer := httptest.NewTLSServer(routes)
+	defer testServer.Close()
+
+	client := testServer.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+
+	var theTests = []struct {
+		name               string
+		email              string
+		expectedStatusCode int
+		expectMail         bool
+	}{
+		{"invalid-email", "nothing", http.StatusOK, false},
+		{"unknown-email", "nobody@mail.com", http.StatusSeeOther, false},
+		{"known-email", "Admin@Example.com", http.StatusSeeOther, true},
+	}
+
+	for _, e := range theTests {
+		before := lastMail(t).ID
+		values := url.Values{}
+		values.Add("email", e.email)
+		res, err := client.PostForm(testServer.URL+"/user/forgot-password", values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d, but %d", e.name, e.expectedStatusCode, res.StatusCode)
+		}
+		if lastMail(t).ID != before != e.expectMail {
+			t.Errorf("for %s, expected mail %t", e.name, e.expectMail)
+		}
+	}
+
+	// the link is on the configured address, never on the host the client sent
+	req, _ := http.NewRequest("POST", testServer.URL+"/user/forgot-password", strings.NewReader("email=admin@example.com"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Host = "evil.example.com"
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	mail := lastMail(t)
+	if !strings.Contains(mail.Content, "http://localhost:8088/user/set-password/") || strings.Contains(mail.Content, "evil.example.com") {
+		t.Errorf("expected the reset link on the base url, but\n%s", mail.PlainContent)
+	}
+	start := strings.Index(mail.Content, "/user/set-password/")
+	if start < 0 {
+		t.Fatal("no reset link in the mail")
+	}
+	link := mail.Content[start:]
+	link = link[:strings.IndexAny(link, `"<`)]
+
+	// asking for a link does not lock the user out
+	if _, _, err := Repo.DB.Authenticate("admin@example.com", "password"); err != nil {
+		t.Error("old password stopped working:", err)
+	}
+
+	res, err = client.Get(testServer.URL + link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected the reset link to work, but %d", res.StatusCode)
+	}
+}
@@ -1,11 +1,17 @@
 package helpers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/fangjjcs/bookings-app/pkg/config"
+	"github.com/fangjjcs/bookings-app/pkg/models"
 )
 
 var app *config.AppConfig
@@ -30,4 +36,35 @@ func ServerError(w http.ResponseWriter, err error){
 func IsAuthenticated(r *http.Request) bool{
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
-}
\ No newline at end of file
+}
+
+// GenerateToken returns a random, url safe token that can not be guessed
+func GenerateToken() (string, error){
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil{
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex sha256 of a token, so that only the hash needs to be stored
+func HashToken(token string) string{
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type contextKey string
+
+const apiKeyContextKey contextKey = "api_key"
+
+// WithAPIKey returns a copy of ctx carrying the api key of the calling machine client
+func WithAPIKey(ctx context.Context, k models.APIKey) context.Context{
+	return context.WithValue(ctx, apiKeyContextKey, k)
+}
+
+// APIKeyFromContext returns the api key the request was authenticated with
+func APIKeyFromContext(ctx context.Context) (models.APIKey, bool){
+	k, ok := ctx.Value(apiKeyContextKey).(models.APIKey)
+	return k, ok
+}
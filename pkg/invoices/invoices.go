@@ -0,0 +1,194 @@
+// Package invoices builds the invoices of reservations from their priced stay and writes them as pdf
+package invoices
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/payments"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+)
+
+// Settings are the site settings printed on invoices
+type Settings struct {
+	// Issuer is the name and address of the business, one line each
+	Issuer string
+	// TaxName and TaxRate are the tax included in the prices, the rate is in hundredths of a percent
+	TaxName string
+	TaxRate int
+}
+
+// Build works out the lines and taxes of the invoice of a reservation, it gets its number when it is issued
+func Build(res models.Reservations, s Settings, issuedAt time.Time) models.Invoice {
+	q := res.Quote
+	inv := models.Invoice{
+		ReservationID: res.ID,
+		BillTo:        strings.TrimSpace(res.FirstName + " " + res.LastName),
+		Email:         res.Email,
+		IssuedAt:      issuedAt,
+	}
+
+	inv.Lines = nightLines(res.Room.RoomName, q.Nights)
+	if q.Discount > 0 {
+		inv.Lines = append(inv.Lines, models.InvoiceLine{
+			Description: fmt.Sprintf("Stay discount %d%%", q.DiscountPercent),
+			Quantity:    1,
+			UnitPrice:   -q.Discount,
+			Amount:      -q.Discount,
+		})
+	}
+	if q.PromoDiscount > 0 {
+		inv.Lines = append(inv.Lines, models.InvoiceLine{
+			Description: "Promo code " + q.PromoCode,
+			Quantity:    1,
+			UnitPrice:   -q.PromoDiscount,
+			Amount:      -q.PromoDiscount,
+		})
+	}
+
+	for _, c := range q.Charges {
+		if c.Kind == models.TaxKindFee {
+			inv.Lines = append(inv.Lines, models.InvoiceLine{
+				Description: c.Name,
+				Quantity:    1,
+				UnitPrice:   c.Amount,
+				Amount:      c.Amount,
+			})
+		}
+	}
+
+	for _, l := range inv.Lines {
+		inv.Total += l.Amount
+	}
+
+	// the taxes of the tax rules come on top of the lines, the tax of the settings is in their prices
+	for _, c := range q.Charges {
+		if c.Kind == models.TaxKindTax {
+			inv.Taxes = append(inv.Taxes, models.InvoiceTax{Name: c.Name, Rate: c.Rate, Amount: c.Amount})
+		}
+	}
+
+	if s.TaxRate > 0 {
+		name := s.TaxName
+		if name == "" {
+			name = "Tax"
+		}
+		inv.Taxes = append(inv.Taxes, models.InvoiceTax{
+			Name:     name,
+			Rate:     s.TaxRate,
+			Amount:   IncludedTax(inv.Total, s.TaxRate),
+			Included: true,
+		})
+	}
+
+	for _, t := range inv.Taxes {
+		if !t.Included {
+			inv.Total += t.Amount
+		}
+	}
+	return inv
+}
+
+// nightLines puts the nights of the same price on one line, in the order they first come up
+func nightLines(roomName string, nights []models.NightPrice) []models.InvoiceLine {
+	var lines []models.InvoiceLine
+	index := make(map[string]int)
+
+	for _, n := range nights {
+		kind := "nights"
+		switch {
+		case n.Season != "":
+			kind = n.Season + " nights"
+		case n.Weekend:
+			kind = "weekend nights"
+		}
+		description := fmt.Sprintf("%s, %s", roomName, kind)
+		key := fmt.Sprintf("%s/%d", description, n.Rate)
+
+		i, ok := index[key]
+		if !ok {
+			i = len(lines)
+			index[key] = i
+			lines = append(lines, models.InvoiceLine{Description: description, UnitPrice: n.Rate})
+		}
+		lines[i].Quantity++
+		lines[i].Amount += n.Rate
+	}
+	return lines
+}
+
+// IncludedTax is the part of gross that is tax at rate hundredths of a percent, rounded to the cent
+func IncludedTax(gross, rate int) int {
+	return (gross*rate + (10000+rate)/2) / (10000 + rate)
+}
+
+// TaxLabel is how a tax line reads, e.g. "incl. VAT 10%", a flat tax has no rate
+func TaxLabel(t models.InvoiceTax) string {
+	label := t.Name
+	if t.Rate > 0 {
+		label += " " + FormatRate(t.Rate)
+	}
+	if t.Included {
+		label = "incl. " + label
+	}
+	return label
+}
+
+// FormatRate shows a rate in hundredths of a percent, e.g. 750 as "7.5%"
+func FormatRate(rate int) string {
+	s := strings.TrimRight(strings.TrimRight(pricing.FormatAmount(rate), "0"), ".")
+	return s + "%"
+}
+
+// Document is an invoice with what is printed around it, the payments show it as a receipt
+type Document struct {
+	Invoice models.Invoice
+	Issuer  string
+	// Stay describes the reservation, e.g. "Generals Quarters from 2026-07-01 to 2026-07-03"
+	Stay     string
+	Payments []models.Payment
+}
+
+// IssuerLines is the issuer one line each
+func (d Document) IssuerLines() []string {
+	var lines []string
+	for _, l := range strings.Split(d.Issuer, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// Receipts are the captures and refunds of the ledger that went through
+func (d Document) Receipts() []models.Payment {
+	var receipts []models.Payment
+	for _, p := range d.Payments {
+		if p.Succeeded && (p.Kind == models.PaymentCapture || p.Kind == models.PaymentRefund) {
+			receipts = append(receipts, p)
+		}
+	}
+	return receipts
+}
+
+// Paid is how much was captured less the refunds
+func (d Document) Paid() int {
+	s := payments.Summarize(d.Payments)
+	return s.Captured - s.Refunded
+}
+
+// BalanceDue is how much of the invoice was not captured yet
+func (d Document) BalanceDue() int {
+	due := d.Invoice.Total - payments.Summarize(d.Payments).Captured
+	if due < 0 {
+		return 0
+	}
+	return due
+}
+
+// FileName is the name of the pdf of the invoice
+func (d Document) FileName() string {
+	return "invoice-" + d.Invoice.Number + ".pdf"
+}
@@ -0,0 +1,159 @@
+package invoices
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// reservation is a 3 night stay with a weekend night, a stay discount and a promo code
+func reservation() models.Reservations {
+	return models.Reservations{
+		ID:        7,
+		FirstName: "John",
+		LastName:  "Mayor",
+		Email:     "john@mail.com",
+		Room:      models.Room{RoomName: "Generals Quarters"},
+		Quote: models.Quote{
+			Nights: []models.NightPrice{
+				{Rate: 10000},
+				{Rate: 12000, Weekend: true},
+				{Rate: 10000},
+			},
+			Subtotal:        32000,
+			DiscountPercent: 10,
+			Discount:        3200,
+			PromoCode:       "WELCOME",
+			PromoDiscount:   800,
+			Total:           28000,
+		},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	inv := Build(reservation(), Settings{TaxName: "VAT", TaxRate: 1000}, time.Now())
+
+	if len(inv.Lines) != 4 {
+		t.Fatalf("expected 2 night lines and 2 discounts, but %+v", inv.Lines)
+	}
+	if l := inv.Lines[0]; l.Quantity != 2 || l.UnitPrice != 10000 || l.Amount != 20000 {
+		t.Errorf("expected the weekday nights on one line, but %+v", l)
+	}
+	if l := inv.Lines[1]; l.Description != "Generals Quarters, weekend nights" || l.Amount != 12000 {
+		t.Errorf("unexpected weekend line %+v", l)
+	}
+	if inv.Total != 28000 || inv.BillTo != "John Mayor" || inv.ReservationID != 7 {
+		t.Errorf("unexpected invoice %+v", inv)
+	}
+	// 280.00 with 10% included is 254.55 and 25.45 of tax
+	if len(inv.Taxes) != 1 || inv.Taxes[0].Amount != 2545 || !inv.Taxes[0].Included {
+		t.Errorf("expected 25.45 of included tax, but %+v", inv.Taxes)
+	}
+
+	if inv := Build(reservation(), Settings{}, time.Now()); len(inv.Taxes) != 0 {
+		t.Errorf("expected no tax without a rate, but %+v", inv.Taxes)
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	var theTests = []struct {
+		rate     int
+		expected string
+	}{
+		{1000, "10%"},
+		{750, "7.5%"},
+		{825, "8.25%"},
+	}
+	for _, e := range theTests {
+		if s := FormatRate(e.rate); s != e.expected {
+			t.Errorf("for %d, expected %s, but %s", e.rate, e.expected, s)
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	inv := Build(reservation(), Settings{}, time.Now())
+	inv.Number = "2026-000001"
+	doc := Document{
+		Invoice: inv,
+		Issuer:  "Fort Smythe\n\n 1 Main Street ",
+		Payments: []models.Payment{
+			{Kind: models.PaymentAuthorize, Amount: 28000, Reference: "a", Succeeded: true},
+			{Kind: models.PaymentCapture, Amount: 28000, Reference: "c", Succeeded: true},
+			{Kind: models.PaymentRefund, Amount: 5000, Succeeded: false},
+			{Kind: models.PaymentRefund, Amount: 3000, Succeeded: true},
+		},
+	}
+
+	if lines := doc.IssuerLines(); len(lines) != 2 || lines[1] != "1 Main Street" {
+		t.Errorf("unexpected issuer lines %q", lines)
+	}
+	if receipts := doc.Receipts(); len(receipts) != 2 {
+		t.Errorf("expected the capture and the refund that went through, but %d", len(receipts))
+	}
+	if doc.Paid() != 25000 || doc.BalanceDue() != 0 {
+		t.Errorf("expected 250.00 paid and nothing due, but %d and %d", doc.Paid(), doc.BalanceDue())
+	}
+}
+
+func TestPDF(t *testing.T) {
+	inv := Build(reservation(), Settings{TaxName: "VAT", TaxRate: 1000}, time.Now())
+	inv.Number = "2026-000001"
+	inv.BillTo = "Zoë (guest)"
+	out := PDF(Document{Invoice: inv, Issuer: "Fort Smythe"})
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("expected a pdf file")
+	}
+	for _, s := range []string{"Invoice no. 2026-000001", "Zo\\353 \\(guest\\)", "$280.00"} {
+		if !bytes.Contains(out, []byte(s)) {
+			t.Errorf("expected the pdf to contain %s", s)
+		}
+	}
+
+	// startxref points at the cross reference table
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("expected startxref")
+	}
+	offset, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(out[offset:], []byte("xref\n")) {
+		t.Errorf("expected the cross reference table at %d", offset)
+	}
+}
+
+func TestBuildCharges(t *testing.T) {
+	res := reservation()
+	res.Quote.Charges = []models.Charge{
+		{Name: "Cleaning fee", Kind: models.TaxKindFee, Amount: 2000},
+		{Name: "Sales tax", Kind: models.TaxKindTax, Rate: 500, Amount: 1400},
+		{Name: "City tax", Kind: models.TaxKindTax, Amount: 600},
+	}
+	res.Quote.Total = 32000
+
+	inv := Build(res, Settings{TaxName: "VAT", TaxRate: 1000}, time.Now())
+
+	if l := inv.Lines[len(inv.Lines)-1]; l.Description != "Cleaning fee" || l.Amount != 2000 {
+		t.Errorf("expected the fee as the last line, but %+v", l)
+	}
+	if len(inv.Taxes) != 3 || inv.Taxes[0].Included || !inv.Taxes[2].Included {
+		t.Fatalf("expected the 2 taxes on top and the included one, but %+v", inv.Taxes)
+	}
+	if inv.Total != res.Quote.Total {
+		t.Errorf("expected the total of the quote %d, but %d", res.Quote.Total, inv.Total)
+	}
+	// the included tax is on the lines only, 300.00 with 10% included is 27.27
+	if inv.Taxes[2].Amount != 2727 {
+		t.Errorf("expected 27.27 of included tax, but %d", inv.Taxes[2].Amount)
+	}
+
+	for tax, expected := range map[int]string{0: "Sales tax 5%", 1: "City tax", 2: "incl. VAT 10%"} {
+		if s := TaxLabel(inv.Taxes[tax]); s != expected {
+			t.Errorf("expected %s, but %s", expected, s)
+		}
+	}
+}
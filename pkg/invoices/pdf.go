@@ -0,0 +1,249 @@
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
+)
+
+// an A4 page in points and its margins
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	margin     = 50.0
+)
+
+// fonts of the pdf, all of them are standard fonts every reader has
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+	fontMono    = "F3"
+)
+
+// columns of the lines table, the numbers are right aligned on them
+const (
+	colQuantity  = 360.0
+	colUnitPrice = 450.0
+	colAmount    = pageWidth - margin
+)
+
+// pdf lays out text on pages top down
+type pdf struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64
+}
+
+// PDF writes the document as a pdf file
+func PDF(d Document) []byte {
+	p := &pdf{}
+	p.newPage()
+	inv := d.Invoice
+
+	p.text(margin, p.y, fontBold, 20, "Invoice")
+	p.y -= 30
+	top := p.y
+	for _, l := range d.IssuerLines() {
+		p.line(fontRegular, 10, l)
+	}
+	// the number and date are next to the issuer
+	p.text(350, top, fontRegular, 10, "Invoice no. "+inv.Number)
+	p.text(350, top-14, fontRegular, 10, "Date "+inv.IssuedAt.Format("2006-01-02"))
+	p.y = minFloat(p.y, top-28) - 20
+
+	p.line(fontBold, 10, "Bill to")
+	p.line(fontRegular, 10, inv.BillTo)
+	p.line(fontRegular, 10, inv.Email)
+	p.y -= 10
+	if d.Stay != "" {
+		p.line(fontRegular, 10, d.Stay)
+		p.y -= 10
+	}
+
+	p.text(margin, p.y, fontBold, 10, "Description")
+	p.right(colQuantity, p.y, fontBold, 10, "Qty")
+	p.right(colUnitPrice, p.y, fontBold, 10, "Unit price")
+	p.right(colAmount, p.y, fontBold, 10, "Amount")
+	p.y -= 6
+	p.rule()
+	p.y -= 14
+
+	for _, l := range inv.Lines {
+		p.need(14)
+		p.text(margin, p.y, fontRegular, 10, truncate(l.Description, 52))
+		p.right(colQuantity, p.y, fontMono, 10, fmt.Sprint(l.Quantity))
+		p.right(colUnitPrice, p.y, fontMono, 10, pricing.FormatMoney(l.UnitPrice))
+		p.right(colAmount, p.y, fontMono, 10, pricing.FormatMoney(l.Amount))
+		p.y -= 14
+	}
+	p.y += 8
+	p.rule()
+	p.y -= 16
+
+	// the taxes added to the lines come before the total, the included ones after it
+	for _, included := range []bool{false, true} {
+		if included {
+			p.need(16)
+			p.text(colQuantity, p.y, fontBold, 11, "Total")
+			p.right(colAmount, p.y, fontMono, 11, pricing.FormatMoney(inv.Total))
+			p.y -= 16
+		}
+		for _, t := range inv.Taxes {
+			if t.Included != included {
+				continue
+			}
+			p.need(14)
+			p.text(colQuantity, p.y, fontRegular, 10, TaxLabel(t))
+			p.right(colAmount, p.y, fontMono, 10, pricing.FormatMoney(t.Amount))
+			p.y -= 14
+		}
+	}
+
+	p.y -= 20
+	receipts := d.Receipts()
+	if len(receipts) > 0 {
+		p.need(28)
+		p.line(fontBold, 10, "Payments")
+		for _, r := range receipts {
+			p.need(14)
+			amount := r.Amount
+			label := r.CreatedAt.Format("2006-01-02") + " paid"
+			if r.CardLast4 != "" {
+				label += " by card ending " + r.CardLast4
+			}
+			if r.Kind == models.PaymentRefund {
+				amount = -amount
+				label = r.CreatedAt.Format("2006-01-02") + " refunded"
+			}
+			p.text(margin, p.y, fontRegular, 10, label)
+			p.right(colAmount, p.y, fontMono, 10, pricing.FormatMoney(amount))
+			p.y -= 14
+		}
+		p.y -= 6
+	}
+	p.need(14)
+	p.text(colQuantity, p.y, fontBold, 10, "Balance due")
+	p.right(colAmount, p.y, fontMono, 10, pricing.FormatMoney(d.BalanceDue()))
+
+	return p.bytes()
+}
+
+// newPage starts a page with the cursor at its top
+func (p *pdf) newPage() {
+	p.page = new(bytes.Buffer)
+	p.pages = append(p.pages, p.page)
+	p.y = pageHeight - margin
+}
+
+// need starts a new page when there is not height left on this one
+func (p *pdf) need(height float64) {
+	if p.y-height < margin {
+		p.newPage()
+	}
+}
+
+// line writes text at the left margin and moves the cursor down
+func (p *pdf) line(font string, size float64, s string) {
+	p.need(size + 4)
+	p.text(margin, p.y, font, size, s)
+	p.y -= size + 4
+}
+
+// text writes s with its baseline starting at x, y
+func (p *pdf) text(x, y float64, font string, size float64, s string) {
+	fmt.Fprintf(p.page, "BT /%s %.0f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(s))
+}
+
+// right writes s ending at x, only the monospaced font can be measured
+func (p *pdf) right(x, y float64, font string, size float64, s string) {
+	width := float64(len([]rune(s))) * size * 0.6
+	if font != fontMono {
+		// a rough average of the proportional fonts
+		width = float64(len([]rune(s))) * size * 0.55
+	}
+	p.text(x-width, y, font, size, s)
+}
+
+// rule draws a line across the page at the cursor
+func (p *pdf) rule() {
+	fmt.Fprintf(p.page, "0.5 w %.2f %.2f m %.2f %.2f l S\n", margin, p.y, pageWidth-margin, p.y)
+}
+
+// bytes puts the pages together into a pdf file
+func (p *pdf) bytes() []byte {
+	var objects []string
+	add := func(o string) int {
+		objects = append(objects, o)
+		return len(objects)
+	}
+
+	catalog := add("<< /Type /Catalog /Pages 2 0 R >>")
+	pagesID := add("")
+	fonts := fmt.Sprintf("<< /%s %d 0 R /%s %d 0 R /%s %d 0 R >>", fontRegular, 3, fontBold, 4, fontMono, 5)
+	add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	add("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+
+	var kids []string
+	for _, page := range p.pages {
+		content := add(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+		id := add(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font %s >> /Contents %d 0 R >>",
+			pagesID, pageWidth, pageHeight, fonts, content))
+		kids = append(kids, fmt.Sprintf("%d 0 R", id))
+	}
+	objects[pagesID-1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	out := new(bytes.Buffer)
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, catalog, xref)
+	return out.Bytes()
+}
+
+// escape makes s a pdf string in the WinAnsi encoding, characters it does not have become "?"
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// truncate cuts s to at most n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,198 @@
+// Package mailer delivers the mails of the outbox, through an SMTP server or, for development and tests, into
+// .eml files or the log
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/outbox"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// transports a mail can go out with
+const (
+	TransportSMTP = "smtp"
+	TransportFile = "file"
+	TransportLog  = "log"
+)
+
+// encryptions of the connection to the SMTP server. ssl connects over TLS, starttls upgrades a plain connection
+const (
+	EncryptionNone     = "none"
+	EncryptionSSL      = "ssl"
+	EncryptionSTARTTLS = "starttls"
+)
+
+// Config chooses how mails are delivered
+type Config struct {
+	Transport string
+
+	// the SMTP server, the username and password are only sent when set
+	Host       string
+	Port       int
+	Username   string
+	Password   string
+	Encryption string
+	// SkipVerify accepts any certificate of the SMTP server, for relays with a self signed one
+	SkipVerify bool
+
+	// From is the sender address of mails that have none
+	From string
+
+	// Dir is where the file transport writes the mails
+	Dir string
+}
+
+// New returns the sender of a configuration, or an error when the configuration is incomplete
+func New(cfg Config, logger *log.Logger) (outbox.Sender, error) {
+	if cfg.From == "" {
+		return nil, errors.New("mailer: a sender address is required")
+	}
+
+	switch cfg.Transport {
+	case TransportSMTP:
+		server, err := smtpServer(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &SMTP{Server: server, From: cfg.From}, nil
+	case TransportFile:
+		if cfg.Dir == "" {
+			return nil, errors.New("mailer: the file transport needs a directory")
+		}
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			return nil, err
+		}
+		return &File{Dir: cfg.Dir, From: cfg.From}, nil
+	case TransportLog:
+		return &Log{Logger: logger, From: cfg.From}, nil
+	}
+	return nil, fmt.Errorf("mailer: unknown transport %q (smtp, file, log)", cfg.Transport)
+}
+
+// smtpServer returns the connection settings of the SMTP server of a configuration
+func smtpServer(cfg Config) (*mail.SMTPServer, error) {
+	if cfg.Host == "" || cfg.Port <= 0 {
+		return nil, errors.New("mailer: the smtp transport needs a host and a port")
+	}
+
+	server := mail.NewSMTPClient()
+	server.Host = cfg.Host
+	server.Port = cfg.Port
+	server.Username = cfg.Username
+	server.Password = cfg.Password
+	server.KeepAlive = false
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+	server.TLSConfig = &tls.Config{ServerName: cfg.Host, InsecureSkipVerify: cfg.SkipVerify}
+
+	switch cfg.Encryption {
+	case EncryptionNone, "":
+		server.Encryption = mail.EncryptionNone
+	case EncryptionSSL:
+		server.Encryption = mail.EncryptionSSLTLS
+	case EncryptionSTARTTLS:
+		server.Encryption = mail.EncryptionSTARTTLS
+	default:
+		return nil, fmt.Errorf("mailer: unknown encryption %q (none, ssl, starttls)", cfg.Encryption)
+	}
+	return server, nil
+}
+
+// Build returns the message of a mail, from is the sender when the mail has none
+func Build(msg models.MailData, from string) (*mail.Email, error) {
+	if msg.From != "" {
+		from = msg.From
+	}
+
+	email := mail.NewMSG()
+	email.SetFrom(from).AddTo(msg.To).SetSubject(msg.Subject)
+	if msg.PlainContent != "" {
+		// mail programs show the last alternative they can, so html comes after plain text
+		email.SetBody(mail.TextPlain, msg.PlainContent)
+		email.AddAlternative(mail.TextHTML, msg.Content)
+	} else {
+		email.SetBody(mail.TextHTML, msg.Content)
+	}
+	for _, a := range msg.Attachments {
+		email.AddAttachmentData(a.Data, a.Name, a.ContentType)
+	}
+	return email, email.GetError()
+}
+
+// SMTP delivers mails through an SMTP server, with a new connection for each
+type SMTP struct {
+	Server *mail.SMTPServer
+	From   string
+}
+
+// Send delivers a mail
+func (s *SMTP) Send(msg models.MailData) error {
+	email, err := Build(msg, s.From)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.Server.Connect()
+	if err != nil {
+		return err
+	}
+	return email.Send(client)
+}
+
+// File writes each mail to an .eml file of a directory, which mail programs open as they would have received it
+type File struct {
+	Dir  string
+	From string
+}
+
+// written numbers the files, so mails of the same instant get a file each
+var written uint64
+
+// Send writes a mail to a new file
+func (f *File) Send(msg models.MailData) error {
+	email, err := Build(msg, f.From)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().Format("20060102-150405.000"), atomic.AddUint64(&written, 1)%10000)
+	return ioutil.WriteFile(filepath.Join(f.Dir, name), []byte(email.GetMessage()), 0644)
+}
+
+// Log writes each mail to the log instead of sending it
+type Log struct {
+	Logger *log.Logger
+	From   string
+}
+
+// Send logs a mail with its plain text, or else its html, and the names of its attachments
+func (l *Log) Send(msg models.MailData) error {
+	from := msg.From
+	if from == "" {
+		from = l.From
+	}
+
+	var names []string
+	for _, a := range msg.Attachments {
+		names = append(names, a.Name)
+	}
+	content := msg.PlainContent
+	if content == "" {
+		content = msg.Content
+	}
+	l.Logger.Printf("Mail from %s to %s: %s\n%s\nAttachments: %s\n", from, msg.To, msg.Subject, content,
+		strings.Join(names, ", "))
+	return nil
+}
@@ -0,0 +1,117 @@
+package mailer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	simplemail "github.com/xhit/go-simple-mail/v2"
+)
+
+var testMail = models.MailData{
+	To:      "john@mail.com",
+	Subject: "Reservation Confirmation",
+	Content: "<strong>Dear John</strong>",
+	Attachments: []models.MailAttachment{
+		{Name: "invoice-2026-000001.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")},
+	},
+}
+
+func TestNew(t *testing.T) {
+	var theTests = []struct {
+		name    string
+		cfg     Config
+		isValid bool
+	}{
+		{"smtp", Config{Transport: TransportSMTP, Host: "localhost", Port: 1025, From: "a@b.com"}, true},
+		{"smtp-starttls", Config{Transport: TransportSMTP, Host: "relay", Port: 587, Encryption: EncryptionSTARTTLS, From: "a@b.com"}, true},
+		{"smtp-no-host", Config{Transport: TransportSMTP, Port: 25, From: "a@b.com"}, false},
+		{"smtp-bad-encryption", Config{Transport: TransportSMTP, Host: "relay", Port: 25, Encryption: "tls13", From: "a@b.com"}, false},
+		{"no-sender", Config{Transport: TransportLog}, false},
+		{"file-no-dir", Config{Transport: TransportFile, From: "a@b.com"}, false},
+		{"log", Config{Transport: TransportLog, From: "a@b.com"}, true},
+		{"unknown", Config{Transport: "pigeon", From: "a@b.com"}, false},
+	}
+
+	for _, e := range theTests {
+		_, err := New(e.cfg, log.New(ioutil.Discard, "", 0))
+		if (err == nil) != e.isValid {
+			t.Errorf("for %s, expected valid %t, but %v", e.name, e.isValid, err)
+		}
+	}
+}
+
+func TestSMTPServer(t *testing.T) {
+	server, err := smtpServer(Config{Host: "relay", Port: 465, Username: "user", Password: "secret", Encryption: EncryptionSSL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Encryption != simplemail.EncryptionSSLTLS || server.Username != "user" || server.TLSConfig.ServerName != "relay" {
+		t.Errorf("unexpected server %+v", server)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sender, err := New(Config{Transport: TransportFile, Dir: filepath.Join(dir, "out"), From: "server@booking.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := sender.Send(testMail); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "out", "*.eml"))
+	if len(files) != 2 {
+		t.Fatalf("expected a file for each mail, but %v", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, _ := msg.Header.AddressList("From")
+	to, _ := msg.Header.AddressList("To")
+	if len(from) != 1 || from[0].Address != "server@booking.com" || len(to) != 1 || to[0].Address != "john@mail.com" ||
+		msg.Header.Get("Subject") != "Reservation Confirmation" {
+		t.Errorf("unexpected headers %v", msg.Header)
+	}
+	if !strings.HasPrefix(msg.Header.Get("Content-Type"), "multipart/mixed") {
+		t.Errorf("expected the attachment in a multipart mail, but %s", msg.Header.Get("Content-Type"))
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	sender, err := New(Config{Transport: TransportLog, From: "server@booking.com"}, log.New(&buf, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(testMail); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	for _, expected := range []string{"server@booking.com", "john@mail.com", "Dear John", "invoice-2026-000001.pdf"} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in the log, but %s", expected, out)
+		}
+	}
+}
@@ -19,18 +19,345 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	DisabledAt  time.Time
+	// two-factor authentication, the secret is only set once enrollment is confirmed
+	TOTPSecret    string
+	TOTPEnabledAt time.Time
+	TOTPLastStep  int64
+	// failed logins since the last successful one, the account is locked for a while after too many
+	FailedLogins int
+	LockedUntil  time.Time
+	// NotifyEmail is whether the user gets their notifications by mail too, one of NotifyEmailModes
+	NotifyEmail string
+	// PasswordChangedAt is when the password was last set or cleared, sessions started before it are logged out
+	PasswordChangedAt time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+// Locked reports whether the user is locked out after too many failed logins
+func (u User) Locked() bool {
+	return u.LockedUntil.After(time.Now())
+}
+
+// TwoFactorEnabled reports whether the user logs in with a one-time code after the password
+func (u User) TwoFactorEnabled() bool {
+	return !u.TOTPEnabledAt.IsZero()
+}
+
+// Disabled reports whether the user is kept from logging in
+func (u User) Disabled() bool {
+	return !u.DisabledAt.IsZero()
+}
+
+// events of the auth audit log
+const (
+	AuthLogin             = "login"
+	AuthLoginRecoveryCode = "login-recovery-code"
+	AuthBadPassword       = "bad-password"
+	AuthUnknownEmail      = "unknown-email"
+	AuthBadCode           = "bad-code"
+	AuthAccountLocked     = "account-locked"
+	AuthAccountDisabled   = "account-disabled"
+	AuthIPBlocked         = "ip-blocked"
+)
+
+// AuthEvent is a login attempt in the auth audit log
+type AuthEvent struct {
+	ID int
+	// UserID is 0 when the email has no account
+	UserID    int
+	Email     string
+	IPAddress string
+	Event     string
+	Success   bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// purposes of a user token
+const (
+	TokenInvite        = "invite"
+	TokenPasswordReset = "password-reset"
+)
+
+// UserToken is a single use token mailed to a user, only a hash of the token is stored
+type UserToken struct {
+	ID        int
+	UserID    int
+	Purpose   string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Usable reports whether the token is neither used nor expired
+func (t UserToken) Usable() bool {
+	return t.UsedAt.IsZero() && time.Now().Before(t.ExpiresAt)
+}
+
+// access levels of the admin tool, each level can do everything the levels below it can
+const (
+	AccessViewer    = 1
+	AccessFrontDesk = 2
+	AccessManager   = 3
+	AccessOwner     = 4
+)
+
+// AccessLevels lists the access levels from lowest to highest
+var AccessLevels = []int{AccessViewer, AccessFrontDesk, AccessManager, AccessOwner}
+
+// permissions checked on the admin routes
+const (
+	PermViewReservations   = "view-reservations"
+	PermEditReservations   = "edit-reservations"
+	PermDeleteReservations = "delete-reservations"
+	PermEditCalendar       = "edit-calendar"
+	PermViewRooms          = "view-rooms"
+	PermManageRooms        = "manage-rooms"
+	PermManagePromotions   = "manage-promotions"
+	PermManageTaxes        = "manage-taxes"
+	PermManagePayments     = "manage-payments"
+	PermManageAPIKeys      = "manage-api-keys"
+	PermManageUsers        = "manage-users"
+	PermViewDashboard      = "view-dashboard"
+	PermManageSettings     = "manage-settings"
+	PermViewAudit          = "view-audit"
+	PermManageMail         = "manage-mail"
+)
+
+// permissionLevels is the permission matrix, the lowest access level that has each permission
+var permissionLevels = map[string]int{
+	PermViewDashboard:      AccessViewer,
+	PermViewReservations:   AccessViewer,
+	PermViewRooms:          AccessViewer,
+	PermEditReservations:   AccessFrontDesk,
+	PermEditCalendar:       AccessFrontDesk,
+	PermDeleteReservations: AccessManager,
+	PermManageRooms:        AccessManager,
+	PermManagePromotions:   AccessManager,
+	PermManageTaxes:        AccessManager,
+	PermManagePayments:     AccessManager,
+	PermViewAudit:          AccessManager,
+	PermManageAPIKeys:      AccessOwner,
+	PermManageUsers:        AccessOwner,
+	PermManageSettings:     AccessOwner,
+	PermManageMail:         AccessOwner,
+}
+
+// keys of the settings table
+const (
+	// SettingRequire2FALevel is the lowest access level that must use two-factor authentication, 0 for nobody
+	SettingRequire2FALevel = "require_2fa_level"
+	// SettingInvoiceIssuer is the name and address of the business printed at the top of invoices
+	SettingInvoiceIssuer = "invoice_issuer"
+	// SettingTaxName and SettingTaxRate are the tax included in the prices, the rate is in hundredths of a
+	// percent, e.g. 1000 for 10%
+	SettingTaxName = "tax_name"
+	SettingTaxRate = "tax_rate"
+	// SettingTrashRetentionDays is how many days deleted reservations can be restored before they are purged
+	SettingTrashRetentionDays = "trash_retention_days"
+	// the scheduled guest mails, each is sent when it is enabled, the days of the reminder and the check-in mail
+	// count before arrival and those of the thank-you mail after departure
+	SettingReminderEnabled = "reminder_enabled"
+	SettingReminderDays    = "reminder_days"
+	SettingCheckInEnabled  = "check_in_enabled"
+	SettingCheckInDays     = "check_in_days"
+	SettingThankYouEnabled = "thank_you_enabled"
+	SettingThankYouDays    = "thank_you_days"
+	// SettingGuestMailHour is the hour of the day from which the scheduled guest mails of a day are sent
+	SettingGuestMailHour = "guest_mail_hour"
+	// SettingCheckInInstructions is what the check-in mail tells the guests
+	SettingCheckInInstructions = "check_in_instructions"
+	// SettingReviewURL is where the thank-you mail asks the guests to review their stay, none when empty
+	SettingReviewURL = "review_url"
+)
+
+// Can reports whether accessLevel has permission, unknown permissions are denied
+func Can(accessLevel int, permission string) bool {
+	level, ok := permissionLevels[permission]
+	return ok && accessLevel >= level
+}
+
+// ValidAccessLevel reports whether accessLevel is one of AccessLevels
+func ValidAccessLevel(accessLevel int) bool {
+	return accessLevel >= AccessViewer && accessLevel <= AccessOwner
+}
+
+// AccessLevelName is the name of an access level as shown in the admin tool
+func AccessLevelName(accessLevel int) string {
+	switch accessLevel {
+	case AccessViewer:
+		return "Viewer"
+	case AccessFrontDesk:
+		return "Front desk"
+	case AccessManager:
+		return "Manager"
+	case AccessOwner:
+		return "Owner"
+	}
+	return "Unknown"
 }
 
 // Room is the room model
 type Room struct {
+	ID          int
+	RoomName    string
+	Slug        string
+	Description string
+	Capacity    int
+	Image       string
+	Active      bool
+	SortOrder   int
+	// nightly rates in cents, a WeekendRate of 0 charges the BaseRate on friday and saturday nights too
+	BaseRate    int
+	WeekendRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// SeasonalRate replaces the rates of a room for the nights from StartDate through EndDate
+type SeasonalRate struct {
+	ID          int
+	RoomID      int
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int
+	WeekendRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// CancellationRule charges Percent of the price of a stay of a room that is cancelled less than DaysBefore
+// days before arrival
+type CancellationRule struct {
+	ID         int
+	RoomID     int
+	DaysBefore int
+	Percent    int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// StayDiscount takes Percent off the stays of a room that are at least MinNights long
+type StayDiscount struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	MinNights int
+	Percent   int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// NightPrice is the price of one night of a stay, starting on Date
+type NightPrice struct {
+	Date    time.Time
+	Rate    int
+	Weekend bool
+	// Season is the name of the seasonal rate that priced the night, "" for the room's own rates
+	Season string
+}
+
+// Quote is what a stay costs, every amount is in cents
+type Quote struct {
+	Nights          []NightPrice
+	Subtotal        int
+	DiscountPercent int
+	Discount        int
+	// the promo code the guest entered and what it took off after the stay discount
+	PromoCodeID   int
+	PromoCode     string
+	PromoDiscount int
+	// Charges are the taxes and fees added to the price after the discounts
+	Charges []Charge
+	Total   int
+}
+
+// Charge is a tax or fee added to the price of a stay
+type Charge struct {
+	Name string
+	// Kind is TaxKindTax or TaxKindFee
+	Kind string
+	// Rate is the percentage of a percent rule in hundredths of a percent, 0 for a flat amount
+	Rate   int
+	Amount int
+}
+
+// kinds of tax rules
+const (
+	TaxKindTax = "tax"
+	TaxKindFee = "fee"
+)
+
+// how a tax rule is charged
+const (
+	ChargePercent       = "percent"
+	ChargePerStay       = "stay"
+	ChargePerNight      = "night"
+	ChargePerGuest      = "guest"
+	ChargePerGuestNight = "guest-night"
+)
+
+// TaxRule is a tax or fee charged on every stay, zero effective dates leave it open on that side
+type TaxRule struct {
+	ID   int
+	Name string
+	Kind string
+	// Basis is ChargePercent, with Amount in hundredths of a percent of the room price, or a flat Amount in
+	// cents per stay, night, guest or guest and night
+	Basis          string
+	Amount         int
+	EffectiveFrom  time.Time
+	EffectiveUntil time.Time
+	Active         bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// kinds of promo code discount
+const (
+	PromoPercent = "percent"
+	PromoFixed   = "fixed"
+)
+
+// PromoCode is a code guests enter at checkout for a discount, zero times, limits and no rooms mean no restriction
+type PromoCode struct {
+	ID          int
+	Code        string
+	Description string
+	// Kind is PromoPercent, with Amount a percentage, or PromoFixed, with Amount in cents
+	Kind   string
+	Amount int
+	// the code can be used to book from BookFrom through BookUntil, for stays within StayFrom through StayUntil
+	BookFrom       time.Time
+	BookUntil      time.Time
+	StayFrom       time.Time
+	StayUntil      time.Time
+	MinNights      int
+	RoomIDs        []int
+	MaxRedemptions int
+	MaxPerEmail    int
+	Active         bool
+	// Redemptions is how often the code has been used, it is filled in by the queries listing codes
+	Redemptions int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// PromoRedemption is a use of a promo code by a reservation
+type PromoRedemption struct {
+	ID            int
+	PromoCodeID   int
+	ReservationID int
+	Email         string
+	Discount      int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
 // Restrictions is the restriction model
 type Restrictions struct {
 	ID              int
@@ -52,7 +379,208 @@ type Reservations struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Room      Room
-	Processed int
+	Status    string
+	// AccessToken is the unguessable token a guest uses to look up the booking
+	AccessToken string
+	// Quote is the price of the stay when it was booked
+	Quote  Quote
+	Guests int
+	// CancelledAt is set once the reservation is cancelled, CancellationFee is what the guest was charged for it
+	CancelledAt     time.Time
+	CancellationFee int
+	// DeletedAt is set while the reservation is in the trash, DeletedBy is the staff member who deleted it
+	DeletedAt time.Time
+	DeletedBy int
+}
+
+// Cancelled reports whether the reservation is cancelled
+func (r Reservations) Cancelled() bool {
+	return r.Status == ReservationCancelled
+}
+
+// CanBecome reports whether the reservation can move on to a status from the one it is in
+func (r Reservations) CanBecome(status string) bool {
+	return CanTransition(r.Status, status)
+}
+
+// statuses of a reservation, a new one is pending until the staff confirms it
+const (
+	ReservationPending    = "pending"
+	ReservationConfirmed  = "confirmed"
+	ReservationCheckedIn  = "checked-in"
+	ReservationCheckedOut = "checked-out"
+	ReservationCancelled  = "cancelled"
+	ReservationNoShow     = "no-show"
+)
+
+// ReservationStatuses are all statuses in the order a stay goes through them
+var ReservationStatuses = []string{
+	ReservationPending,
+	ReservationConfirmed,
+	ReservationCheckedIn,
+	ReservationCheckedOut,
+	ReservationCancelled,
+	ReservationNoShow,
+}
+
+// reservationTransitions are the statuses a reservation can move on to from each status, checked-out,
+// cancelled and no-show are final
+var reservationTransitions = map[string][]string{
+	ReservationPending:   {ReservationConfirmed, ReservationCancelled},
+	ReservationConfirmed: {ReservationCheckedIn, ReservationCancelled, ReservationNoShow},
+	ReservationCheckedIn: {ReservationCheckedOut},
+}
+
+// CanTransition reports whether a reservation can move from one status to another, it is the one place the
+// lifecycle of a reservation is decided
+func CanTransition(from, to string) bool {
+	for _, status := range reservationTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transitions returns the statuses a reservation can move on to from a status
+func Transitions(from string) []string {
+	return reservationTransitions[from]
+}
+
+// StatusChange is an entry of the status history of a reservation
+type StatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    string
+	ToStatus      string
+	// UserID is the staff member who made the change, 0 when the guest did
+	UserID    int
+	UserName  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// entities of the audit log
+const (
+	AuditReservation      = "reservation"
+	AuditBlock            = "block"
+	AuditRoom             = "room"
+	AuditSeasonalRate     = "seasonal-rate"
+	AuditStayDiscount     = "stay-discount"
+	AuditCancellationRule = "cancellation-rule"
+	AuditTaxRule          = "tax-rule"
+	AuditPromoCode        = "promo-code"
+	AuditSettings         = "settings"
+	AuditUser             = "user"
+	AuditAPIKey           = "api-key"
+	AuditMail             = "mail"
+)
+
+// AuditEntities are the entities of the audit log, in the order they are offered in its search
+var AuditEntities = []string{
+	AuditReservation,
+	AuditBlock,
+	AuditRoom,
+	AuditSeasonalRate,
+	AuditStayDiscount,
+	AuditCancellationRule,
+	AuditTaxRule,
+	AuditPromoCode,
+	AuditSettings,
+	AuditUser,
+	AuditAPIKey,
+	AuditMail,
+}
+
+// AuditEntry is a change to the data in the audit log
+type AuditEntry struct {
+	ID int
+	// UserID is the staff member who made the change, 0 when a guest, an API client or the app did
+	UserID   int
+	UserName string
+	// Actor names who made the change when it was not a staff member
+	Actor    string
+	Action   string
+	Entity   string
+	EntityID int
+	// Changes is the JSON list of the changed fields with their values before and after
+	Changes   string
+	IPAddress string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// AuditFilter is a search of the audit log, the empty fields match every entry
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	Action   string
+	UserID   int
+	// Query is found in the actor, the user's name or the changes
+	Query string
+	From  time.Time
+	Until time.Time
+	Limit int
+}
+
+// kinds of payments ledger entries, one for every call to the payment gateway
+const (
+	PaymentAuthorize = "authorize"
+	PaymentCapture   = "capture"
+	PaymentRefund    = "refund"
+	PaymentVoid      = "void"
+)
+
+// Payment is an entry of the payments ledger of a reservation, amounts are in cents
+type Payment struct {
+	ID            int
+	ReservationID int
+	Kind          string
+	Amount        int
+	// Reference is the gateway's id of the transaction, empty when the gateway refused it
+	Reference string
+	Succeeded bool
+	// Message is why the gateway refused the transaction
+	Message   string
+	CardLast4 string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Invoice is an issued invoice of a reservation, its lines are kept as they were when it was issued
+type Invoice struct {
+	ID int
+	// Number is the gap-free number of the invoice within the year it was issued, e.g. "2026-000042"
+	Number   string
+	Year     int
+	Sequence int
+	// ReservationID is 0 once the reservation is deleted, the invoice itself is kept
+	ReservationID int
+	BillTo        string
+	Email         string
+	Lines         []InvoiceLine
+	Taxes         []InvoiceTax
+	Total         int
+	IssuedAt      time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// InvoiceLine is a line of an invoice, discounts have a negative amount
+type InvoiceLine struct {
+	Description string
+	Quantity    int
+	UnitPrice   int
+	Amount      int
+}
+
+// InvoiceTax is a tax line of an invoice, Rate is in hundredths of a percent and 0 for a flat tax, an included
+// tax is already part of the lines and is not added to the total
+type InvoiceTax struct {
+	Name     string
+	Rate     int
+	Amount   int
+	Included bool
 }
 
 // RoomRestrictions is the room restriction model
@@ -70,10 +598,134 @@ type RoomRestrictions struct {
 	Restriction   Restrictions
 }
 
+// API key scopes
+const (
+	ScopeReadAvailability  = "read-availability"
+	ScopeWriteReservations = "write-reservations"
+	ScopeAdmin             = "admin"
+)
+
+// APIScopes lists every scope an API key can be given
+var APIScopes = []string{ScopeReadAvailability, ScopeWriteReservations, ScopeAdmin}
+
+// APIKey is the api key model, only a hash of the key itself is stored
+type APIKey struct {
+	ID         int
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+	UserID     int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// HasScope reports whether the key may be used for scope, the admin scope allows everything
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Usable reports whether the key is neither revoked nor expired
+func (k APIKey) Usable() bool {
+	if !k.RevokedAt.IsZero() {
+		return false
+	}
+	return k.ExpiresAt.IsZero() || time.Now().Before(k.ExpiresAt)
+}
+
 // Holds the mail message
 type MailData struct{
 	To string
 	From string
 	Subject string
 	Content string
-}
\ No newline at end of file
+	// PlainContent is the plain text version of the html Content, mail programs show it when they do not show html
+	PlainContent string
+	Attachments []MailAttachment
+}
+
+// statuses of a mail in the outbox, a mail that is still pending after too many attempts is dead
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxDead    = "dead"
+)
+
+// OutboxStatuses are the statuses of the outbox, in the order they are offered in its filter
+var OutboxStatuses = []string{OutboxPending, OutboxSent, OutboxDead}
+
+// OutboxMessage is a mail in the outbox, it is delivered in the background and retried until it goes out
+type OutboxMessage struct {
+	ID int
+	MailData
+	Status   string
+	Attempts int
+	// NextAttemptAt is when the mail is due, a mail is held back by setting it in the future
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// MailAttachment is a file sent along with a mail
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// how users get their notifications by mail, they are always shown in the admin tool
+const (
+	NotifyEmailOff       = "off"
+	NotifyEmailImmediate = "immediate"
+	NotifyEmailDigest    = "digest"
+)
+
+// NotifyEmailModes are the ways users get their notifications by mail, in the order they are offered
+var NotifyEmailModes = []string{NotifyEmailOff, NotifyEmailImmediate, NotifyEmailDigest}
+
+// kinds of notification
+const (
+	NotificationNewReservation = "new-reservation"
+)
+
+// Notification tells a user of the admin tool about something that happened, like a new reservation
+type Notification struct {
+	ID     int
+	UserID int
+	Kind   string
+	Title  string
+	Body   string
+	// Link is the path of the admin tool the notification is about
+	Link   string
+	ReadAt time.Time
+	// MailedAt is when the notification went out by mail, on its own or in a digest
+	MailedAt  time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Unread reports whether the user has not opened the notification yet
+func (n Notification) Unread() bool {
+	return n.ReadAt.IsZero()
+}
+
+// GuestMail records that a scheduled mail of a kind was queued for the guest of a reservation, each kind goes
+// out once
+type GuestMail struct {
+	ID              int
+	ReservationID   int
+	Kind            string
+	OutboxMessageID int
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
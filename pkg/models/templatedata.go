@@ -14,4 +14,10 @@ type TemplateData struct {
 	Error     string
 	Form      *forms.Form
 	IsAuthenticated int // if==0 : not login
+	AccessLevel int // access level of the logged in user
+}
+
+// Can reports whether the logged in user has permission, templates use it to hide actions
+func (td *TemplateData) Can(permission string) bool {
+	return Can(td.AccessLevel, permission)
 }
@@ -0,0 +1,198 @@
+// Package outbox delivers the mails queued in the outbox table in the background, retrying failed deliveries
+// with an exponential backoff until they go out or run out of attempts
+package outbox
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// Store is the part of the repository the worker uses
+type Store interface {
+	ClaimDueMail(limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkMailSent(id int) error
+	MarkMailFailed(id int, lastError string, retryAt time.Time) error
+}
+
+// Sender delivers a mail
+type Sender interface {
+	Send(msg models.MailData) error
+}
+
+// SenderFunc lets a plain function deliver the mails
+type SenderFunc func(msg models.MailData) error
+
+// Send calls f(msg)
+func (f SenderFunc) Send(msg models.MailData) error {
+	return f(msg)
+}
+
+// defaults of a new worker
+const (
+	DefaultWorkers      = 4
+	DefaultBatchSize    = 20
+	DefaultMaxAttempts  = 8
+	DefaultBaseDelay    = 30 * time.Second
+	DefaultMaxDelay     = 6 * time.Hour
+	DefaultPollInterval = 5 * time.Second
+	DefaultLease        = 5 * time.Minute
+)
+
+// Worker delivers the due mails of the outbox with a pool of goroutines. A mail that fails is tried again after
+// Backoff, once it failed MaxAttempts times it is dead and left for the staff to resend
+type Worker struct {
+	Store  Store
+	Sender Sender
+
+	// Workers is how many mails are delivered at once
+	Workers int
+	// BatchSize is how many due mails are claimed at a time
+	BatchSize int
+	// MaxAttempts is how often a mail is tried before it is dead
+	MaxAttempts int
+	// BaseDelay is the wait after the first failure, it doubles with every further one up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// PollInterval is how often the outbox is checked for due mails
+	PollInterval time.Duration
+	// Lease is how long a claimed mail is not due again, it has to outlast a delivery. A mail whose worker
+	// stopped during the delivery is retried once it is over
+	Lease time.Duration
+
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a worker with the default settings
+func New(store Store, sender Sender, infoLog, errorLog *log.Logger) *Worker {
+	return &Worker{
+		Store:        store,
+		Sender:       sender,
+		Workers:      DefaultWorkers,
+		BatchSize:    DefaultBatchSize,
+		MaxAttempts:  DefaultMaxAttempts,
+		BaseDelay:    DefaultBaseDelay,
+		MaxDelay:     DefaultMaxDelay,
+		PollInterval: DefaultPollInterval,
+		Lease:        DefaultLease,
+		InfoLog:      infoLog,
+		ErrorLog:     errorLog,
+	}
+}
+
+// Backoff returns how long to wait before the next try of a mail that failed attempt times, base after the first
+// failure and twice as long after each further one, but never more than max
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := base
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= max || delay <= 0 {
+			return max
+		}
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// RunOnce claims a batch of due mails and delivers them, it returns how many were claimed
+func (w *Worker) RunOnce() int {
+	messages, err := w.Store.ClaimDueMail(w.BatchSize, w.Lease)
+	if err != nil {
+		w.ErrorLog.Println("outbox:", err)
+		return 0
+	}
+	if len(messages) == 0 {
+		return 0
+	}
+
+	queue := make(chan models.OutboxMessage)
+	var wg sync.WaitGroup
+	workers := w.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range queue {
+				w.deliver(msg)
+			}
+		}()
+	}
+	for _, msg := range messages {
+		queue <- msg
+	}
+	close(queue)
+	wg.Wait()
+
+	return len(messages)
+}
+
+// deliver sends a claimed mail and records how it went
+func (w *Worker) deliver(msg models.OutboxMessage) {
+	err := w.Sender.Send(msg.MailData)
+	if err == nil {
+		if err := w.Store.MarkMailSent(msg.ID); err != nil {
+			w.ErrorLog.Printf("outbox: mail %d was sent but not marked: %v", msg.ID, err)
+		}
+		w.InfoLog.Printf("Sent mail %d %q to %s\n", msg.ID, msg.Subject, msg.To)
+		return
+	}
+
+	var retryAt time.Time
+	if msg.Attempts < w.MaxAttempts {
+		retryAt = time.Now().Add(Backoff(msg.Attempts, w.BaseDelay, w.MaxDelay))
+		w.ErrorLog.Printf("outbox: mail %d to %s failed (attempt %d of %d), retrying at %s: %v",
+			msg.ID, msg.To, msg.Attempts, w.MaxAttempts, retryAt.Format("2006-01-02 15:04:05"), err)
+	} else {
+		w.ErrorLog.Printf("outbox: mail %d to %s failed %d times, giving up: %v", msg.ID, msg.To, msg.Attempts, err)
+	}
+	if err := w.Store.MarkMailFailed(msg.ID, err.Error(), retryAt); err != nil {
+		w.ErrorLog.Printf("outbox: could not record the failure of mail %d: %v", msg.ID, err)
+	}
+}
+
+// Start delivers the outbox in the background until Stop is called. A full batch is followed by the next right
+// away, otherwise the outbox is checked again after PollInterval
+func (w *Worker) Start() {
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+		for {
+			if w.RunOnce() >= w.BatchSize {
+				select {
+				case <-w.stop:
+					return
+				default:
+					continue
+				}
+			}
+			select {
+			case <-w.stop:
+				return
+			case <-time.After(w.PollInterval):
+			}
+		}
+	}()
+}
+
+// Stop ends the background delivery, it returns once the mails being delivered are done. The mails still due
+// stay in the outbox for the next start
+func (w *Worker) Stop() {
+	close(w.stop)
+	<-w.done
+}
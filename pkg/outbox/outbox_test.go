@@ -0,0 +1,165 @@
+package outbox
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/config"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+	"github.com/fangjjcs/bookings-app/pkg/repository/dbrepo"
+)
+
+func TestBackoff(t *testing.T) {
+	base := 30 * time.Second
+	max := 10 * time.Minute
+
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{6, max},
+		{100, max},
+	}
+	for _, test := range tests {
+		if got := Backoff(test.attempt, base, max); got != test.expected {
+			t.Errorf("Backoff(%d): expected %s but got %s", test.attempt, test.expected, got)
+		}
+	}
+}
+
+// failingSender delivers every mail except those to failTo and remembers what it sent
+type failingSender struct {
+	mu     sync.Mutex
+	failTo string
+	sent   []models.MailData
+}
+
+func (s *failingSender) Send(msg models.MailData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if msg.To == s.failTo {
+		return errors.New("connection refused")
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func newTestWorker(repo repository.DatabaseRepo, sender Sender) *Worker {
+	discard := log.New(ioutil.Discard, "", 0)
+	w := New(repo, sender, discard, discard)
+	w.Workers = 2
+	w.MaxAttempts = 2
+	w.BaseDelay = time.Minute
+	w.PollInterval = 10 * time.Millisecond
+	return w
+}
+
+func queue(t *testing.T, repo repository.DatabaseRepo, to string) int {
+	t.Helper()
+	id, err := repo.QueueMail(models.OutboxMessage{MailData: models.MailData{To: to, From: "me@here.com", Subject: "Hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestWorkerRetriesUntilDead(t *testing.T) {
+	repo := dbrepo.NewMemoryRepo(&config.AppConfig{})
+	sender := &failingSender{failTo: "down@example.com"}
+	w := newTestWorker(repo, sender)
+
+	ok := queue(t, repo, "guest@example.com")
+	failing := queue(t, repo, "down@example.com")
+
+	if n := w.RunOnce(); n != 2 {
+		t.Fatalf("expected 2 mails to be claimed but got %d", n)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].To != "guest@example.com" {
+		t.Fatalf("expected the mail to guest@example.com to be sent, sent %v", sender.sent)
+	}
+
+	msg, _ := repo.GetOutboxMessage(ok)
+	if msg.Status != models.OutboxSent || msg.SentAt.IsZero() {
+		t.Errorf("expected mail %d to be sent, it is %s", ok, msg.Status)
+	}
+
+	msg, _ = repo.GetOutboxMessage(failing)
+	if msg.Status != models.OutboxPending || msg.Attempts != 1 || msg.LastError != "connection refused" {
+		t.Errorf("expected mail %d to wait for a retry after 1 attempt, got %s after %d: %q", failing, msg.Status, msg.Attempts, msg.LastError)
+	}
+	if wait := time.Until(msg.NextAttemptAt); wait < 50*time.Second || wait > time.Minute {
+		t.Errorf("expected the retry in about a minute but it is in %s", wait)
+	}
+
+	// it is not due yet
+	if n := w.RunOnce(); n != 0 {
+		t.Errorf("expected no due mails but %d were claimed", n)
+	}
+
+	// the retry is due, the second failure is the last attempt
+	if err := repo.MarkMailFailed(failing, msg.LastError, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	w.RunOnce()
+	msg, _ = repo.GetOutboxMessage(failing)
+	if msg.Status != models.OutboxDead || msg.Attempts != 2 {
+		t.Errorf("expected mail %d to be dead after 2 attempts, got %s after %d", failing, msg.Status, msg.Attempts)
+	}
+
+	// a dead mail that is resent gets fresh attempts
+	if err := repo.ResendMail(failing); err != nil {
+		t.Fatal(err)
+	}
+	sender.failTo = ""
+	w.RunOnce()
+	msg, _ = repo.GetOutboxMessage(failing)
+	if msg.Status != models.OutboxSent || msg.Attempts != 1 {
+		t.Errorf("expected the resent mail %d to be sent at the first attempt, got %s after %d", failing, msg.Status, msg.Attempts)
+	}
+}
+
+func TestWorkerStartStop(t *testing.T) {
+	repo := dbrepo.NewMemoryRepo(&config.AppConfig{})
+	var sent sync.WaitGroup
+	sent.Add(3)
+	w := newTestWorker(repo, SenderFunc(func(msg models.MailData) error {
+		sent.Done()
+		return nil
+	}))
+	w.BatchSize = 2
+
+	w.Start()
+	for i := 0; i < 3; i++ {
+		queue(t, repo, "guest@example.com")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		sent.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the mails were not delivered")
+	}
+	w.Stop()
+
+	pending, err := repo.OutboxMessages(models.OutboxPending, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending mails but got %d", len(pending))
+	}
+}
@@ -0,0 +1,105 @@
+package payments
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FakeDeclinedCard is a card number the fake gateway declines, every other valid card is approved
+const FakeDeclinedCard = "4000000000000002"
+
+// FakeGateway is a payment gateway that lives in the process, for development and tests, no money moves
+type FakeGateway struct {
+	mu           sync.Mutex
+	next         int
+	transactions map[string]*fakeTransaction
+}
+
+// fakeTransaction is an authorization, capture or refund of the fake gateway
+type fakeTransaction struct {
+	kind   string
+	amount int
+	// used is how much of an authorization is captured or of a capture is refunded
+	used   int
+	voided bool
+}
+
+// NewFakeGateway creates a fake payment gateway
+func NewFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		transactions: make(map[string]*fakeTransaction),
+	}
+}
+
+// Authorize holds amount on the card, the card FakeDeclinedCard is declined
+func (g *FakeGateway) Authorize(amount int, card Card) (string, error) {
+	if amount <= 0 {
+		return "", ErrInvalidAmount
+	}
+	if err := card.Validate(time.Now()); err != nil {
+		return "", err
+	}
+	if card.digits() == FakeDeclinedCard {
+		return "", ErrDeclined
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.add("auth", amount), nil
+}
+
+// Capture charges up to the authorized amount, an authorization can be captured once
+func (g *FakeGateway) Capture(authorization string, amount int) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	auth, ok := g.transactions[authorization]
+	if !ok || auth.kind != "auth" || auth.voided || auth.used > 0 {
+		return "", ErrUnknownTransaction
+	}
+	if amount <= 0 || amount > auth.amount {
+		return "", ErrInvalidAmount
+	}
+
+	auth.used = amount
+	return g.add("cap", amount), nil
+}
+
+// Refund pays back part or all of a capture, the refunds of a capture can not add up to more than it
+func (g *FakeGateway) Refund(capture string, amount int) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.transactions[capture]
+	if !ok || c.kind != "cap" {
+		return "", ErrUnknownTransaction
+	}
+	if amount <= 0 || c.used+amount > c.amount {
+		return "", ErrInvalidAmount
+	}
+
+	c.used += amount
+	return g.add("ref", amount), nil
+}
+
+// Void releases an authorization that was not captured
+func (g *FakeGateway) Void(authorization string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	auth, ok := g.transactions[authorization]
+	if !ok || auth.kind != "auth" || auth.voided || auth.used > 0 {
+		return ErrUnknownTransaction
+	}
+	auth.voided = true
+	return nil
+}
+
+// add stores a transaction and returns its reference, the caller holds the lock
+func (g *FakeGateway) add(kind string, amount int) string {
+	g.next++
+	reference := fmt.Sprintf("fake_%s_%d", kind, g.next)
+	g.transactions[reference] = &fakeTransaction{kind: kind, amount: amount}
+	return reference
+}
@@ -0,0 +1,50 @@
+package payments
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeGateway(t *testing.T) {
+	g := NewFakeGateway()
+	card := Card{Number: "4242424242424242", ExpMonth: 1, ExpYear: time.Now().Year() + 2, CVC: "123"}
+
+	declined := card
+	declined.Number = FakeDeclinedCard
+	if _, err := g.Authorize(5000, declined); err != ErrDeclined {
+		t.Errorf("expected the card to be declined, but %v", err)
+	}
+
+	auth, err := g.Authorize(5000, card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Capture(auth, 6000); err != ErrInvalidAmount {
+		t.Errorf("expected capturing more than authorized to fail, but %v", err)
+	}
+	capture, err := g.Capture(auth, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Void(auth); err != ErrUnknownTransaction {
+		t.Errorf("expected a captured authorization not to be voided, but %v", err)
+	}
+
+	if _, err := g.Refund(capture, 3000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Refund(capture, 2001); err != ErrInvalidAmount {
+		t.Errorf("expected refunding more than captured to fail, but %v", err)
+	}
+	if _, err := g.Refund(auth, 100); err != ErrUnknownTransaction {
+		t.Errorf("expected an authorization not to be refunded, but %v", err)
+	}
+
+	other, _ := g.Authorize(1000, card)
+	if err := g.Void(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Capture(other, 1000); err != ErrUnknownTransaction {
+		t.Errorf("expected a voided authorization not to be captured, but %v", err)
+	}
+}
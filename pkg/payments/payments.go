@@ -0,0 +1,170 @@
+// Package payments takes the guests' payments through a payment gateway and keeps track of them
+package payments
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// errors of the gateway the guest can do something about, the messages are shown to guests
+var (
+	ErrInvalidCard = errors.New("the card details are not valid")
+	ErrDeclined    = errors.New("the card was declined")
+)
+
+// errors of transactions the gateway refuses
+var (
+	ErrInvalidAmount      = errors.New("the amount is not valid for this transaction")
+	ErrUnknownTransaction = errors.New("the transaction does not exist or can not be used for this")
+)
+
+// PaymentGateway takes payments from cards, amounts are in cents and transactions are referred to by the
+// references the gateway returns
+type PaymentGateway interface {
+	// Authorize holds amount on the card and returns the reference of the authorization
+	Authorize(amount int, card Card) (string, error)
+	// Capture charges up to the authorized amount and returns the reference of the capture
+	Capture(authorization string, amount int) (string, error)
+	// Refund pays back part or all of a capture and returns the reference of the refund
+	Refund(capture string, amount int) (string, error)
+	// Void releases an authorization that was not captured
+	Void(authorization string) error
+}
+
+// Card is the card a guest pays with, it is passed on to the gateway and never stored
+type Card struct {
+	Name     string
+	Number   string
+	ExpMonth int
+	ExpYear  int
+	CVC      string
+}
+
+// Last4 is the end of the card number that may be shown and stored
+func (c Card) Last4() string {
+	n := c.digits()
+	if len(n) < 4 {
+		return n
+	}
+	return n[len(n)-4:]
+}
+
+// digits is the card number without spaces and dashes
+func (c Card) digits() string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(c.Number)
+}
+
+// Validate checks the card number, expiry and cvc the way gateways do before asking the bank
+func (c Card) Validate(now time.Time) error {
+	n := c.digits()
+	if len(n) < 12 || len(n) > 19 || !luhn(n) {
+		return ErrInvalidCard
+	}
+	if c.ExpMonth < 1 || c.ExpMonth > 12 {
+		return ErrInvalidCard
+	}
+	// a card is good through the last day of its expiry month
+	if time.Date(c.ExpYear, time.Month(c.ExpMonth)+1, 1, 0, 0, 0, 0, time.UTC).Before(now) {
+		return ErrInvalidCard
+	}
+	if len(c.CVC) < 3 || len(c.CVC) > 4 || strings.Trim(c.CVC, "0123456789") != "" {
+		return ErrInvalidCard
+	}
+	return nil
+}
+
+// luhn checks the check digit of a card number
+func luhn(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// states of the payment of a reservation
+const (
+	StateUnpaid            = "unpaid"
+	StateAuthorized        = "authorized"
+	StatePaid              = "paid"
+	StatePartiallyRefunded = "partially refunded"
+	StateRefunded          = "refunded"
+	StateVoided            = "voided"
+)
+
+// Summary is where the payment of a reservation stands, worked out from its ledger
+type Summary struct {
+	State      string
+	Authorized int
+	Captured   int
+	Refunded   int
+	// Authorization and Capture are the references of the latest authorization and capture
+	Authorization string
+	Capture       string
+}
+
+// Summarize works out the payment of a reservation from its ledger entries, oldest first
+func Summarize(ledger []models.Payment) Summary {
+	var s Summary
+	voided := false
+
+	for _, p := range ledger {
+		if !p.Succeeded {
+			continue
+		}
+		switch p.Kind {
+		case models.PaymentAuthorize:
+			s.Authorized = p.Amount
+			s.Authorization = p.Reference
+			voided = false
+		case models.PaymentCapture:
+			s.Captured += p.Amount
+			s.Capture = p.Reference
+		case models.PaymentRefund:
+			s.Refunded += p.Amount
+		case models.PaymentVoid:
+			voided = true
+		}
+	}
+
+	switch {
+	case s.Captured > 0 && s.Refunded >= s.Captured:
+		s.State = StateRefunded
+	case s.Captured > 0 && s.Refunded > 0:
+		s.State = StatePartiallyRefunded
+	case s.Captured > 0:
+		s.State = StatePaid
+	case voided:
+		s.State = StateVoided
+	case s.Authorization != "":
+		s.State = StateAuthorized
+	default:
+		s.State = StateUnpaid
+	}
+	return s
+}
+
+// CanCapture reports whether an authorization is waiting to be captured
+func (s Summary) CanCapture() bool {
+	return s.State == StateAuthorized
+}
+
+// Refundable is how much of the captured amount can still be refunded
+func (s Summary) Refundable() int {
+	return s.Captured - s.Refunded
+}
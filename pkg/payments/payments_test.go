@@ -0,0 +1,77 @@
+package payments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestCardValidate(t *testing.T) {
+	now := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	card := Card{Name: "John", Number: "4242 4242 4242 4242", ExpMonth: 12, ExpYear: 2028, CVC: "123"}
+
+	var theTests = []struct {
+		name     string
+		change   func(c *Card)
+		expected error
+	}{
+		{"valid", func(c *Card) {}, nil},
+		{"dashes", func(c *Card) { c.Number = "4242-4242-4242-4242" }, nil},
+		{"check-digit", func(c *Card) { c.Number = "4242424242424241" }, ErrInvalidCard},
+		{"letters", func(c *Card) { c.Number = "4242abcd42424242" }, ErrInvalidCard},
+		{"short", func(c *Card) { c.Number = "4242" }, ErrInvalidCard},
+		{"month", func(c *Card) { c.ExpMonth = 13 }, ErrInvalidCard},
+		// a card is good through its expiry month
+		{"expires-this-month", func(c *Card) { c.ExpMonth, c.ExpYear = 10, 2026 }, nil},
+		{"expired", func(c *Card) { c.ExpMonth, c.ExpYear = 9, 2026 }, ErrInvalidCard},
+		{"cvc", func(c *Card) { c.CVC = "12a" }, ErrInvalidCard},
+	}
+
+	for _, e := range theTests {
+		c := card
+		e.change(&c)
+		if err := c.Validate(now); err != e.expected {
+			t.Errorf("for %s, expected %v, but %v", e.name, e.expected, err)
+		}
+	}
+
+	if last4 := card.Last4(); last4 != "4242" {
+		t.Errorf("expected 4242, but %s", last4)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	auth := models.Payment{Kind: models.PaymentAuthorize, Amount: 5000, Reference: "a1", Succeeded: true}
+	capture := models.Payment{Kind: models.PaymentCapture, Amount: 5000, Reference: "c1", Succeeded: true}
+	failed := models.Payment{Kind: models.PaymentCapture, Amount: 5000, Succeeded: false}
+	void := models.Payment{Kind: models.PaymentVoid, Amount: 5000, Reference: "a1", Succeeded: true}
+	refund := func(amount int) models.Payment {
+		return models.Payment{Kind: models.PaymentRefund, Amount: amount, Succeeded: true}
+	}
+
+	var theTests = []struct {
+		name       string
+		ledger     []models.Payment
+		state      string
+		refundable int
+	}{
+		{"unpaid", nil, StateUnpaid, 0},
+		{"authorized", []models.Payment{auth, failed}, StateAuthorized, 0},
+		{"voided", []models.Payment{auth, void}, StateVoided, 0},
+		{"paid", []models.Payment{auth, capture}, StatePaid, 5000},
+		{"partially-refunded", []models.Payment{auth, capture, refund(1000)}, StatePartiallyRefunded, 4000},
+		{"refunded", []models.Payment{auth, capture, refund(1000), refund(4000)}, StateRefunded, 0},
+	}
+
+	for _, e := range theTests {
+		s := Summarize(e.ledger)
+		if s.State != e.state || s.Refundable() != e.refundable {
+			t.Errorf("for %s, expected %s with %d refundable, but %s with %d", e.name, e.state, e.refundable, s.State, s.Refundable())
+		}
+	}
+
+	if s := Summarize([]models.Payment{auth, failed}); !s.CanCapture() || s.Authorization != "a1" {
+		t.Errorf("expected a1 to be waiting for a capture, but %+v", s)
+	}
+}
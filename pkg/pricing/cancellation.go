@@ -0,0 +1,28 @@
+package pricing
+
+import (
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// Cancellation is what cancelling a stay costs, Percent of its price
+type Cancellation struct {
+	// DaysBefore is how many days before arrival the stay is cancelled
+	DaysBefore int
+	Percent    int
+	Fee        int
+}
+
+// Cancel works out the fee of cancelling a stay of price total arriving on start at the time at, the rule with
+// the highest percentage of those the cancellation is late for applies, without one it is free
+func Cancel(total int, start, at time.Time, rules []models.CancellationRule) Cancellation {
+	c := Cancellation{DaysBefore: int(day(start).Sub(day(at)).Hours() / 24)}
+	for _, rule := range rules {
+		if c.DaysBefore < rule.DaysBefore && rule.Percent > c.Percent {
+			c.Percent = rule.Percent
+		}
+	}
+	c.Fee = (total*c.Percent + 50) / 100
+	return c
+}
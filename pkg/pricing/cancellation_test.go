@@ -0,0 +1,39 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestCancel(t *testing.T) {
+	// free until 14 days before arrival, then half and the last 2 days all of it
+	rules := []models.CancellationRule{
+		{DaysBefore: 14, Percent: 50},
+		{DaysBefore: 2, Percent: 100},
+	}
+	start := time.Date(2026, 8, 20, 14, 0, 0, 0, time.UTC)
+
+	var theTests = []struct {
+		name       string
+		at         time.Time
+		daysBefore int
+		fee        int
+	}{
+		{"early", time.Date(2026, 8, 1, 23, 0, 0, 0, time.UTC), 19, 0},
+		{"14 days before", time.Date(2026, 8, 6, 9, 0, 0, 0, time.UTC), 14, 0},
+		{"13 days before", time.Date(2026, 8, 7, 9, 0, 0, 0, time.UTC), 13, 15001},
+		{"the day before", time.Date(2026, 8, 19, 9, 0, 0, 0, time.UTC), 1, 30001},
+	}
+	for _, e := range theTests {
+		c := Cancel(30001, start, e.at, rules)
+		if c.DaysBefore != e.daysBefore || c.Fee != e.fee {
+			t.Errorf("for %s, expected %d days and a fee of %d, but %+v", e.name, e.daysBefore, e.fee, c)
+		}
+	}
+
+	if c := Cancel(30001, start, start, nil); c.Fee != 0 || c.Percent != 0 {
+		t.Errorf("expected a room without rules to cancel for free, but %+v", c)
+	}
+}
@@ -0,0 +1,88 @@
+package pricing
+
+import (
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// ApplyCharges adds the taxes and fees of the active rules to a quote for a number of guests, after its
+// stay discount and promo code. A rule is charged for the nights of the stay it is in effect, percent rules on
+// the room price of those nights, fees come before taxes
+func ApplyCharges(q models.Quote, rules []models.TaxRule, guests int) models.Quote {
+	if guests < 1 {
+		guests = 1
+	}
+	room := q.Subtotal - q.Discount - q.PromoDiscount
+	if room < 0 {
+		room = 0
+	}
+
+	q.Charges = nil
+	for _, kind := range []string{models.TaxKindFee, models.TaxKindTax} {
+		for _, rule := range rules {
+			if !rule.Active || rule.Kind != kind {
+				continue
+			}
+			if c, ok := charge(q, rule, room, guests); ok {
+				q.Charges = append(q.Charges, c)
+			}
+		}
+	}
+
+	q.Total = room
+	for _, c := range q.Charges {
+		q.Total += c.Amount
+	}
+	return q
+}
+
+// charge works out what a rule adds to a stay whose nights cost room after the discounts, ok is false when
+// the rule is not in effect for any of the nights
+func charge(q models.Quote, rule models.TaxRule, room, guests int) (models.Charge, bool) {
+	nights, rates := 0, 0
+	for _, n := range q.Nights {
+		if inEffect(rule, n.Date) {
+			nights++
+			rates += n.Rate
+		}
+	}
+	if nights == 0 {
+		return models.Charge{}, false
+	}
+
+	c := models.Charge{Name: rule.Name, Kind: rule.Kind}
+	switch rule.Basis {
+	case models.ChargePercent:
+		c.Rate = rule.Amount
+		// the discounts are spread over the nights by their price
+		taxable := room
+		if rates < q.Subtotal {
+			taxable = room * rates / q.Subtotal
+		}
+		c.Amount = (taxable*rule.Amount + 5000) / 10000
+	case models.ChargePerStay:
+		c.Amount = rule.Amount
+	case models.ChargePerNight:
+		c.Amount = rule.Amount * nights
+	case models.ChargePerGuest:
+		c.Amount = rule.Amount * guests
+	case models.ChargePerGuestNight:
+		c.Amount = rule.Amount * guests * nights
+	default:
+		return c, false
+	}
+	return c, c.Amount > 0
+}
+
+// inEffect reports whether a rule applies to the night starting on d
+func inEffect(rule models.TaxRule, d time.Time) bool {
+	d = day(d)
+	if !rule.EffectiveFrom.IsZero() && d.Before(day(rule.EffectiveFrom)) {
+		return false
+	}
+	if !rule.EffectiveUntil.IsZero() && d.After(day(rule.EffectiveUntil)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package pricing
+
+import (
+	"testing"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestApplyCharges(t *testing.T) {
+	// 3 weekday nights of 100.00 with 20.00 off
+	q := models.Quote{
+		Nights: []models.NightPrice{
+			{Date: date("2026-03-02"), Rate: 10000},
+			{Date: date("2026-03-03"), Rate: 10000},
+			{Date: date("2026-03-04"), Rate: 10000},
+		},
+		Subtotal:      30000,
+		PromoDiscount: 2000,
+		Total:         28000,
+	}
+	fee := func(basis string, amount int) models.TaxRule {
+		return models.TaxRule{Name: "Fee", Kind: models.TaxKindFee, Basis: basis, Amount: amount, Active: true}
+	}
+	tax := models.TaxRule{Name: "City tax", Kind: models.TaxKindTax, Basis: models.ChargePercent, Amount: 500, Active: true}
+
+	var theTests = []struct {
+		name     string
+		rule     models.TaxRule
+		guests   int
+		expected int
+	}{
+		{"percent-of-discounted-price", tax, 2, 1400},
+		{"per-stay", fee(models.ChargePerStay, 5000), 2, 5000},
+		{"per-night", fee(models.ChargePerNight, 100), 2, 300},
+		{"per-guest", fee(models.ChargePerGuest, 100), 2, 200},
+		{"per-guest-night", fee(models.ChargePerGuestNight, 100), 2, 600},
+		{"no-guests-is-one", fee(models.ChargePerGuest, 100), 0, 100},
+		{"inactive", models.TaxRule{Kind: models.TaxKindFee, Basis: models.ChargePerStay, Amount: 100}, 1, 0},
+		{"not-yet", models.TaxRule{Kind: models.TaxKindFee, Basis: models.ChargePerNight, Amount: 100, Active: true,
+			EffectiveFrom: date("2026-03-05")}, 1, 0},
+		// the rule starts on the last night
+		{"from-last-night", models.TaxRule{Kind: models.TaxKindFee, Basis: models.ChargePerNight, Amount: 100, Active: true,
+			EffectiveFrom: date("2026-03-04")}, 1, 100},
+		// the rule ends after the first night, only that night's share of the discounted price is taxed
+		{"until-first-night", models.TaxRule{Kind: models.TaxKindTax, Basis: models.ChargePercent, Amount: 1000, Active: true,
+			EffectiveUntil: date("2026-03-02")}, 1, 933},
+	}
+
+	for _, e := range theTests {
+		got := ApplyCharges(q, []models.TaxRule{e.rule}, e.guests)
+		charged := got.Total - 28000
+		if charged != e.expected {
+			t.Errorf("for %s, expected %d charged, but %d", e.name, e.expected, charged)
+		}
+		if charged == 0 && len(got.Charges) != 0 {
+			t.Errorf("for %s, expected no charge lines, but %v", e.name, got.Charges)
+		}
+	}
+
+	// fees come first, whatever order the rules are in
+	got := ApplyCharges(q, []models.TaxRule{tax, fee(models.ChargePerStay, 5000)}, 1)
+	if len(got.Charges) != 2 || got.Charges[0].Kind != models.TaxKindFee || got.Charges[1].Rate != 500 {
+		t.Errorf("unexpected charges %+v", got.Charges)
+	}
+	if got.Total != 28000+5000+1400 {
+		t.Errorf("expected 344.00, but %d", got.Total)
+	}
+}
@@ -0,0 +1,145 @@
+// Package pricing works out what a stay in a room costs, night by night
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// ErrInvalidStay is returned for a stay that does not end after it starts
+var ErrInvalidStay = errors.New("the stay has to end after it starts")
+
+// Plan holds everything that sets the price of a room's nights
+type Plan struct {
+	Room      models.Room
+	Seasons   []models.SeasonalRate
+	Discounts []models.StayDiscount
+}
+
+// Quote prices every night from start up to, not including, end and applies the best stay discount
+func (p Plan) Quote(start, end time.Time) (models.Quote, error) {
+	var q models.Quote
+
+	start, end = day(start), day(end)
+	if !end.After(start) {
+		return q, ErrInvalidStay
+	}
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := p.price(d)
+		q.Nights = append(q.Nights, night)
+		q.Subtotal += night.Rate
+	}
+
+	q.DiscountPercent = p.discount(len(q.Nights))
+	q.Discount = (q.Subtotal*q.DiscountPercent + 50) / 100
+	q.Total = q.Subtotal - q.Discount
+	return q, nil
+}
+
+// price is the price of the night starting on d, a seasonal rate wins over the room's rates and
+// of overlapping seasons the one that starts last wins
+func (p Plan) price(d time.Time) models.NightPrice {
+	night := models.NightPrice{
+		Date:    d,
+		Weekend: IsWeekend(d),
+		Rate:    rate(p.Room.BaseRate, p.Room.WeekendRate, IsWeekend(d)),
+	}
+
+	var season *models.SeasonalRate
+	for i, s := range p.Seasons {
+		if d.Before(day(s.StartDate)) || d.After(day(s.EndDate)) {
+			continue
+		}
+		if season == nil || s.StartDate.After(season.StartDate) {
+			season = &p.Seasons[i]
+		}
+	}
+	if season != nil {
+		night.Season = season.Name
+		night.Rate = rate(season.NightlyRate, season.WeekendRate, night.Weekend)
+	}
+	return night
+}
+
+// discount is the percentage of the longest stay discount a stay of nights qualifies for
+func (p Plan) discount(nights int) int {
+	percent, minNights := 0, 0
+	for _, d := range p.Discounts {
+		if nights >= d.MinNights && d.MinNights >= minNights {
+			percent, minNights = d.Percent, d.MinNights
+		}
+	}
+	return percent
+}
+
+// rate picks the weekend rate for weekend nights, when there is one
+func rate(nightly, weekend int, isWeekend bool) int {
+	if isWeekend && weekend > 0 {
+		return weekend
+	}
+	return nightly
+}
+
+// IsWeekend reports whether the night starting on d is a friday or saturday night
+func IsWeekend(d time.Time) bool {
+	return d.Weekday() == time.Friday || d.Weekday() == time.Saturday
+}
+
+// day drops the time of day, stays are priced by calendar date
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// FormatMoney shows an amount in cents as dollars, e.g. 12950 as "$129.50"
+func FormatMoney(cents int) string {
+	if cents < 0 {
+		return "-$" + FormatAmount(-cents)
+	}
+	return "$" + FormatAmount(cents)
+}
+
+// FormatAmount shows an amount in cents the way ParseMoney reads it, e.g. 12950 as "129.50"
+func FormatAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// ParseMoney reads an amount in dollars such as "129.5" or "$1,200" as cents
+func ParseMoney(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "$")
+	s = strings.ReplaceAll(s, ",", "")
+	if s == "" {
+		return 0, nil
+	}
+
+	dollars, cents := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		dollars, cents = s[:i], s[i+1:]
+	}
+	if len(cents) > 2 {
+		return 0, fmt.Errorf("%q has more than 2 decimals", s)
+	}
+	cents += strings.Repeat("0", 2-len(cents))
+	if dollars == "" {
+		dollars = "0"
+	}
+
+	d, err := strconv.ParseUint(dollars, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an amount", s)
+	}
+	c, err := strconv.ParseUint(cents, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an amount", s)
+	}
+	return int(d)*100 + int(c), nil
+}
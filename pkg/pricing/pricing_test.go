@@ -0,0 +1,116 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+var testPlan = Plan{
+	Room: models.Room{BaseRate: 10000, WeekendRate: 12000},
+	Seasons: []models.SeasonalRate{
+		{Name: "Summer", StartDate: date("2026-07-01"), EndDate: date("2026-08-31"), NightlyRate: 15000},
+		{Name: "Festival", StartDate: date("2026-07-10"), EndDate: date("2026-07-12"), NightlyRate: 20000, WeekendRate: 25000},
+	},
+	Discounts: []models.StayDiscount{
+		{MinNights: 7, Percent: 10},
+		{MinNights: 14, Percent: 15},
+	},
+}
+
+func TestQuote(t *testing.T) {
+	var theTests = []struct {
+		name     string
+		start    string
+		end      string
+		subtotal int
+		percent  int
+		total    int
+	}{
+		// 2026-06-01 is a monday
+		{"weekday", "2026-06-01", "2026-06-03", 20000, 0, 20000},
+		{"weekend", "2026-06-05", "2026-06-08", 34000, 0, 34000},
+		{"week", "2026-06-01", "2026-06-08", 74000, 10, 66600},
+		{"two-weeks", "2026-06-01", "2026-06-15", 148000, 15, 125800},
+		{"into-summer", "2026-06-30", "2026-07-02", 25000, 0, 25000},
+		// the festival starts on a friday and wins over summer, its sunday night has no weekend rate
+		{"festival", "2026-07-09", "2026-07-13", 85000, 0, 85000},
+	}
+
+	for _, e := range theTests {
+		q, err := testPlan.Quote(date(e.start), date(e.end))
+		if err != nil {
+			t.Errorf("for %s, unexpected error %v", e.name, err)
+			continue
+		}
+		if q.Subtotal != e.subtotal || q.DiscountPercent != e.percent || q.Total != e.total {
+			t.Errorf("for %s, expected %d less %d%% is %d, but %d less %d%% is %d",
+				e.name, e.subtotal, e.percent, e.total, q.Subtotal, q.DiscountPercent, q.Total)
+		}
+		if q.Discount != q.Subtotal-q.Total {
+			t.Errorf("for %s, the discount %d does not add up", e.name, q.Discount)
+		}
+	}
+}
+
+func TestQuoteNights(t *testing.T) {
+	q, _ := testPlan.Quote(date("2026-07-09"), date("2026-07-11"))
+	if len(q.Nights) != 2 {
+		t.Fatalf("expected 2 nights, but %d", len(q.Nights))
+	}
+	if q.Nights[0].Season != "Summer" || q.Nights[0].Weekend || q.Nights[0].Rate != 15000 {
+		t.Errorf("unexpected first night %+v", q.Nights[0])
+	}
+	if q.Nights[1].Season != "Festival" || !q.Nights[1].Weekend || q.Nights[1].Rate != 25000 {
+		t.Errorf("unexpected second night %+v", q.Nights[1])
+	}
+}
+
+func TestQuoteInvalidStay(t *testing.T) {
+	if _, err := testPlan.Quote(date("2026-06-02"), date("2026-06-02")); err != ErrInvalidStay {
+		t.Errorf("expected ErrInvalidStay, but %v", err)
+	}
+	if _, err := testPlan.Quote(date("2026-06-02"), date("2026-06-01")); err != ErrInvalidStay {
+		t.Errorf("expected ErrInvalidStay, but %v", err)
+	}
+}
+
+func TestMoney(t *testing.T) {
+	var theTests = []struct {
+		input     string
+		cents     int
+		valid     bool
+		formatted string
+	}{
+		{"129.50", 12950, true, "$129.50"},
+		{"$1,200", 120000, true, "$1200.00"},
+		{"0.5", 50, true, "$0.50"},
+		{"", 0, true, "$0.00"},
+		{"12.345", 0, false, ""},
+		{"-5", 0, false, ""},
+		{"ten", 0, false, ""},
+	}
+
+	for _, e := range theTests {
+		cents, err := ParseMoney(e.input)
+		if (err == nil) != e.valid {
+			t.Errorf("for %q, expected valid to be %t, but %v", e.input, e.valid, err)
+			continue
+		}
+		if !e.valid {
+			continue
+		}
+		if cents != e.cents {
+			t.Errorf("for %q, expected %d cents, but %d", e.input, e.cents, cents)
+		}
+		if got := FormatMoney(cents); got != e.formatted {
+			t.Errorf("for %d, expected %s, but %s", cents, e.formatted, got)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package pricing
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// reasons a promo code can not be used, the messages are shown to guests
+var (
+	ErrPromoNotValid    = errors.New("this promo code is not valid")
+	ErrPromoBookWindow  = errors.New("this promo code can not be used to book today")
+	ErrPromoStayWindow  = errors.New("this promo code is not valid for these dates")
+	ErrPromoMinNights   = errors.New("the stay is too short for this promo code")
+	ErrPromoRoom        = errors.New("this promo code is not valid for this room")
+	ErrPromoUsedUp      = errors.New("this promo code has been used up")
+	ErrPromoEmailUsedUp = errors.New("this promo code has already been used with this email")
+)
+
+// PromoUse is a booking a promo code is entered for, with how often the code has been used already
+type PromoUse struct {
+	RoomID   int
+	Start    time.Time
+	End      time.Time
+	BookedAt time.Time
+	// Redemptions counts every use of the code, EmailRedemptions the uses with the guest's email
+	Redemptions      int
+	EmailRedemptions int
+}
+
+// NormalizeCode is how promo codes are stored and looked up, guests may type them in any case
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CheckPromo returns why the promo code can not be used for the booking, nil when it can
+func CheckPromo(p models.PromoCode, use PromoUse) error {
+	if !p.Active {
+		return ErrPromoNotValid
+	}
+
+	booked := day(use.BookedAt)
+	if !p.BookFrom.IsZero() && booked.Before(day(p.BookFrom)) {
+		return ErrPromoBookWindow
+	}
+	if !p.BookUntil.IsZero() && booked.After(day(p.BookUntil)) {
+		return ErrPromoBookWindow
+	}
+
+	// the stay window holds the first and the last night of the stay
+	first, last := day(use.Start), day(use.End).AddDate(0, 0, -1)
+	if !p.StayFrom.IsZero() && first.Before(day(p.StayFrom)) {
+		return ErrPromoStayWindow
+	}
+	if !p.StayUntil.IsZero() && last.After(day(p.StayUntil)) {
+		return ErrPromoStayWindow
+	}
+
+	nights := int(day(use.End).Sub(day(use.Start)).Hours() / 24)
+	if nights < p.MinNights {
+		return ErrPromoMinNights
+	}
+
+	if len(p.RoomIDs) > 0 && !containsInt(p.RoomIDs, use.RoomID) {
+		return ErrPromoRoom
+	}
+
+	if p.MaxRedemptions > 0 && use.Redemptions >= p.MaxRedemptions {
+		return ErrPromoUsedUp
+	}
+	if p.MaxPerEmail > 0 && use.EmailRedemptions >= p.MaxPerEmail {
+		return ErrPromoEmailUsedUp
+	}
+	return nil
+}
+
+// ApplyPromo takes the promo code's discount off a quote after its stay discount, it never goes below zero
+func ApplyPromo(q models.Quote, p models.PromoCode) models.Quote {
+	price := q.Subtotal - q.Discount
+
+	discount := p.Amount
+	if p.Kind == models.PromoPercent {
+		discount = (price*p.Amount + 50) / 100
+	}
+	if discount > price {
+		discount = price
+	}
+
+	q.PromoCodeID = p.ID
+	q.PromoCode = p.Code
+	q.PromoDiscount = discount
+	q.Total = price - discount
+	return q
+}
+
+func containsInt(list []int, n int) bool {
+	for _, i := range list {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package pricing
+
+import (
+	"testing"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+func TestCheckPromo(t *testing.T) {
+	promo := models.PromoCode{
+		Active:         true,
+		BookFrom:       date("2026-05-01"),
+		BookUntil:      date("2026-06-30"),
+		StayFrom:       date("2026-07-01"),
+		StayUntil:      date("2026-08-31"),
+		MinNights:      2,
+		RoomIDs:        []int{1, 2},
+		MaxRedemptions: 100,
+		MaxPerEmail:    1,
+	}
+	use := PromoUse{RoomID: 1, Start: date("2026-07-10"), End: date("2026-07-12"), BookedAt: date("2026-06-01")}
+
+	inactive := promo
+	inactive.Active = false
+	open := models.PromoCode{Active: true}
+
+	var theTests = []struct {
+		name     string
+		promo    models.PromoCode
+		change   func(u *PromoUse)
+		expected error
+	}{
+		{"valid", promo, func(u *PromoUse) {}, nil},
+		{"no-rules", open, func(u *PromoUse) { u.RoomID = 9 }, nil},
+		{"inactive", inactive, func(u *PromoUse) {}, ErrPromoNotValid},
+		{"booked-early", promo, func(u *PromoUse) { u.BookedAt = date("2026-04-30") }, ErrPromoBookWindow},
+		{"booked-last-day", promo, func(u *PromoUse) { u.BookedAt = date("2026-06-30") }, nil},
+		{"booked-late", promo, func(u *PromoUse) { u.BookedAt = date("2026-07-01") }, ErrPromoBookWindow},
+		{"stay-early", promo, func(u *PromoUse) { u.Start = date("2026-06-30") }, ErrPromoStayWindow},
+		// the stay window holds nights, checking out the day after it ends is fine
+		{"stay-last-night", promo, func(u *PromoUse) { u.Start, u.End = date("2026-08-30"), date("2026-09-01") }, nil},
+		{"stay-late", promo, func(u *PromoUse) { u.Start, u.End = date("2026-08-31"), date("2026-09-02") }, ErrPromoStayWindow},
+		{"one-night", promo, func(u *PromoUse) { u.End = date("2026-07-11") }, ErrPromoMinNights},
+		{"other-room", promo, func(u *PromoUse) { u.RoomID = 3 }, ErrPromoRoom},
+		{"used-up", promo, func(u *PromoUse) { u.Redemptions = 100 }, ErrPromoUsedUp},
+		{"email-used-up", promo, func(u *PromoUse) { u.Redemptions, u.EmailRedemptions = 5, 1 }, ErrPromoEmailUsedUp},
+	}
+
+	for _, e := range theTests {
+		u := use
+		e.change(&u)
+		if err := CheckPromo(e.promo, u); err != e.expected {
+			t.Errorf("for %s, expected %v, but %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestApplyPromo(t *testing.T) {
+	// 2 weekday nights at 100.00 with 10% off for the stay leaves 180.00
+	q := models.Quote{Subtotal: 20000, DiscountPercent: 10, Discount: 2000, Total: 18000}
+
+	var theTests = []struct {
+		name     string
+		promo    models.PromoCode
+		discount int
+	}{
+		{"percent", models.PromoCode{ID: 1, Code: "TEN", Kind: models.PromoPercent, Amount: 15}, 2700},
+		{"fixed", models.PromoCode{ID: 2, Code: "FIFTY", Kind: models.PromoFixed, Amount: 5000}, 5000},
+		{"more-than-the-price", models.PromoCode{ID: 3, Code: "FREE", Kind: models.PromoFixed, Amount: 50000}, 18000},
+	}
+
+	for _, e := range theTests {
+		got := ApplyPromo(q, e.promo)
+		if got.PromoDiscount != e.discount || got.Total != 18000-e.discount {
+			t.Errorf("for %s, expected %d off, but %d off leaving %d", e.name, e.discount, got.PromoDiscount, got.Total)
+		}
+		if got.PromoCodeID != e.promo.ID || got.PromoCode != e.promo.Code {
+			t.Errorf("for %s, the promo code is not kept on the quote", e.name)
+		}
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	if got := NormalizeCode("  summer-26 "); got != "SUMMER-26" {
+		t.Errorf("expected SUMMER-26, but %q", got)
+	}
+}
@@ -10,8 +10,11 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/fangjjcs/bookings-app/pkg/audit"
 	"github.com/fangjjcs/bookings-app/pkg/config"
+	"github.com/fangjjcs/bookings-app/pkg/invoices"
 	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/pricing"
 	"github.com/justinas/nosurf"
 )
 
@@ -19,6 +22,12 @@ var functions = template.FuncMap{
 	"humanDate": HumanDate,
 	"formatDate": FormatDate,
 	"iterate": Iterate,
+	"money": pricing.FormatMoney,
+	"amount": pricing.FormatAmount,
+	"rate": invoices.FormatRate,
+	"statusClass": StatusClass,
+	"changes": audit.Decode,
+	"auditValue": audit.Format,
 }
 var app *config.AppConfig
 var pathToTemplates = "./templates"
@@ -33,6 +42,24 @@ func HumanDate(t time.Time) string{
 	return t.Format("2006-01-02")
 }
 
+// statusClasses are the bootstrap colours of the reservation statuses
+var statusClasses = map[string]string{
+	models.ReservationPending:    "warning",
+	models.ReservationConfirmed:  "primary",
+	models.ReservationCheckedIn:  "success",
+	models.ReservationCheckedOut: "secondary",
+	models.ReservationCancelled:  "danger",
+	models.ReservationNoShow:     "dark",
+}
+
+// StatusClass is the bootstrap colour a reservation status is shown in
+func StatusClass(status string) string{
+	if class, ok := statusClasses[status]; ok{
+		return class
+	}
+	return "light"
+}
+
 func FormatDate(t time.Time, f string) string{
 	return t.Format(f)
 }
@@ -54,6 +81,7 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	td.CSRFToken = nosurf.Token(r)
 	if app.Session.Exists(r.Context(),"user_id"){
 		td.IsAuthenticated = 1
+		td.AccessLevel = app.Session.GetInt(r.Context(),"access_level")
 	}
 	return td
 }
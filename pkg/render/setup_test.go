@@ -18,7 +18,7 @@ var testApp config.AppConfig
 func TestMain(m *testing.M){
 
 	// what am I going to put in the session
-	gob.Register(models.Reservation{})
+	gob.Register(models.Reservations{})
 
 	// change this to true when in production
 	testApp.InProduction = false
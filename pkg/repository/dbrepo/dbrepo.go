@@ -2,9 +2,20 @@ package dbrepo
 
 import (
 	"database/sql"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/fangjjcs/bookings-app/pkg/config"
+	"github.com/fangjjcs/bookings-app/pkg/models"
 	"github.com/fangjjcs/bookings-app/pkg/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Login of the administrator seeded into the in-memory repository
+const (
+	DemoEmail    = "admin@example.com"
+	DemoPassword = "password"
 )
 
 type postgresDBRepo struct {
@@ -12,9 +23,125 @@ type postgresDBRepo struct {
 	DB  *sql.DB
 }
 
+// memoryDBRepo keeps every table in maps guarded by a single lock
+type memoryDBRepo struct {
+	App *config.AppConfig
+
+	mu               sync.RWMutex
+	sequences        map[string]int
+	users            map[int]models.User
+	rooms            map[int]models.Room
+	restrictions     map[int]models.Restrictions
+	reservations     map[int]models.Reservations
+	roomRestrictions map[int]models.RoomRestrictions
+	apiKeys          map[int]models.APIKey
+	userTokens       map[int]models.UserToken
+	recoveryCodes    map[int]memoryRecoveryCode
+	settings         map[string]string
+	authEvents       map[int]models.AuthEvent
+	seasonalRates    map[int]models.SeasonalRate
+	stayDiscounts    map[int]models.StayDiscount
+	promoCodes       map[int]models.PromoCode
+	promoRedemptions map[int]models.PromoRedemption
+	payments         map[int]models.Payment
+	invoices         map[int]models.Invoice
+	// invoiceNumbers is the last invoice number of every year
+	invoiceNumbers map[int]int
+	taxRules       map[int]models.TaxRule
+	// cancellationRules are the cancellation policies of the rooms
+	cancellationRules map[int]models.CancellationRule
+	// statusChanges is the status history of the reservations
+	statusChanges map[int]models.StatusChange
+	// auditEntries is the audit log of the changes to the data
+	auditEntries map[int]models.AuditEntry
+	outbox       map[int]models.OutboxMessage
+	// notifications are those of every user of the admin tool
+	notifications map[int]models.Notification
+	// guestMails are the scheduled mails the guests got
+	guestMails map[int]models.GuestMail
+}
+
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
 	return &postgresDBRepo{
 		App: a,
 		DB:  conn,
 	}
 }
+
+// NewMemoryRepo creates a repository that lives in memory, for tests and demo mode
+func NewMemoryRepo(a *config.AppConfig) repository.DatabaseRepo {
+	m := &memoryDBRepo{
+		App:              a,
+		sequences:        make(map[string]int),
+		users:            make(map[int]models.User),
+		rooms:            make(map[int]models.Room),
+		restrictions:     make(map[int]models.Restrictions),
+		reservations:     make(map[int]models.Reservations),
+		roomRestrictions: make(map[int]models.RoomRestrictions),
+		apiKeys:          make(map[int]models.APIKey),
+		userTokens:       make(map[int]models.UserToken),
+		recoveryCodes:    make(map[int]memoryRecoveryCode),
+		settings:         make(map[string]string),
+		authEvents:       make(map[int]models.AuthEvent),
+		seasonalRates:    make(map[int]models.SeasonalRate),
+		stayDiscounts:    make(map[int]models.StayDiscount),
+		promoCodes:       make(map[int]models.PromoCode),
+		promoRedemptions: make(map[int]models.PromoRedemption),
+		payments:         make(map[int]models.Payment),
+		invoices:         make(map[int]models.Invoice),
+		invoiceNumbers:   make(map[int]int),
+		taxRules:         make(map[int]models.TaxRule),
+		// rooms start without a cancellation policy, cancelling is free
+		cancellationRules: make(map[int]models.CancellationRule),
+		statusChanges:     make(map[int]models.StatusChange),
+		auditEntries:      make(map[int]models.AuditEntry),
+		outbox:            make(map[int]models.OutboxMessage),
+		notifications:     make(map[int]models.Notification),
+		guestMails:        make(map[int]models.GuestMail),
+	}
+	m.seed()
+	return m
+}
+
+// invoiceNumber is the number printed on an invoice, the year and its sequence within the year
+func invoiceNumber(year, sequence int) string {
+	return fmt.Sprintf("%d-%06d", year, sequence)
+}
+
+// dummyPasswordHash is a bcrypt hash of the default cost that no password matches, it is compared against when
+// there is no password to check so a login takes as long whether the account exists or not
+const dummyPasswordHash = "$2a$10$88PnRf/zzgWkd8RNMEiKs.aCGCuVsA1Esj7U9LA/s9a4f3WmxoEzi"
+
+// checkPassword compares a password with a user's hash, a user without one, unknown or invited, is compared
+// against dummyPasswordHash and fails all the same
+func checkPassword(hash, password string) error {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+// nullTime stores a zero time as NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// timeOrZero reads a nullable timestamp, NULL becomes the zero time
+func timeOrZero(t sql.NullTime) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return t.Time
+}
+
+// nullID stores a zero id as NULL
+func nullID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
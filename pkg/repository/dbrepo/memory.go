@@ -0,0 +1,730 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// nextID returns the next primary key for a table, like a serial column would
+func (m *memoryDBRepo) nextID(table string) int {
+	m.sequences[table]++
+	return m.sequences[table]
+}
+
+// seed loads the same rows the seed migrations put into a fresh database,
+// plus a demo administrator so the admin tool can be used without a database
+func (m *memoryDBRepo) seed() {
+	created := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	rooms := []models.Room{
+		{RoomName: "generals quater", Slug: "generals-quarters", Image: "/static/images/bay.png", SortOrder: 1, BaseRate: 8900},
+		{RoomName: "majors suite", Slug: "majors-suite", Image: "/static/images/bird.png", SortOrder: 2, BaseRate: 12900, WeekendRate: 14900},
+	}
+	for _, room := range rooms {
+		room.ID = m.nextID("rooms")
+		room.Description = "Welcome to Fort Smith Bed and Breakfast."
+		room.Capacity = 2
+		room.Active = true
+		room.CreatedAt = created
+		room.UpdatedAt = created
+		m.rooms[room.ID] = room
+	}
+
+	for _, name := range []string{"reservation", "owner block"} {
+		id := m.nextID("restrictions")
+		m.restrictions[id] = models.Restrictions{ID: id, RestrictionName: name, CreatedAt: created, UpdatedAt: created}
+	}
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(DemoPassword), bcrypt.DefaultCost)
+	id := m.nextID("users")
+	m.users[id] = models.User{
+		ID:          id,
+		FirstName:   "Demo",
+		LastName:    "Admin",
+		Email:       DemoEmail,
+		Password:    string(hashedPassword),
+		AccessLevel: models.AccessOwner,
+		NotifyEmail: models.NotifyEmailOff,
+		CreatedAt:   created,
+		UpdatedAt:   created,
+	}
+}
+
+// withRoom fills in the joined room of a reservation
+func (m *memoryDBRepo) withRoom(res models.Reservations) models.Reservations {
+	room := m.rooms[res.RoomID]
+	res.Room = models.Room{ID: room.ID, RoomName: room.RoomName}
+	return res
+}
+
+// sortRooms orders rooms the way the admin arranged them
+func sortRooms(rooms []models.Room) {
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].SortOrder == rooms[j].SortOrder {
+			return rooms[i].RoomName < rooms[j].RoomName
+		}
+		return rooms[i].SortOrder < rooms[j].SortOrder
+	})
+}
+
+// sortReservations orders reservations by start date, then id
+func sortReservations(reservations []models.Reservations) {
+	sort.Slice(reservations, func(i, j int) bool {
+		if reservations[i].StartDate.Equal(reservations[j].StartDate) {
+			return reservations[i].ID < reservations[j].ID
+		}
+		return reservations[i].StartDate.Before(reservations[j].StartDate)
+	})
+}
+
+// InsertReservations inserts reservation
+func (m *memoryDBRepo) InsertReservations(res models.Reservations) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return 0, errors.New("room does not exist")
+	}
+
+	res.ID = m.nextID("reservations")
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	res.Room = models.Room{}
+	if res.Status == "" {
+		res.Status = models.ReservationPending
+	}
+	m.reservations[res.ID] = res
+
+	return res.ID, nil
+}
+
+// InsertRoomRestriction inserts a room restriction
+func (m *memoryDBRepo) InsertRoomRestriction(res models.RoomRestrictions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return errors.New("room does not exist")
+	}
+	if _, ok := m.restrictions[res.RestrictionID]; !ok {
+		return errors.New("restriction does not exist")
+	}
+	if res.ReservationID > 0 {
+		if _, ok := m.reservations[res.ReservationID]; !ok {
+			return errors.New("reservation does not exist")
+		}
+	}
+
+	res.ID = m.nextID("room_restrictions")
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	m.roomRestrictions[res.ID] = res
+
+	return nil
+}
+
+// BookReservation checks availability and inserts the reservation with its room restriction
+// while holding the lock, so two guests can never book the same room for the same night. A deactivated room is
+// never available. A confirmation mail is queued along with it and gets its id
+func (m *memoryDBRepo) BookReservation(res models.Reservations, confirmation *models.OutboxMessage) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.rooms[res.RoomID]
+	if !ok {
+		return 0, errors.New("room does not exist")
+	}
+	if !room.Active {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == res.RoomID && res.StartDate.Before(rr.EndDate) && res.EndDate.After(rr.StartDate) {
+			return 0, repository.ErrRoomNotAvailable
+		}
+	}
+
+	res.ID = m.nextID("reservations")
+	if res.Quote.PromoCodeID > 0 {
+		if err := m.redeemPromoCode(res); err != nil {
+			return 0, err
+		}
+	}
+
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	res.Room = models.Room{}
+	if res.Status == "" {
+		res.Status = models.ReservationPending
+	}
+	m.reservations[res.ID] = res
+
+	rrID := m.nextID("room_restrictions")
+	m.roomRestrictions[rrID] = models.RoomRestrictions{
+		ID:            rrID,
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: res.ID,
+		RestrictionID: 1, // 1 for reservation
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	if confirmation != nil {
+		confirmation.ID = m.queueMail(*confirmation)
+	}
+
+	return res.ID, nil
+}
+
+// SearchAvailabilityByDatesAndRoomID search availability by room id
+func (m *memoryDBRepo) SearchAvailabilityByDatesAndRoomID(start, end time.Time, roomID int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID && start.Before(rr.EndDate) && end.After(rr.StartDate) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SearchAvailibilityForAllRooms handles room availability by dates
+func (m *memoryDBRepo) SearchAvailibilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	booked := make(map[int]bool)
+	for _, rr := range m.roomRestrictions {
+		if rr.StartDate.Before(end) && rr.EndDate.After(start) {
+			booked[rr.RoomID] = true
+		}
+	}
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		if room.Active && !booked[room.ID] {
+			rooms = append(rooms, room)
+		}
+	}
+	sortRooms(rooms)
+
+	return rooms, nil
+}
+
+// AllReservations returns a slice of all reservations
+func (m *memoryDBRepo) AllReservations() ([]models.Reservations, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reservations []models.Reservations
+	for _, res := range m.reservations {
+		if res.DeletedAt.IsZero() {
+			reservations = append(reservations, m.withRoom(res))
+		}
+	}
+	sortReservations(reservations)
+
+	return reservations, nil
+}
+
+// ReservationsWithStatus returns the reservations in a status
+func (m *memoryDBRepo) ReservationsWithStatus(status string) ([]models.Reservations, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reservations []models.Reservations
+	for _, res := range m.reservations {
+		if res.Status == status && res.DeletedAt.IsZero() {
+			reservations = append(reservations, m.withRoom(res))
+		}
+	}
+	sortReservations(reservations)
+
+	return reservations, nil
+}
+
+// GetReservationByID return one reservation detail by ID
+func (m *memoryDBRepo) GetReservationByID(id int) (models.Reservations, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res, ok := m.reservations[id]
+	if !ok || !res.DeletedAt.IsZero() {
+		return models.Reservations{}, sql.ErrNoRows
+	}
+	return m.withRoom(res), nil
+}
+
+// GetReservationByAccessToken returns the reservation a guest token belongs to
+func (m *memoryDBRepo) GetReservationByAccessToken(token string) (models.Reservations, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, res := range m.reservations {
+		if token != "" && res.AccessToken == token && res.DeletedAt.IsZero() {
+			return m.withRoom(res), nil
+		}
+	}
+	return models.Reservations{}, sql.ErrNoRows
+}
+
+// UpdateReservation updates a reservation
+func (m *memoryDBRepo) UpdateReservation(u models.Reservations, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return nil
+	}
+	res.FirstName = u.FirstName
+	res.LastName = u.LastName
+	res.Email = u.Email
+	res.Phone = u.Phone
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = res
+
+	return nil
+}
+
+// ChangeReservationDates moves a reservation and its room restriction to new dates with the new price while
+// holding the lock, the room has to be free on the new dates apart from the reservation's own nights
+func (m *memoryDBRepo) ChangeReservationDates(u models.Reservations) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.reservations[u.ID]
+	if !ok || !res.DeletedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == res.RoomID && rr.ReservationID != res.ID &&
+			u.StartDate.Before(rr.EndDate) && u.EndDate.After(rr.StartDate) {
+			return repository.ErrRoomNotAvailable
+		}
+	}
+
+	res.StartDate = u.StartDate
+	res.EndDate = u.EndDate
+	res.Quote = u.Quote
+	res.UpdatedAt = time.Now()
+	m.reservations[res.ID] = res
+
+	for rrID, rr := range m.roomRestrictions {
+		if rr.ReservationID == res.ID {
+			rr.StartDate = res.StartDate
+			rr.EndDate = res.EndDate
+			rr.UpdatedAt = time.Now()
+			m.roomRestrictions[rrID] = rr
+		}
+	}
+
+	return nil
+}
+
+// ChangeReservationStatus moves a reservation on to a status when its lifecycle allows it, keeping who did it in
+// its status history
+func (m *memoryDBRepo) ChangeReservationStatus(id int, status string, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.changeStatus(id, status, userID)
+	return err
+}
+
+// CancelReservation marks a reservation cancelled with the fee it cost and frees its room
+func (m *memoryDBRepo) CancelReservation(id, fee, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, err := m.changeStatus(id, models.ReservationCancelled, userID)
+	if err != nil {
+		return err
+	}
+	res.CancellationFee = fee
+	m.reservations[id] = res
+	return nil
+}
+
+// changeStatus moves a reservation on to a status and adds it to the history, a cancelled reservation frees its
+// room. The caller holds the lock
+func (m *memoryDBRepo) changeStatus(id int, status string, userID int) (models.Reservations, error) {
+	res, ok := m.reservations[id]
+	if !ok || !res.DeletedAt.IsZero() {
+		return res, sql.ErrNoRows
+	}
+	if !res.CanBecome(status) {
+		return res, repository.ErrInvalidTransition
+	}
+
+	now := time.Now()
+	changeID := m.nextID("reservation_status_changes")
+	m.statusChanges[changeID] = models.StatusChange{
+		ID:            changeID,
+		ReservationID: id,
+		FromStatus:    res.Status,
+		ToStatus:      status,
+		UserID:        userID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	res.Status = status
+	res.UpdatedAt = now
+	if status == models.ReservationCancelled {
+		res.CancelledAt = now
+		for rrID, rr := range m.roomRestrictions {
+			if rr.ReservationID == id {
+				delete(m.roomRestrictions, rrID)
+			}
+		}
+	}
+	m.reservations[id] = res
+	return res, nil
+}
+
+// StatusChangesForReservation returns the status history of a reservation, oldest first
+func (m *memoryDBRepo) StatusChangesForReservation(reservationID int) ([]models.StatusChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var changes []models.StatusChange
+	for _, c := range m.statusChanges {
+		if c.ReservationID == reservationID {
+			if u, ok := m.users[c.UserID]; ok {
+				c.UserName = u.FirstName + " " + u.LastName
+			}
+			changes = append(changes, c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].ID < changes[j].ID })
+	return changes, nil
+}
+
+// DeleteReservation moves a reservation to the trash and frees its room
+func (m *memoryDBRepo) DeleteReservation(id, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.reservations[id]
+	if !ok || !res.DeletedAt.IsZero() {
+		return nil
+	}
+	res.DeletedAt = time.Now()
+	res.DeletedBy = userID
+	m.reservations[id] = res
+
+	for rrID, rr := range m.roomRestrictions {
+		if rr.ReservationID == id {
+			delete(m.roomRestrictions, rrID)
+		}
+	}
+	return nil
+}
+
+// DeletedReservations returns the reservations in the trash, the last deleted first
+func (m *memoryDBRepo) DeletedReservations() ([]models.Reservations, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reservations []models.Reservations
+	for _, res := range m.reservations {
+		if !res.DeletedAt.IsZero() {
+			reservations = append(reservations, m.withRoom(res))
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].DeletedAt.After(reservations[j].DeletedAt) })
+
+	return reservations, nil
+}
+
+// RestoreReservation takes a reservation out of the trash, a reservation that holds its room gets it back when
+// it is still free on its dates
+func (m *memoryDBRepo) RestoreReservation(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.reservations[id]
+	if !ok || res.DeletedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+
+	if !res.Cancelled() {
+		for _, rr := range m.roomRestrictions {
+			if rr.RoomID == res.RoomID && res.StartDate.Before(rr.EndDate) && res.EndDate.After(rr.StartDate) {
+				return repository.ErrRoomNotAvailable
+			}
+		}
+
+		rrID := m.nextID("room_restrictions")
+		m.roomRestrictions[rrID] = models.RoomRestrictions{
+			ID:            rrID,
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			RoomID:        res.RoomID,
+			ReservationID: res.ID,
+			RestrictionID: 1, // 1 for reservation
+			CreatedAt:     time.Now(),
+			UpdatedAt:     time.Now(),
+		}
+	}
+
+	res.DeletedAt = time.Time{}
+	res.DeletedBy = 0
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = res
+	return nil
+}
+
+// PurgeDeletedReservations deletes the reservations that went to the trash before a time for good. Reservations
+// with payments or an invoice are kept, money records are never purged. It returns the ids of the purged ones
+func (m *memoryDBRepo) PurgeDeletedReservations(before time.Time) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged []int
+	for id, res := range m.reservations {
+		if !res.DeletedAt.IsZero() && res.DeletedAt.Before(before) && !m.hasMoneyRecords(id) {
+			m.purgeReservation(id)
+			purged = append(purged, id)
+		}
+	}
+	sort.Ints(purged)
+	return purged, nil
+}
+
+// hasMoneyRecords reports whether a reservation has payments or an invoice. The caller holds the lock
+func (m *memoryDBRepo) hasMoneyRecords(id int) bool {
+	for _, p := range m.payments {
+		if p.ReservationID == id {
+			return true
+		}
+	}
+	for _, inv := range m.invoices {
+		if inv.ReservationID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// purgeReservation deletes a reservation with what the foreign keys cascade to. The caller holds the lock
+func (m *memoryDBRepo) purgeReservation(id int) {
+	delete(m.reservations, id)
+	for rrID, rr := range m.roomRestrictions {
+		if rr.ReservationID == id {
+			delete(m.roomRestrictions, rrID)
+		}
+	}
+	// like the foreign keys, the promo code use and the payments ledger go with the reservation
+	for prID, pr := range m.promoRedemptions {
+		if pr.ReservationID == id {
+			delete(m.promoRedemptions, prID)
+		}
+	}
+	for pID, p := range m.payments {
+		if p.ReservationID == id {
+			delete(m.payments, pID)
+		}
+	}
+	for cID, c := range m.statusChanges {
+		if c.ReservationID == id {
+			delete(m.statusChanges, cID)
+		}
+	}
+	for gID, g := range m.guestMails {
+		if g.ReservationID == id {
+			delete(m.guestMails, gID)
+		}
+	}
+	// invoices are kept, numbered invoices can not go missing
+	for invID, inv := range m.invoices {
+		if inv.ReservationID == id {
+			inv.ReservationID = 0
+			m.invoices[invID] = inv
+		}
+	}
+}
+
+// AllRooms gets all rooms, including deactivated ones
+func (m *memoryDBRepo) AllRooms() ([]models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	sortRooms(rooms)
+
+	return rooms, nil
+}
+
+// AllActiveRooms gets the rooms shown on the public site
+func (m *memoryDBRepo) AllActiveRooms() ([]models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		if room.Active {
+			rooms = append(rooms, room)
+		}
+	}
+	sortRooms(rooms)
+
+	return rooms, nil
+}
+
+// GetRoomByID gets one room by ID
+func (m *memoryDBRepo) GetRoomByID(id int) (models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	room, ok := m.rooms[id]
+	if !ok {
+		return models.Room{}, sql.ErrNoRows
+	}
+	return room, nil
+}
+
+// GetRoomBySlug gets one room by the slug used in its public url
+func (m *memoryDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, room := range m.rooms {
+		if room.Slug == slug {
+			return room, nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+// slugTaken reports whether another room already uses the slug, like the unique index
+func (m *memoryDBRepo) slugTaken(slug string, id int) bool {
+	for _, room := range m.rooms {
+		if room.Slug == slug && room.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertRoom inserts a room
+func (m *memoryDBRepo) InsertRoom(room models.Room) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.slugTaken(room.Slug, 0) {
+		return 0, errors.New("duplicate room slug")
+	}
+
+	room.ID = m.nextID("rooms")
+	room.CreatedAt = time.Now()
+	room.UpdatedAt = time.Now()
+	m.rooms[room.ID] = room
+
+	return room.ID, nil
+}
+
+// UpdateRoom updates a room
+func (m *memoryDBRepo) UpdateRoom(room models.Room) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.rooms[room.ID]
+	if !ok {
+		return nil
+	}
+	if m.slugTaken(room.Slug, room.ID) {
+		return errors.New("duplicate room slug")
+	}
+
+	room.CreatedAt = existing.CreatedAt
+	room.UpdatedAt = time.Now()
+	m.rooms[room.ID] = room
+
+	return nil
+}
+
+// UpdateActiveForRoom activates or deactivates a room
+func (m *memoryDBRepo) UpdateActiveForRoom(id int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	room, ok := m.rooms[id]
+	if !ok {
+		return nil
+	}
+	room.Active = active
+	room.UpdatedAt = time.Now()
+	m.rooms[id] = room
+
+	return nil
+}
+
+// GetRestrictionsForRoomByDate get restrictions for rooms
+func (m *memoryDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestrictions, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var restrictions []models.RoomRestrictions
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID && start.Before(rr.EndDate) && !end.Before(rr.StartDate) {
+			restrictions = append(restrictions, models.RoomRestrictions{
+				ID:            rr.ID,
+				ReservationID: rr.ReservationID,
+				RestrictionID: rr.RestrictionID,
+				RoomID:        rr.RoomID,
+				StartDate:     rr.StartDate,
+				EndDate:       rr.EndDate,
+			})
+		}
+	}
+	sort.Slice(restrictions, func(i, j int) bool { return restrictions[i].ID < restrictions[j].ID })
+
+	return restrictions, nil
+}
+
+// InsertBlockForRoom inserts blocks for a room
+func (m *memoryDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[id]; !ok {
+		return errors.New("room does not exist")
+	}
+
+	rrID := m.nextID("room_restrictions")
+	m.roomRestrictions[rrID] = models.RoomRestrictions{
+		ID:            rrID,
+		StartDate:     startDate,
+		EndDate:       startDate.AddDate(0, 0, 1),
+		RoomID:        id,
+		RestrictionID: 2,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	return nil
+}
+
+// DeleteBlockByID handles deleting blocks on calendar
+func (m *memoryDBRepo) DeleteBlockByID(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.roomRestrictions, id)
+	return nil
+}
@@ -0,0 +1,82 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// InsertAPIKey inserts an api key
+func (m *memoryDBRepo) InsertAPIKey(k models.APIKey) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, other := range m.apiKeys {
+		if other.Prefix == k.Prefix {
+			return 0, errors.New("duplicate api key prefix")
+		}
+	}
+
+	k.ID = m.nextID("api_keys")
+	k.Scopes = append([]string(nil), k.Scopes...)
+	k.CreatedAt = time.Now()
+	k.UpdatedAt = time.Now()
+	m.apiKeys[k.ID] = k
+
+	return k.ID, nil
+}
+
+// AllAPIKeys returns every api key, the newest first
+func (m *memoryDBRepo) AllAPIKeys() ([]models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []models.APIKey
+	for _, k := range m.apiKeys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+
+	return keys, nil
+}
+
+// GetAPIKeyByPrefix returns the api key with the public prefix
+func (m *memoryDBRepo) GetAPIKeyByPrefix(prefix string) (models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.apiKeys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+	return models.APIKey{}, sql.ErrNoRows
+}
+
+// UpdateLastUsedForAPIKey records when an api key was last used
+func (m *memoryDBRepo) UpdateLastUsedForAPIKey(id int, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if k, ok := m.apiKeys[id]; ok {
+		k.LastUsedAt = t
+		m.apiKeys[id] = k
+	}
+	return nil
+}
+
+// RevokeAPIKey revokes an api key, it can not be used afterwards
+func (m *memoryDBRepo) RevokeAPIKey(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if k, ok := m.apiKeys[id]; ok && k.RevokedAt.IsZero() {
+		k.RevokedAt = time.Now()
+		k.UpdatedAt = time.Now()
+		m.apiKeys[id] = k
+	}
+	return nil
+}
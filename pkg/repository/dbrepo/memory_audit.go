@@ -0,0 +1,55 @@
+package dbrepo
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// InsertAuditEntry adds a change to the audit log
+func (m *memoryDBRepo) InsertAuditEntry(e models.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = m.nextID("audit_entries")
+	e.CreatedAt = time.Now()
+	e.UpdatedAt = e.CreatedAt
+	m.auditEntries[e.ID] = e
+	return nil
+}
+
+// SearchAuditEntries returns the entries of the audit log matching a filter, the newest first
+func (m *memoryDBRepo) SearchAuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	query := strings.ToLower(f.Query)
+	var entries []models.AuditEntry
+	for _, e := range m.auditEntries {
+		if u, ok := m.users[e.UserID]; ok {
+			e.UserName = u.FirstName + " " + u.LastName
+		}
+		switch {
+		case f.Entity != "" && e.Entity != f.Entity,
+			f.EntityID != 0 && e.EntityID != f.EntityID,
+			f.Action != "" && e.Action != f.Action,
+			f.UserID != 0 && e.UserID != f.UserID,
+			!f.From.IsZero() && e.CreatedAt.Before(f.From),
+			!f.Until.IsZero() && !e.CreatedAt.Before(f.Until):
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(e.Actor+" "+e.UserName+" "+e.Changes), query) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID > entries[j].ID
+	})
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[:f.Limit]
+	}
+	return entries, nil
+}
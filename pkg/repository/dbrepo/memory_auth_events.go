@@ -0,0 +1,70 @@
+package dbrepo
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// InsertAuthEvent adds a login attempt to the auth audit log
+func (m *memoryDBRepo) InsertAuthEvent(e models.AuthEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = m.nextID("auth_events")
+	e.CreatedAt = time.Now()
+	e.UpdatedAt = e.CreatedAt
+	m.authEvents[e.ID] = e
+	return nil
+}
+
+// RecentAuthEvents returns the latest login attempts, the newest first
+func (m *memoryDBRepo) RecentAuthEvents(limit int) ([]models.AuthEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []models.AuthEvent
+	for _, e := range m.authEvents {
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID > events[j].ID
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// CountFailedLoginsForIP counts the failed login attempts from an ip address since the given time, the attempts
+// turned away because the address was blocked do not count, so a block ends while the client keeps trying
+func (m *memoryDBRepo) CountFailedLoginsForIP(ip string, since time.Time) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := 0
+	for _, e := range m.authEvents {
+		if e.IPAddress == ip && !e.Success && e.Event != models.AuthIPBlocked && e.CreatedAt.After(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// CountFailedLoginsForEmail counts the logins with an email that failed on the credentials or a lock since the
+// given time, the same for an email with an account and one without
+func (m *memoryDBRepo) CountFailedLoginsForEmail(email string, since time.Time) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := 0
+	for _, e := range m.authEvents {
+		if strings.EqualFold(e.Email, email) && e.CreatedAt.After(since) && (e.Event == models.AuthBadPassword ||
+			e.Event == models.AuthUnknownEmail || e.Event == models.AuthAccountLocked) {
+			n++
+		}
+	}
+	return n, nil
+}
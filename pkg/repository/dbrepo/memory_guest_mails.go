@@ -0,0 +1,89 @@
+package dbrepo
+
+import (
+	"sort"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// reservationsWithoutGuestMail returns the reservations whose date picked by date lies between from and to and
+// that have no guest mail of a kind yet, deleted, cancelled and no-show reservations are left out. The caller
+// holds the lock
+func (m *memoryDBRepo) reservationsWithoutGuestMail(kind string, from, to time.Time, date func(models.Reservations) time.Time) []models.Reservations {
+	mailed := make(map[int]bool)
+	for _, g := range m.guestMails {
+		if g.Kind == kind {
+			mailed[g.ReservationID] = true
+		}
+	}
+
+	var reservations []models.Reservations
+	for _, res := range m.reservations {
+		d := date(res)
+		if mailed[res.ID] || !res.DeletedAt.IsZero() || d.Before(from) || d.After(to) ||
+			res.Status == models.ReservationCancelled || res.Status == models.ReservationNoShow {
+			continue
+		}
+		reservations = append(reservations, m.withRoom(res))
+	}
+	sortReservations(reservations)
+	return reservations
+}
+
+// ArrivalsWithoutGuestMail returns the reservations arriving between from and to that have no guest mail of a
+// kind yet, deleted, cancelled and no-show reservations are left out
+func (m *memoryDBRepo) ArrivalsWithoutGuestMail(kind string, from, to time.Time) ([]models.Reservations, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.reservationsWithoutGuestMail(kind, from, to, func(res models.Reservations) time.Time { return res.StartDate }), nil
+}
+
+// DeparturesWithoutGuestMail returns the reservations departing between from and to that have no guest mail of a
+// kind yet, deleted, cancelled and no-show reservations are left out
+func (m *memoryDBRepo) DeparturesWithoutGuestMail(kind string, from, to time.Time) ([]models.Reservations, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.reservationsWithoutGuestMail(kind, from, to, func(res models.Reservations) time.Time { return res.EndDate }), nil
+}
+
+// QueueGuestMail records a guest mail of a kind for a reservation and queues it in the outbox, both or neither.
+// It returns false without queueing when the reservation got a mail of the kind already
+func (m *memoryDBRepo) QueueGuestMail(reservationID int, kind string, msg models.OutboxMessage) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, g := range m.guestMails {
+		if g.ReservationID == reservationID && g.Kind == kind {
+			return false, nil
+		}
+	}
+
+	g := models.GuestMail{
+		ID:              m.nextID("guest_mails"),
+		ReservationID:   reservationID,
+		Kind:            kind,
+		OutboxMessageID: m.queueMail(msg),
+		CreatedAt:       time.Now(),
+	}
+	g.UpdatedAt = g.CreatedAt
+	m.guestMails[g.ID] = g
+	return true, nil
+}
+
+// GuestMailsForReservation returns the guest mails of a reservation, the oldest first
+func (m *memoryDBRepo) GuestMailsForReservation(reservationID int) ([]models.GuestMail, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var mails []models.GuestMail
+	for _, g := range m.guestMails {
+		if g.ReservationID == reservationID {
+			mails = append(mails, g)
+		}
+	}
+	sort.Slice(mails, func(i, j int) bool { return mails[i].ID < mails[j].ID })
+	return mails, nil
+}
@@ -0,0 +1,48 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// IssueInvoice stores an invoice under the next number of the year it is issued in, a reservation that has
+// an invoice already keeps it and gets it back
+func (m *memoryDBRepo) IssueInvoice(inv models.Invoice) (models.Invoice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.reservations[inv.ReservationID]; !ok {
+		return inv, errors.New("reservation does not exist")
+	}
+	for _, existing := range m.invoices {
+		if existing.ReservationID == inv.ReservationID {
+			return existing, nil
+		}
+	}
+
+	inv.Year = inv.IssuedAt.Year()
+	m.invoiceNumbers[inv.Year]++
+	inv.Sequence = m.invoiceNumbers[inv.Year]
+	inv.Number = invoiceNumber(inv.Year, inv.Sequence)
+	inv.ID = m.nextID("invoices")
+	inv.CreatedAt = time.Now()
+	inv.UpdatedAt = time.Now()
+	m.invoices[inv.ID] = inv
+	return inv, nil
+}
+
+// InvoiceForReservation returns the invoice of a reservation, sql.ErrNoRows when it has none
+func (m *memoryDBRepo) InvoiceForReservation(reservationID int) (models.Invoice, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, inv := range m.invoices {
+		if inv.ReservationID == reservationID && reservationID != 0 {
+			return inv, nil
+		}
+	}
+	return models.Invoice{}, sql.ErrNoRows
+}
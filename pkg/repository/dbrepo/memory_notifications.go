@@ -0,0 +1,125 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// InsertNotification adds a notification for a user
+func (m *memoryDBRepo) InsertNotification(n models.Notification) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n.ID = m.nextID("notifications")
+	n.CreatedAt = time.Now()
+	n.UpdatedAt = n.CreatedAt
+	m.notifications[n.ID] = n
+	return n.ID, nil
+}
+
+// NotificationsForUser returns the latest notifications of a user, the newest first
+func (m *memoryDBRepo) NotificationsForUser(userID, limit int) ([]models.Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var notifications []models.Notification
+	for _, n := range m.notifications {
+		if n.UserID == userID {
+			notifications = append(notifications, n)
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].ID > notifications[j].ID })
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+	return notifications, nil
+}
+
+// CountUnreadNotifications returns how many notifications a user has not read
+func (m *memoryDBRepo) CountUnreadNotifications(userID int) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, n := range m.notifications {
+		if n.UserID == userID && n.Unread() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetNotificationByID returns a notification
+func (m *memoryDBRepo) GetNotificationByID(id int) (models.Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, ok := m.notifications[id]
+	if !ok {
+		return n, sql.ErrNoRows
+	}
+	return n, nil
+}
+
+// MarkNotificationRead records that a notification was read, a notification that was read already keeps its time
+func (m *memoryDBRepo) MarkNotificationRead(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n, ok := m.notifications[id]; ok && n.Unread() {
+		n.ReadAt = time.Now()
+		n.UpdatedAt = n.ReadAt
+		m.notifications[id] = n
+	}
+	return nil
+}
+
+// MarkAllNotificationsRead records that a user read all their notifications
+func (m *memoryDBRepo) MarkAllNotificationsRead(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, n := range m.notifications {
+		if n.UserID == userID && n.Unread() {
+			n.ReadAt = now
+			n.UpdatedAt = now
+			m.notifications[id] = n
+		}
+	}
+	return nil
+}
+
+// UnmailedNotifications returns the notifications of a user that are neither read nor mailed yet, the oldest first
+func (m *memoryDBRepo) UnmailedNotifications(userID int) ([]models.Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var notifications []models.Notification
+	for _, n := range m.notifications {
+		if n.UserID == userID && n.Unread() && n.MailedAt.IsZero() {
+			notifications = append(notifications, n)
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].ID < notifications[j].ID })
+	return notifications, nil
+}
+
+// MarkNotificationsMailed records that notifications went out by mail
+func (m *memoryDBRepo) MarkNotificationsMailed(ids []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, id := range ids {
+		if n, ok := m.notifications[id]; ok {
+			n.MailedAt = now
+			n.UpdatedAt = now
+			m.notifications[id] = n
+		}
+	}
+	return nil
+}
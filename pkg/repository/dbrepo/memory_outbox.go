@@ -0,0 +1,169 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// queueMail adds a mail to the outbox, the caller holds the lock
+func (m *memoryDBRepo) queueMail(msg models.OutboxMessage) int {
+	msg.ID = m.nextID("outbox_messages")
+	msg.Status = models.OutboxPending
+	msg.Attempts = 0
+	msg.CreatedAt = time.Now()
+	msg.UpdatedAt = msg.CreatedAt
+	if msg.NextAttemptAt.IsZero() {
+		msg.NextAttemptAt = msg.CreatedAt
+	}
+	m.outbox[msg.ID] = msg
+	return msg.ID
+}
+
+// QueueMail adds a mail to the outbox, it is due right away unless NextAttemptAt holds it back
+func (m *memoryDBRepo) QueueMail(msg models.OutboxMessage) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.queueMail(msg), nil
+}
+
+// ReleaseMail adds attachments to a mail that is held back and makes it due right away
+func (m *memoryDBRepo) ReleaseMail(id int, attachments []models.MailAttachment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.outbox[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if msg.Status != models.OutboxPending || msg.Attempts > 0 {
+		// it went out without them already
+		return nil
+	}
+	msg.Attachments = append(msg.Attachments, attachments...)
+	msg.NextAttemptAt = time.Now()
+	msg.UpdatedAt = time.Now()
+	m.outbox[id] = msg
+	return nil
+}
+
+// ClaimDueMail takes the pending mails that are due for delivery, the oldest first. Each counts as an attempt
+// and is not due again until the lease is over, so a mail whose sender stops is retried later
+func (m *memoryDBRepo) ClaimDueMail(limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var due []models.OutboxMessage
+	for _, msg := range m.outbox {
+		if msg.Status == models.OutboxPending && !msg.NextAttemptAt.After(now) {
+			due = append(due, msg)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].ID < due[j].ID
+		}
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for i := range due {
+		due[i].Attempts++
+		due[i].NextAttemptAt = now.Add(lease)
+		due[i].UpdatedAt = now
+		m.outbox[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+// MarkMailSent records that a mail went out
+func (m *memoryDBRepo) MarkMailSent(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.outbox[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	msg.Status = models.OutboxSent
+	msg.SentAt = time.Now()
+	msg.LastError = ""
+	msg.UpdatedAt = time.Now()
+	m.outbox[id] = msg
+	return nil
+}
+
+// MarkMailFailed records a failed delivery, the mail is tried again at retryAt or is dead when retryAt is zero
+func (m *memoryDBRepo) MarkMailFailed(id int, lastError string, retryAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.outbox[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	msg.LastError = lastError
+	if retryAt.IsZero() {
+		msg.Status = models.OutboxDead
+	} else {
+		msg.NextAttemptAt = retryAt
+	}
+	msg.UpdatedAt = time.Now()
+	m.outbox[id] = msg
+	return nil
+}
+
+// OutboxMessages returns the mails of the outbox with a status, or all of them for an empty status, the newest
+// first
+func (m *memoryDBRepo) OutboxMessages(status string, limit int) ([]models.OutboxMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var messages []models.OutboxMessage
+	for _, msg := range m.outbox {
+		if status == "" || msg.Status == status {
+			messages = append(messages, msg)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID > messages[j].ID })
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// GetOutboxMessage returns a mail of the outbox
+func (m *memoryDBRepo) GetOutboxMessage(id int) (models.OutboxMessage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	msg, ok := m.outbox[id]
+	if !ok {
+		return msg, sql.ErrNoRows
+	}
+	return msg, nil
+}
+
+// ResendMail queues a sent or dead mail again with a fresh count of attempts
+func (m *memoryDBRepo) ResendMail(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg, ok := m.outbox[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	msg.Status = models.OutboxPending
+	msg.Attempts = 0
+	msg.LastError = ""
+	msg.NextAttemptAt = time.Now()
+	msg.UpdatedAt = time.Now()
+	m.outbox[id] = msg
+	return nil
+}
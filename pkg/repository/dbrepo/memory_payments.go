@@ -0,0 +1,42 @@
+package dbrepo
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// InsertPayment adds an entry to the payments ledger of a reservation
+func (m *memoryDBRepo) InsertPayment(p models.Payment) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.reservations[p.ReservationID]; !ok {
+		return 0, errors.New("reservation does not exist")
+	}
+
+	p.ID = m.nextID("payments")
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.payments[p.ID] = p
+	return p.ID, nil
+}
+
+// PaymentsForReservation returns the payments ledger of a reservation, the oldest entry first
+func (m *memoryDBRepo) PaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ledger []models.Payment
+	for _, p := range m.payments {
+		if p.ReservationID == reservationID {
+			ledger = append(ledger, p)
+		}
+	}
+	sort.Slice(ledger, func(i, j int) bool {
+		return ledger[i].ID < ledger[j].ID
+	})
+	return ledger, nil
+}
@@ -0,0 +1,155 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+)
+
+// AllPromoCodes returns every promo code with its redemption count, the newest first
+func (m *memoryDBRepo) AllPromoCodes() ([]models.PromoCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var codes []models.PromoCode
+	for _, p := range m.promoCodes {
+		p.Redemptions, _ = m.countPromoRedemptions(p.ID, "")
+		codes = append(codes, p)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i].ID > codes[j].ID
+	})
+	return codes, nil
+}
+
+// GetPromoCodeByID returns a promo code with its redemption count
+func (m *memoryDBRepo) GetPromoCodeByID(id int) (models.PromoCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.promoCodes[id]
+	if !ok {
+		return p, sql.ErrNoRows
+	}
+	p.Redemptions, _ = m.countPromoRedemptions(p.ID, "")
+	return p, nil
+}
+
+// GetPromoCodeByCode returns a promo code by its code in any case, with its redemption count
+func (m *memoryDBRepo) GetPromoCodeByCode(code string) (models.PromoCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, p := range m.promoCodes {
+		if strings.EqualFold(p.Code, code) {
+			p.Redemptions, _ = m.countPromoRedemptions(p.ID, "")
+			return p, nil
+		}
+	}
+	return models.PromoCode{}, sql.ErrNoRows
+}
+
+// InsertPromoCode inserts a promo code, like the unique index codes can not repeat
+func (m *memoryDBRepo) InsertPromoCode(p models.PromoCode) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, other := range m.promoCodes {
+		if strings.EqualFold(other.Code, p.Code) {
+			return 0, errors.New("duplicate promo code")
+		}
+	}
+
+	p.ID = m.nextID("promo_codes")
+	p.Redemptions = 0
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.promoCodes[p.ID] = p
+	return p.ID, nil
+}
+
+// UpdateActiveForPromoCode turns a promo code on or off
+func (m *memoryDBRepo) UpdateActiveForPromoCode(id int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.promoCodes[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	p.Active = active
+	p.UpdatedAt = time.Now()
+	m.promoCodes[id] = p
+	return nil
+}
+
+// CountPromoRedemptions returns how often a promo code was used, in total and with an email
+func (m *memoryDBRepo) CountPromoRedemptions(promoCodeID int, email string) (int, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	total, forEmail := m.countPromoRedemptions(promoCodeID, email)
+	return total, forEmail, nil
+}
+
+// countPromoRedemptions counts the uses of a promo code, the caller holds the lock
+func (m *memoryDBRepo) countPromoRedemptions(promoCodeID int, email string) (int, int) {
+	total, forEmail := 0, 0
+	for _, r := range m.promoRedemptions {
+		if r.PromoCodeID != promoCodeID {
+			continue
+		}
+		total++
+		if email != "" && strings.EqualFold(r.Email, email) {
+			forEmail++
+		}
+	}
+	return total, forEmail
+}
+
+// PromoRedemptionsForCode returns the uses of a promo code, the newest first
+func (m *memoryDBRepo) PromoRedemptionsForCode(promoCodeID int) ([]models.PromoRedemption, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var redemptions []models.PromoRedemption
+	for _, r := range m.promoRedemptions {
+		if r.PromoCodeID == promoCodeID {
+			redemptions = append(redemptions, r)
+		}
+	}
+	sort.Slice(redemptions, func(i, j int) bool {
+		return redemptions[i].ID > redemptions[j].ID
+	})
+	return redemptions, nil
+}
+
+// redeemPromoCode records the use of the quote's promo code by a new reservation, the caller holds the lock
+func (m *memoryDBRepo) redeemPromoCode(res models.Reservations) error {
+	p, ok := m.promoCodes[res.Quote.PromoCodeID]
+	if !ok {
+		return errors.New("promo code does not exist")
+	}
+
+	total, forEmail := m.countPromoRedemptions(p.ID, res.Email)
+	if (p.MaxRedemptions > 0 && total >= p.MaxRedemptions) || (p.MaxPerEmail > 0 && forEmail >= p.MaxPerEmail) {
+		return repository.ErrPromoCodeUsedUp
+	}
+
+	id := m.nextID("promo_redemptions")
+	m.promoRedemptions[id] = models.PromoRedemption{
+		ID:            id,
+		PromoCodeID:   p.ID,
+		ReservationID: res.ID,
+		Email:         res.Email,
+		Discount:      res.Quote.PromoDiscount,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	return nil
+}
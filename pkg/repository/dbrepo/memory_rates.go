@@ -0,0 +1,148 @@
+package dbrepo
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// SeasonalRatesForRoom returns the seasonal rates of a room in date order
+func (m *memoryDBRepo) SeasonalRatesForRoom(roomID int) ([]models.SeasonalRate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rates []models.SeasonalRate
+	for _, s := range m.seasonalRates {
+		if s.RoomID == roomID {
+			rates = append(rates, s)
+		}
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].StartDate.Equal(rates[j].StartDate) {
+			return rates[i].ID < rates[j].ID
+		}
+		return rates[i].StartDate.Before(rates[j].StartDate)
+	})
+	return rates, nil
+}
+
+// InsertSeasonalRate inserts a seasonal rate
+func (m *memoryDBRepo) InsertSeasonalRate(rate models.SeasonalRate) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[rate.RoomID]; !ok {
+		return 0, errors.New("room does not exist")
+	}
+
+	rate.ID = m.nextID("seasonal_rates")
+	rate.CreatedAt = time.Now()
+	rate.UpdatedAt = time.Now()
+	m.seasonalRates[rate.ID] = rate
+	return rate.ID, nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate
+func (m *memoryDBRepo) DeleteSeasonalRate(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.seasonalRates, id)
+	return nil
+}
+
+// StayDiscountsForRoom returns the stay discounts of a room, the shortest stay first
+func (m *memoryDBRepo) StayDiscountsForRoom(roomID int) ([]models.StayDiscount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var discounts []models.StayDiscount
+	for _, d := range m.stayDiscounts {
+		if d.RoomID == roomID {
+			discounts = append(discounts, d)
+		}
+	}
+	sort.Slice(discounts, func(i, j int) bool {
+		return discounts[i].MinNights < discounts[j].MinNights
+	})
+	return discounts, nil
+}
+
+// InsertStayDiscount inserts a stay discount, like the unique index a room has one per length of stay
+func (m *memoryDBRepo) InsertStayDiscount(d models.StayDiscount) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[d.RoomID]; !ok {
+		return 0, errors.New("room does not exist")
+	}
+	for _, other := range m.stayDiscounts {
+		if other.RoomID == d.RoomID && other.MinNights == d.MinNights {
+			return 0, errors.New("duplicate stay discount")
+		}
+	}
+
+	d.ID = m.nextID("stay_discounts")
+	d.CreatedAt = time.Now()
+	d.UpdatedAt = time.Now()
+	m.stayDiscounts[d.ID] = d
+	return d.ID, nil
+}
+
+// DeleteStayDiscount deletes a stay discount
+func (m *memoryDBRepo) DeleteStayDiscount(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.stayDiscounts, id)
+	return nil
+}
+
+// CancellationRulesForRoom returns the cancellation policy of a room, the latest cancellation first
+func (m *memoryDBRepo) CancellationRulesForRoom(roomID int) ([]models.CancellationRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rules []models.CancellationRule
+	for _, rule := range m.cancellationRules {
+		if rule.RoomID == roomID {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].DaysBefore < rules[j].DaysBefore
+	})
+	return rules, nil
+}
+
+// InsertCancellationRule inserts a cancellation rule, like the unique index a room has one per number of days
+func (m *memoryDBRepo) InsertCancellationRule(rule models.CancellationRule) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[rule.RoomID]; !ok {
+		return 0, errors.New("room does not exist")
+	}
+	for _, other := range m.cancellationRules {
+		if other.RoomID == rule.RoomID && other.DaysBefore == rule.DaysBefore {
+			return 0, errors.New("duplicate cancellation rule")
+		}
+	}
+
+	rule.ID = m.nextID("cancellation_rules")
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+	m.cancellationRules[rule.ID] = rule
+	return rule.ID, nil
+}
+
+// DeleteCancellationRule deletes a cancellation rule
+func (m *memoryDBRepo) DeleteCancellationRule(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.cancellationRules, id)
+	return nil
+}
@@ -0,0 +1,18 @@
+package dbrepo
+
+// GetSetting returns the value of a setting, or "" when it was never set
+func (m *memoryDBRepo) GetSetting(key string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.settings[key], nil
+}
+
+// UpdateSetting stores the value of a setting
+func (m *memoryDBRepo) UpdateSetting(key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.settings[key] = value
+	return nil
+}
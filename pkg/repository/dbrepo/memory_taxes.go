@@ -0,0 +1,72 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// AllTaxRules returns every tax and fee rule, in the order they were added
+func (m *memoryDBRepo) AllTaxRules() ([]models.TaxRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rules []models.TaxRule
+	for _, rule := range m.taxRules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].ID < rules[j].ID
+	})
+	return rules, nil
+}
+
+// GetTaxRuleByID returns a tax or fee rule
+func (m *memoryDBRepo) GetTaxRuleByID(id int) (models.TaxRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rule, ok := m.taxRules[id]
+	if !ok {
+		return rule, sql.ErrNoRows
+	}
+	return rule, nil
+}
+
+// InsertTaxRule inserts a tax or fee rule
+func (m *memoryDBRepo) InsertTaxRule(rule models.TaxRule) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rule.ID = m.nextID("tax_rules")
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+	m.taxRules[rule.ID] = rule
+	return rule.ID, nil
+}
+
+// UpdateActiveForTaxRule turns a tax or fee rule on or off
+func (m *memoryDBRepo) UpdateActiveForTaxRule(id int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rule, ok := m.taxRules[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	rule.Active = active
+	rule.UpdatedAt = time.Now()
+	m.taxRules[id] = rule
+	return nil
+}
+
+// DeleteTaxRule deletes a tax or fee rule, stays booked with it keep what they were charged
+func (m *memoryDBRepo) DeleteTaxRule(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.taxRules, id)
+	return nil
+}
@@ -0,0 +1,903 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/config"
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMemoryRepoAvailability(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	start := time.Date(2021, 7, 10, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 3)
+
+	id, err := repo.InsertReservations(models.Reservations{FirstName: "John", StartDate: start, EndDate: end, RoomID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.InsertRoomRestriction(models.RoomRestrictions{StartDate: start, EndDate: end, RoomID: 1, ReservationID: id, RestrictionID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		available bool
+	}{
+		{"same dates", start, end, false},
+		{"overlaps the start", start.AddDate(0, 0, -2), start.AddDate(0, 0, 1), false},
+		{"inside", start.AddDate(0, 0, 1), start.AddDate(0, 0, 2), false},
+		{"departs on arrival day", start.AddDate(0, 0, -2), start, true},
+		{"arrives on departure day", end, end.AddDate(0, 0, 2), true},
+	}
+
+	for _, e := range tests {
+		available, err := repo.SearchAvailabilityByDatesAndRoomID(e.start, e.end, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available != e.available {
+			t.Errorf("for %s, expected available %t, but %t", e.name, e.available, available)
+		}
+
+		rooms, err := repo.SearchAvailibilityForAllRooms(e.start, e.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.available && len(rooms) != 2 || !e.available && len(rooms) != 1 {
+			t.Errorf("for %s, got %d free rooms", e.name, len(rooms))
+		}
+	}
+
+	// deleting the reservation frees the room
+	if err := repo.DeleteReservation(id, 1); err != nil {
+		t.Fatal(err)
+	}
+	available, _ := repo.SearchAvailabilityByDatesAndRoomID(start, end, 1)
+	if !available {
+		t.Error("room still restricted after deleting the reservation")
+	}
+}
+
+func TestMemoryRepoAuthenticate(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	if _, _, err := repo.Authenticate(DemoEmail, DemoPassword); err != nil {
+		t.Error("demo user could not log in:", err)
+	}
+	if _, _, err := repo.Authenticate(DemoEmail, "wrong"); err == nil {
+		t.Error("logged in with a wrong password")
+	}
+	if _, _, err := repo.Authenticate("nobody@here.com", DemoPassword); err == nil {
+		t.Error("logged in with an unknown email")
+	}
+}
+
+func TestMemoryRepoUsers(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	// invited users have no password until they follow their link
+	id, err := repo.InsertUser(models.User{FirstName: "Jane", Email: "jane@here.com", AccessLevel: models.AccessViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.InsertUser(models.User{Email: "JANE@here.com"}); err == nil {
+		t.Error("inserted a second user with the same email")
+	}
+	if _, _, err := repo.Authenticate("jane@here.com", ""); err == nil {
+		t.Error("logged in without a password")
+	}
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err := repo.UpdatePasswordForUser(id, string(hashedPassword)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.Authenticate("Jane@here.com", "secret123"); err != nil {
+		t.Error("user could not log in with the new password:", err)
+	}
+
+	if err := repo.UpdateDisabledForUser(id, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.Authenticate("jane@here.com", "secret123"); err != repository.ErrUserDisabled {
+		t.Errorf("expected ErrUserDisabled, but %v", err)
+	}
+
+	// only the other user is updated
+	u, _ := repo.GetUserByID(id)
+	u.AccessLevel = models.AccessManager
+	if err := repo.UpdateUser(u); err != nil {
+		t.Fatal(err)
+	}
+	demo, _ := repo.GetUserByEmail(DemoEmail)
+	if demo.AccessLevel != models.AccessOwner {
+		t.Error("updating a user changed another user")
+	}
+}
+
+func TestMemoryRepoTwoFactor(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	if err := repo.UpdateTOTPForUser(1, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := repo.GetUserByID(1)
+	if !u.TwoFactorEnabled() {
+		t.Error("two-factor authentication is not on")
+	}
+
+	// a time step works once, and never after a later one
+	if ok, _ := repo.UseTOTPStepForUser(1, 100); !ok {
+		t.Error("could not use a new step")
+	}
+	if ok, _ := repo.UseTOTPStepForUser(1, 100); ok {
+		t.Error("used the same step twice")
+	}
+	if ok, _ := repo.UseTOTPStepForUser(1, 99); ok {
+		t.Error("used an earlier step")
+	}
+
+	if err := repo.ReplaceRecoveryCodes(1, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := repo.UseRecoveryCode(1, "a"); !ok {
+		t.Error("could not use a recovery code")
+	}
+	if ok, _ := repo.UseRecoveryCode(1, "a"); ok {
+		t.Error("used a recovery code twice")
+	}
+	if n, _ := repo.CountRecoveryCodes(1); n != 1 {
+		t.Errorf("expected 1 recovery code left, but %d", n)
+	}
+	if err := repo.ReplaceRecoveryCodes(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := repo.UseRecoveryCode(1, "b"); ok {
+		t.Error("used a replaced recovery code")
+	}
+
+	if err := repo.UpdateTOTPForUser(1, ""); err != nil {
+		t.Fatal(err)
+	}
+	u, _ = repo.GetUserByID(1)
+	if u.TwoFactorEnabled() || u.TOTPLastStep != 0 {
+		t.Error("two-factor authentication is still on")
+	}
+}
+
+func TestMemoryRepoLockout(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	for i := 1; i <= 3; i++ {
+		if n, _ := repo.IncrementFailedLoginsForUser(1); n != i {
+			t.Errorf("expected %d failed logins, but %d", i, n)
+		}
+	}
+	if err := repo.UpdateLockedUntilForUser(1, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := repo.GetUserByID(1)
+	if !u.Locked() {
+		t.Error("user is not locked")
+	}
+
+	if err := repo.ResetFailedLoginsForUser(1); err != nil {
+		t.Fatal(err)
+	}
+	u, _ = repo.GetUserByID(1)
+	if u.Locked() || u.FailedLogins != 0 {
+		t.Error("user is still locked")
+	}
+}
+
+func TestMemoryRepoAuthEvents(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	events := []models.AuthEvent{
+		{Email: "nobody@here.com", IPAddress: "10.0.0.1", Event: models.AuthUnknownEmail},
+		{UserID: 1, Email: DemoEmail, IPAddress: "10.0.0.1", Event: models.AuthBadPassword},
+		{UserID: 1, Email: DemoEmail, IPAddress: "10.0.0.1", Event: models.AuthLogin, Success: true},
+		{Email: DemoEmail, IPAddress: "10.0.0.1", Event: models.AuthIPBlocked},
+		{UserID: 1, Email: DemoEmail, IPAddress: "10.0.0.2", Event: models.AuthBadPassword},
+	}
+	for _, e := range events {
+		if err := repo.InsertAuthEvent(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, _ := repo.CountFailedLoginsForIP("10.0.0.1", time.Now().Add(-time.Minute)); n != 2 {
+		t.Errorf("expected 2 failed logins without the blocked one, but %d", n)
+	}
+	if n, _ := repo.CountFailedLoginsForIP("10.0.0.1", time.Now().Add(time.Minute)); n != 0 {
+		t.Errorf("expected no failed logins after now, but %d", n)
+	}
+
+	recent, _ := repo.RecentAuthEvents(2)
+	if len(recent) != 2 || recent[0].IPAddress != "10.0.0.2" {
+		t.Errorf("expected the newest 2 events, but %v", recent)
+	}
+}
+
+func TestMemoryRepoSettings(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	if value, err := repo.GetSetting("missing"); err != nil || value != "" {
+		t.Errorf("expected an empty setting, but %q, %v", value, err)
+	}
+	if err := repo.UpdateSetting(models.SettingRequire2FALevel, "3"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := repo.GetSetting(models.SettingRequire2FALevel); value != "3" {
+		t.Errorf("expected 3, but %q", value)
+	}
+}
+
+func TestMemoryRepoUserTokens(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	first := models.UserToken{UserID: 1, Purpose: models.TokenPasswordReset, TokenHash: "first", ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err := repo.InsertUserToken(first); err != nil {
+		t.Fatal(err)
+	}
+	invite := models.UserToken{UserID: 1, Purpose: models.TokenInvite, TokenHash: "invite", ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err := repo.InsertUserToken(invite); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.UseUserTokens(1, models.TokenPasswordReset); err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := repo.GetUserTokenByHash("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Usable() {
+		t.Error("token still usable after it was used")
+	}
+	tok, _ = repo.GetUserTokenByHash("invite")
+	if !tok.Usable() {
+		t.Error("using password reset tokens used the invite token")
+	}
+	if _, err := repo.GetUserTokenByHash("nothing"); err == nil {
+		t.Error("found a token that does not exist")
+	}
+}
+
+func TestMemoryRepoSetPasswordWithToken(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	tokens := []models.UserToken{
+		{UserID: 1, Purpose: models.TokenPasswordReset, TokenHash: "reset", ExpiresAt: time.Now().Add(time.Hour)},
+		{UserID: 1, Purpose: models.TokenPasswordReset, TokenHash: "older", ExpiresAt: time.Now().Add(time.Hour)},
+		{UserID: 1, Purpose: models.TokenPasswordReset, TokenHash: "expired", ExpiresAt: time.Now().Add(-time.Hour)},
+	}
+	for _, tok := range tokens {
+		if _, err := repo.InsertUserToken(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := repo.SetPasswordWithToken("expired", "hash"); err != sql.ErrNoRows {
+		t.Errorf("expected an expired token to set nothing, but %v", err)
+	}
+	id, err := repo.SetPasswordWithToken("reset", "hash")
+	if err != nil || id != 1 {
+		t.Fatalf("expected the password of user 1 to be set, but %d, %v", id, err)
+	}
+	u, _ := repo.GetUserByID(1)
+	if u.Password != "hash" || u.PasswordChangedAt.IsZero() {
+		t.Errorf("expected the password and the time it changed, but %q at %v", u.Password, u.PasswordChangedAt)
+	}
+
+	// the token is used up with the password, and so are the other links of the mail kind
+	for _, hash := range []string{"reset", "older"} {
+		if _, err := repo.SetPasswordWithToken(hash, "other"); err != sql.ErrNoRows {
+			t.Errorf("expected token %s to be used up, but %v", hash, err)
+		}
+	}
+	if u, _ := repo.GetUserByID(1); u.Password != "hash" {
+		t.Error("a used token set the password again")
+	}
+}
+
+func TestMemoryRepoRates(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	summer := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	spring := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	repo.InsertSeasonalRate(models.SeasonalRate{RoomID: 1, Name: "Summer", StartDate: summer, EndDate: summer.AddDate(0, 2, 0), NightlyRate: 11900})
+	springID, _ := repo.InsertSeasonalRate(models.SeasonalRate{RoomID: 1, Name: "Spring", StartDate: spring, EndDate: spring.AddDate(0, 1, 0), NightlyRate: 9900})
+	if _, err := repo.InsertSeasonalRate(models.SeasonalRate{RoomID: 99, Name: "Nowhere"}); err == nil {
+		t.Error("expected an error for a seasonal rate of a missing room")
+	}
+
+	seasons, _ := repo.SeasonalRatesForRoom(1)
+	if len(seasons) != 2 || seasons[0].Name != "Spring" {
+		t.Errorf("expected 2 seasons starting with spring, but %v", seasons)
+	}
+	repo.DeleteSeasonalRate(springID)
+	if seasons, _ = repo.SeasonalRatesForRoom(1); len(seasons) != 1 {
+		t.Errorf("expected 1 season, but %d", len(seasons))
+	}
+
+	repo.InsertStayDiscount(models.StayDiscount{RoomID: 1, MinNights: 14, Percent: 15})
+	weekID, _ := repo.InsertStayDiscount(models.StayDiscount{RoomID: 1, MinNights: 7, Percent: 10})
+	if _, err := repo.InsertStayDiscount(models.StayDiscount{RoomID: 1, MinNights: 7, Percent: 5}); err == nil {
+		t.Error("expected an error for a second discount of the same length of stay")
+	}
+
+	discounts, _ := repo.StayDiscountsForRoom(1)
+	if len(discounts) != 2 || discounts[0].MinNights != 7 {
+		t.Errorf("expected 2 discounts starting with 7 nights, but %v", discounts)
+	}
+	repo.DeleteStayDiscount(weekID)
+	if discounts, _ = repo.StayDiscountsForRoom(1); len(discounts) != 1 {
+		t.Errorf("expected 1 discount, but %d", len(discounts))
+	}
+}
+
+func TestMemoryRepoPromoCodes(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	id, err := repo.InsertPromoCode(models.PromoCode{Code: "ONCE", Kind: models.PromoFixed, Amount: 1000, MaxRedemptions: 2, MaxPerEmail: 1, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.InsertPromoCode(models.PromoCode{Code: "once", Kind: models.PromoFixed, Amount: 500}); err == nil {
+		t.Error("expected an error for a duplicate code")
+	}
+	if p, err := repo.GetPromoCodeByCode("Once"); err != nil || p.ID != id {
+		t.Errorf("expected to find the code in any case, but %v", err)
+	}
+
+	start := time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)
+	book := func(email string, offset int) error {
+		res := models.Reservations{
+			FirstName: "John",
+			Email:     email,
+			StartDate: start.AddDate(0, 0, offset),
+			EndDate:   start.AddDate(0, 0, offset+1),
+			RoomID:    1,
+			Quote:     models.Quote{PromoCodeID: id, PromoCode: "ONCE", PromoDiscount: 1000},
+		}
+		_, err := repo.BookReservation(res, nil)
+		return err
+	}
+
+	if err := book("john@mail.com", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := book("JOHN@mail.com", 2); err != repository.ErrPromoCodeUsedUp {
+		t.Errorf("expected the second use of an email to fail, but %v", err)
+	}
+	if err := book("jane@mail.com", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := book("joe@mail.com", 6); err != repository.ErrPromoCodeUsedUp {
+		t.Errorf("expected a third use to fail, but %v", err)
+	}
+
+	total, forEmail, _ := repo.CountPromoRedemptions(id, "john@mail.com")
+	if total != 2 || forEmail != 1 {
+		t.Errorf("expected 2 uses and 1 by john, but %d and %d", total, forEmail)
+	}
+	if p, _ := repo.GetPromoCodeByID(id); p.Redemptions != 2 {
+		t.Errorf("expected the code to count 2 uses, but %d", p.Redemptions)
+	}
+	// a booking that fails on its promo code is not kept
+	if restrictions, _ := repo.GetRestrictionsForRoomByDate(1, start, start.AddDate(0, 0, 8)); len(restrictions) != 2 {
+		t.Errorf("expected 2 room restrictions, but %d", len(restrictions))
+	}
+}
+
+func TestMemoryRepoTaxRules(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	first, _ := repo.InsertTaxRule(models.TaxRule{Name: "City tax", Kind: models.TaxKindTax, Basis: models.ChargePerNight, Amount: 200, Active: true})
+	second, _ := repo.InsertTaxRule(models.TaxRule{Name: "Cleaning fee", Kind: models.TaxKindFee, Basis: models.ChargePerStay, Amount: 2500, Active: true})
+
+	if err := repo.UpdateActiveForTaxRule(first, false); err != nil {
+		t.Fatal(err)
+	}
+	if rule, _ := repo.GetTaxRuleByID(first); rule.Active {
+		t.Error("expected the rule to be inactive")
+	}
+	if err := repo.UpdateActiveForTaxRule(99, true); err != sql.ErrNoRows {
+		t.Errorf("expected no rows for an unknown rule, but %v", err)
+	}
+
+	if err := repo.DeleteTaxRule(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetTaxRuleByID(first); err != sql.ErrNoRows {
+		t.Errorf("expected the rule to be deleted, but %v", err)
+	}
+	if rules, _ := repo.AllTaxRules(); len(rules) != 1 || rules[0].ID != second {
+		t.Errorf("expected the fee to be left, but %+v", rules)
+	}
+}
+
+func TestMemoryRepoPayments(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	id, err := repo.BookReservation(models.Reservations{FirstName: "John", StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.InsertPayment(models.Payment{ReservationID: 999, Kind: models.PaymentAuthorize}); err == nil {
+		t.Error("expected an error for an unknown reservation")
+	}
+	for _, kind := range []string{models.PaymentAuthorize, models.PaymentCapture, models.PaymentRefund} {
+		if _, err := repo.InsertPayment(models.Payment{ReservationID: id, Kind: kind, Amount: 100, Succeeded: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ledger, _ := repo.PaymentsForReservation(id)
+	if len(ledger) != 3 || ledger[0].Kind != models.PaymentAuthorize || ledger[2].Kind != models.PaymentRefund {
+		t.Errorf("expected the ledger oldest first, but %v", ledger)
+	}
+
+	// a paid reservation keeps its ledger, also when the trash is purged
+	repo.DeleteReservation(id, 1)
+	if ledger, _ = repo.PaymentsForReservation(id); len(ledger) != 3 {
+		t.Errorf("expected the ledger kept in the trash, but %d", len(ledger))
+	}
+	if purged, _ := repo.PurgeDeletedReservations(time.Now().Add(time.Second)); len(purged) != 0 {
+		t.Errorf("expected a paid reservation not to be purged, but %v", purged)
+	}
+	if ledger, _ = repo.PaymentsForReservation(id); len(ledger) != 3 {
+		t.Errorf("expected the ledger to survive the purge, but %d", len(ledger))
+	}
+	if deleted, _ := repo.DeletedReservations(); len(deleted) != 1 || deleted[0].ID != id {
+		t.Errorf("expected the paid reservation left in the trash, but %+v", deleted)
+	}
+}
+
+func TestMemoryRepoInvoices(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	start := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	var ids []int
+	for i := 0; i < 3; i++ {
+		id, err := repo.BookReservation(models.Reservations{FirstName: "John", StartDate: start.AddDate(0, 0, 2*i), EndDate: start.AddDate(0, 0, 2*i+1), RoomID: 1}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	if _, err := repo.InvoiceForReservation(ids[0]); err != sql.ErrNoRows {
+		t.Errorf("expected no invoice yet, but %v", err)
+	}
+
+	// numbers run on within a year and start again in the next one
+	issue := func(id int, issuedAt time.Time) models.Invoice {
+		inv, err := repo.IssueInvoice(models.Invoice{ReservationID: id, Total: 100, IssuedAt: issuedAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return inv
+	}
+	first := issue(ids[0], time.Date(2026, 12, 30, 0, 0, 0, 0, time.UTC))
+	second := issue(ids[1], time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC))
+	next := issue(ids[2], time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	if first.Number != "2026-000001" || second.Number != "2026-000002" || next.Number != "2027-000001" {
+		t.Errorf("unexpected numbers %s, %s and %s", first.Number, second.Number, next.Number)
+	}
+
+	// a reservation keeps its invoice
+	if again := issue(ids[0], time.Now()); again.ID != first.ID || again.Number != first.Number {
+		t.Errorf("expected the same invoice, but %s", again.Number)
+	}
+
+	// a reservation with an invoice is not purged, the invoice keeps its reservation
+	repo.DeleteReservation(ids[0], 1)
+	if purged, _ := repo.PurgeDeletedReservations(time.Now().Add(time.Second)); len(purged) != 0 {
+		t.Errorf("expected an invoiced reservation not to be purged, but %v", purged)
+	}
+	if inv, err := repo.InvoiceForReservation(ids[0]); err != nil || inv.ID != first.ID {
+		t.Errorf("expected the invoice to keep its reservation, but %v", err)
+	}
+}
+
+func TestMemoryRepoBookReservation(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	start := time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)
+	res := models.Reservations{FirstName: "John", StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 2}
+
+	// many guests submit at the same time, only one of them gets the room
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := repo.BookReservation(res, nil)
+			results <- err
+		}()
+	}
+
+	booked := 0
+	for i := 0; i < 10; i++ {
+		err := <-results
+		if err == nil {
+			booked++
+		} else if err != repository.ErrRoomNotAvailable {
+			t.Error("unexpected error:", err)
+		}
+	}
+	if booked != 1 {
+		t.Errorf("expected 1 booking, but %d", booked)
+	}
+
+	restrictions, _ := repo.GetRestrictionsForRoomByDate(2, start, start.AddDate(0, 0, 2))
+	if len(restrictions) != 1 {
+		t.Errorf("expected 1 room restriction, but %d", len(restrictions))
+	}
+}
+
+func TestMemoryRepoChangeReservationDates(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	start := time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)
+	id, _ := repo.BookReservation(models.Reservations{FirstName: "John", StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 1}, nil)
+	repo.BookReservation(models.Reservations{FirstName: "Jane", StartDate: start.AddDate(0, 0, 5), EndDate: start.AddDate(0, 0, 7), RoomID: 1}, nil)
+
+	// moving a night later overlaps the reservation's own nights only
+	res, _ := repo.GetReservationByID(id)
+	res.StartDate, res.EndDate = start.AddDate(0, 0, 1), start.AddDate(0, 0, 4)
+	res.Quote = models.Quote{Total: 30000}
+	if err := repo.ChangeReservationDates(res); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := repo.GetReservationByID(id); !changed.EndDate.Equal(res.EndDate) || changed.Quote.Total != 30000 {
+		t.Errorf("expected the new dates and price, but %+v", changed)
+	}
+	restrictions, _ := repo.GetRestrictionsForRoomByDate(1, start, start.AddDate(0, 0, 4))
+	if len(restrictions) != 1 || !restrictions[0].StartDate.Equal(res.StartDate) {
+		t.Errorf("expected the room restriction to move along, but %+v", restrictions)
+	}
+
+	res.EndDate = start.AddDate(0, 0, 6)
+	if err := repo.ChangeReservationDates(res); err != repository.ErrRoomNotAvailable {
+		t.Errorf("expected the room to be taken, but %v", err)
+	}
+	if err := repo.ChangeReservationDates(models.Reservations{ID: 99}); err != sql.ErrNoRows {
+		t.Errorf("expected no rows for an unknown reservation, but %v", err)
+	}
+}
+
+func TestMemoryRepoCancellation(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	repo.InsertCancellationRule(models.CancellationRule{RoomID: 1, DaysBefore: 30, Percent: 50})
+	id, _ := repo.InsertCancellationRule(models.CancellationRule{RoomID: 1, DaysBefore: 7, Percent: 100})
+	repo.InsertCancellationRule(models.CancellationRule{RoomID: 2, DaysBefore: 14, Percent: 20})
+	rules, _ := repo.CancellationRulesForRoom(1)
+	if len(rules) != 2 || rules[0].DaysBefore != 7 {
+		t.Errorf("expected the rules of room 1 by days, but %+v", rules)
+	}
+	repo.DeleteCancellationRule(id)
+	if rules, _ = repo.CancellationRulesForRoom(1); len(rules) != 1 {
+		t.Errorf("expected 1 rule after deleting, but %d", len(rules))
+	}
+
+	start := time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)
+	resID, _ := repo.BookReservation(models.Reservations{FirstName: "John", StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 1}, nil)
+	if err := repo.CancelReservation(resID, 1500, 1); err != nil {
+		t.Fatal(err)
+	}
+	res, err := repo.GetReservationByID(resID)
+	if err != nil || !res.Cancelled() || res.CancellationFee != 1500 {
+		t.Errorf("expected the reservation kept as cancelled with its fee, but %+v", res)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesAndRoomID(start, start.AddDate(0, 0, 2), 1); !available {
+		t.Error("expected the room to be free again")
+	}
+	if pending, _ := repo.ReservationsWithStatus(models.ReservationPending); len(pending) != 0 {
+		t.Errorf("expected cancelled reservations left out of the pending ones, but %d", len(pending))
+	}
+
+	// cancelling again keeps the first fee
+	if err := repo.CancelReservation(resID, 0, 1); err != repository.ErrInvalidTransition {
+		t.Errorf("expected a cancelled reservation to stay cancelled, but %v", err)
+	}
+	if res, _ = repo.GetReservationByID(resID); res.CancellationFee != 1500 {
+		t.Errorf("expected the fee to stay, but %d", res.CancellationFee)
+	}
+}
+
+func TestMemoryRepoStatusLifecycle(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	start := time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)
+	id, _ := repo.BookReservation(models.Reservations{FirstName: "John", StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 1}, nil)
+	if res, _ := repo.GetReservationByID(id); res.Status != models.ReservationPending {
+		t.Fatalf("expected a new reservation to be pending, but %s", res.Status)
+	}
+
+	var theTests = []struct {
+		status   string
+		expected error
+	}{
+		{models.ReservationCheckedIn, repository.ErrInvalidTransition},
+		{models.ReservationConfirmed, nil},
+		{models.ReservationCheckedIn, nil},
+		{models.ReservationNoShow, repository.ErrInvalidTransition},
+		{models.ReservationCheckedOut, nil},
+		{models.ReservationPending, repository.ErrInvalidTransition},
+	}
+	for _, e := range theTests {
+		if err := repo.ChangeReservationStatus(id, e.status, 1); err != e.expected {
+			t.Errorf("for %s, expected %v, but %v", e.status, e.expected, err)
+		}
+	}
+	if err := repo.ChangeReservationStatus(99, models.ReservationConfirmed, 1); err != sql.ErrNoRows {
+		t.Errorf("expected no rows for an unknown reservation, but %v", err)
+	}
+
+	history, _ := repo.StatusChangesForReservation(id)
+	if len(history) != 3 || history[0].FromStatus != models.ReservationPending || history[2].ToStatus != models.ReservationCheckedOut {
+		t.Fatalf("expected the 3 changes in order, but %+v", history)
+	}
+	if history[0].UserID != 1 || history[0].UserName == "" || history[0].CreatedAt.IsZero() {
+		t.Errorf("expected who made the change and when, but %+v", history[0])
+	}
+	if out, _ := repo.ReservationsWithStatus(models.ReservationCheckedOut); len(out) != 1 {
+		t.Errorf("expected 1 checked-out reservation, but %d", len(out))
+	}
+
+	repo.DeleteReservation(id, 1)
+	repo.PurgeDeletedReservations(time.Now().Add(time.Second))
+	if history, _ = repo.StatusChangesForReservation(id); len(history) != 0 {
+		t.Errorf("expected the history to go with the reservation, but %d", len(history))
+	}
+}
+
+func TestMemoryRepoTrash(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	start := time.Date(2026, 8, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 2)
+	id, _ := repo.BookReservation(models.Reservations{FirstName: "John", StartDate: start, EndDate: end, RoomID: 1}, nil)
+	if err := repo.DeleteReservation(id, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.GetReservationByID(id); err != sql.ErrNoRows {
+		t.Errorf("expected a deleted reservation not to be found, but %v", err)
+	}
+	if all, _ := repo.AllReservations(); len(all) != 0 {
+		t.Errorf("expected deleted reservations left out, but %d", len(all))
+	}
+	trash, _ := repo.DeletedReservations()
+	if len(trash) != 1 || trash[0].DeletedBy != 1 || trash[0].DeletedAt.IsZero() {
+		t.Fatalf("expected the reservation in the trash with who deleted it, but %+v", trash)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesAndRoomID(start, end, 1); !available {
+		t.Error("expected a deleted reservation to free its room")
+	}
+
+	// the room was booked again meanwhile
+	other, _ := repo.BookReservation(models.Reservations{FirstName: "Jane", StartDate: start.AddDate(0, 0, 1), EndDate: end, RoomID: 1}, nil)
+	if err := repo.RestoreReservation(id); err != repository.ErrRoomNotAvailable {
+		t.Errorf("expected the restore refused for a booked room, but %v", err)
+	}
+	if trash, _ = repo.DeletedReservations(); len(trash) != 1 {
+		t.Error("expected the reservation to stay in the trash")
+	}
+
+	repo.DeleteReservation(other, 1)
+	if err := repo.RestoreReservation(id); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := repo.GetReservationByID(id); err != nil || !res.DeletedAt.IsZero() {
+		t.Errorf("expected the reservation back, but %v", err)
+	}
+	if available, _ := repo.SearchAvailabilityByDatesAndRoomID(start, end, 1); available {
+		t.Error("expected the restored reservation to hold its room again")
+	}
+
+	if purged, _ := repo.PurgeDeletedReservations(time.Now().Add(-time.Hour)); len(purged) != 0 {
+		t.Errorf("expected nothing purged within the window, but %v", purged)
+	}
+	if purged, _ := repo.PurgeDeletedReservations(time.Now().Add(time.Second)); len(purged) != 1 {
+		t.Errorf("expected the other reservation purged, but %v", purged)
+	}
+	if trash, _ = repo.DeletedReservations(); len(trash) != 0 {
+		t.Errorf("expected an empty trash, but %d", len(trash))
+	}
+}
+
+func TestMemoryRepoAuditEntries(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	repo.InsertAuditEntry(models.AuditEntry{UserID: 1, Action: "update", Entity: models.AuditReservation, EntityID: 4,
+		Changes: `[{"field":"FirstName","from":"John","to":"Jane"}]`})
+	repo.InsertAuditEntry(models.AuditEntry{Actor: "guest", Action: "create", Entity: models.AuditReservation, EntityID: 5})
+	repo.InsertAuditEntry(models.AuditEntry{UserID: 1, Action: "create", Entity: models.AuditRoom, EntityID: 4})
+
+	var theTests = []struct {
+		name     string
+		filter   models.AuditFilter
+		expected int
+	}{
+		{"everything", models.AuditFilter{}, 3},
+		{"a record", models.AuditFilter{Entity: models.AuditReservation, EntityID: 4}, 1},
+		{"an action", models.AuditFilter{Action: "create"}, 2},
+		{"a user", models.AuditFilter{UserID: 1}, 2},
+		{"the changes", models.AuditFilter{Query: "jane"}, 1},
+		{"the user's name", models.AuditFilter{Query: "demo"}, 2},
+		{"a limit", models.AuditFilter{Limit: 1}, 1},
+		{"the future", models.AuditFilter{From: time.Now().Add(time.Hour)}, 0},
+	}
+	for _, e := range theTests {
+		entries, err := repo.SearchAuditEntries(e.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != e.expected {
+			t.Errorf("for %s, expected %d entries, but %d", e.name, e.expected, len(entries))
+		}
+	}
+
+	entries, _ := repo.SearchAuditEntries(models.AuditFilter{})
+	if entries[0].EntityID != 4 || entries[0].Entity != models.AuditRoom || entries[2].UserName == "" {
+		t.Errorf("expected the newest first with the names of the users, but %+v", entries)
+	}
+}
+
+func TestMemoryRepoOutbox(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	start := time.Now().AddDate(0, 1, 0).Truncate(24 * time.Hour)
+
+	// the confirmation is queued with the booking and held back
+	confirmation := models.OutboxMessage{
+		MailData:      models.MailData{To: "john@mail.com", Subject: "Reservation Confirmation"},
+		NextAttemptAt: time.Now().Add(time.Minute),
+	}
+	if _, err := repo.BookReservation(models.Reservations{FirstName: "John", StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: 1}, &confirmation); err != nil {
+		t.Fatal(err)
+	}
+	if confirmation.ID == 0 {
+		t.Fatal("expected the confirmation to get an id")
+	}
+	later, _ := repo.QueueMail(models.OutboxMessage{MailData: models.MailData{To: "jane@mail.com"}})
+
+	claimed, _ := repo.ClaimDueMail(10, time.Minute)
+	if len(claimed) != 1 || claimed[0].ID != later || claimed[0].Attempts != 1 {
+		t.Fatalf("expected only the mail that is not held back to be claimed, but %+v", claimed)
+	}
+	if again, _ := repo.ClaimDueMail(10, time.Minute); len(again) != 0 {
+		t.Errorf("expected a claimed mail not to be due during its lease, but %+v", again)
+	}
+
+	// the release attaches the invoice and makes it due
+	invoice := models.MailAttachment{Name: "invoice.pdf", ContentType: "application/pdf", Data: []byte("%PDF")}
+	if err := repo.ReleaseMail(confirmation.ID, []models.MailAttachment{invoice}); err != nil {
+		t.Fatal(err)
+	}
+	claimed, _ = repo.ClaimDueMail(10, time.Minute)
+	if len(claimed) != 1 || claimed[0].ID != confirmation.ID || len(claimed[0].Attachments) != 1 {
+		t.Fatalf("expected the released confirmation with its invoice, but %+v", claimed)
+	}
+
+	// a mail that went out is not changed by a late release
+	repo.MarkMailSent(confirmation.ID)
+	repo.ReleaseMail(confirmation.ID, []models.MailAttachment{invoice})
+	msg, _ := repo.GetOutboxMessage(confirmation.ID)
+	if msg.Status != models.OutboxSent || len(msg.Attachments) != 1 {
+		t.Errorf("expected the sent mail to stay as it was, but %s with %d attachments", msg.Status, len(msg.Attachments))
+	}
+
+	repo.MarkMailFailed(later, "timeout", time.Time{})
+	dead, _ := repo.OutboxMessages(models.OutboxDead, 0)
+	if len(dead) != 1 || dead[0].LastError != "timeout" {
+		t.Errorf("expected the failed mail to be dead, but %+v", dead)
+	}
+	all, _ := repo.OutboxMessages("", 0)
+	if len(all) != 2 || all[0].ID != later {
+		t.Errorf("expected both mails, the newest first, but %+v", all)
+	}
+
+	if err := repo.ResendMail(999); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an unknown mail, but %v", err)
+	}
+}
+
+func TestMemoryRepoNotifications(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	admin, _ := repo.GetUserByEmail(DemoEmail)
+	if admin.NotifyEmail != models.NotifyEmailOff {
+		t.Errorf("expected notification mails to be off at first, but %q", admin.NotifyEmail)
+	}
+	repo.UpdateNotifyEmailForUser(admin.ID, models.NotifyEmailDigest)
+	if admin, _ = repo.GetUserByID(admin.ID); admin.NotifyEmail != models.NotifyEmailDigest {
+		t.Errorf("expected a digest, but %q", admin.NotifyEmail)
+	}
+
+	first, _ := repo.InsertNotification(models.Notification{UserID: admin.ID, Kind: models.NotificationNewReservation, Title: "first"})
+	second, _ := repo.InsertNotification(models.Notification{UserID: admin.ID, Kind: models.NotificationNewReservation, Title: "second"})
+	mailed, _ := repo.InsertNotification(models.Notification{UserID: admin.ID, Title: "mailed", MailedAt: time.Now()})
+	repo.InsertNotification(models.Notification{UserID: admin.ID + 1, Title: "someone else's"})
+
+	if n, _ := repo.CountUnreadNotifications(admin.ID); n != 3 {
+		t.Errorf("expected 3 unread notifications, but %d", n)
+	}
+	latest, _ := repo.NotificationsForUser(admin.ID, 2)
+	if len(latest) != 2 || latest[0].ID != mailed || latest[1].ID != second {
+		t.Errorf("expected the 2 newest notifications, but %+v", latest)
+	}
+
+	repo.MarkNotificationRead(first)
+	unmailed, _ := repo.UnmailedNotifications(admin.ID)
+	if len(unmailed) != 1 || unmailed[0].ID != second {
+		t.Errorf("expected only the notification that is neither read nor mailed, but %+v", unmailed)
+	}
+	repo.MarkNotificationsMailed([]int{second})
+	if unmailed, _ = repo.UnmailedNotifications(admin.ID); len(unmailed) != 0 {
+		t.Errorf("expected nothing left to mail, but %+v", unmailed)
+	}
+
+	repo.MarkAllNotificationsRead(admin.ID)
+	if n, _ := repo.CountUnreadNotifications(admin.ID); n != 0 {
+		t.Errorf("expected all notifications read, but %d unread", n)
+	}
+	if n, _ := repo.CountUnreadNotifications(admin.ID + 1); n != 1 {
+		t.Errorf("expected the notifications of other users to stay unread, but %d", n)
+	}
+}
+
+func TestMemoryRepoGuestMails(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	day := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	arriving, _ := repo.InsertReservations(models.Reservations{FirstName: "Arriving", StartDate: day, EndDate: day.AddDate(0, 0, 2), RoomID: 1})
+	later, _ := repo.InsertReservations(models.Reservations{FirstName: "Later", StartDate: day.AddDate(0, 0, 5), EndDate: day.AddDate(0, 0, 6), RoomID: 1})
+	cancelled, _ := repo.InsertReservations(models.Reservations{FirstName: "Cancelled", StartDate: day, EndDate: day.AddDate(0, 0, 1), RoomID: 2})
+	repo.CancelReservation(cancelled, 0, 0)
+	deleted, _ := repo.InsertReservations(models.Reservations{FirstName: "Deleted", StartDate: day.AddDate(0, 0, 1), EndDate: day.AddDate(0, 0, 2), RoomID: 2})
+	repo.DeleteReservation(deleted, 0)
+
+	due, _ := repo.ArrivalsWithoutGuestMail("reminder", day, day.AddDate(0, 0, 3))
+	if len(due) != 1 || due[0].ID != arriving || due[0].Room.RoomName == "" {
+		t.Fatalf("expected only the reservation arriving in time, but %+v", due)
+	}
+	if due, _ = repo.DeparturesWithoutGuestMail("thank-you", day.AddDate(0, 0, 6), day.AddDate(0, 0, 6)); len(due) != 1 || due[0].ID != later {
+		t.Errorf("expected the reservation departing that day, but %+v", due)
+	}
+
+	queued, err := repo.QueueGuestMail(arriving, "reminder", models.OutboxMessage{MailData: models.MailData{To: "arriving@mail.com"}})
+	if err != nil || !queued {
+		t.Fatalf("expected the reminder to be queued, but %v, %v", queued, err)
+	}
+	if queued, _ = repo.QueueGuestMail(arriving, "reminder", models.OutboxMessage{}); queued {
+		t.Error("expected a second reminder not to be queued")
+	}
+	if messages, _ := repo.OutboxMessages("", 10); len(messages) != 1 || messages[0].To != "arriving@mail.com" {
+		t.Errorf("expected one mail in the outbox, but %+v", messages)
+	}
+	if due, _ = repo.ArrivalsWithoutGuestMail("reminder", day, day.AddDate(0, 0, 3)); len(due) != 0 {
+		t.Errorf("expected no reservation left for a reminder, but %+v", due)
+	}
+	// the other kinds are still due
+	if due, _ = repo.ArrivalsWithoutGuestMail("check-in", day, day); len(due) != 1 {
+		t.Errorf("expected the check-in mail still due, but %+v", due)
+	}
+
+	mails, _ := repo.GuestMailsForReservation(arriving)
+	if len(mails) != 1 || mails[0].Kind != "reminder" || mails[0].OutboxMessageID == 0 {
+		t.Errorf("expected the reminder recorded, but %+v", mails)
+	}
+}
@@ -0,0 +1,370 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+)
+
+// AllUsers returns every user ordered by name
+func (m *memoryDBRepo) AllUsers() ([]models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var users []models.User
+	for _, u := range m.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].LastName != users[j].LastName {
+			return users[i].LastName < users[j].LastName
+		}
+		return users[i].FirstName < users[j].FirstName
+	})
+
+	return users, nil
+}
+
+// GetUserByID gets user information by user ID
+func (m *memoryDBRepo) GetUserByID(id int) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return models.User{}, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+// GetUserByEmail gets user information by email, emails are compared case insensitively
+func (m *memoryDBRepo) GetUserByEmail(email string) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.userByEmail(email)
+}
+
+func (m *memoryDBRepo) userByEmail(email string) (models.User, error) {
+	for _, u := range m.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+// InsertUser inserts a user, the password must already be hashed
+func (m *memoryDBRepo) InsertUser(u models.User) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.userByEmail(u.Email); err == nil {
+		return 0, errors.New("duplicate user email")
+	}
+
+	u.ID = m.nextID("users")
+	u.DisabledAt = time.Time{}
+	if u.NotifyEmail == "" {
+		u.NotifyEmail = models.NotifyEmailOff
+	}
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = time.Now()
+	m.users[u.ID] = u
+
+	return u.ID, nil
+}
+
+// UpdateUser updates user information
+func (m *memoryDBRepo) UpdateUser(u models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.users[u.ID]
+	if !ok {
+		return nil
+	}
+	if other, err := m.userByEmail(u.Email); err == nil && other.ID != u.ID {
+		return errors.New("duplicate user email")
+	}
+
+	existing.FirstName = u.FirstName
+	existing.LastName = u.LastName
+	existing.Email = u.Email
+	existing.AccessLevel = u.AccessLevel
+	existing.UpdatedAt = time.Now()
+	m.users[u.ID] = existing
+
+	return nil
+}
+
+// UpdatePasswordForUser sets the bcrypt hash of a user's password, an empty hash locks the user out. Sessions
+// of the user started before are logged out
+func (m *memoryDBRepo) UpdatePasswordForUser(id int, hashedPassword string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.setPassword(id, hashedPassword)
+	return nil
+}
+
+// setPassword sets the password of a user, the caller holds the lock
+func (m *memoryDBRepo) setPassword(id int, hashedPassword string) {
+	if u, ok := m.users[id]; ok {
+		u.Password = hashedPassword
+		u.PasswordChangedAt = time.Now()
+		u.UpdatedAt = u.PasswordChangedAt
+		m.users[id] = u
+	}
+}
+
+// UpdateNotifyEmailForUser sets whether a user gets their notifications by mail too
+func (m *memoryDBRepo) UpdateNotifyEmailForUser(id int, mode string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok {
+		u.NotifyEmail = mode
+		u.UpdatedAt = time.Now()
+		m.users[id] = u
+	}
+	return nil
+}
+
+// UpdateDisabledForUser disables or enables a user
+func (m *memoryDBRepo) UpdateDisabledForUser(id int, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok {
+		u.DisabledAt = time.Time{}
+		if disabled {
+			u.DisabledAt = time.Now()
+		}
+		u.UpdatedAt = time.Now()
+		m.users[id] = u
+	}
+	return nil
+}
+
+// Authenticate validates login information, an unknown email and a wrong password both return
+// repository.ErrInvalidCredentials
+func (m *memoryDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+	u, _ := m.GetUserByEmail(email)
+
+	// compare password, unknown emails and invited users without a password take as long and fail here too
+	err := checkPassword(u.Password, testPassword)
+	if err != nil {
+		return 0, "", repository.ErrInvalidCredentials
+	}
+
+	if u.Disabled() {
+		return 0, "", repository.ErrUserDisabled
+	}
+
+	return u.ID, u.Password, nil
+}
+
+// memoryRecoveryCode is a row of the user_recovery_codes table
+type memoryRecoveryCode struct {
+	userID   int
+	codeHash string
+	used     bool
+}
+
+// UpdateTOTPForUser turns on two-factor authentication with secret, an empty secret turns it off
+func (m *memoryDBRepo) UpdateTOTPForUser(id int, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok {
+		u.TOTPSecret = secret
+		u.TOTPEnabledAt = time.Time{}
+		if secret != "" {
+			u.TOTPEnabledAt = time.Now()
+		}
+		u.TOTPLastStep = 0
+		u.UpdatedAt = time.Now()
+		m.users[id] = u
+	}
+	return nil
+}
+
+// UseTOTPStepForUser records the time step of a code a user logged in with, it reports false
+// when that step or a later one was used before, so every code works only once
+func (m *memoryDBRepo) UseTOTPStepForUser(id int, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || u.TOTPLastStep >= step {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	m.users[id] = u
+	return true, nil
+}
+
+// ReplaceRecoveryCodes drops the recovery codes of a user and stores the hashes of new ones
+func (m *memoryDBRepo) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, c := range m.recoveryCodes {
+		if c.userID == userID {
+			delete(m.recoveryCodes, id)
+		}
+	}
+	for _, codeHash := range codeHashes {
+		m.recoveryCodes[m.nextID("user_recovery_codes")] = memoryRecoveryCode{userID: userID, codeHash: codeHash}
+	}
+	return nil
+}
+
+// UseRecoveryCode uses up an unused recovery code of a user, it reports false when there is none
+func (m *memoryDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, c := range m.recoveryCodes {
+		if c.userID == userID && c.codeHash == codeHash && !c.used {
+			c.used = true
+			m.recoveryCodes[id] = c
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (m *memoryDBRepo) CountRecoveryCodes(userID int) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := 0
+	for _, c := range m.recoveryCodes {
+		if c.userID == userID && !c.used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// IncrementFailedLoginsForUser counts a failed login of a user and returns the count since their last login
+func (m *memoryDBRepo) IncrementFailedLoginsForUser(id int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	u.FailedLogins++
+	m.users[id] = u
+	return u.FailedLogins, nil
+}
+
+// UpdateLockedUntilForUser locks a user out until the given time
+func (m *memoryDBRepo) UpdateLockedUntilForUser(id int, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok {
+		u.LockedUntil = until
+		u.UpdatedAt = time.Now()
+		m.users[id] = u
+	}
+	return nil
+}
+
+// ResetFailedLoginsForUser forgets the failed logins of a user and lifts their lock
+func (m *memoryDBRepo) ResetFailedLoginsForUser(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok {
+		u.FailedLogins = 0
+		u.LockedUntil = time.Time{}
+		u.UpdatedAt = time.Now()
+		m.users[id] = u
+	}
+	return nil
+}
+
+// InsertUserToken inserts a user token
+func (m *memoryDBRepo) InsertUserToken(t models.UserToken) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[t.UserID]; !ok {
+		return 0, errors.New("user does not exist")
+	}
+
+	t.ID = m.nextID("user_tokens")
+	t.UsedAt = time.Time{}
+	t.CreatedAt = time.Now()
+	t.UpdatedAt = time.Now()
+	m.userTokens[t.ID] = t
+
+	return t.ID, nil
+}
+
+// GetUserTokenByHash gets the user token with the hash
+func (m *memoryDBRepo) GetUserTokenByHash(tokenHash string) (models.UserToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, t := range m.userTokens {
+		if t.TokenHash == tokenHash {
+			return t, nil
+		}
+	}
+	return models.UserToken{}, sql.ErrNoRows
+}
+
+// UseUserTokens marks every unused token of a user for purpose as used
+func (m *memoryDBRepo) UseUserTokens(userID int, purpose string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, t := range m.userTokens {
+		if t.UserID == userID && t.Purpose == purpose && t.UsedAt.IsZero() {
+			t.UsedAt = time.Now()
+			t.UpdatedAt = t.UsedAt
+			m.userTokens[id] = t
+		}
+	}
+	return nil
+}
+
+// SetPasswordWithToken uses up a usable token and sets the password of its user at once and returns the user,
+// an unknown, used or expired token gives sql.ErrNoRows
+func (m *memoryDBRepo) SetPasswordWithToken(tokenHash, hashedPassword string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var token models.UserToken
+	for _, t := range m.userTokens {
+		if t.TokenHash == tokenHash && t.Usable() {
+			token = t
+		}
+	}
+	if token.ID == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	for id, t := range m.userTokens {
+		if t.UserID == token.UserID && t.Purpose == token.Purpose && t.UsedAt.IsZero() {
+			t.UsedAt = time.Now()
+			t.UpdatedAt = t.UsedAt
+			m.userTokens[id] = t
+		}
+	}
+	m.setPassword(token.UserID, hashedPassword)
+	return token.UserID, nil
+}
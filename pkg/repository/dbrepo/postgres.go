@@ -2,16 +2,17 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"log"
+	"math/rand"
 	"time"
 
 	"github.com/fangjjcs/bookings-app/pkg/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+	"github.com/jackc/pgconn"
 )
-func (m *postgresDBRepo) AllUsers() bool{
-	return true
-}
 
 // InsertReservations inserts reservation
 func (m *postgresDBRepo) InsertReservations(res models.Reservations) (int, error){
@@ -19,11 +20,17 @@ func (m *postgresDBRepo) InsertReservations(res models.Reservations) (int, error
 	defer cancel()
 
 	var newID int
+	quote, err := json.Marshal(res.Quote)
+	if err != nil{
+		return 0, err
+	}
+
 	stmt := `insert into reservations 
-	(first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at)
-	 values($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	(first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at, access_token,
+	total, price_quote, guests)
+	 values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`
 	
-    err := m.DB.QueryRowContext(ctx, stmt,
+    err = m.DB.QueryRowContext(ctx, stmt,
 	res.FirstName,
 	res.LastName,
 	res.Email,
@@ -33,6 +40,10 @@ func (m *postgresDBRepo) InsertReservations(res models.Reservations) (int, error
 	res.RoomID,
 	time.Now(),
 	time.Now(),
+	res.AccessToken,
+	res.Quote.Total,
+	string(quote),
+	res.Guests,
 	).Scan(&newID)
 
 	if err != nil{
@@ -68,6 +79,143 @@ func (m *postgresDBRepo) InsertRoomRestriction(res models.RoomRestrictions) erro
 }
 
 
+// BookReservation checks availability and inserts the reservation with its room restriction
+// in one serializable transaction, so two guests can never book the same room for the same night.
+// A confirmation mail is queued in the same transaction and gets its ID. The transaction is tried again when
+// postgres aborts it with a serialization failure, only an overlap found in it means the room is taken
+func (m *postgresDBRepo) BookReservation(res models.Reservations, confirmation *models.OutboxMessage) (int, error){
+	var newID int
+	err := retrySerializable(func() error {
+		var err error
+		newID, err = m.bookReservation(res, confirmation)
+		return err
+	})
+	return newID, err
+}
+
+// bookReservation makes one attempt of BookReservation
+func (m *postgresDBRepo) bookReservation(res models.Reservations, confirmation *models.OutboxMessage) (int, error){
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil{
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// a deactivated room is never available
+	var active bool
+	stmt := `select active from rooms where id = $1`
+	err = tx.QueryRowContext(ctx, stmt, res.RoomID).Scan(&active)
+	if err != nil{
+		return 0, err
+	}
+	if !active {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	var count int
+	stmt = `select count(id) from room_restrictions where
+	        $1 < end_date and $2 > start_date and room_id = $3`
+	err = tx.QueryRowContext(ctx, stmt, res.StartDate, res.EndDate, res.RoomID).Scan(&count)
+	if err != nil{
+		return 0, err
+	}
+	if count > 0 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	quote, err := json.Marshal(res.Quote)
+	if err != nil{
+		return 0, err
+	}
+
+	var newID int
+	stmt = `insert into reservations 
+	(first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at, access_token,
+	total, price_quote, guests)
+	 values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		time.Now(),
+		time.Now(),
+		res.AccessToken,
+		res.Quote.Total,
+		string(quote),
+		res.Guests,
+	).Scan(&newID)
+	if err != nil{
+		return 0, err
+	}
+
+	if res.Quote.PromoCodeID > 0 {
+		res.ID = newID
+		if err = redeemPromoCode(ctx, tx, res); err != nil{
+			return 0, err
+		}
+	}
+
+	stmt = `insert into room_restrictions 
+	(start_date, end_date, room_id, reservation_id,created_at, updated_at, restriction_id)
+	 values($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		time.Now(),
+		time.Now(),
+		1, // 1 for reservation
+	)
+	if err != nil{
+		return 0, err
+	}
+
+	if confirmation != nil {
+		confirmation.ID, err = queueMail(ctx, tx, *confirmation)
+		if err != nil{
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil{
+		return 0, err
+	}
+	return newID, nil
+}
+
+// serializableAttempts is how often a serializable transaction is tried before its serialization failure is
+// returned
+const serializableAttempts = 5
+
+// retrySerializable runs a serializable transaction again while postgres aborts it with a serialization failure.
+// Postgres aborts one of two transactions that might conflict, also when they did not, so the failure only means
+// to try again. Each attempt waits a little longer and at random, so the transactions do not meet again
+func retrySerializable(attempt func() error) error {
+	var err error
+	for i := 1; i <= serializableAttempts; i++ {
+		err = attempt()
+		if !isSerializationFailure(err) {
+			return err
+		}
+		time.Sleep(time.Duration(i*10+rand.Intn(10)) * time.Millisecond)
+	}
+	return err
+}
+
+// isSerializationFailure reports whether postgres aborted a transaction because of a concurrent one
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "40001"
+}
+
 // SearchAvailabilityByDatesAndRoomID search availability by room id
 func (m *postgresDBRepo) SearchAvailabilityByDatesAndRoomID(start, end time.Time, roomID int) (bool,error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -96,258 +244,454 @@ func (m *postgresDBRepo) SearchAvailibilityForAllRooms(start, end time.Time) ([]
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var rooms []models.Room
-	query :=`
-	select
-		r.id, r.room_name
-	from
-		rooms r 
-	where r.id not in 
+	query := roomQuery + ` where active = true and id not in 
 		(select rr.room_id from room_restrictions rr where rr.start_date <$2 and rr.end_date>$1)
-		`
-	rows, err := m.DB.QueryContext(ctx,query,start,end)
+	order by sort_order, room_name`
+	return m.queryRooms(ctx, query, start, end)
+}
+
+// reservationQuery selects every reservation column, joined with its room
+const reservationQuery = `
+	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+	r.end_date, r.room_id, r.created_at, r.updated_at, r.status, r.access_token, r.price_quote, r.guests,
+	r.cancelled_at, r.cancellation_fee, r.deleted_at, coalesce(r.deleted_by, 0), rm.id, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)`
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanReservation scans a row selected by reservationQuery
+func scanReservation(row scanner) (models.Reservations, error){
+	var res models.Reservations
+	var quote string
+	var cancelledAt, deletedAt sql.NullTime
+	err := row.Scan(
+		&res.ID,&res.FirstName,&res.LastName,&res.Email,&res.Phone,&res.StartDate,
+		&res.EndDate,&res.RoomID,&res.CreatedAt,&res.UpdatedAt,&res.Status,&res.AccessToken,&quote,&res.Guests,
+		&cancelledAt,&res.CancellationFee,&deletedAt,&res.DeletedBy,&res.Room.ID,&res.Room.RoomName,
+	)
 	if err != nil{
-		return nil, err
+		return res, err
 	}
-	var room models.Room
+	res.CancelledAt = timeOrZero(cancelledAt)
+	res.DeletedAt = timeOrZero(deletedAt)
+
+	// reservations made before prices were kept have no quote
+	if quote != ""{
+		err = json.Unmarshal([]byte(quote), &res.Quote)
+	}
+	return res, err
+}
+
+// queryReservations runs a query built on reservationQuery and scans the rows
+func (m *postgresDBRepo) queryReservations(ctx context.Context, query string, args ...interface{}) ([]models.Reservations, error){
+	var reservations []models.Reservations
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+	
 	for rows.Next(){
-		err := rows.Scan(&room.ID,&room.RoomName)
-		if err!= nil{
-			return nil, err
+		item, err := scanReservation(rows)
+		if err != nil{
+			return reservations, err
 		}
-		rooms = append(rooms, room)
+		reservations = append(reservations, item)
+	}
+	if err = rows.Err(); err != nil{
+		return reservations, err
 	}
+	return reservations, nil
+}
 
-	return rooms, nil
+// AllReservations returns a slice of all reservations
+func (m *postgresDBRepo) AllReservations() ([]models.Reservations, error){
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := reservationQuery + ` where r.deleted_at is null order by r.start_date asc`
+	return m.queryReservations(ctx, query)
 }
 
-// GetUserByID gets user information by user ID
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error){
+
+// ReservationsWithStatus returns the reservations in a status
+func (m *postgresDBRepo) ReservationsWithStatus(status string) ([]models.Reservations, error){
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, first_name, last_name, email, password, access_level created_at, updated_at,
-				from users where id=$1`
-	
-	row := m.DB.QueryRowContext(ctx, query, id)
+	query := reservationQuery + ` where r.status = $1 and r.deleted_at is null order by r.start_date asc`
+	return m.queryReservations(ctx, query, status)
+}
 
-	var u models.User
-	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.AccessLevel, &u.CreatedAt, &u.UpdatedAt)
-	if err != nil{
-		return u, err
-	}
-	return u, nil
+
+// GetReservationByID return one reservation detail by ID
+func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservations, error){
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, reservationQuery + ` where r.id = $1 and r.deleted_at is null`, id)
+	return scanReservation(row)
 }
 
-// UpdateUser updates user information
-func (m *postgresDBRepo) UpdateUser(u models.User) (error){
+// GetReservationByAccessToken returns the reservation a guest token belongs to
+func (m *postgresDBRepo) GetReservationByAccessToken(token string) (models.Reservations, error){
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `update users set first_name=$1, last_name=$2, email=$3, access_level=$4, updated_at=$5`
+	row := m.DB.QueryRowContext(ctx, reservationQuery + ` where r.access_token = $1 and r.deleted_at is null`, token)
+	return scanReservation(row)
+}
+
+// UpdateReservation updates a reservation
+func (m *postgresDBRepo) UpdateReservation(u models.Reservations, id int) (error){
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update reservations set first_name=$1, last_name=$2, email=$3, phone=$4, updated_at=$5
+				where id = $6`
 	
-	_, err := m.DB.ExecContext(ctx, query,u.FirstName,u.LastName,u.Email,u.AccessLevel,time.Now())
+	_, err := m.DB.ExecContext(ctx, query,u.FirstName,u.LastName,u.Email,u.Phone,time.Now(),id)
 	if err != nil{
 		return err
 	}
 
 	return nil
+
 }
 
-// Authenticate validates login information
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error){
+// ChangeReservationDates moves a reservation and its room restriction to new dates with the new price, in one
+// serializable transaction. The room has to be free on the new dates apart from the reservation's own nights,
+// otherwise it fails with repository.ErrRoomNotAvailable. It is tried again on serialization failures
+func (m *postgresDBRepo) ChangeReservationDates(res models.Reservations) error {
+	return retrySerializable(func() error {
+		return m.changeReservationDates(res)
+	})
+}
+
+// changeReservationDates makes one attempt of ChangeReservationDates
+func (m *postgresDBRepo) changeReservationDates(res models.Reservations) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var id int
-	var hashedPassword string
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	// to see if this user exist in db or not
-	query := `select id, password from users where email=$1`
-	row := m.DB.QueryRowContext(ctx, query, email)
-	err := row.Scan(&id, &hashedPassword)
-	if err != nil{
-		return id, hashedPassword, err
+	var count int
+	stmt := `select count(id) from room_restrictions where $1 < end_date and $2 > start_date and room_id = $3
+		and (reservation_id is null or reservation_id <> $4)`
+	err = tx.QueryRowContext(ctx, stmt, res.StartDate, res.EndDate, res.RoomID, res.ID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return repository.ErrRoomNotAvailable
 	}
 
-	// compare password
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword),[]byte(testPassword))
-	if err == bcrypt.ErrMismatchedHashAndPassword{
-		return 0, "", errors.New("Incorrect Password")
-	}else if err != nil{
-		return 0, "", err
+	quote, err := json.Marshal(res.Quote)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update reservations set start_date = $1, end_date = $2, total = $3, price_quote = $4, updated_at = $5
+		where id = $6`
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, res.Quote.Total, string(quote), time.Now(), res.ID)
+	if err != nil {
+		return err
 	}
 
-	return id, hashedPassword, nil
+	stmt = `update room_restrictions set start_date = $1, end_date = $2, updated_at = $3 where reservation_id = $4`
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
 
+	return tx.Commit()
 }
 
-// AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservations, error){
+// ChangeReservationStatus moves a reservation on to a status when its lifecycle allows it, keeping who did it in
+// its status history
+func (m *postgresDBRepo) ChangeReservationStatus(id int, status string, userID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var reservations []models.Reservations
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = changeStatus(ctx, tx, id, status, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	query := ` select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-				r.end_date, r.room_id, r.created_at, r.updated_at, rm.id, rm.room_name
-				from reservations r
-				left join rooms rm on (r.room_id = rm.id)
-				order by r.start_date asc`
+// CancelReservation marks a reservation cancelled with the fee it cost and frees its room
+func (m *postgresDBRepo) CancelReservation(id, fee, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return reservations, err
+		return err
 	}
-	defer rows.Close()
-	
-	for rows.Next(){
-		var item models.Reservations
-		err := rows.Scan(&item.ID,&item.FirstName,&item.LastName,&item.Email,&item.Phone,&item.StartDate,
-			&item.EndDate,&item.RoomID,&item.CreatedAt,&item.UpdatedAt,&item.Room.ID,&item.Room.RoomName)
-		if err != nil{
-			return reservations, err
-		}
-		reservations = append(reservations, item)
+	defer tx.Rollback()
+
+	err = changeStatus(ctx, tx, id, models.ReservationCancelled, userID)
+	if err != nil {
+		return err
 	}
-	return reservations, nil
+
+	_, err = tx.ExecContext(ctx, `update reservations set cancellation_fee = $1 where id = $2`, fee, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+// changeStatus moves a reservation on to a status within a transaction and adds it to the history, the row is
+// locked while the transition is checked. A cancelled reservation frees its room
+func changeStatus(ctx context.Context, tx *sql.Tx, id int, status string, userID int) error {
+	var from string
+	err := tx.QueryRowContext(ctx, `select status from reservations where id = $1 and deleted_at is null for update`, id).Scan(&from)
+	if err != nil {
+		return err
+	}
+	if !models.CanTransition(from, status) {
+		return repository.ErrInvalidTransition
+	}
+
+	now := time.Now()
+	stmt := `update reservations set status = $1, updated_at = $2 where id = $3`
+	if status == models.ReservationCancelled {
+		stmt = `update reservations set status = $1, updated_at = $2, cancelled_at = $2 where id = $3`
+	}
+	_, err = tx.ExecContext(ctx, stmt, status, now, id)
+	if err != nil {
+		return err
+	}
+
+	stmt = `insert into reservation_status_changes (reservation_id, from_status, to_status, user_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.ExecContext(ctx, stmt, id, from, status, nullID(userID), now, now)
+	if err != nil {
+		return err
+	}
 
-// AllNewReservations returns a slice of all NEW(processed=0) reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservations, error){
+	if status == models.ReservationCancelled {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	}
+	return err
+}
+
+// StatusChangesForReservation returns the status history of a reservation, oldest first
+func (m *postgresDBRepo) StatusChangesForReservation(reservationID int) ([]models.StatusChange, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var reservations []models.Reservations
-
-	query := ` select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-				r.end_date, r.room_id, r.created_at, r.updated_at,r.processed, rm.id, rm.room_name
-				from reservations r
-				left join rooms rm on (r.room_id = rm.id)
-				where processed = 0
-				order by r.start_date asc`
+	query := `select c.id, c.reservation_id, c.from_status, c.to_status, coalesce(c.user_id, 0),
+		coalesce(u.first_name || ' ' || u.last_name, ''), c.created_at, c.updated_at
+		from reservation_status_changes c
+		left join users u on (c.user_id = u.id)
+		where c.reservation_id = $1
+		order by c.id`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
 	if err != nil {
-		return reservations, err
+		return nil, err
 	}
 	defer rows.Close()
-	
-	for rows.Next(){
-		var item models.Reservations
-		err := rows.Scan(&item.ID,&item.FirstName,&item.LastName,&item.Email,&item.Phone,&item.StartDate,
-			&item.EndDate,&item.RoomID,&item.CreatedAt,&item.UpdatedAt,&item.Processed,&item.Room.ID,&item.Room.RoomName)
-		if err != nil{
-			return reservations, err
+
+	var changes []models.StatusChange
+	for rows.Next() {
+		var c models.StatusChange
+		err := rows.Scan(&c.ID, &c.ReservationID, &c.FromStatus, &c.ToStatus, &c.UserID, &c.UserName,
+			&c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return changes, err
 		}
-		reservations = append(reservations, item)
+		changes = append(changes, c)
 	}
-	return reservations, nil
+	return changes, rows.Err()
 }
 
-
-// GetReservationByID return one reservation detail by ID
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservations, error){
+// DeleteReservation moves a reservation to the trash and frees its room
+func (m *postgresDBRepo) DeleteReservation(id, userID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var res models.Reservations
-
-	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, rm.id, rm.room_name
-		from reservations r
-		left join rooms rm on (r.room_id = rm.id )
-		where r.id = $1
-	`
-	row := m.DB.QueryRowContext(ctx, query, id )
-	err := row.Scan(
-		&res.ID,&res.FirstName,&res.LastName,&res.Email,&res.Phone,&res.StartDate,
-		&res.EndDate,&res.RoomID,&res.CreatedAt,&res.UpdatedAt,&res.Processed,
-		&res.Room.ID,&res.Room.RoomName,
-	)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return res, err
+		return err
 	}
+	defer tx.Rollback()
 
-	return res, nil
+	stmt := `update reservations set deleted_at = $1, deleted_by = $2 where id = $3 and deleted_at is null`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), nullID(userID), id)
+	if err != nil {
+		return err
+	}
 
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// UpdateReservation updates a reservation
-func (m *postgresDBRepo) UpdateReservation(u models.Reservations, id int) (error){
+// DeletedReservations returns the reservations in the trash, the last deleted first
+func (m *postgresDBRepo) DeletedReservations() ([]models.Reservations, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `update reservations set first_name=$1, last_name=$2, email=$3, phone=$4, updated_at=$5
-				where id = $6`
-	
-	_, err := m.DB.ExecContext(ctx, query,u.FirstName,u.LastName,u.Email,u.Phone,time.Now(),id)
-	if err != nil{
-		return err
-	}
-
-	return nil
+	query := reservationQuery + ` where r.deleted_at is not null order by r.deleted_at desc`
+	return m.queryReservations(ctx, query)
+}
 
+// RestoreReservation takes a reservation out of the trash, a reservation that holds its room gets it back when
+// it is still free on its dates. It is tried again on serialization failures
+func (m *postgresDBRepo) RestoreReservation(id int) error {
+	return retrySerializable(func() error {
+		return m.restoreReservation(id)
+	})
 }
 
-// DeleteReservation delete a rerservation
-func (m *postgresDBRepo) DeleteReservation(id int) (error){
+// restoreReservation makes one attempt of RestoreReservation
+func (m *postgresDBRepo) restoreReservation(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `delete from reservations where id=$1`
-	
-	_, err := m.DB.ExecContext(ctx, query, id)
-	if err != nil{
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	var res models.Reservations
+	stmt := `select room_id, start_date, end_date, status from reservations where id = $1 and deleted_at is not null`
+	err = tx.QueryRowContext(ctx, stmt, id).Scan(&res.RoomID, &res.StartDate, &res.EndDate, &res.Status)
+	if err != nil {
+		return err
+	}
+
+	if !res.Cancelled() {
+		var count int
+		stmt = `select count(id) from room_restrictions where $1 < end_date and $2 > start_date and room_id = $3`
+		err = tx.QueryRowContext(ctx, stmt, res.StartDate, res.EndDate, res.RoomID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return repository.ErrRoomNotAvailable
+		}
 
+		stmt = `insert into room_restrictions 
+		(start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+		values($1, $2, $3, $4, $5, $6, $7)`
+		_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, res.RoomID, id, time.Now(), time.Now(), 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt = `update reservations set deleted_at = null, deleted_by = null, updated_at = $1 where id = $2`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// UpdateProcessedForReservation updates proceesed
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) (error){
+// PurgeDeletedReservations deletes the reservations that went to the trash before a time for good, the foreign
+// keys take their restrictions and history along. Reservations with payments or an invoice are kept, money
+// records are never purged. It returns the ids of the purged ones
+func (m *postgresDBRepo) PurgeDeletedReservations(before time.Time) ([]int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `update reservations set processed = $1 where id = $2`
-	
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
-	if err != nil{
-		return err
+	var purged []int
+	stmt := `delete from reservations r where r.deleted_at < $1
+		and not exists (select 1 from payments p where p.reservation_id = r.id)
+		and not exists (select 1 from invoices i where i.reservation_id = r.id)
+		returning r.id`
+	rows, err := m.DB.QueryContext(ctx, stmt, before)
+	if err != nil {
+		return purged, err
 	}
+	defer rows.Close()
 
-	return nil
-
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return purged, err
+		}
+		purged = append(purged, id)
+	}
+	return purged, rows.Err()
 }
 
-
-// AllRooms gets all rooms
+// AllRooms gets all rooms, including deactivated ones
 func (m *postgresDBRepo) AllRooms() ([]models.Room, error){
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	return m.queryRooms(ctx, roomQuery + ` order by sort_order, room_name`)
+}
+
+// AllActiveRooms gets the rooms shown on the public site
+func (m *postgresDBRepo) AllActiveRooms() ([]models.Room, error){
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.queryRooms(ctx, roomQuery + ` where active = true order by sort_order, room_name`)
+}
+
+// roomQuery selects every room column
+const roomQuery = `select id, room_name, slug, description, capacity, image, active, sort_order,
+	base_rate, weekend_rate, created_at, updated_at from rooms`
+
+// scanRoom scans a row selected by roomQuery
+func scanRoom(row scanner) (models.Room, error){
+	var room models.Room
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&room.Image,
+		&room.Active,
+		&room.SortOrder,
+		&room.BaseRate,
+		&room.WeekendRate,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	return room, err
+}
+
+// queryRooms runs a query built on roomQuery and scans the rows
+func (m *postgresDBRepo) queryRooms(ctx context.Context, query string, args ...interface{}) ([]models.Room, error){
 	var rooms []models.Room
 
-	query := `select id, room_name, created_at, updated_at from rooms
-				order by room_name`
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil{
 		return rooms, err
 	}
 	defer rows.Close()
 
 	for rows.Next(){
-		var room models.Room
-		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
-			&room.CreatedAt,
-			&room.UpdatedAt,
-		)
+		room, err := scanRoom(rows)
 		if err != nil{
 			return rooms, err
 		}
@@ -361,6 +705,93 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error){
 	return rooms, nil
 }
 
+// GetRoomByID gets one room by ID
+func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error){
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanRoom(m.DB.QueryRowContext(ctx, roomQuery + ` where id = $1`, id))
+}
+
+// GetRoomBySlug gets one room by the slug used in its public url
+func (m *postgresDBRepo) GetRoomBySlug(slug string) (models.Room, error){
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanRoom(m.DB.QueryRowContext(ctx, roomQuery + ` where slug = $1`, slug))
+}
+
+// InsertRoom inserts a room
+func (m *postgresDBRepo) InsertRoom(room models.Room) (int, error){
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into rooms
+	(room_name, slug, description, capacity, image, active, sort_order, base_rate, weekend_rate, created_at, updated_at)
+	values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		room.Image,
+		room.Active,
+		room.SortOrder,
+		room.BaseRate,
+		room.WeekendRate,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil{
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateRoom updates a room
+func (m *postgresDBRepo) UpdateRoom(room models.Room) error{
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update rooms set room_name=$1, slug=$2, description=$3, capacity=$4, image=$5,
+				active=$6, sort_order=$7, base_rate=$8, weekend_rate=$9, updated_at=$10
+				where id = $11`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		room.Image,
+		room.Active,
+		room.SortOrder,
+		room.BaseRate,
+		room.WeekendRate,
+		time.Now(),
+		room.ID,
+	)
+	if err != nil{
+		return err
+	}
+	return nil
+}
+
+// UpdateActiveForRoom activates or deactivates a room
+func (m *postgresDBRepo) UpdateActiveForRoom(id int, active bool) error{
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update rooms set active = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, stmt, active, time.Now(), id)
+	if err != nil{
+		return err
+	}
+	return nil
+}
+
 // GetRestrictionsForRoomByDate get restrictions for rooms
 func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestrictions, error){
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -0,0 +1,114 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// apiKeyQuery selects every api key column
+const apiKeyQuery = `select id, name, prefix, key_hash, scopes, expires_at, last_used_at, revoked_at,
+	user_id, created_at, updated_at from api_keys`
+
+// scanAPIKey scans a row selected by apiKeyQuery
+func scanAPIKey(row scanner) (models.APIKey, error) {
+	var k models.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.KeyHash, &scopes, &expiresAt, &lastUsedAt, &revokedAt,
+		&k.UserID, &k.CreatedAt, &k.UpdatedAt)
+	if err != nil {
+		return k, err
+	}
+
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	k.ExpiresAt = timeOrZero(expiresAt)
+	k.LastUsedAt = timeOrZero(lastUsedAt)
+	k.RevokedAt = timeOrZero(revokedAt)
+	return k, nil
+}
+
+// InsertAPIKey inserts an api key
+func (m *postgresDBRepo) InsertAPIKey(k models.APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into api_keys (name, prefix, key_hash, scopes, expires_at, user_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		k.Name,
+		k.Prefix,
+		k.KeyHash,
+		strings.Join(k.Scopes, ","),
+		nullTime(k.ExpiresAt),
+		k.UserID,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// AllAPIKeys returns every api key, the newest first
+func (m *postgresDBRepo) AllAPIKeys() ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var keys []models.APIKey
+
+	rows, err := m.DB.QueryContext(ctx, apiKeyQuery+` order by created_at desc`)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByPrefix returns the api key with the public prefix
+func (m *postgresDBRepo) GetAPIKeyByPrefix(prefix string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, apiKeyQuery+` where prefix = $1`, prefix)
+	return scanAPIKey(row)
+}
+
+// UpdateLastUsedForAPIKey records when an api key was last used
+func (m *postgresDBRepo) UpdateLastUsedForAPIKey(id int, t time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update api_keys set last_used_at = $1 where id = $2`, t, id)
+	return err
+}
+
+// RevokeAPIKey revokes an api key, it can not be used afterwards
+func (m *postgresDBRepo) RevokeAPIKey(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = $1, updated_at = $1 where id = $2 and revoked_at is null`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	return err
+}
@@ -0,0 +1,88 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// InsertAuditEntry adds a change to the audit log
+func (m *postgresDBRepo) InsertAuditEntry(e models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into audit_entries (user_id, actor, action, entity, entity_id, changes, ip_address,
+		created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $8)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, nullID(e.UserID), e.Actor, e.Action, e.Entity, e.EntityID, e.Changes,
+		e.IPAddress, time.Now())
+	return err
+}
+
+// SearchAuditEntries returns the entries of the audit log matching a filter, the newest first
+func (m *postgresDBRepo) SearchAuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var where []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(condition, len(args)))
+	}
+	if f.Entity != "" {
+		add("a.entity = $%d", f.Entity)
+	}
+	if f.EntityID != 0 {
+		add("a.entity_id = $%d", f.EntityID)
+	}
+	if f.Action != "" {
+		add("a.action = $%d", f.Action)
+	}
+	if f.UserID != 0 {
+		add("a.user_id = $%d", f.UserID)
+	}
+	if !f.From.IsZero() {
+		add("a.created_at >= $%d", f.From)
+	}
+	if !f.Until.IsZero() {
+		add("a.created_at < $%d", f.Until)
+	}
+	if f.Query != "" {
+		add("(a.actor || ' ' || coalesce(u.first_name || ' ' || u.last_name, '') || ' ' || a.changes) ilike '%%' || $%d || '%%'", f.Query)
+	}
+
+	query := `select a.id, coalesce(a.user_id, 0), coalesce(u.first_name || ' ' || u.last_name, ''), a.actor,
+		a.action, a.entity, a.entity_id, a.changes, a.ip_address, a.created_at, a.updated_at
+		from audit_entries a
+		left join users u on (a.user_id = u.id)`
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+	query += " order by a.id desc"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" limit %d", f.Limit)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(&e.ID, &e.UserID, &e.UserName, &e.Actor, &e.Action, &e.Entity, &e.EntityID, &e.Changes,
+			&e.IPAddress, &e.CreatedAt, &e.UpdatedAt)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,81 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// InsertAuthEvent adds a login attempt to the auth audit log
+func (m *postgresDBRepo) InsertAuthEvent(e models.AuthEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into auth_events (user_id, email, ip_address, event, success, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $6)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, nullID(e.UserID), e.Email, e.IPAddress, e.Event, e.Success, time.Now())
+	return err
+}
+
+// RecentAuthEvents returns the latest login attempts, the newest first
+func (m *postgresDBRepo) RecentAuthEvents(limit int) ([]models.AuthEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var events []models.AuthEvent
+
+	query := `select id, user_id, email, ip_address, event, success, created_at, updated_at
+		from auth_events order by created_at desc, id desc limit $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuthEvent
+		var userID sql.NullInt64
+		err := rows.Scan(&e.ID, &userID, &e.Email, &e.IPAddress, &e.Event, &e.Success, &e.CreatedAt, &e.UpdatedAt)
+		if err != nil {
+			return events, err
+		}
+		e.UserID = int(userID.Int64)
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return events, err
+	}
+	return events, nil
+}
+
+// CountFailedLoginsForIP counts the failed login attempts from an ip address since the given time, the attempts
+// turned away because the address was blocked do not count, so a block ends while the client keeps trying
+func (m *postgresDBRepo) CountFailedLoginsForIP(ip string, since time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var n int
+	query := `select count(id) from auth_events where ip_address = $1 and success = false and event <> $2
+		and created_at > $3`
+	err := m.DB.QueryRowContext(ctx, query, ip, models.AuthIPBlocked, since).Scan(&n)
+	return n, err
+}
+
+// CountFailedLoginsForEmail counts the logins with an email that failed on the credentials or a lock since the
+// given time, the same for an email with an account and one without
+func (m *postgresDBRepo) CountFailedLoginsForEmail(email string, since time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var n int
+	query := `select count(id) from auth_events where lower(email) = lower($1) and event in ($2, $3, $4)
+		and created_at > $5`
+	err := m.DB.QueryRowContext(ctx, query, email, models.AuthBadPassword, models.AuthUnknownEmail,
+		models.AuthAccountLocked, since).Scan(&n)
+	return n, err
+}
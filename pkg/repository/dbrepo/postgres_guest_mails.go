@@ -0,0 +1,93 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// reservationsWithoutGuestMail returns the reservations whose date column lies between from and to and that have
+// no guest mail of a kind yet, deleted, cancelled and no-show reservations are left out
+func (m *postgresDBRepo) reservationsWithoutGuestMail(column, kind string, from, to time.Time) ([]models.Reservations, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := reservationQuery + ` where r.` + column + ` between $1 and $2 and r.deleted_at is null
+		and r.status not in ($3, $4)
+		and not exists (select 1 from guest_mails g where g.reservation_id = r.id and g.kind = $5)
+		order by r.start_date, r.id`
+	return m.queryReservations(ctx, query, from, to, models.ReservationCancelled, models.ReservationNoShow, kind)
+}
+
+// ArrivalsWithoutGuestMail returns the reservations arriving between from and to that have no guest mail of a
+// kind yet, deleted, cancelled and no-show reservations are left out
+func (m *postgresDBRepo) ArrivalsWithoutGuestMail(kind string, from, to time.Time) ([]models.Reservations, error) {
+	return m.reservationsWithoutGuestMail("start_date", kind, from, to)
+}
+
+// DeparturesWithoutGuestMail returns the reservations departing between from and to that have no guest mail of a
+// kind yet, deleted, cancelled and no-show reservations are left out
+func (m *postgresDBRepo) DeparturesWithoutGuestMail(kind string, from, to time.Time) ([]models.Reservations, error) {
+	return m.reservationsWithoutGuestMail("end_date", kind, from, to)
+}
+
+// QueueGuestMail records a guest mail of a kind for a reservation and queues it in the outbox, both or neither.
+// It returns false without queueing when the reservation got a mail of the kind already
+func (m *postgresDBRepo) QueueGuestMail(reservationID int, kind string, msg models.OutboxMessage) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	outboxID, err := queueMail(ctx, tx, msg)
+	if err != nil {
+		return false, err
+	}
+
+	// the unique index makes a second instance wait for the first and then insert nothing, the rollback drops
+	// its mail again
+	var id int
+	stmt := `insert into guest_mails (reservation_id, kind, outbox_message_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $4)
+		on conflict (reservation_id, kind) do nothing returning id`
+	err = tx.QueryRowContext(ctx, stmt, reservationID, kind, outboxID, time.Now()).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GuestMailsForReservation returns the guest mails of a reservation, the oldest first
+func (m *postgresDBRepo) GuestMailsForReservation(reservationID int) ([]models.GuestMail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, reservation_id, kind, coalesce(outbox_message_id, 0), created_at, updated_at
+		from guest_mails where reservation_id = $1 order by id`
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mails []models.GuestMail
+	for rows.Next() {
+		var g models.GuestMail
+		err := rows.Scan(&g.ID, &g.ReservationID, &g.Kind, &g.OutboxMessageID, &g.CreatedAt, &g.UpdatedAt)
+		if err != nil {
+			return mails, err
+		}
+		mails = append(mails, g)
+	}
+	return mails, rows.Err()
+}
@@ -0,0 +1,115 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// invoiceQuery selects every invoice column
+const invoiceQuery = `select id, number, year, sequence, coalesce(reservation_id, 0), bill_to, email, lines, taxes,
+	total, issued_at, created_at, updated_at from invoices`
+
+// scanInvoice scans a row selected by invoiceQuery
+func scanInvoice(row scanner) (models.Invoice, error) {
+	var inv models.Invoice
+	var lines, taxes string
+
+	err := row.Scan(&inv.ID, &inv.Number, &inv.Year, &inv.Sequence, &inv.ReservationID, &inv.BillTo, &inv.Email,
+		&lines, &taxes, &inv.Total, &inv.IssuedAt, &inv.CreatedAt, &inv.UpdatedAt)
+	if err != nil {
+		return inv, err
+	}
+
+	if err := json.Unmarshal([]byte(lines), &inv.Lines); err != nil {
+		return inv, err
+	}
+	if err := json.Unmarshal([]byte(taxes), &inv.Taxes); err != nil {
+		return inv, err
+	}
+	return inv, nil
+}
+
+// IssueInvoice stores an invoice under the next number of the year it is issued in, a reservation that has
+// an invoice already keeps it and gets it back. The number is taken in the same transaction as the invoice
+// is stored, so a failure gives the number back and the numbers have no gaps
+func (m *postgresDBRepo) IssueInvoice(inv models.Invoice) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback()
+
+	// the reservation row is locked so the same reservation is not invoiced twice at once
+	var reservationID int
+	err = tx.QueryRowContext(ctx, `select id from reservations where id = $1 for update`, inv.ReservationID).
+		Scan(&reservationID)
+	if err != nil {
+		return inv, err
+	}
+
+	existing, err := scanInvoice(tx.QueryRowContext(ctx, invoiceQuery+` where reservation_id = $1`, inv.ReservationID))
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return inv, err
+	}
+
+	// the row of the year stays locked until the invoice is stored
+	inv.Year = inv.IssuedAt.Year()
+	stmt := `insert into invoice_numbers (year, last_number) values ($1, 1)
+		on conflict (year) do update set last_number = invoice_numbers.last_number + 1 returning last_number`
+	err = tx.QueryRowContext(ctx, stmt, inv.Year).Scan(&inv.Sequence)
+	if err != nil {
+		return inv, err
+	}
+	inv.Number = invoiceNumber(inv.Year, inv.Sequence)
+
+	lines, err := json.Marshal(inv.Lines)
+	if err != nil {
+		return inv, err
+	}
+	taxes, err := json.Marshal(inv.Taxes)
+	if err != nil {
+		return inv, err
+	}
+
+	inv.CreatedAt = time.Now()
+	inv.UpdatedAt = inv.CreatedAt
+	stmt = `insert into invoices (number, year, sequence, reservation_id, bill_to, email, lines, taxes, total,
+		issued_at, created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		inv.Number,
+		inv.Year,
+		inv.Sequence,
+		inv.ReservationID,
+		inv.BillTo,
+		inv.Email,
+		string(lines),
+		string(taxes),
+		inv.Total,
+		inv.IssuedAt,
+		inv.CreatedAt,
+	).Scan(&inv.ID)
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, tx.Commit()
+}
+
+// InvoiceForReservation returns the invoice of a reservation, sql.ErrNoRows when it has none
+func (m *postgresDBRepo) InvoiceForReservation(reservationID int) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanInvoice(m.DB.QueryRowContext(ctx, invoiceQuery+` where reservation_id = $1`, reservationID))
+}
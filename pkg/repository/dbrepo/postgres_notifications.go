@@ -0,0 +1,125 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// notificationColumns are the columns of a notification in the order scanNotification reads them
+const notificationColumns = `id, user_id, kind, title, body, link, read_at, mailed_at, created_at, updated_at`
+
+func scanNotification(row scanner) (models.Notification, error) {
+	var n models.Notification
+	var readAt, mailedAt sql.NullTime
+	err := row.Scan(&n.ID, &n.UserID, &n.Kind, &n.Title, &n.Body, &n.Link, &readAt, &mailedAt,
+		&n.CreatedAt, &n.UpdatedAt)
+	if err != nil {
+		return n, err
+	}
+	n.ReadAt = timeOrZero(readAt)
+	n.MailedAt = timeOrZero(mailedAt)
+	return n, nil
+}
+
+// queryNotifications returns the notifications a query selects with notificationColumns
+func (m *postgresDBRepo) queryNotifications(query string, args ...interface{}) ([]models.Notification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return notifications, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// InsertNotification adds a notification for a user
+func (m *postgresDBRepo) InsertNotification(n models.Notification) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	stmt := `insert into notifications (user_id, kind, title, body, link, read_at, mailed_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $8) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt, n.UserID, n.Kind, n.Title, n.Body, n.Link, nullTime(n.ReadAt),
+		nullTime(n.MailedAt), time.Now()).Scan(&id)
+	return id, err
+}
+
+// NotificationsForUser returns the latest notifications of a user, the newest first
+func (m *postgresDBRepo) NotificationsForUser(userID, limit int) ([]models.Notification, error) {
+	query := `select ` + notificationColumns + ` from notifications where user_id = $1 order by id desc limit $2`
+	return m.queryNotifications(query, userID, limit)
+}
+
+// CountUnreadNotifications returns how many notifications a user has not read
+func (m *postgresDBRepo) CountUnreadNotifications(userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	query := `select count(*) from notifications where user_id = $1 and read_at is null`
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// GetNotificationByID returns a notification
+func (m *postgresDBRepo) GetNotificationByID(id int) (models.Notification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + notificationColumns + ` from notifications where id = $1`
+	return scanNotification(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// MarkNotificationRead records that a notification was read, a notification that was read already keeps its time
+func (m *postgresDBRepo) MarkNotificationRead(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update notifications set read_at = $1, updated_at = $1 where id = $2 and read_at is null`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	return err
+}
+
+// MarkAllNotificationsRead records that a user read all their notifications
+func (m *postgresDBRepo) MarkAllNotificationsRead(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update notifications set read_at = $1, updated_at = $1 where user_id = $2 and read_at is null`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), userID)
+	return err
+}
+
+// UnmailedNotifications returns the notifications of a user that are neither read nor mailed yet, the oldest first
+func (m *postgresDBRepo) UnmailedNotifications(userID int) ([]models.Notification, error) {
+	query := `select ` + notificationColumns + ` from notifications
+		where user_id = $1 and read_at is null and mailed_at is null
+		order by id`
+	return m.queryNotifications(query, userID)
+}
+
+// MarkNotificationsMailed records that notifications went out by mail
+func (m *postgresDBRepo) MarkNotificationsMailed(ids []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// pgx sends the ids as an array
+	stmt := `update notifications set mailed_at = $1, updated_at = $1 where id = any($2)`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), ids)
+	return err
+}
@@ -0,0 +1,205 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// outboxColumns are the columns of a mail of the outbox in the order scanOutboxMessage reads them
+const outboxColumns = `id, to_address, from_address, subject, content, plain_content, attachments, status,
+	attempts, next_attempt_at, last_error, sent_at, created_at, updated_at`
+
+// queueMail adds a mail to the outbox on a connection or within a transaction
+func queueMail(ctx context.Context, q queryRower, msg models.OutboxMessage) (int, error) {
+	attachments, err := json.Marshal(msg.Attachments)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	if msg.NextAttemptAt.IsZero() {
+		msg.NextAttemptAt = now
+	}
+
+	var id int
+	stmt := `insert into outbox_messages (to_address, from_address, subject, content, plain_content, attachments,
+		status, attempts, next_attempt_at, last_error, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, 0, $8, '', $9, $9) returning id`
+	err = q.QueryRowContext(ctx, stmt, msg.To, msg.From, msg.Subject, msg.Content, msg.PlainContent,
+		string(attachments), models.OutboxPending, msg.NextAttemptAt, now).Scan(&id)
+	return id, err
+}
+
+func scanOutboxMessage(row scanner) (models.OutboxMessage, error) {
+	var msg models.OutboxMessage
+	var attachments string
+	var sentAt sql.NullTime
+	err := row.Scan(&msg.ID, &msg.To, &msg.From, &msg.Subject, &msg.Content, &msg.PlainContent, &attachments,
+		&msg.Status, &msg.Attempts, &msg.NextAttemptAt, &msg.LastError, &sentAt, &msg.CreatedAt, &msg.UpdatedAt)
+	if err != nil {
+		return msg, err
+	}
+	msg.SentAt = timeOrZero(sentAt)
+	err = json.Unmarshal([]byte(attachments), &msg.Attachments)
+	return msg, err
+}
+
+// QueueMail adds a mail to the outbox, it is due right away unless NextAttemptAt holds it back
+func (m *postgresDBRepo) QueueMail(msg models.OutboxMessage) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return queueMail(ctx, m.DB, msg)
+}
+
+// ReleaseMail adds attachments to a mail that is held back and makes it due right away
+func (m *postgresDBRepo) ReleaseMail(id int, attachments []models.MailAttachment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `select ` + outboxColumns + ` from outbox_messages where id = $1 for update`
+	msg, err := scanOutboxMessage(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return err
+	}
+	if msg.Status != models.OutboxPending || msg.Attempts > 0 {
+		// it went out without them already
+		return nil
+	}
+
+	b, err := json.Marshal(append(msg.Attachments, attachments...))
+	if err != nil {
+		return err
+	}
+	stmt := `update outbox_messages set attachments = $1, next_attempt_at = $2, updated_at = $2 where id = $3`
+	_, err = tx.ExecContext(ctx, stmt, string(b), time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimDueMail takes the pending mails that are due for delivery, the oldest first. Each counts as an attempt
+// and is not due again until the lease is over, so a mail whose sender stops is retried later. Mails another
+// process is claiming are skipped
+func (m *postgresDBRepo) ClaimDueMail(limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	query := `update outbox_messages set attempts = attempts + 1, next_attempt_at = $1, updated_at = $2
+		where id in (
+			select id from outbox_messages
+			where status = $3 and next_attempt_at <= $2
+			order by next_attempt_at, id
+			limit $4
+			for update skip locked)
+		returning ` + outboxColumns
+
+	rows, err := m.DB.QueryContext(ctx, query, now.Add(lease), now, models.OutboxPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.OutboxMessage
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// MarkMailSent records that a mail went out
+func (m *postgresDBRepo) MarkMailSent(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update outbox_messages set status = $1, sent_at = $2, last_error = '', updated_at = $2 where id = $3`
+	_, err := m.DB.ExecContext(ctx, stmt, models.OutboxSent, time.Now(), id)
+	return err
+}
+
+// MarkMailFailed records a failed delivery, the mail is tried again at retryAt or is dead when retryAt is zero
+func (m *postgresDBRepo) MarkMailFailed(id int, lastError string, retryAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var err error
+	if retryAt.IsZero() {
+		stmt := `update outbox_messages set status = $1, last_error = $2, updated_at = $3 where id = $4`
+		_, err = m.DB.ExecContext(ctx, stmt, models.OutboxDead, lastError, time.Now(), id)
+	} else {
+		stmt := `update outbox_messages set next_attempt_at = $1, last_error = $2, updated_at = $3 where id = $4`
+		_, err = m.DB.ExecContext(ctx, stmt, retryAt, lastError, time.Now(), id)
+	}
+	return err
+}
+
+// OutboxMessages returns the mails of the outbox with a status, or all of them for an empty status, the newest
+// first
+func (m *postgresDBRepo) OutboxMessages(status string, limit int) ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// a null limit is no limit
+	query := `select ` + outboxColumns + ` from outbox_messages
+		where $1 = '' or status = $1
+		order by id desc
+		limit $2`
+
+	rows, err := m.DB.QueryContext(ctx, query, status, sql.NullInt64{Int64: int64(limit), Valid: limit > 0})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []models.OutboxMessage
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// GetOutboxMessage returns a mail of the outbox
+func (m *postgresDBRepo) GetOutboxMessage(id int) (models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + outboxColumns + ` from outbox_messages where id = $1`
+	return scanOutboxMessage(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// ResendMail queues a sent or dead mail again with a fresh count of attempts
+func (m *postgresDBRepo) ResendMail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update outbox_messages set status = $1, attempts = 0, last_error = '', next_attempt_at = $2,
+		updated_at = $2 where id = $3`
+	result, err := m.DB.ExecContext(ctx, stmt, models.OutboxPending, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// InsertPayment adds an entry to the payments ledger of a reservation
+func (m *postgresDBRepo) InsertPayment(p models.Payment) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into payments (reservation_id, kind, amount, reference, succeeded, message, card_last4,
+		created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.ReservationID,
+		p.Kind,
+		p.Amount,
+		p.Reference,
+		p.Succeeded,
+		p.Message,
+		p.CardLast4,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// PaymentsForReservation returns the payments ledger of a reservation, the oldest entry first
+func (m *postgresDBRepo) PaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var ledger []models.Payment
+
+	query := `select id, reservation_id, kind, amount, reference, succeeded, message, card_last4, created_at,
+		updated_at from payments where reservation_id = $1 order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return ledger, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(&p.ID, &p.ReservationID, &p.Kind, &p.Amount, &p.Reference, &p.Succeeded, &p.Message,
+			&p.CardLast4, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return ledger, err
+		}
+		ledger = append(ledger, p)
+	}
+	if err = rows.Err(); err != nil {
+		return ledger, err
+	}
+	return ledger, nil
+}
@@ -0,0 +1,221 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+)
+
+// promoCodeQuery selects every promo code column and its redemption count
+const promoCodeQuery = `select p.id, p.code, p.description, p.kind, p.amount, p.book_from, p.book_until,
+	p.stay_from, p.stay_until, p.min_nights, p.room_ids, p.max_redemptions, p.max_per_email, p.active,
+	(select count(id) from promo_redemptions where promo_code_id = p.id), p.created_at, p.updated_at
+	from promo_codes p`
+
+// scanPromoCode scans a row selected by promoCodeQuery
+func scanPromoCode(row scanner) (models.PromoCode, error) {
+	var p models.PromoCode
+	var roomIDs string
+	var bookFrom, bookUntil, stayFrom, stayUntil sql.NullTime
+
+	err := row.Scan(&p.ID, &p.Code, &p.Description, &p.Kind, &p.Amount, &bookFrom, &bookUntil,
+		&stayFrom, &stayUntil, &p.MinNights, &roomIDs, &p.MaxRedemptions, &p.MaxPerEmail, &p.Active,
+		&p.Redemptions, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return p, err
+	}
+
+	p.BookFrom = timeOrZero(bookFrom)
+	p.BookUntil = timeOrZero(bookUntil)
+	p.StayFrom = timeOrZero(stayFrom)
+	p.StayUntil = timeOrZero(stayUntil)
+	p.RoomIDs = splitIDs(roomIDs)
+	return p, nil
+}
+
+// joinIDs stores a list of ids as "1,2,3"
+func joinIDs(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, ",")
+}
+
+// splitIDs reads a list of ids stored by joinIDs
+func splitIDs(s string) []int {
+	var ids []int
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.Atoi(part); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// AllPromoCodes returns every promo code with its redemption count, the newest first
+func (m *postgresDBRepo) AllPromoCodes() ([]models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var codes []models.PromoCode
+
+	rows, err := m.DB.QueryContext(ctx, promoCodeQuery+` order by p.created_at desc`)
+	if err != nil {
+		return codes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPromoCode(rows)
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, p)
+	}
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+	return codes, nil
+}
+
+// GetPromoCodeByID returns a promo code with its redemption count
+func (m *postgresDBRepo) GetPromoCodeByID(id int) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, promoCodeQuery+` where p.id = $1`, id)
+	return scanPromoCode(row)
+}
+
+// GetPromoCodeByCode returns a promo code by its code in any case, with its redemption count
+func (m *postgresDBRepo) GetPromoCodeByCode(code string) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, promoCodeQuery+` where upper(p.code) = upper($1)`, code)
+	return scanPromoCode(row)
+}
+
+// InsertPromoCode inserts a promo code
+func (m *postgresDBRepo) InsertPromoCode(p models.PromoCode) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into promo_codes (code, description, kind, amount, book_from, book_until, stay_from,
+		stay_until, min_nights, room_ids, max_redemptions, max_per_email, active, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $14) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.Code,
+		p.Description,
+		p.Kind,
+		p.Amount,
+		nullTime(p.BookFrom),
+		nullTime(p.BookUntil),
+		nullTime(p.StayFrom),
+		nullTime(p.StayUntil),
+		p.MinNights,
+		joinIDs(p.RoomIDs),
+		p.MaxRedemptions,
+		p.MaxPerEmail,
+		p.Active,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateActiveForPromoCode turns a promo code on or off
+func (m *postgresDBRepo) UpdateActiveForPromoCode(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update promo_codes set active = $1, updated_at = $2 where id = $3`
+	_, err := m.DB.ExecContext(ctx, stmt, active, time.Now(), id)
+	return err
+}
+
+// CountPromoRedemptions returns how often a promo code was used, in total and with an email
+func (m *postgresDBRepo) CountPromoRedemptions(promoCodeID int, email string) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return countPromoRedemptions(ctx, m.DB, promoCodeID, email)
+}
+
+// queryRower is what countPromoRedemptions needs from a connection or a transaction
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// countPromoRedemptions counts the uses of a promo code, in total and with an email
+func countPromoRedemptions(ctx context.Context, db queryRower, promoCodeID int, email string) (int, int, error) {
+	var total, forEmail int
+	query := `select count(id), count(id) filter (where lower(email) = lower($2))
+		from promo_redemptions where promo_code_id = $1`
+	err := db.QueryRowContext(ctx, query, promoCodeID, email).Scan(&total, &forEmail)
+	return total, forEmail, err
+}
+
+// PromoRedemptionsForCode returns the uses of a promo code, the newest first
+func (m *postgresDBRepo) PromoRedemptionsForCode(promoCodeID int) ([]models.PromoRedemption, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var redemptions []models.PromoRedemption
+
+	query := `select id, promo_code_id, reservation_id, email, discount, created_at, updated_at
+		from promo_redemptions where promo_code_id = $1 order by created_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, promoCodeID)
+	if err != nil {
+		return redemptions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.PromoRedemption
+		err := rows.Scan(&r.ID, &r.PromoCodeID, &r.ReservationID, &r.Email, &r.Discount, &r.CreatedAt, &r.UpdatedAt)
+		if err != nil {
+			return redemptions, err
+		}
+		redemptions = append(redemptions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return redemptions, err
+	}
+	return redemptions, nil
+}
+
+// redeemPromoCode records the use of the quote's promo code by a new reservation within the booking's
+// transaction, the promo code row stays locked until it commits so concurrent bookings can not pass its limits
+func redeemPromoCode(ctx context.Context, tx *sql.Tx, res models.Reservations) error {
+	var maxRedemptions, maxPerEmail int
+	query := `select max_redemptions, max_per_email from promo_codes where id = $1 for update`
+	err := tx.QueryRowContext(ctx, query, res.Quote.PromoCodeID).Scan(&maxRedemptions, &maxPerEmail)
+	if err != nil {
+		return err
+	}
+
+	total, forEmail, err := countPromoRedemptions(ctx, tx, res.Quote.PromoCodeID, res.Email)
+	if err != nil {
+		return err
+	}
+	if (maxRedemptions > 0 && total >= maxRedemptions) || (maxPerEmail > 0 && forEmail >= maxPerEmail) {
+		return repository.ErrPromoCodeUsedUp
+	}
+
+	stmt := `insert into promo_redemptions (promo_code_id, reservation_id, email, discount, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $5)`
+	_, err = tx.ExecContext(ctx, stmt, res.Quote.PromoCodeID, res.ID, res.Email, res.Quote.PromoDiscount, time.Now())
+	return err
+}
@@ -0,0 +1,185 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// SeasonalRatesForRoom returns the seasonal rates of a room in date order
+func (m *postgresDBRepo) SeasonalRatesForRoom(roomID int) ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rates []models.SeasonalRate
+
+	query := `select id, room_id, name, start_date, end_date, nightly_rate, weekend_rate, created_at, updated_at
+		from seasonal_rates where room_id = $1 order by start_date, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.SeasonalRate
+		err := rows.Scan(&s.ID, &s.RoomID, &s.Name, &s.StartDate, &s.EndDate, &s.NightlyRate, &s.WeekendRate,
+			&s.CreatedAt, &s.UpdatedAt)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+	return rates, nil
+}
+
+// InsertSeasonalRate inserts a seasonal rate
+func (m *postgresDBRepo) InsertSeasonalRate(rate models.SeasonalRate) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into seasonal_rates (room_id, name, start_date, end_date, nightly_rate, weekend_rate,
+		created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		rate.RoomID,
+		rate.Name,
+		rate.StartDate,
+		rate.EndDate,
+		rate.NightlyRate,
+		rate.WeekendRate,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate
+func (m *postgresDBRepo) DeleteSeasonalRate(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from seasonal_rates where id = $1`, id)
+	return err
+}
+
+// StayDiscountsForRoom returns the stay discounts of a room, the shortest stay first
+func (m *postgresDBRepo) StayDiscountsForRoom(roomID int) ([]models.StayDiscount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var discounts []models.StayDiscount
+
+	query := `select id, room_id, min_nights, percent, created_at, updated_at
+		from stay_discounts where room_id = $1 order by min_nights`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return discounts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.StayDiscount
+		err := rows.Scan(&d.ID, &d.RoomID, &d.MinNights, &d.Percent, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return discounts, err
+		}
+		discounts = append(discounts, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return discounts, err
+	}
+	return discounts, nil
+}
+
+// InsertStayDiscount inserts a stay discount
+func (m *postgresDBRepo) InsertStayDiscount(d models.StayDiscount) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into stay_discounts (room_id, min_nights, percent, created_at, updated_at)
+		values ($1, $2, $3, $4, $4) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, d.RoomID, d.MinNights, d.Percent, time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeleteStayDiscount deletes a stay discount
+func (m *postgresDBRepo) DeleteStayDiscount(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from stay_discounts where id = $1`, id)
+	return err
+}
+
+// CancellationRulesForRoom returns the cancellation policy of a room, the latest cancellation first
+func (m *postgresDBRepo) CancellationRulesForRoom(roomID int) ([]models.CancellationRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rules []models.CancellationRule
+
+	query := `select id, room_id, days_before, percent, created_at, updated_at
+		from cancellation_rules where room_id = $1 order by days_before`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.CancellationRule
+		err := rows.Scan(&rule.ID, &rule.RoomID, &rule.DaysBefore, &rule.Percent, &rule.CreatedAt, &rule.UpdatedAt)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+// InsertCancellationRule inserts a cancellation rule
+func (m *postgresDBRepo) InsertCancellationRule(rule models.CancellationRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into cancellation_rules (room_id, days_before, percent, created_at, updated_at)
+		values ($1, $2, $3, $4, $4) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, rule.RoomID, rule.DaysBefore, rule.Percent, time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeleteCancellationRule deletes a cancellation rule
+func (m *postgresDBRepo) DeleteCancellationRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from cancellation_rules where id = $1`, id)
+	return err
+}
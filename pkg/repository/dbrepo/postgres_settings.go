@@ -0,0 +1,33 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// GetSetting returns the value of a setting, or "" when it was never set
+func (m *postgresDBRepo) GetSetting(key string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var value string
+	err := m.DB.QueryRowContext(ctx, `select value from settings where key = $1`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+// UpdateSetting stores the value of a setting
+func (m *postgresDBRepo) UpdateSetting(key, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into settings (key, value, created_at, updated_at) values ($1, $2, $3, $3)
+		on conflict (key) do update set value = excluded.value, updated_at = excluded.updated_at`
+
+	_, err := m.DB.ExecContext(ctx, stmt, key, value, time.Now())
+	return err
+}
@@ -0,0 +1,107 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+)
+
+// taxRuleQuery selects every tax rule column
+const taxRuleQuery = `select id, name, kind, basis, amount, effective_from, effective_until, active, created_at,
+	updated_at from tax_rules`
+
+// scanTaxRule scans a row selected by taxRuleQuery
+func scanTaxRule(row scanner) (models.TaxRule, error) {
+	var rule models.TaxRule
+	var from, until sql.NullTime
+
+	err := row.Scan(&rule.ID, &rule.Name, &rule.Kind, &rule.Basis, &rule.Amount, &from, &until, &rule.Active,
+		&rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return rule, err
+	}
+
+	rule.EffectiveFrom = timeOrZero(from)
+	rule.EffectiveUntil = timeOrZero(until)
+	return rule, nil
+}
+
+// AllTaxRules returns every tax and fee rule, in the order they were added
+func (m *postgresDBRepo) AllTaxRules() ([]models.TaxRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rules []models.TaxRule
+
+	rows, err := m.DB.QueryContext(ctx, taxRuleQuery+` order by id`)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rule, err := scanTaxRule(rows)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+// GetTaxRuleByID returns a tax or fee rule
+func (m *postgresDBRepo) GetTaxRuleByID(id int) (models.TaxRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanTaxRule(m.DB.QueryRowContext(ctx, taxRuleQuery+` where id = $1`, id))
+}
+
+// InsertTaxRule inserts a tax or fee rule
+func (m *postgresDBRepo) InsertTaxRule(rule models.TaxRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into tax_rules (name, kind, basis, amount, effective_from, effective_until, active,
+		created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		rule.Name,
+		rule.Kind,
+		rule.Basis,
+		rule.Amount,
+		nullTime(rule.EffectiveFrom),
+		nullTime(rule.EffectiveUntil),
+		rule.Active,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateActiveForTaxRule turns a tax or fee rule on or off
+func (m *postgresDBRepo) UpdateActiveForTaxRule(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update tax_rules set active = $1, updated_at = $2 where id = $3`
+	_, err := m.DB.ExecContext(ctx, stmt, active, time.Now(), id)
+	return err
+}
+
+// DeleteTaxRule deletes a tax or fee rule, stays booked with it keep what they were charged
+func (m *postgresDBRepo) DeleteTaxRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from tax_rules where id = $1`, id)
+	return err
+}
@@ -0,0 +1,43 @@
+package dbrepo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+)
+
+func TestRetrySerializable(t *testing.T) {
+	conflict := &pgconn.PgError{Code: "40001"}
+	other := errors.New("connection lost")
+
+	tests := []struct {
+		name             string
+		failures         int
+		failWith         error
+		expectedAttempts int
+		expectedErr      error
+	}{
+		{"success", 0, nil, 1, nil},
+		{"conflict then success", 2, conflict, 3, nil},
+		{"conflict every time", serializableAttempts, conflict, serializableAttempts, conflict},
+		{"other error", 1, other, 1, other},
+	}
+
+	for _, e := range tests {
+		attempts := 0
+		err := retrySerializable(func() error {
+			attempts++
+			if attempts <= e.failures {
+				return e.failWith
+			}
+			return nil
+		})
+		if attempts != e.expectedAttempts {
+			t.Errorf("for %s expected %d attempts, but %d", e.name, e.expectedAttempts, attempts)
+		}
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("for %s expected %v, but %v", e.name, e.expectedErr, err)
+		}
+	}
+}
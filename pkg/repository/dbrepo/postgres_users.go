@@ -0,0 +1,398 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/fangjjcs/bookings-app/pkg/models"
+	"github.com/fangjjcs/bookings-app/pkg/repository"
+)
+
+// userQuery selects every user column
+const userQuery = `select id, first_name, last_name, email, password, access_level, disabled_at,
+	totp_secret, totp_enabled_at, totp_last_step, failed_logins, locked_until, notify_email, password_changed_at,
+	created_at, updated_at
+	from users`
+
+// scanUser scans a row selected by userQuery
+func scanUser(row scanner) (models.User, error) {
+	var u models.User
+	var disabledAt, totpEnabledAt, lockedUntil, passwordChangedAt sql.NullTime
+
+	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.AccessLevel, &disabledAt,
+		&u.TOTPSecret, &totpEnabledAt, &u.TOTPLastStep, &u.FailedLogins, &lockedUntil, &u.NotifyEmail,
+		&passwordChangedAt, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return u, err
+	}
+
+	u.DisabledAt = timeOrZero(disabledAt)
+	u.TOTPEnabledAt = timeOrZero(totpEnabledAt)
+	u.LockedUntil = timeOrZero(lockedUntil)
+	u.PasswordChangedAt = timeOrZero(passwordChangedAt)
+	return u, nil
+}
+
+// AllUsers returns every user ordered by name
+func (m *postgresDBRepo) AllUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var users []models.User
+
+	rows, err := m.DB.QueryContext(ctx, userQuery+` order by last_name, first_name`)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+	return users, nil
+}
+
+// GetUserByID gets user information by user ID
+func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanUser(m.DB.QueryRowContext(ctx, userQuery+` where id = $1`, id))
+}
+
+// GetUserByEmail gets user information by email, emails are compared case insensitively
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanUser(m.DB.QueryRowContext(ctx, userQuery+` where lower(email) = lower($1)`, email))
+}
+
+// InsertUser inserts a user, the password must already be hashed
+func (m *postgresDBRepo) InsertUser(u models.User) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into users (first_name, last_name, email, password, access_level, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Password,
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateUser updates user information
+func (m *postgresDBRepo) UpdateUser(u models.User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update users set first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5
+		where id = $6`
+
+	_, err := m.DB.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now(), u.ID)
+	return err
+}
+
+// UpdateNotifyEmailForUser sets whether a user gets their notifications by mail too
+func (m *postgresDBRepo) UpdateNotifyEmailForUser(id int, mode string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update users set notify_email = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, mode, time.Now(), id)
+	return err
+}
+
+// UpdatePasswordForUser sets the bcrypt hash of a user's password, an empty hash locks the user out. Sessions
+// of the user started before are logged out
+func (m *postgresDBRepo) UpdatePasswordForUser(id int, hashedPassword string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update users set password = $1, password_changed_at = $2, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, hashedPassword, time.Now(), id)
+	return err
+}
+
+// UpdateDisabledForUser disables or enables a user
+func (m *postgresDBRepo) UpdateDisabledForUser(id int, disabled bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var disabledAt time.Time
+	if disabled {
+		disabledAt = time.Now()
+	}
+
+	query := `update users set disabled_at = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, nullTime(disabledAt), time.Now(), id)
+	return err
+}
+
+// Authenticate validates login information, an unknown email and a wrong password both return
+// repository.ErrInvalidCredentials
+func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+	u, err := m.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, "", err
+	}
+
+	// compare password, unknown emails and invited users without a password take as long and fail here too
+	err = checkPassword(u.Password, testPassword)
+	if err != nil {
+		return 0, "", repository.ErrInvalidCredentials
+	}
+
+	if u.Disabled() {
+		return 0, "", repository.ErrUserDisabled
+	}
+
+	return u.ID, u.Password, nil
+}
+
+// UpdateTOTPForUser turns on two-factor authentication with secret, an empty secret turns it off
+func (m *postgresDBRepo) UpdateTOTPForUser(id int, secret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var enabledAt time.Time
+	if secret != "" {
+		enabledAt = time.Now()
+	}
+
+	query := `update users set totp_secret = $1, totp_enabled_at = $2, totp_last_step = 0, updated_at = $3
+		where id = $4`
+
+	_, err := m.DB.ExecContext(ctx, query, secret, nullTime(enabledAt), time.Now(), id)
+	return err
+}
+
+// UseTOTPStepForUser records the time step of a code a user logged in with, it reports false
+// when that step or a later one was used before, so every code works only once
+func (m *postgresDBRepo) UseTOTPStepForUser(id int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update users set totp_last_step = $1 where id = $2 and totp_last_step < $1`
+
+	result, err := m.DB.ExecContext(ctx, query, step, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ReplaceRecoveryCodes drops the recovery codes of a user and stores the hashes of new ones
+func (m *postgresDBRepo) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into user_recovery_codes (user_id, code_hash, created_at, updated_at) values ($1, $2, $3, $3)`
+	for _, codeHash := range codeHashes {
+		_, err = tx.ExecContext(ctx, stmt, userID, codeHash, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode uses up an unused recovery code of a user, it reports false when there is none
+func (m *postgresDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update user_recovery_codes set used_at = $1, updated_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (m *postgresDBRepo) CountRecoveryCodes(userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var n int
+	query := `select count(id) from user_recovery_codes where user_id = $1 and used_at is null`
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&n)
+	return n, err
+}
+
+// IncrementFailedLoginsForUser counts a failed login of a user and returns the count since their last login
+func (m *postgresDBRepo) IncrementFailedLoginsForUser(id int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var n int
+	query := `update users set failed_logins = failed_logins + 1 where id = $1 returning failed_logins`
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&n)
+	return n, err
+}
+
+// UpdateLockedUntilForUser locks a user out until the given time
+func (m *postgresDBRepo) UpdateLockedUntilForUser(id int, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update users set locked_until = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, nullTime(until), time.Now(), id)
+	return err
+}
+
+// ResetFailedLoginsForUser forgets the failed logins of a user and lifts their lock
+func (m *postgresDBRepo) ResetFailedLoginsForUser(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update users set failed_logins = 0, locked_until = null, updated_at = $1 where id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	return err
+}
+
+// userTokenQuery selects every user token column
+const userTokenQuery = `select id, user_id, purpose, token_hash, expires_at, used_at, created_at, updated_at
+	from user_tokens`
+
+// InsertUserToken inserts a user token
+func (m *postgresDBRepo) InsertUserToken(t models.UserToken) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into user_tokens (user_id, purpose, token_hash, expires_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.Purpose,
+		t.TokenHash,
+		t.ExpiresAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// GetUserTokenByHash gets the user token with the hash
+func (m *postgresDBRepo) GetUserTokenByHash(tokenHash string) (models.UserToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t models.UserToken
+	var usedAt sql.NullTime
+
+	row := m.DB.QueryRowContext(ctx, userTokenQuery+` where token_hash = $1`, tokenHash)
+	err := row.Scan(&t.ID, &t.UserID, &t.Purpose, &t.TokenHash, &t.ExpiresAt, &usedAt, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return t, err
+	}
+
+	t.UsedAt = timeOrZero(usedAt)
+	return t, nil
+}
+
+// UseUserTokens marks every unused token of a user for purpose as used
+func (m *postgresDBRepo) UseUserTokens(userID int, purpose string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update user_tokens set used_at = $1, updated_at = $1
+		where user_id = $2 and purpose = $3 and used_at is null`
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), userID, purpose)
+	return err
+}
+
+// SetPasswordWithToken uses up a usable token and sets the password of its user in one transaction and returns
+// the user. The token is taken by the update itself, so of two requests with the same token only one sets a
+// password, the other gets sql.ErrNoRows like an unknown, used or expired token does
+func (m *postgresDBRepo) SetPasswordWithToken(tokenHash, hashedPassword string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int
+	var purpose string
+	query := `update user_tokens set used_at = $1, updated_at = $1
+		where token_hash = $2 and used_at is null and expires_at > $1 returning user_id, purpose`
+	err = tx.QueryRowContext(ctx, query, now, tokenHash).Scan(&userID, &purpose)
+	if err != nil {
+		return 0, err
+	}
+
+	// the other links of the same mail kind stop working as well
+	query = `update user_tokens set used_at = $1, updated_at = $1
+		where user_id = $2 and purpose = $3 and used_at is null`
+	_, err = tx.ExecContext(ctx, query, now, userID, purpose)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `update users set password = $1, password_changed_at = $2, updated_at = $2 where id = $3`
+	_, err = tx.ExecContext(ctx, query, hashedPassword, now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
@@ -1,32 +1,151 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
 	"github.com/fangjjcs/bookings-app/pkg/models"
 )
 
+// ErrRoomNotAvailable is returned when a booking overlaps an existing room restriction or its room is deactivated
+var ErrRoomNotAvailable = errors.New("room is no longer available for these dates")
+
+// ErrPromoCodeUsedUp is returned by BookReservation when the promo code of the quote reached its limits meanwhile
+var ErrPromoCodeUsedUp = errors.New("promo code has been used up")
+
+// ErrUserDisabled is returned by Authenticate when the password is right but the account is disabled
+var ErrUserDisabled = errors.New("user is disabled")
+
+// ErrInvalidCredentials is returned by Authenticate for an unknown email or a wrong password alike
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrInvalidTransition is returned when a reservation can not move on to a status from the one it is in
+var ErrInvalidTransition = errors.New("reservation can not move on to this status")
+
 // Interface for different demand of database type
 type DatabaseRepo interface{
-	AllUsers() bool
 	InsertReservations(res models.Reservations) (int,error)
 	InsertRoomRestriction(r models.RoomRestrictions) error
+	BookReservation(res models.Reservations, confirmation *models.OutboxMessage) (int, error)
 	SearchAvailabilityByDatesAndRoomID(start, end time.Time, roomID int) (bool,error)
 	SearchAvailibilityForAllRooms(start, end time.Time) ([]models.Room, error)
 
+	AllUsers() ([]models.User, error)
 	GetUserByID(id int) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
+	InsertUser(u models.User) (int, error)
+	UpdateUser(u models.User) error
+	UpdatePasswordForUser(id int, hashedPassword string) error
+	UpdateDisabledForUser(id int, disabled bool) error
+	UpdateNotifyEmailForUser(id int, mode string) error
 	Authenticate(email, testPassword string) (int, string, error)
+	UpdateTOTPForUser(id int, secret string) error
+	UseTOTPStepForUser(id int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+	IncrementFailedLoginsForUser(id int) (int, error)
+	UpdateLockedUntilForUser(id int, until time.Time) error
+	ResetFailedLoginsForUser(id int) error
+
+	InsertAuthEvent(e models.AuthEvent) error
+	RecentAuthEvents(limit int) ([]models.AuthEvent, error)
+	CountFailedLoginsForIP(ip string, since time.Time) (int, error)
+	CountFailedLoginsForEmail(email string, since time.Time) (int, error)
+
+	QueueMail(msg models.OutboxMessage) (int, error)
+	ReleaseMail(id int, attachments []models.MailAttachment) error
+	ClaimDueMail(limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkMailSent(id int) error
+	MarkMailFailed(id int, lastError string, retryAt time.Time) error
+	OutboxMessages(status string, limit int) ([]models.OutboxMessage, error)
+	GetOutboxMessage(id int) (models.OutboxMessage, error)
+	ResendMail(id int) error
+
+	InsertNotification(n models.Notification) (int, error)
+	NotificationsForUser(userID, limit int) ([]models.Notification, error)
+	CountUnreadNotifications(userID int) (int, error)
+	GetNotificationByID(id int) (models.Notification, error)
+	MarkNotificationRead(id int) error
+	MarkAllNotificationsRead(userID int) error
+	UnmailedNotifications(userID int) ([]models.Notification, error)
+	MarkNotificationsMailed(ids []int) error
+
+	ArrivalsWithoutGuestMail(kind string, from, to time.Time) ([]models.Reservations, error)
+	DeparturesWithoutGuestMail(kind string, from, to time.Time) ([]models.Reservations, error)
+	QueueGuestMail(reservationID int, kind string, msg models.OutboxMessage) (bool, error)
+	GuestMailsForReservation(reservationID int) ([]models.GuestMail, error)
+
+	InsertAuditEntry(e models.AuditEntry) error
+	SearchAuditEntries(f models.AuditFilter) ([]models.AuditEntry, error)
+
+	InsertUserToken(t models.UserToken) (int, error)
+	GetUserTokenByHash(tokenHash string) (models.UserToken, error)
+	UseUserTokens(userID int, purpose string) error
+	SetPasswordWithToken(tokenHash, hashedPassword string) (int, error)
 
 	AllReservations() ([]models.Reservations, error)
-	AllNewReservations() ([]models.Reservations, error)
+	ReservationsWithStatus(status string) ([]models.Reservations, error)
 	GetReservationByID(id int) (models.Reservations, error) 
+	GetReservationByAccessToken(token string) (models.Reservations, error)
 	UpdateReservation(u models.Reservations,id int) (error)
-	DeleteReservation(id int) (error)
-	UpdateProcessedForReservation(id, processed int) (error)
+	ChangeReservationDates(res models.Reservations) error
+	ChangeReservationStatus(id int, status string, userID int) error
+	CancelReservation(id, fee, userID int) error
+	StatusChangesForReservation(reservationID int) ([]models.StatusChange, error)
+	DeleteReservation(id, userID int) (error)
+	DeletedReservations() ([]models.Reservations, error)
+	RestoreReservation(id int) error
+	PurgeDeletedReservations(before time.Time) ([]int, error)
+
+	InsertPayment(p models.Payment) (int, error)
+	PaymentsForReservation(reservationID int) ([]models.Payment, error)
+
+	IssueInvoice(inv models.Invoice) (models.Invoice, error)
+	InvoiceForReservation(reservationID int) (models.Invoice, error)
+
+	AllTaxRules() ([]models.TaxRule, error)
+	GetTaxRuleByID(id int) (models.TaxRule, error)
+	InsertTaxRule(rule models.TaxRule) (int, error)
+	UpdateActiveForTaxRule(id int, active bool) error
+	DeleteTaxRule(id int) error
 
 	AllRooms() ([]models.Room, error)
+	AllActiveRooms() ([]models.Room, error)
+	GetRoomByID(id int) (models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	InsertRoom(room models.Room) (int, error)
+	UpdateRoom(room models.Room) error
+	UpdateActiveForRoom(id int, active bool) error
 	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestrictions, error)
 
+	SeasonalRatesForRoom(roomID int) ([]models.SeasonalRate, error)
+	InsertSeasonalRate(rate models.SeasonalRate) (int, error)
+	DeleteSeasonalRate(id int) error
+	StayDiscountsForRoom(roomID int) ([]models.StayDiscount, error)
+	InsertStayDiscount(d models.StayDiscount) (int, error)
+	DeleteStayDiscount(id int) error
+	CancellationRulesForRoom(roomID int) ([]models.CancellationRule, error)
+	InsertCancellationRule(rule models.CancellationRule) (int, error)
+	DeleteCancellationRule(id int) error
+
+	AllPromoCodes() ([]models.PromoCode, error)
+	GetPromoCodeByID(id int) (models.PromoCode, error)
+	GetPromoCodeByCode(code string) (models.PromoCode, error)
+	InsertPromoCode(p models.PromoCode) (int, error)
+	UpdateActiveForPromoCode(id int, active bool) error
+	CountPromoRedemptions(promoCodeID int, email string) (int, int, error)
+	PromoRedemptionsForCode(promoCodeID int) ([]models.PromoRedemption, error)
+
 	InsertBlockForRoom(id int, startDate time.Time) error
 	DeleteBlockByID(id int) error
-}
\ No newline at end of file
+
+	InsertAPIKey(k models.APIKey) (int, error)
+	AllAPIKeys() ([]models.APIKey, error)
+	GetAPIKeyByPrefix(prefix string) (models.APIKey, error)
+	UpdateLastUsedForAPIKey(id int, t time.Time) error
+	RevokeAPIKey(id int) error
+
+	GetSetting(key string) (string, error)
+	UpdateSetting(key, value string) error
+}
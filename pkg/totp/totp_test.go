@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 test vectors of RFC 6238 appendix B
+func TestCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	var tests = []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, e := range tests {
+		got := code(key, uint64(Step(time.Unix(e.unix, 0))), 8)
+		if got != e.expected {
+			t.Errorf("for %d, expected %s, but %s", e.unix, e.expected, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1600000000, 0)
+	current, _ := Code(secret, now)
+	previous, _ := Code(secret, now.Add(-Period*time.Second))
+	old, _ := Code(secret, now.Add(-3*Period*time.Second))
+
+	var tests = []struct {
+		name     string
+		code     string
+		lastStep int64
+		valid    bool
+	}{
+		{"current", current, 0, true},
+		{"with spaces", current[:3] + " " + current[3:], 0, true},
+		{"previous step", previous, 0, true},
+		{"too old", old, 0, false},
+		{"already used", current, Step(now), false},
+		{"wrong length", current[:5], 0, false},
+	}
+
+	for _, e := range tests {
+		_, valid := Validate(secret, e.code, now, e.lastStep)
+		if valid != e.valid {
+			t.Errorf("for %s, expected %t, but %t", e.name, e.valid, valid)
+		}
+	}
+
+	if _, valid := Validate("not base32!", current, now, 0); valid {
+		t.Error("validated against a broken secret")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Bookings", "admin@example.com", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/Bookings:admin@example.com?") {
+		t.Error("unexpected uri", uri)
+	}
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=Bookings") {
+		t.Error("uri is missing the secret or issuer", uri)
+	}
+}